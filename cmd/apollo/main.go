@@ -5,6 +5,10 @@ import (
 	"os"
 	"os/signal"
 
+	// Quiet hours are in the device's timezone, which shouldn't depend on the host
+	// having tzdata installed.
+	_ "time/tzdata"
+
 	"github.com/christianselig/apollo-backend/internal/cmd"
 )
 
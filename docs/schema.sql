@@ -8,7 +8,13 @@ CREATE TABLE accounts (
     last_message_id character varying(32) DEFAULT ''::character varying,
     next_notification_check_at timestamp without time zone,
     next_stuck_notification_check_at timestamp without time zone,
-    check_count integer DEFAULT 0
+    check_count integer DEFAULT 0,
+    is_deleted boolean DEFAULT false,
+    development boolean DEFAULT false,
+    idle_check_count integer DEFAULT 0,
+    revocation_confirmations integer DEFAULT 0,
+    created_at timestamp without time zone DEFAULT NOW(),
+    updated_at timestamp without time zone DEFAULT NOW()
 );
 
 CREATE TABLE devices (
@@ -16,7 +22,16 @@ CREATE TABLE devices (
     apns_token character varying(100) UNIQUE,
     sandbox boolean,
     expires_at timestamp without time zone,
-    grace_period_expires_at timestamp without time zone
+    grace_period_expires_at timestamp without time zone,
+    entitlement_active boolean DEFAULT false,
+    receipt_checked_at timestamp without time zone DEFAULT '1970-01-01 00:00:00',
+    receipt text DEFAULT ''::text,
+    quiet_hours_start integer DEFAULT 0,
+    quiet_hours_end integer DEFAULT 0,
+    quiet_hours_timezone character varying(64) DEFAULT ''::character varying,
+    is_deleted boolean DEFAULT false,
+    created_at timestamp without time zone DEFAULT NOW(),
+    updated_at timestamp without time zone DEFAULT NOW()
 );
 
 CREATE TABLE devices_accounts (
@@ -34,14 +49,17 @@ CREATE TABLE subreddits (
     id SERIAL PRIMARY KEY,
     subreddit_id character varying(32) DEFAULT ''::character varying UNIQUE,
     name character varying(32) DEFAULT ''::character varying,
-    next_check_at timestamp without time zone
+    next_check_at timestamp without time zone,
+    posts_per_hour real DEFAULT 0,
+    watcher_count integer DEFAULT 0
 );
 
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
     user_id character varying(32) DEFAULT ''::character varying UNIQUE,
     name character varying(32) DEFAULT ''::character varying,
-    next_check_at timestamp without time zone
+    next_check_at timestamp without time zone,
+    watcher_count integer DEFAULT 0
 );
 
 CREATE TABLE watchers (
@@ -59,6 +77,42 @@ CREATE TABLE watchers (
     type integer DEFAULT 0,
     label character varying(64) DEFAULT ''::character varying,
     author character varying(32) DEFAULT ''::character varying,
-    subreddit character varying(32) DEFAULT ''::character varying
+    subreddit character varying(32) DEFAULT ''::character varying,
+    exclude_nsfw boolean DEFAULT false,
+    exclude_crossposts boolean DEFAULT false,
+    sound character varying(32) DEFAULT ''::character varying
 );
 
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX watchers_search_idx ON watchers USING GIN (label gin_trgm_ops, keyword gin_trgm_ops, domain gin_trgm_ops);
+
+
+CREATE TABLE sent_notifications (
+    id SERIAL PRIMARY KEY,
+    device_id integer REFERENCES devices(id) ON DELETE CASCADE,
+    type character varying(64) DEFAULT ''::character varying,
+    title text DEFAULT ''::text,
+    created_at timestamp without time zone
+);
+
+CREATE INDEX sent_notifications_device_id_created_at_idx ON sent_notifications(device_id int4_ops,created_at timestamp_ops);
+CREATE INDEX sent_notifications_created_at_idx ON sent_notifications(created_at timestamp_ops);
+
+CREATE TABLE live_activities (
+    id SERIAL PRIMARY KEY,
+    apns_token character varying(100) UNIQUE,
+    reddit_account_id character varying(32) DEFAULT ''::character varying,
+    access_token character varying(64) DEFAULT ''::character varying,
+    refresh_token character varying(64) DEFAULT ''::character varying,
+    token_expires_at timestamp without time zone,
+    thread_id character varying(32) DEFAULT ''::character varying,
+    subreddit character varying(32) DEFAULT ''::character varying,
+    keyword character varying(32) DEFAULT ''::character varying,
+    next_check_at timestamp without time zone,
+    expires_at timestamp without time zone,
+    development boolean DEFAULT false,
+    renew_on_activity boolean DEFAULT false,
+    created_at timestamp without time zone DEFAULT NOW(),
+    last_comment_id character varying(32) DEFAULT ''::character varying,
+    reply_token_hash character varying(64) DEFAULT ''::character varying
+);
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -154,6 +155,11 @@ func (a *api) upsertAccountsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if err := checkScopes(tokens); err != nil {
+			a.errorResponse(w, r, 422, err)
+			return
+		}
+
 		// Reset expiration timer
 		acc.TokenExpiresAt = time.Now().Add(tokens.Expiry)
 		acc.RefreshToken = tokens.RefreshToken
@@ -188,15 +194,12 @@ func (a *api) upsertAccountsHandler(w http.ResponseWriter, r *http.Request) {
 			acc.CheckCount = 1
 		}
 
-		if err := a.accountRepo.CreateOrUpdate(ctx, &acc); err != nil {
-			a.errorResponse(w, r, 422, err)
-			return
-		}
-
-		if err := a.accountRepo.Associate(ctx, &acc, &dev); err != nil {
+		outcome, err := a.accountRepo.UpsertAndAssociate(ctx, &acc, &dev)
+		if err != nil {
 			a.errorResponse(w, r, 422, err)
 			return
 		}
+		a.recordUpsert(ctx, &acc, outcome)
 	}
 
 	for _, acc := range accsMap {
@@ -215,7 +218,7 @@ func (a *api) upsertAccountHandler(w http.ResponseWriter, r *http.Request) {
 	var acct domain.Account
 
 	if err := json.NewDecoder(r.Body).Decode(&acct); err != nil {
-		a.logger.Error("failed to parse request json", zap.Error(err))
+		a.requestLogger(ctx).Error("failed to parse request json", zap.Error(err))
 		a.errorResponse(w, r, 422, err)
 		return
 	}
@@ -224,7 +227,13 @@ func (a *api) upsertAccountHandler(w http.ResponseWriter, r *http.Request) {
 	rac := a.reddit.NewAuthenticatedClient(reddit.SkipRateLimiting, acct.RefreshToken, acct.AccessToken)
 	tokens, err := rac.RefreshTokens(ctx)
 	if err != nil {
-		a.logger.Error("failed to refresh token", zap.Error(err))
+		a.requestLogger(ctx).Error("failed to refresh token", zap.Error(err))
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	if err := checkScopes(tokens); err != nil {
+		a.requestLogger(ctx).Info("account is missing required scopes", zap.Error(err))
 		a.errorResponse(w, r, 422, err)
 		return
 	}
@@ -238,14 +247,14 @@ func (a *api) upsertAccountHandler(w http.ResponseWriter, r *http.Request) {
 	me, err := rac.Me(ctx)
 
 	if err != nil {
-		a.logger.Error("failed to grab user details from reddit", zap.Error(err))
+		a.requestLogger(ctx).Error("failed to grab user details from reddit", zap.Error(err))
 		a.errorResponse(w, r, 500, err)
 		return
 	}
 
 	if me.NormalizedUsername() != acct.NormalizedUsername() {
 		err := fmt.Errorf("wrong user: expected %s, got %s", me.NormalizedUsername(), acct.NormalizedUsername())
-		a.logger.Warn("user is not who they say they are", zap.Error(err))
+		a.requestLogger(ctx).Warn("user is not who they say they are", zap.Error(err))
 		a.errorResponse(w, r, 401, err)
 		return
 	}
@@ -267,23 +276,42 @@ func (a *api) upsertAccountHandler(w http.ResponseWriter, r *http.Request) {
 	// Associate
 	dev, err := a.deviceRepo.GetByAPNSToken(ctx, vars["apns"])
 	if err != nil {
-		a.logger.Error("failed to fetch device from database", zap.Error(err))
+		a.requestLogger(ctx).Error("failed to fetch device from database", zap.Error(err))
 		a.errorResponse(w, r, 500, err)
 		return
 	}
 
-	// Upsert account
-	if err := a.accountRepo.CreateOrUpdate(ctx, &acct); err != nil {
-		a.logger.Error("failed to update account", zap.Error(err))
+	// Upsert account and associate it with the device
+	outcome, err := a.accountRepo.UpsertAndAssociate(ctx, &acct, &dev)
+	if err != nil {
+		a.requestLogger(ctx).Error("failed to upsert and associate account", zap.Error(err))
 		a.errorResponse(w, r, 500, err)
 		return
 	}
+	a.recordUpsert(ctx, &acct, outcome)
 
-	if err := a.accountRepo.Associate(ctx, &acct, &dev); err != nil {
-		a.logger.Error("failed to associate account with device", zap.Error(err))
-		a.errorResponse(w, r, 500, err)
-		return
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkScopes returns an error listing the required scopes the refreshed tokens weren't
+// granted, if any.
+func checkScopes(tokens *reddit.RefreshTokenResponse) error {
+	missing := tokens.MissingScopes(reddit.RequiredScopes)
+	if len(missing) == 0 {
+		return nil
 	}
+	return fmt.Errorf("%w: %s", reddit.ErrMissingScopes, strings.Join(missing, ", "))
+}
 
-	w.WriteHeader(http.StatusOK)
+func (a *api) recordUpsert(ctx context.Context, acc *domain.Account, outcome domain.UpsertOutcome) {
+	created := outcome == domain.UpsertCreated
+	_ = a.statsd.Incr("api.accounts.upserted", []string{fmt.Sprintf("created:%t", created)}, 1)
+
+	if outcome == domain.UpsertReactivated {
+		_ = a.statsd.Incr("api.accounts.reactivated", nil, 1)
+		a.requestLogger(ctx).Info("reactivated deleted account",
+			zap.String("account#username", acc.NormalizedUsername()),
+			zap.Int64("account#id", acc.ID),
+		)
+	}
 }
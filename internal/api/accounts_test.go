@@ -0,0 +1,58 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+
+	"github.com/christianselig/apollo-backend/internal/api"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestUpsertAccountHandler_MissingScopes(t *testing.T) {
+	t.Parallel()
+
+	client := &http.Client{
+		Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			body := `{"access_token": "xxx", "refresh_token": "yyy", "expires_in": 3600, "scope": "identity read"}`
+			return &http.Response{
+				StatusCode: 200,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		}),
+	}
+	rc := reddit.NewClient("<ID>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1, reddit.WithClient(client))
+	a := api.NewTestRedditAPI(nil, rc)
+
+	body := `{"Username": "hugocat", "RefreshToken": "<REFRESH>", "AccessToken": "<ACCESS>"}`
+	req := httptest.NewRequest("POST", "/v1/device/"+oldToken+"/account", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	a.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	var res struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+	assert.Equal(t, "missing_scopes", res.Error.Code)
+	assert.Equal(t, "missing required scopes: history, privatemessages", res.Error.Message)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+const (
+	adminSecretHeader = "X-Apollo-Admin-Secret"
+
+	defaultWatcherSearchLimit = 50
+	maxWatcherSearchLimit     = 200
+)
+
+var ErrAdminUnauthorized = errors.New("missing or invalid admin secret")
+
+// adminOnly lets requests through to next only when they carry the shared admin secret.
+// Without ADMIN_SECRET set, nobody gets in.
+func (a *api) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := r.Header.Get(adminSecretHeader)
+		if a.adminSecret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(a.adminSecret)) != 1 {
+			a.errorResponse(w, r, http.StatusUnauthorized, ErrAdminUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+type adminWatcherItem struct {
+	watcherItem
+
+	DeviceID        int64  `json:"device_id"`
+	RedditAccountID string `json:"reddit_account_id"`
+}
+
+func (a *api) searchWatchersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	query := r.URL.Query().Get("q")
+	limit := defaultWatcherSearchLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil {
+			a.errorResponse(w, r, 422, validation.Errors{"limit": err})
+			return
+		}
+	}
+
+	errs := validation.Errors{
+		"q":     validation.Validate(query, validation.Required, validation.Length(3, 64)),
+		"limit": validation.Validate(limit, validation.Min(1), validation.Max(maxWatcherSearchLimit)),
+	}
+	if err := errs.Filter(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	watchers, err := a.watcherRepo.Search(ctx, query, limit)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	items := make([]adminWatcherItem, len(watchers))
+	for i, watcher := range watchers {
+		items[i] = adminWatcherItem{
+			watcherItem: watcherItem{
+				ID:          watcher.ID,
+				CreatedAt:   watcher.CreatedAt,
+				Type:        watcher.Type.String(),
+				Label:       watcher.Label,
+				SourceLabel: watcher.WatcheeLabel,
+				Keyword:     watcher.Keyword,
+				Flair:       watcher.Flair,
+				Domain:      watcher.Domain,
+				Hits:        watcher.Hits,
+				Author:      watcher.Author,
+				Upvotes:     watcher.Upvotes,
+				Sound:       watcher.NotificationSound(),
+
+				ExcludeNSFW:       watcher.ExcludeNSFW,
+				ExcludeCrossposts: watcher.ExcludeCrossposts,
+			},
+			DeviceID:        watcher.DeviceID,
+			RedditAccountID: watcher.Account.AccountID,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(items)
+}
@@ -0,0 +1,96 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/api"
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestAdminOnly(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		secret string
+		header string
+		want   int
+	}{
+		"missing header": {"hunter2", "", http.StatusUnauthorized},
+		"wrong secret":   {"hunter2", "hunter3", http.StatusUnauthorized},
+		"not configured": {"", "", http.StatusUnauthorized},
+		// Gets past the guard and fails validation on the missing query instead.
+		"right secret": {"hunter2", "hunter2", http.StatusUnprocessableEntity},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/admin/watchers", nil)
+			if tc.header != "" {
+				req.Header.Set("X-Apollo-Admin-Secret", tc.header)
+			}
+			rr := httptest.NewRecorder()
+
+			api.NewTestAdminAPI(nil, tc.secret).ServeHTTP(rr, req)
+			assert.Equal(t, tc.want, rr.Code, rr.Body.String())
+		})
+	}
+}
+
+func TestSearchWatchersHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	deviceRepo := repository.NewPostgresDevice(tx)
+	accountRepo := repository.NewPostgresAccount(tx)
+	watcherRepo := repository.NewPostgresWatcher(tx)
+
+	dev := &domain.Device{APNSToken: oldToken, ExpiresAt: time.Now().Add(time.Hour), GracePeriodExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, deviceRepo.Create(ctx, dev))
+
+	acc := &domain.Account{Username: "janedoe", AccountID: "abc123", TokenExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, accountRepo.Create(ctx, acc))
+
+	scam := &domain.Watcher{Label: "deals", Domain: "free-apollo-gold.example", DeviceID: dev.ID, AccountID: acc.ID, Type: domain.SubredditWatcher, WatcheeID: 1}
+	require.NoError(t, watcherRepo.Create(ctx, scam))
+	other := &domain.Watcher{Label: "pics", Keyword: "cats", DeviceID: dev.ID, AccountID: acc.ID, Type: domain.SubredditWatcher, WatcheeID: 1}
+	require.NoError(t, watcherRepo.Create(ctx, other))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/admin/watchers?q=APOLLO-GOLD", nil)
+	req.Header.Set("X-Apollo-Admin-Secret", "hunter2")
+	rr := httptest.NewRecorder()
+
+	api.NewTestAdminAPI(tx, "hunter2").ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var res []struct {
+		ID              int64  `json:"id"`
+		Domain          string `json:"domain"`
+		DeviceID        int64  `json:"device_id"`
+		RedditAccountID string `json:"reddit_account_id"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+	require.Len(t, res, 1)
+	assert.Equal(t, scam.ID, res[0].ID)
+	assert.Equal(t, "free-apollo-gold.example", res[0].Domain)
+	assert.Equal(t, dev.ID, res[0].DeviceID)
+	assert.Equal(t, "abc123", res[0].RedditAccountID)
+}
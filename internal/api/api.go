@@ -6,6 +6,8 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
@@ -18,17 +20,28 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
 
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
 	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/itunes"
 	"github.com/christianselig/apollo-backend/internal/reddit"
 	"github.com/christianselig/apollo-backend/internal/repository"
 )
 
 type api struct {
 	logger     *zap.Logger
-	statsd     *statsd.Client
+	statsd     statsd.ClientInterface
 	reddit     *reddit.Client
 	apns       *token.Token
 	httpClient *http.Client
+	pool       *pgxpool.Pool
+	redis      *redis.Client
+
+	receiptVerifier *itunes.CircuitBreaker
+
+	contactSender  contactSender
+	contactLimiter rateLimiter
+
+	adminSecret string
 
 	accountRepo      domain.AccountRepository
 	deviceRepo       domain.DeviceRepository
@@ -36,49 +49,56 @@ type api struct {
 	watcherRepo      domain.WatcherRepository
 	userRepo         domain.UserRepository
 	liveActivityRepo domain.LiveActivityRepository
+
+	sentNotificationRepo domain.SentNotificationRepository
 }
 
-func NewAPI(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, redis *redis.Client, pool *pgxpool.Pool) *api {
+func NewAPI(ctx context.Context, logger *zap.Logger, statsd statsd.ClientInterface, redis *redis.Client, pool *pgxpool.Pool) *api {
 	tracer := otel.Tracer("api")
 
-	reddit := reddit.NewClient(
-		os.Getenv("REDDIT_CLIENT_ID"),
-		os.Getenv("REDDIT_CLIENT_SECRET"),
-		tracer,
-		statsd,
-		redis,
-		16,
-	)
-
-	var apns *token.Token
-	{
-		authKey, err := token.AuthKeyFromFile(os.Getenv("APPLE_KEY_PATH"))
-		if err != nil {
-			panic(err)
-		}
+	reddit := cmdutil.NewRedditClient(tracer, statsd, redis, 16)
 
-		apns = &token.Token{
-			AuthKey: authKey,
-			KeyID:   os.Getenv("APPLE_KEY_ID"),
-			TeamID:  os.Getenv("APPLE_TEAM_ID"),
-		}
+	apns, err := cmdutil.NewAPNSToken()
+	if err != nil {
+		panic(err)
 	}
 
-	accountRepo := repository.NewPostgresAccount(pool)
-	deviceRepo := repository.NewPostgresDevice(pool)
-	subredditRepo := repository.NewPostgresSubreddit(pool)
-	watcherRepo := repository.NewPostgresWatcher(pool)
-	userRepo := repository.NewPostgresUser(pool)
-	liveActivityRepo := repository.NewPostgresLiveActivity(pool)
+	conn := repository.WithAcquireTimeout(pool, repository.DefaultAcquireTimeout)
+	accountRepo := repository.NewPostgresAccount(conn)
+	deviceRepo := repository.NewPostgresDevice(conn)
+	subredditRepo := repository.NewPostgresSubreddit(conn)
+	watcherRepo := repository.NewPostgresWatcher(conn)
+	userRepo := repository.NewPostgresUser(conn)
+	liveActivityRepo := repository.NewPostgresLiveActivity(conn)
+	sentNotificationRepo := repository.NewPostgresSentNotification(conn)
 
 	client := &http.Client{}
 
+	threshold, err := strconv.Atoi(os.Getenv("ITUNES_CIRCUIT_BREAKER_THRESHOLD"))
+	if err != nil {
+		threshold = itunes.DefaultCircuitBreakerThreshold
+	}
+	cooldown := cmdutil.DurationFromEnv("ITUNES_CIRCUIT_BREAKER_COOLDOWN", itunes.DefaultCircuitBreakerCooldown)
+	receiptVerifier := itunes.NewCircuitBreaker(threshold, cooldown)
+
 	return &api{
 		logger:     logger,
 		statsd:     statsd,
 		reddit:     reddit,
 		apns:       apns,
 		httpClient: client,
+		pool:       pool,
+		redis:      redis,
+
+		receiptVerifier: receiptVerifier,
+
+		contactSender: smtp2goSender{},
+		contactLimiter: &redisRateLimiter{
+			redis:  redis,
+			prefix: "ratelimit:contact",
+			limit:  contactRateLimit,
+			window: contactRateLimitWindow,
+		},
 
 		accountRepo:      accountRepo,
 		deviceRepo:       deviceRepo,
@@ -86,6 +106,10 @@ func NewAPI(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, redi
 		watcherRepo:      watcherRepo,
 		userRepo:         userRepo,
 		liveActivityRepo: liveActivityRepo,
+
+		sentNotificationRepo: sentNotificationRepo,
+
+		adminSecret: os.Getenv("ADMIN_SECRET"),
 	}
 }
 
@@ -102,14 +126,16 @@ func (a *api) Routes() *mux.Router {
 	r.HandleFunc("/v1/health", a.healthCheckHandler).Methods("GET")
 
 	r.HandleFunc("/v1/device", a.upsertDeviceHandler).Methods("POST")
+	r.HandleFunc("/v1/device/migrate", a.migrateDeviceHandler).Methods("POST")
 	r.HandleFunc("/v1/device/{apns}", a.deleteDeviceHandler).Methods("DELETE")
 	r.HandleFunc("/v1/device/{apns}/test", a.testDeviceHandler).Methods("POST")
-	r.HandleFunc("/v1/device/{apns}/test/comment_reply", generateNotificationTester(a, commentReply)).Methods("POST")
-	r.HandleFunc("/v1/device/{apns}/test/post_reply", generateNotificationTester(a, postReply)).Methods("POST")
-	r.HandleFunc("/v1/device/{apns}/test/private_message", generateNotificationTester(a, privateMessage)).Methods("POST")
-	r.HandleFunc("/v1/device/{apns}/test/subreddit_watcher", generateNotificationTester(a, subredditWatcher)).Methods("POST")
-	r.HandleFunc("/v1/device/{apns}/test/trending_post", generateNotificationTester(a, trendingPost)).Methods("POST")
-	r.HandleFunc("/v1/device/{apns}/test/username_mention", generateNotificationTester(a, usernameMention)).Methods("POST")
+	r.HandleFunc("/v1/device/{apns}/rotate", a.rotateDeviceHandler).Methods("POST")
+	r.HandleFunc("/v1/device/{apns}/receipt/refresh", a.adminOnly(a.refreshReceiptHandler)).Methods("POST")
+	r.HandleFunc("/v1/device/{apns}/notifications", a.notificationsDeviceHandler).Methods("PATCH")
+	r.HandleFunc("/v1/device/{apns}/quiet_hours", a.quietHoursDeviceHandler).Methods("PATCH")
+	r.HandleFunc("/v1/device/{apns}/notifications/history", a.notificationHistoryHandler).Methods("GET")
+	r.HandleFunc("/v1/device/{apns}/stats", a.notificationStatsHandler).Methods("GET")
+	r.HandleFunc("/v1/device/{apns}/test/{category}", a.testNotificationHandler).Methods("POST")
 
 	r.HandleFunc("/v1/device/{apns}/account", a.upsertAccountHandler).Methods("POST")
 	r.HandleFunc("/v1/device/{apns}/accounts", a.upsertAccountsHandler).Methods("POST")
@@ -123,6 +149,7 @@ func (a *api) Routes() *mux.Router {
 	r.HandleFunc("/v1/device/{apns}/account/{redditID}/watchers", a.listWatchersHandler).Methods("GET")
 
 	r.HandleFunc("/v1/live_activities", a.createLiveActivityHandler).Methods("POST")
+	r.HandleFunc("/v1/live_activities/{apns}/comment", a.commentLiveActivityHandler).Methods("POST")
 
 	r.HandleFunc("/v1/receipt", a.checkReceiptHandler).Methods("POST")
 	r.HandleFunc("/v1/receipt/{apns}", a.checkReceiptHandler).Methods("POST")
@@ -131,8 +158,11 @@ func (a *api) Routes() *mux.Router {
 
 	r.HandleFunc("/v1/test/bugsnag", a.testBugsnagHandler).Methods("POST")
 
-	r.Use(a.loggingMiddleware)
+	r.HandleFunc("/v1/admin/watchers", a.adminOnly(a.searchWatchersHandler)).Methods("GET")
+
 	r.Use(a.requestIdMiddleware)
+	r.Use(a.loggingMiddleware)
+	r.Use(a.apnsTokenMiddleware)
 
 	return r
 }
@@ -166,14 +196,85 @@ func (lrw *LoggingResponseWriter) WriteHeader(statusCode int) {
 	lrw.statusCode = statusCode
 }
 
+type requestIDKey struct{}
+
+// maxRequestIDLength caps how much of a client supplied X-Request-ID we are willing to
+// echo back and log.
+const maxRequestIDLength = 64
+
+// validRequestID reports whether a client supplied request ID is short enough and only
+// uses characters that are safe to put in headers and logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestIdMiddleware tags each request with the client's X-Request-ID, or a fresh one
+// if it didn't send a usable one, and makes it available to everything downstream
+// through the request's context.
 func (a *api) requestIdMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		id := uuid.Must(uuid.NewV4()).String()
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = uuid.Must(uuid.NewV4()).String()
+		}
+
+		w.Header().Set("X-Request-ID", id)
 		w.Header().Set("X-Apollo-Request-Id", id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestIDFromContext returns the ID of the request ctx belongs to, if any.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns a logger that tags everything with the request's ID.
+func (a *api) requestLogger(ctx context.Context) *zap.Logger {
+	return a.logger.With(zap.String("request#id", requestIDFromContext(ctx)))
+}
+
+// apnsTokenMiddleware rejects requests whose {apns} path parameter could never be a
+// device token, before any handler goes looking for it in the database.
+func (a *api) apnsTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apns, ok := mux.Vars(r)["apns"]; ok {
+			dev := &domain.Device{APNSToken: apns}
+			if err := dev.Validate(); err != nil {
+				a.errorResponse(w, r, 422, err)
+				return
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// obfuscatedURI is the request URI with any APNs token in the path obfuscated.
+func obfuscatedURI(r *http.Request) string {
+	apns := mux.Vars(r)["apns"]
+	if apns == "" {
+		return r.RequestURI
+	}
+	return strings.Replace(r.RequestURI, apns, domain.ObfuscateToken(apns), 1)
+}
+
 func (a *api) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip logging health checks
@@ -205,8 +306,8 @@ func (a *api) loggingMiddleware(next http.Handler) http.Handler {
 			zap.String("remote#addr", remoteAddr),
 			zap.Int("response#bytes", lrw.bytes),
 			zap.Int("status", lrw.statusCode),
-			zap.String("uri", r.RequestURI),
-			zap.String("request#id", lrw.Header().Get("X-Apollo-Request-Id")),
+			zap.String("uri", obfuscatedURI(r)),
+			zap.String("request#id", requestIDFromContext(r.Context())),
 		}
 
 		if lrw.statusCode == 200 {
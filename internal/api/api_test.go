@@ -0,0 +1,102 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/api"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		header   string
+		generate bool
+	}{
+		"uses the client's ID":   {"abc-123", false},
+		"generates a missing ID": {"", true},
+		"replaces a long ID":     {strings.Repeat("a", 65), true},
+		"replaces unsafe IDs":    {"abc 123\tinjected=1", true},
+		"keeps a 64 char ID":     {strings.Repeat("a", 64), false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			var seen string
+			handler := api.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = api.RequestIDFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/health", nil)
+			if tc.header != "" {
+				req.Header.Set("X-Request-ID", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if tc.generate {
+				assert.Len(t, seen, 36)
+			} else {
+				assert.Equal(t, tc.header, seen)
+			}
+			assert.Equal(t, seen, rr.Header().Get("X-Request-ID"))
+			assert.Equal(t, seen, rr.Header().Get("X-Apollo-Request-Id"))
+		})
+	}
+}
+
+func TestAPNSTokenMiddleware(t *testing.T) {
+	t.Parallel()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/device", func(w http.ResponseWriter, r *http.Request) {}).Methods("POST")
+	r.HandleFunc("/v1/device/{apns}/test", func(w http.ResponseWriter, r *http.Request) {}).Methods("POST")
+	r.Use(api.APNSTokenMiddleware)
+
+	tt := map[string]struct {
+		path string
+		want int
+	}{
+		"valid token":     {"/v1/device/" + oldToken + "/test", http.StatusOK},
+		"short token":     {"/v1/device/abc123/test", http.StatusUnprocessableEntity},
+		"overlong token":  {"/v1/device/" + strings.Repeat("a", 201) + "/test", http.StatusUnprocessableEntity},
+		"non-hex token":   {"/v1/device/" + strings.Repeat("z", 64) + "/test", http.StatusUnprocessableEntity},
+		"no token in url": {"/v1/device", http.StatusOK},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, tc.path, nil))
+
+			assert.Equal(t, tc.want, rr.Code)
+			if tc.want != http.StatusOK {
+				assert.Contains(t, rr.Body.String(), "validation_failed")
+			}
+		})
+	}
+}
+
+func TestRoutesRejectMalformedTokens(t *testing.T) {
+	t.Parallel()
+
+	// There's no database behind this API, so getting a 422 back means the request
+	// never made it as far as a query.
+	handler := api.NewTestAPI(nil)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/device/not-a-token/test", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+}
@@ -1,34 +1,176 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/mail"
+	"strings"
+	"time"
 
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-redis/redis/v8"
 	"github.com/smtp2go-oss/smtp2go-go"
+	"go.uber.org/zap"
 )
 
+const (
+	contactRateLimit       = 5
+	contactRateLimitWindow = time.Hour
+	contactMaxBodyLength   = 5000
+	contactMaxSubjectLen   = 200
+)
+
+var ErrContactRateLimited = errors.New("too many messages, try again later")
+
+type contactMessage struct {
+	Subject string
+	Body    string
+	ReplyTo string
+}
+
+// contactSender delivers messages sent through the contact form.
+type contactSender interface {
+	Send(ctx context.Context, msg contactMessage) error
+}
+
+// rateLimiter reports whether another action is allowed for key.
+type rateLimiter interface {
+	Allow(ctx context.Context, key string) (bool, error)
+}
+
+type smtp2goSender struct{}
+
+func (smtp2goSender) Send(_ context.Context, msg contactMessage) error {
+	body := msg.Body
+	if msg.ReplyTo != "" {
+		body = fmt.Sprintf("Reply to: %s\n\n%s", msg.ReplyTo, body)
+	}
+
+	_, err := smtp2go.Send(&smtp2go.Email{
+		From:     "🤖 Apollo API <robot@apollonotifications.com>",
+		To:       []string{"ultrasurvey@apolloapp.io"},
+		Subject:  msg.Subject,
+		TextBody: body,
+	})
+	return err
+}
+
+// rateLimitScript counts an action and starts the window on the first one in the same
+// step, so a crash between the two can't leave a counter that never expires.
+var rateLimitScript = redis.NewScript(`
+	local count = redis.call("INCR", KEYS[1])
+	if count == 1 then
+		redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	end
+	return count
+`)
+
+// redisRateLimiter allows up to limit actions per key in each fixed window.
+type redisRateLimiter struct {
+	redis  *redis.Client
+	prefix string
+	limit  int64
+	window time.Duration
+}
+
+func (rl *redisRateLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	key = fmt.Sprintf("%s:%s", rl.prefix, key)
+
+	count, err := rateLimitScript.Run(ctx, rl.redis, []string{key}, rl.window.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+
+	return count <= rl.limit, nil
+}
+
 type sendMessageRequest struct {
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+	ReplyTo   string `json:"reply_to"`
+	APNSToken string `json:"apns_token"`
+
+	// Older clients send the subject as the title.
 	Title string `json:"title"`
-	Body  string `json:"body"`
+}
+
+func (smr *sendMessageRequest) Validate() error {
+	return validation.ValidateStruct(smr,
+		validation.Field(&smr.Subject, validation.Length(0, contactMaxSubjectLen)),
+		validation.Field(&smr.Body, validation.Required, validation.Length(1, contactMaxBodyLength)),
+		validation.Field(&smr.ReplyTo, validation.By(func(value interface{}) error {
+			if addr, _ := value.(string); addr != "" {
+				if _, err := mail.ParseAddress(addr); err != nil {
+					return errors.New("must be a valid email address")
+				}
+			}
+			return nil
+		})),
+		validation.Field(&smr.APNSToken, validation.Length(64, 200)),
+	)
 }
 
 func (a *api) contactHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
 	smr := &sendMessageRequest{}
 	if err := json.NewDecoder(r.Body).Decode(smr); err != nil {
-		a.errorResponse(w, r, 500, err)
+		a.errorResponse(w, r, 400, err)
 		return
 	}
 
-	msg := &smtp2go.Email{
-		From:     "🤖 Apollo API <robot@apollonotifications.com>",
-		To:       []string{"ultrasurvey@apolloapp.io"},
-		Subject:  smr.Title,
-		TextBody: smr.Body,
+	if smr.Subject == "" {
+		smr.Subject = smr.Title
+	}
+
+	if err := smr.Validate(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
 	}
 
-	if _, err := smtp2go.Send(msg); err != nil {
+	ok, err := a.contactLimiter.Allow(ctx, contactRateLimitKey(r))
+	if err != nil {
+		a.requestLogger(ctx).Error("failed to check contact rate limit", zap.Error(err))
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+	if !ok {
+		a.errorResponse(w, r, 429, ErrContactRateLimited)
+		return
+	}
+
+	msg := contactMessage{Subject: smr.Subject, Body: smr.Body, ReplyTo: smr.ReplyTo}
+	if err := a.contactSender.Send(ctx, msg); err != nil {
 		a.errorResponse(w, r, 500, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+// contactRateLimitKey limits by the address the request came from. The device token in
+// the body is up to the sender, so it can't be what they're counted by.
+func contactRateLimitKey(r *http.Request) string {
+	return fmt.Sprintf("ip:%s", clientIP(r))
+}
+
+// clientIP is the address of whoever connected to our load balancer. The balancer
+// appends it to X-Forwarded-For, so only the last entry can be trusted; anything before
+// it came from the client.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		hops := strings.Split(fwd, ",")
+		if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+			return ip
+		}
+	}
+
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return ip
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,132 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/api"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestContactHandler(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		body    string
+		allowed bool
+		status  int
+		want    *api.ContactMessage
+	}{
+		"valid message": {
+			`{"subject": "Hi", "body": "Love the app", "reply_to": "jane@example.com"}`,
+			true, http.StatusOK,
+			&api.ContactMessage{Subject: "Hi", Body: "Love the app", ReplyTo: "jane@example.com"},
+		},
+		"title from older clients": {
+			`{"title": "Hi", "body": "Love the app"}`,
+			true, http.StatusOK,
+			&api.ContactMessage{Subject: "Hi", Body: "Love the app"},
+		},
+		"empty body":       {`{"subject": "Hi", "body": ""}`, true, http.StatusUnprocessableEntity, nil},
+		"overlong body":    {fmt.Sprintf(`{"body": "%s"}`, strings.Repeat("a", 5001)), true, http.StatusUnprocessableEntity, nil},
+		"invalid reply to": {`{"body": "Hi", "reply_to": "not an email"}`, true, http.StatusUnprocessableEntity, nil},
+		"invalid token":    {`{"body": "Hi", "apns_token": "abc"}`, true, http.StatusUnprocessableEntity, nil},
+		"malformed json":   {`{"body": `, true, http.StatusBadRequest, nil},
+		"rate limited":     {`{"body": "Hi"}`, false, http.StatusTooManyRequests, nil},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			var sent *api.ContactMessage
+			send := func(_ context.Context, msg api.ContactMessage) error {
+				sent = &msg
+				return nil
+			}
+			allow := func(context.Context, string) (bool, error) { return tc.allowed, nil }
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/v1/contact", strings.NewReader(tc.body))
+			api.NewTestContactAPI(send, allow).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code, rr.Body.String())
+			assert.Equal(t, tc.want, sent)
+		})
+	}
+}
+
+func TestContactHandlerRateLimitKey(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		body      string
+		forwarded string
+		want      string
+	}{
+		"forwarded":            {`{"body": "Hi"}`, "203.0.113.7", "ip:203.0.113.7"},
+		"spoofed forwarding":   {`{"body": "Hi"}`, "198.51.100.1, 203.0.113.7", "ip:203.0.113.7"},
+		"direct":               {`{"body": "Hi"}`, "", "ip:192.0.2.1"},
+		"device token in body": {fmt.Sprintf(`{"body": "Hi", "apns_token": "%s"}`, oldToken), "203.0.113.7", "ip:203.0.113.7"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			var key string
+			send := func(context.Context, api.ContactMessage) error { return nil }
+			allow := func(_ context.Context, k string) (bool, error) {
+				key = k
+				return true, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/contact", strings.NewReader(tc.body))
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			api.NewTestContactAPI(send, allow).ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tc.want, key)
+		})
+	}
+}
+
+func TestRedisRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	rdb := testhelper.NewTestRedisClient(t)
+
+	prefix := fmt.Sprintf("ratelimit:test:%d", time.Now().UnixNano())
+	allow := api.NewTestRateLimiter(rdb, prefix, 5, time.Minute)
+	t.Cleanup(func() { _ = rdb.Del(ctx, prefix+":device", prefix+":other").Err() })
+
+	for i := 0; i < 5; i++ {
+		ok, err := allow(ctx, "device")
+		require.NoError(t, err)
+		assert.True(t, ok, "attempt %d", i+1)
+	}
+
+	ok, err := allow(ctx, "device")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = allow(ctx, "other")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ttl, err := rdb.TTL(ctx, prefix+":device").Result()
+	require.NoError(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+}
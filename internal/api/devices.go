@@ -3,12 +3,15 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize/english"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/gorilla/mux"
 	"github.com/sideshow/apns2"
 	"github.com/sideshow/apns2/payload"
@@ -32,10 +35,12 @@ func (a *api) upsertDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	d.ExpiresAt = time.Now().Add(domain.DeviceReceiptCheckPeriodDuration)
 	d.GracePeriodExpiresAt = d.ExpiresAt.Add(domain.DeviceGracePeriodAfterReceiptExpiry)
 
-	if err := a.deviceRepo.CreateOrUpdate(ctx, d); err != nil {
+	created, err := a.deviceRepo.CreateOrUpdate(ctx, d)
+	if err != nil {
 		a.errorResponse(w, r, 500, err)
 		return
 	}
+	_ = a.statsd.Incr("api.devices.upserted", []string{fmt.Sprintf("created:%t", created)}, 1)
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -49,7 +54,7 @@ func (a *api) testDeviceHandler(w http.ResponseWriter, r *http.Request) {
 
 	d, err := a.deviceRepo.GetByAPNSToken(ctx, tok)
 	if err != nil {
-		a.logger.Error("failed to fetch device from database", zap.Error(err))
+		a.requestLogger(ctx).Error("failed to fetch device from database", zap.Error(err))
 		a.errorResponse(w, r, 500, err)
 		return
 	}
@@ -85,7 +90,7 @@ func (a *api) testDeviceHandler(w http.ResponseWriter, r *http.Request) {
 
 	res, err := client.Push(notification)
 	if err != nil {
-		a.logger.Info("failed to send test notification", zap.Error(err))
+		a.requestLogger(ctx).Info("failed to send test notification", zap.Error(err))
 		a.errorResponse(w, r, 500, err)
 	} else if !res.Sent() {
 		a.errorResponse(w, r, 422, fmt.Errorf("errror sending notification: %d: %s", res.StatusCode, res.Reason))
@@ -120,3 +125,267 @@ func (a *api) deleteDeviceHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+type rotateDeviceRequest struct {
+	APNSToken string `json:"apns_token"`
+}
+
+func (rdr *rotateDeviceRequest) Validate() error {
+	return validation.ValidateStruct(rdr,
+		validation.Field(&rdr.APNSToken, validation.Required, validation.By(validAPNSToken)),
+	)
+}
+
+// rotateDeviceHandler moves a device over to the new token iOS handed out, so its
+// accounts and watchers carry over instead of having to be set up again.
+func (a *api) rotateDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+
+	rdr := &rotateDeviceRequest{}
+	if err := json.NewDecoder(r.Body).Decode(rdr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	if err := rdr.Validate(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, vars["apns"])
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	if err := a.deviceRepo.UpdateToken(ctx, &dev, rdr.APNSToken); err != nil {
+		if errors.Is(err, domain.ErrConflict) {
+			a.errorResponse(w, r, 409, ErrDuplicateAPNSToken)
+			return
+		}
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// validAPNSToken checks a token in a request body the same way apnsTokenMiddleware
+// checks the one in the path.
+func validAPNSToken(value interface{}) error {
+	token, _ := value.(string)
+	return (&domain.Device{APNSToken: token}).Validate()
+}
+
+type migrateDeviceRequest struct {
+	OldAPNSToken string `json:"old_apns"`
+	NewAPNSToken string `json:"new_apns"`
+}
+
+func (mdr *migrateDeviceRequest) Validate() error {
+	return validation.ValidateStruct(mdr,
+		validation.Field(&mdr.OldAPNSToken, validation.Required, validation.Length(64, 200)),
+		validation.Field(&mdr.NewAPNSToken, validation.Required, validation.By(validAPNSToken), validation.NotIn(mdr.OldAPNSToken)),
+	)
+}
+
+// migrateDeviceHandler moves a device's accounts, watchers and settings over to the new
+// token iOS handed out, even when the app already registered that token as a device of
+// its own, so notifications keep flowing throughout.
+func (a *api) migrateDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	mdr := &migrateDeviceRequest{}
+	if err := json.NewDecoder(r.Body).Decode(mdr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	if err := mdr.Validate(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	dev, err := a.deviceRepo.Migrate(ctx, mdr.OldAPNSToken, mdr.NewAPNSToken)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			a.errorResponse(w, r, 404, err)
+			return
+		}
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	a.requestLogger(ctx).Info("migrated device",
+		zap.String("device#old_token", domain.ObfuscateToken(mdr.OldAPNSToken)),
+		zap.String("device#token", dev.ObfuscatedToken()),
+	)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type deviceNotificationsRequest struct {
+	InboxNotifications   *bool `json:"inbox_notifications"`
+	WatcherNotifications *bool `json:"watcher_notifications"`
+	GlobalMute           *bool `json:"global_mute"`
+}
+
+// notificationsDeviceHandler updates the notification settings of every account on a
+// device at once, e.g. to mute everything.
+func (a *api) notificationsDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+
+	dnr := &deviceNotificationsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(dnr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, vars["apns"])
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	if err := a.deviceRepo.SetNotifiableForAllAccounts(ctx, &dev, dnr.InboxNotifications, dnr.WatcherNotifications, dnr.GlobalMute); err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type quietHoursRequest struct {
+	StartMinute int    `json:"start_minute"`
+	EndMinute   int    `json:"end_minute"`
+	Timezone    string `json:"timezone"`
+}
+
+// quietHoursDeviceHandler sets the window during which the device only gets inbox
+// notifications. Starting and ending at the same minute turns it off.
+func (a *api) quietHoursDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+
+	qhr := &quietHoursRequest{}
+	if err := json.NewDecoder(r.Body).Decode(qhr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	qh := domain.QuietHours{Start: qhr.StartMinute, End: qhr.EndMinute, Timezone: qhr.Timezone}
+	if err := qh.Validate(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, vars["apns"])
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	if err := a.deviceRepo.SetQuietHours(ctx, &dev, qh); err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type sentNotificationItem struct {
+	Type      string    `json:"type"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (a *api) notificationHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, vars["apns"])
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	sns, err := a.sentNotificationRepo.GetByDeviceID(ctx, dev.ID, domain.SentNotificationHistoryLimit)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	items := make([]sentNotificationItem, len(sns))
+	for i, sn := range sns {
+		items[i] = sentNotificationItem{
+			Type:      sn.Type,
+			Title:     sn.Title,
+			CreatedAt: sn.CreatedAt,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+// notificationStatsCacheAge is how long the app may reuse a device's notification stats.
+const notificationStatsCacheAge = 5 * time.Minute
+
+type notificationStatsResponse struct {
+	Since      time.Time        `json:"since"`
+	Total      int64            `json:"total"`
+	Categories map[string]int64 `json:"categories"`
+}
+
+// notificationStatsHandler counts the notifications a device got by category over the
+// last ?days (up to as far back as they're kept, which is also the default).
+func (a *api) notificationStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+
+	maxDays := int(domain.SentNotificationRetention / (24 * time.Hour))
+	days := maxDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDays {
+			a.errorResponse(w, r, 422, fmt.Errorf("days must be between 1 and %d", maxDays))
+			return
+		}
+		days = n
+	}
+
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, vars["apns"])
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour).UTC().Truncate(time.Minute)
+	counts, err := a.sentNotificationRepo.CountByDeviceID(ctx, dev.ID, since)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	res := notificationStatsResponse{Since: since, Categories: counts}
+	for _, count := range counts {
+		res.Total += count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(notificationStatsCacheAge.Seconds())))
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(res)
+}
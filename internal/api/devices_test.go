@@ -0,0 +1,417 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/api"
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+const (
+	oldToken = "313a182b63224821f5595f42aa019de850a0e7b776253659a9aac8140bb8a3f2"
+	newToken = "9b3c1f0e7c1a4d5e8f2b6a7c9d0e1f2a3b4c5d6e7f8091a2b3c4d5e6f7a8b9c0"
+)
+
+func TestRotateDeviceHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	deviceRepo := repository.NewPostgresDevice(tx)
+	accountRepo := repository.NewPostgresAccount(tx)
+	watcherRepo := repository.NewPostgresWatcher(tx)
+
+	dev := &domain.Device{APNSToken: oldToken, ExpiresAt: time.Now().Add(time.Hour), GracePeriodExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, deviceRepo.Create(ctx, dev))
+
+	acc := &domain.Account{Username: "janedoe", AccountID: "abc123", TokenExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, accountRepo.Create(ctx, acc))
+	require.NoError(t, accountRepo.Associate(ctx, acc, dev))
+
+	watcher := &domain.Watcher{Label: "pics", DeviceID: dev.ID, AccountID: acc.ID, Type: domain.SubredditWatcher, WatcheeID: 1}
+	require.NoError(t, watcherRepo.Create(ctx, watcher))
+
+	body := strings.NewReader(fmt.Sprintf(`{"apns_token": %q}`, newToken))
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v1/device/%s/rotate", oldToken), body)
+	rr := httptest.NewRecorder()
+
+	api.NewTestAPI(tx).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	_, err = deviceRepo.GetByAPNSToken(ctx, oldToken)
+	assert.Equal(t, domain.ErrNotFound, err)
+
+	rotated, err := deviceRepo.GetByAPNSToken(ctx, newToken)
+	require.NoError(t, err)
+	assert.Equal(t, dev.ID, rotated.ID)
+
+	accs, err := accountRepo.GetByAPNSToken(ctx, newToken)
+	require.NoError(t, err)
+	require.Len(t, accs, 1)
+	assert.Equal(t, acc.ID, accs[0].ID)
+
+	watchers, err := watcherRepo.GetByDeviceAPNSTokenAndAccountRedditID(ctx, newToken, acc.AccountID)
+	require.NoError(t, err)
+	require.Len(t, watchers, 1)
+	assert.Equal(t, watcher.ID, watchers[0].ID)
+}
+
+func TestMigrateDeviceHandler(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		registered bool
+	}{
+		"new token unregistered":     {false},
+		"new token already in place": {true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			conn := testhelper.NewTestPgxConn(t)
+
+			tx, err := conn.Begin(ctx)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+			deviceRepo := repository.NewPostgresDevice(tx)
+			accountRepo := repository.NewPostgresAccount(tx)
+			watcherRepo := repository.NewPostgresWatcher(tx)
+
+			dev := &domain.Device{APNSToken: oldToken, ExpiresAt: time.Now().Add(time.Hour), GracePeriodExpiresAt: time.Now().Add(time.Hour)}
+			require.NoError(t, deviceRepo.Create(ctx, dev))
+
+			acc := &domain.Account{Username: "janedoe", AccountID: "abc123", TokenExpiresAt: time.Now().Add(time.Hour)}
+			require.NoError(t, accountRepo.Create(ctx, acc))
+			require.NoError(t, accountRepo.Associate(ctx, acc, dev))
+			require.NoError(t, deviceRepo.SetNotifiable(ctx, dev, acc, true, false, true))
+
+			watcher := &domain.Watcher{Label: "pics", DeviceID: dev.ID, AccountID: acc.ID, Type: domain.SubredditWatcher, WatcheeID: 1}
+			require.NoError(t, watcherRepo.Create(ctx, watcher))
+
+			if tc.registered {
+				// The app registered the new token and re-added the account with defaults.
+				fresh := &domain.Device{APNSToken: newToken, ExpiresAt: time.Now().Add(time.Hour), GracePeriodExpiresAt: time.Now().Add(time.Hour)}
+				require.NoError(t, deviceRepo.Create(ctx, fresh))
+				require.NoError(t, accountRepo.Associate(ctx, acc, fresh))
+			}
+
+			body := strings.NewReader(fmt.Sprintf(`{"old_apns": %q, "new_apns": %q}`, oldToken, newToken))
+			req := httptest.NewRequest(http.MethodPost, "/v1/device/migrate", body)
+			rr := httptest.NewRecorder()
+
+			api.NewTestAPI(tx).ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			_, err = deviceRepo.GetByAPNSToken(ctx, oldToken)
+			assert.Equal(t, domain.ErrNotFound, err)
+
+			migrated, err := deviceRepo.GetByAPNSToken(ctx, newToken)
+			require.NoError(t, err)
+
+			accs, err := accountRepo.GetByAPNSToken(ctx, newToken)
+			require.NoError(t, err)
+			require.Len(t, accs, 1)
+			assert.Equal(t, acc.ID, accs[0].ID)
+
+			// The settings from the old device carry over.
+			inbox, watcherNotifiable, global, err := deviceRepo.GetNotifiable(ctx, &migrated, acc)
+			require.NoError(t, err)
+			assert.True(t, inbox)
+			assert.False(t, watcherNotifiable)
+			assert.True(t, global)
+
+			watchers, err := watcherRepo.GetByDeviceAPNSTokenAndAccountRedditID(ctx, newToken, acc.AccountID)
+			require.NoError(t, err)
+			require.Len(t, watchers, 1)
+			assert.Equal(t, watcher.ID, watchers[0].ID)
+		})
+	}
+}
+
+func TestMigrateDeviceHandler_UnknownDevice(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	body := strings.NewReader(fmt.Sprintf(`{"old_apns": %q, "new_apns": %q}`, oldToken, newToken))
+	req := httptest.NewRequest(http.MethodPost, "/v1/device/migrate", body)
+	rr := httptest.NewRecorder()
+
+	api.NewTestAPI(tx).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestMigrateDeviceHandler_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		body string
+		want int
+	}{
+		"malformed":   {`{"old_apns": 1}`, http.StatusBadRequest},
+		"missing old": {fmt.Sprintf(`{"new_apns": %q}`, newToken), http.StatusUnprocessableEntity},
+		"missing new": {fmt.Sprintf(`{"old_apns": %q}`, oldToken), http.StatusUnprocessableEntity},
+		"short token": {fmt.Sprintf(`{"old_apns": %q, "new_apns": "short"}`, oldToken), http.StatusUnprocessableEntity},
+		"same token":  {fmt.Sprintf(`{"old_apns": %q, "new_apns": %q}`, oldToken, oldToken), http.StatusUnprocessableEntity},
+		"non-hex new": {fmt.Sprintf(`{"old_apns": %q, "new_apns": %q}`, oldToken, strings.Repeat("z", 64)), http.StatusUnprocessableEntity},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			// Rejected before ever touching the database.
+			req := httptest.NewRequest(http.MethodPost, "/v1/device/migrate", strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+
+			api.NewTestAPI(nil).ServeHTTP(rr, req)
+			assert.Equal(t, tc.want, rr.Code)
+		})
+	}
+}
+
+func TestNotificationsDeviceHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	deviceRepo := repository.NewPostgresDevice(tx)
+	accountRepo := repository.NewPostgresAccount(tx)
+
+	dev := &domain.Device{APNSToken: oldToken, ExpiresAt: time.Now().Add(time.Hour), GracePeriodExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, deviceRepo.Create(ctx, dev))
+
+	accs := []*domain.Account{
+		{Username: "janedoe", AccountID: "abc123", TokenExpiresAt: time.Now().Add(time.Hour)},
+		{Username: "johndoe", AccountID: "def456", TokenExpiresAt: time.Now().Add(time.Hour)},
+	}
+	for _, acc := range accs {
+		require.NoError(t, accountRepo.Create(ctx, acc))
+		require.NoError(t, accountRepo.Associate(ctx, acc, dev))
+		require.NoError(t, deviceRepo.SetNotifiable(ctx, dev, acc, true, true, false))
+	}
+
+	body := strings.NewReader(`{"global_mute": true, "watcher_notifications": false}`)
+	req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/v1/device/%s/notifications", oldToken), body)
+	rr := httptest.NewRecorder()
+
+	api.NewTestAPI(tx).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	for _, acc := range accs {
+		inbox, watcher, global, err := deviceRepo.GetNotifiable(ctx, dev, acc)
+		require.NoError(t, err)
+		assert.True(t, inbox, acc.Username)
+		assert.False(t, watcher, acc.Username)
+		assert.True(t, global, acc.Username)
+	}
+
+	// Settings left out of the request stay as they are.
+	body = strings.NewReader(`{"inbox_notifications": false}`)
+	req = httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/v1/device/%s/notifications", oldToken), body)
+	rr = httptest.NewRecorder()
+
+	api.NewTestAPI(tx).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	for _, acc := range accs {
+		inbox, watcher, global, err := deviceRepo.GetNotifiable(ctx, dev, acc)
+		require.NoError(t, err)
+		assert.False(t, inbox, acc.Username)
+		assert.False(t, watcher, acc.Username)
+		assert.True(t, global, acc.Username)
+	}
+}
+
+func TestNotificationsDeviceHandler_UnknownDevice(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/v1/device/%s/notifications", newToken), strings.NewReader(`{"global_mute": true}`))
+	rr := httptest.NewRecorder()
+
+	api.NewTestAPI(tx).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestNotificationStatsHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	deviceRepo := repository.NewPostgresDevice(tx)
+	sentRepo := repository.NewPostgresSentNotification(tx)
+
+	dev := &domain.Device{APNSToken: oldToken, ExpiresAt: time.Now().Add(time.Hour), GracePeriodExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, deviceRepo.Create(ctx, dev))
+
+	now := time.Now().UTC()
+	for _, sn := range []*domain.SentNotification{
+		{DeviceID: dev.ID, Type: "subreddit-watcher", Title: "a", CreatedAt: now.Add(-time.Hour)},
+		{DeviceID: dev.ID, Type: "inbox-item", Title: "b", CreatedAt: now.Add(-time.Hour)},
+		{DeviceID: dev.ID, Type: "inbox-item", Title: "c", CreatedAt: now.Add(-3 * 24 * time.Hour)},
+	} {
+		require.NoError(t, sentRepo.Create(ctx, sn))
+	}
+
+	tt := map[string]struct {
+		query string
+		want  string
+	}{
+		"default window": {"", `"total":3,"categories":{"inbox-item":2,"subreddit-watcher":1}`},
+		"one day":        {"?days=1", `"total":2,"categories":{"inbox-item":1,"subreddit-watcher":1}`},
+	}
+
+	for scenario, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v1/device/%s/stats%s", oldToken, tc.query), nil)
+		rr := httptest.NewRecorder()
+
+		api.NewTestAPI(tx).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, scenario)
+		assert.Contains(t, rr.Body.String(), tc.want, scenario)
+		assert.Equal(t, "private, max-age=300", rr.Header().Get("Cache-Control"), scenario)
+	}
+}
+
+func TestNotificationStatsHandler_UnknownDevice(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v1/device/%s/stats", newToken), nil)
+	rr := httptest.NewRecorder()
+
+	api.NewTestAPI(tx).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestNotificationStatsHandler_InvalidDays(t *testing.T) {
+	t.Parallel()
+
+	for _, days := range []string{"0", "8", "-1", "week"} {
+		days := days
+		t.Run(days, func(t *testing.T) {
+			t.Parallel()
+
+			// Rejected before ever touching the database.
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v1/device/%s/stats?days=%s", oldToken, days), nil)
+			rr := httptest.NewRecorder()
+
+			api.NewTestAPI(nil).ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		})
+	}
+}
+
+func TestRotateDeviceHandler_InvalidToken(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]string{
+		"short":   "short",
+		"non-hex": strings.Repeat("z", 64),
+	}
+
+	for scenario, token := range tt {
+		token := token
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			// Rejected before ever touching the database.
+			body := strings.NewReader(fmt.Sprintf(`{"apns_token": %q}`, token))
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v1/device/%s/rotate", oldToken), body)
+			rr := httptest.NewRecorder()
+
+			api.NewTestAPI(nil).ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		})
+	}
+}
+
+func TestQuietHoursDeviceHandler_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		body string
+		want int
+	}{
+		"malformed":        {`{"start_minute": "late"}`, http.StatusBadRequest},
+		"out of range":     {`{"start_minute": 1440, "end_minute": 420}`, http.StatusUnprocessableEntity},
+		"unknown timezone": {`{"start_minute": 1320, "end_minute": 420, "timezone": "Nowhere/Special"}`, http.StatusUnprocessableEntity},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/v1/device/%s/quiet_hours", oldToken), strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+
+			api.NewTestAPI(nil).ServeHTTP(rr, req)
+			assert.Equal(t, tc.want, rr.Code)
+		})
+	}
+}
+
+func TestObfuscatedURI(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v1/device/%s/notifications/history?limit=5", oldToken), nil)
+	req = mux.SetURLVars(req, map[string]string{"apns": oldToken})
+
+	got := api.ObfuscatedURI(req)
+	assert.Equal(t, "/v1/device/313a...a3f2/notifications/history?limit=5", got)
+	assert.NotContains(t, got, oldToken)
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/device", nil)
+	assert.Equal(t, "/v1/device", api.ObfuscatedURI(req))
+}
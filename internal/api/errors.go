@@ -1,13 +1,82 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/itunes"
+	"github.com/christianselig/apollo-backend/internal/reddit"
 )
 
 var ErrDuplicateAPNSToken = errors.New("duplicate apns token")
 
+// knownErrors pins errors clients may want to act on to a status and a code that
+// won't change along with the error's message.
+var knownErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{domain.ErrNotFound, http.StatusNotFound, "not_found"},
+	{domain.ErrConflict, http.StatusConflict, "conflict"},
+	{ErrDuplicateAPNSToken, http.StatusConflict, "duplicate_apns_token"},
+	{ErrMissingReceipt, http.StatusUnprocessableEntity, "missing_receipt"},
+	{ErrInvalidReplyToken, http.StatusUnauthorized, "invalid_reply_token"},
+	{reddit.ErrSubredditIsPrivate, http.StatusForbidden, "subreddit_private"},
+	{reddit.ErrSubredditIsQuarantined, http.StatusForbidden, "subreddit_quarantined"},
+	{reddit.ErrSubredditNotFound, http.StatusNotFound, "subreddit_not_found"},
+	{reddit.ErrSubredditBanned, http.StatusNotFound, "subreddit_banned"},
+	{reddit.ErrRateLimited, http.StatusTooManyRequests, "reddit_rate_limited"},
+	{reddit.ErrTooManyRequests, http.StatusTooManyRequests, "reddit_rate_limited"},
+	{reddit.ErrCommentRejected, http.StatusUnprocessableEntity, "comment_rejected"},
+	{reddit.ErrMissingScopes, http.StatusUnprocessableEntity, "missing_scopes"},
+	{itunes.ErrCircuitOpen, http.StatusServiceUnavailable, "receipt_verification_unavailable"},
+}
+
+type errorBody struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
 func (a *api) errorResponse(w http.ResponseWriter, _ *http.Request, status int, err error) {
+	status, code := errorStatusAndCode(status, err)
+
+	body := errorBody{}
+	body.Error.Code = code
+	body.Error.Message = err.Error()
+
 	w.Header().Set("X-Apollo-Error", err.Error())
-	http.Error(w, err.Error(), status)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// errorStatusAndCode picks the status and code to report err with. Known errors and
+// validation failures override the status the handler asked for, anything else gets a
+// code derived from that status.
+func errorStatusAndCode(status int, err error) (int, string) {
+	for _, known := range knownErrors {
+		if errors.Is(err, known.err) {
+			return known.status, known.code
+		}
+	}
+
+	var verrs validation.Errors
+	if errors.As(err, &verrs) {
+		return http.StatusUnprocessableEntity, "validation_failed"
+	}
+
+	code := strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	if code == "" {
+		code = "error"
+	}
+	return status, code
 }
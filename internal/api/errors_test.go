@@ -0,0 +1,60 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/api"
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+func TestErrorResponse(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		status     int
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		"not found":         {500, domain.ErrNotFound, 404, "not_found"},
+		"wrapped not found": {500, fmt.Errorf("fetching device: %w", domain.ErrNotFound), 404, "not_found"},
+		"private subreddit": {422, reddit.ErrSubredditIsPrivate, 403, "subreddit_private"},
+		"duplicate token":   {400, api.ErrDuplicateAPNSToken, 409, "duplicate_apns_token"},
+		"validation":        {500, (&domain.Device{}).Validate(), 422, "validation_failed"},
+		"unknown error":     {500, errors.New("boom"), 500, "internal_server_error"},
+		"unknown client":    {422, errors.New("bad input"), 422, "unprocessable_entity"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			rr := httptest.NewRecorder()
+			api.ErrorResponse(rr, httptest.NewRequest(http.MethodGet, "/", nil), tc.status, tc.err)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+			assert.Equal(t, tc.err.Error(), rr.Header().Get("X-Apollo-Error"))
+
+			var body struct {
+				Error struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+			assert.Equal(t, tc.wantCode, body.Error.Code)
+			assert.Equal(t, tc.err.Error(), body.Error.Message)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/reddit"
+	"github.com/christianselig/apollo-backend/internal/repository"
+)
+
+var (
+	AlertNotification  = alertNotification
+	SilentNotification = silentNotification
+	SubscriptionSync   = subscriptionSync
+
+	PrivateMessage   = privateMessage
+	CommentReply     = commentReply
+	PostReply        = postReply
+	UsernameMention  = usernameMention
+	SubredditWatcher = subredditWatcher
+	TrendingPost     = trendingPost
+	UserWatcher      = userWatcher
+
+	TestNotifications = testNotifications
+
+	ApplyReceiptVerification = applyReceiptVerification
+	ObfuscatedURI            = obfuscatedURI
+	RequestIDFromContext     = requestIDFromContext
+	BackfillWatcher          = backfillWatcher
+	SubredditWarning         = subredditWarning
+	HashReplyToken           = hashReplyToken
+)
+
+// NewTestAPI returns the API's routes backed by repositories on conn, without any of the
+// external clients.
+func NewTestAPI(conn repository.Connection) http.Handler {
+	a := &api{
+		logger: zap.NewNop(),
+		statsd: &statsd.NoOpClient{},
+
+		accountRepo:      repository.NewPostgresAccount(conn),
+		deviceRepo:       repository.NewPostgresDevice(conn),
+		subredditRepo:    repository.NewPostgresSubreddit(conn),
+		watcherRepo:      repository.NewPostgresWatcher(conn),
+		userRepo:         repository.NewPostgresUser(conn),
+		liveActivityRepo: repository.NewPostgresLiveActivity(conn),
+
+		sentNotificationRepo: repository.NewPostgresSentNotification(conn),
+	}
+
+	return a.Routes()
+}
+
+// NewTestRedditAPI returns the API's routes backed by repositories on conn, talking to
+// Reddit through rc.
+func NewTestRedditAPI(conn repository.Connection, rc *reddit.Client) http.Handler {
+	a := &api{
+		logger: zap.NewNop(),
+		statsd: &statsd.NoOpClient{},
+		reddit: rc,
+
+		accountRepo:      repository.NewPostgresAccount(conn),
+		deviceRepo:       repository.NewPostgresDevice(conn),
+		subredditRepo:    repository.NewPostgresSubreddit(conn),
+		watcherRepo:      repository.NewPostgresWatcher(conn),
+		liveActivityRepo: repository.NewPostgresLiveActivity(conn),
+	}
+
+	return a.Routes()
+}
+
+// NewTestAdminAPI returns the API's routes backed by repositories on conn, letting admin
+// requests in with secret.
+func NewTestAdminAPI(conn repository.Connection, secret string) http.Handler {
+	a := &api{
+		logger:      zap.NewNop(),
+		statsd:      &statsd.NoOpClient{},
+		adminSecret: secret,
+
+		deviceRepo:  repository.NewPostgresDevice(conn),
+		watcherRepo: repository.NewPostgresWatcher(conn),
+	}
+
+	return a.Routes()
+}
+
+// NewTestHealthAPI returns the API's routes with only the dependencies the health check
+// looks at.
+func NewTestHealthAPI(pool *pgxpool.Pool, redis *redis.Client) http.Handler {
+	a := &api{
+		logger: zap.NewNop(),
+		statsd: &statsd.NoOpClient{},
+		pool:   pool,
+		redis:  redis,
+	}
+
+	return a.Routes()
+}
+
+// RequestIDMiddleware wraps next in the API's request ID middleware.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	a := &api{logger: zap.NewNop()}
+	return a.requestIdMiddleware(next)
+}
+
+// ErrorResponse writes err the way the API's handlers do.
+func ErrorResponse(w http.ResponseWriter, r *http.Request, status int, err error) {
+	a := &api{logger: zap.NewNop()}
+	a.errorResponse(w, r, status, err)
+}
+
+// APNSTokenMiddleware wraps next in the API's {apns} path parameter validation.
+func APNSTokenMiddleware(next http.Handler) http.Handler {
+	a := &api{logger: zap.NewNop()}
+	return a.apnsTokenMiddleware(next)
+}
+
+type ContactMessage = contactMessage
+
+// NewTestContactAPI returns the API's routes delivering contact messages through send
+// and rate limiting them with allow.
+func NewTestContactAPI(send func(context.Context, ContactMessage) error, allow func(context.Context, string) (bool, error)) http.Handler {
+	a := &api{
+		logger:         zap.NewNop(),
+		statsd:         &statsd.NoOpClient{},
+		contactSender:  contactSenderFunc(send),
+		contactLimiter: rateLimiterFunc(allow),
+	}
+
+	return a.Routes()
+}
+
+type contactSenderFunc func(context.Context, contactMessage) error
+
+func (f contactSenderFunc) Send(ctx context.Context, msg contactMessage) error { return f(ctx, msg) }
+
+type rateLimiterFunc func(context.Context, string) (bool, error)
+
+func (f rateLimiterFunc) Allow(ctx context.Context, key string) (bool, error) { return f(ctx, key) }
+
+// NewTestRateLimiter returns the Redis backed rate limiter the API uses.
+func NewTestRateLimiter(client *redis.Client, prefix string, limit int64, window time.Duration) func(context.Context, string) (bool, error) {
+	rl := &redisRateLimiter{redis: client, prefix: prefix, limit: limit, window: window}
+	return rl.Allow
+}
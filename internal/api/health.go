@@ -1,16 +1,50 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 )
 
+const healthCheckTimeout = 2 * time.Second
+
 func (a *api) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	data := map[string]string{
+	data := map[string]interface{}{
 		"status": "available",
 	}
+	status := http.StatusOK
+
+	// Load balancers probe this constantly, so dependencies are only checked on request.
+	if r.URL.Query().Get("deep") == "true" {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		deps := map[string]string{}
+		for name, check := range a.dependencyChecks() {
+			deps[name] = "available"
+			if err := check(ctx); err != nil {
+				deps[name] = "unavailable"
+				data["status"] = "unavailable"
+				status = http.StatusServiceUnavailable
+			}
+		}
+		data["dependencies"] = deps
+	}
 
-	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(data)
 }
+
+func (a *api) dependencyChecks() map[string]func(context.Context) error {
+	return map[string]func(context.Context) error{
+		"postgres": func(ctx context.Context) error {
+			_, err := a.pool.Exec(ctx, "SELECT 1")
+			return err
+		},
+		"redis": func(ctx context.Context) error {
+			return a.redis.Ping(ctx).Err()
+		},
+	}
+}
@@ -0,0 +1,71 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/api"
+)
+
+func TestHealthCheckHandler(t *testing.T) {
+	t.Parallel()
+
+	// Nothing listens on port 1, so both dependencies fail straight away.
+	pool, err := pgxpool.New(context.Background(), "postgres://apollo@127.0.0.1:1/apollo?connect_timeout=1")
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	handler := api.NewTestHealthAPI(pool, rdb)
+
+	tt := map[string]struct {
+		path   string
+		status int
+		want   map[string]interface{}
+	}{
+		"shallow": {
+			"/v1/health",
+			http.StatusOK,
+			map[string]interface{}{"status": "available"},
+		},
+		"deep with failing dependencies": {
+			"/v1/health?deep=true",
+			http.StatusServiceUnavailable,
+			map[string]interface{}{
+				"status": "unavailable",
+				"dependencies": map[string]interface{}{
+					"postgres": "unavailable",
+					"redis":    "unavailable",
+				},
+			},
+		},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+			got := map[string]interface{}{}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -2,13 +2,73 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
 	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
 )
 
+// liveActivityMaxCommentLength is the longest comment reddit accepts.
+const liveActivityMaxCommentLength = 10000
+
+var ErrInvalidReplyToken = errors.New("missing or invalid reply token")
+
+type liveActivityCreatedResponse struct {
+	ReplyToken string `json:"reply_token"`
+}
+
+// newReplyToken returns a random token for quick replies to a live activity, along with
+// the hash that gets stored in its place.
+func newReplyToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := hex.EncodeToString(b)
+	return token, hashReplyToken(token), nil
+}
+
+func hashReplyToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// replyTokenMatches reports whether the request's bearer token is the one issued for
+// the live activity. Live activities created before tokens were issued never match.
+func replyTokenMatches(la *domain.LiveActivity, r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if la.ReplyTokenHash == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashReplyToken(token)), []byte(la.ReplyTokenHash)) == 1
+}
+
+type liveActivityCommentRequest struct {
+	ParentID string `json:"parent_id"`
+	Text     string `json:"text"`
+}
+
+func (lacr *liveActivityCommentRequest) Validate() error {
+	return validation.ValidateStruct(lacr,
+		validation.Field(&lacr.ParentID, validation.Length(0, 32)),
+		validation.Field(&lacr.Text, validation.Required, validation.Length(1, liveActivityMaxCommentLength)),
+	)
+}
+
 func (a *api) createLiveActivityHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
@@ -19,6 +79,8 @@ func (a *api) createLiveActivityHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	la.Keyword = strings.ToLower(la.Keyword)
+
 	if _, err := a.liveActivityRepo.Get(ctx, la.APNSToken); err == nil {
 		a.errorResponse(w, r, 400, ErrDuplicateAPNSToken)
 		return
@@ -34,10 +96,105 @@ func (a *api) createLiveActivityHandler(w http.ResponseWriter, r *http.Request)
 	la.RefreshToken = rtr.RefreshToken
 	la.TokenExpiresAt = time.Now().Add(1 * time.Hour)
 
+	// Only whoever created the live activity, with valid reddit tokens, gets to reply
+	// from it.
+	token, hash, err := newReplyToken()
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+	la.ReplyTokenHash = hash
+
 	if err := a.liveActivityRepo.Create(ctx, la); err != nil {
 		a.errorResponse(w, r, 500, err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(liveActivityCreatedResponse{ReplyToken: token})
+}
+
+// commentLiveActivityHandler posts a quick reply from a live activity, to the thread
+// itself or to the comment given as parent_id. The request has to carry the reply
+// token handed out when the live activity was created.
+func (a *api) commentLiveActivityHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+	apns := vars["apns"]
+
+	if r.Header.Get("Authorization") == "" {
+		a.errorResponse(w, r, 401, ErrInvalidReplyToken)
+		return
+	}
+
+	lacr := &liveActivityCommentRequest{}
+	if err := json.NewDecoder(r.Body).Decode(lacr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	if err := lacr.Validate(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	// Unknown live activities look the same as a wrong token, so the endpoint can't be
+	// used to find out which APNs tokens have one.
+	la, err := a.liveActivityRepo.Get(ctx, apns)
+	if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+	if err != nil || !replyTokenMatches(&la, r) {
+		a.errorResponse(w, r, 401, ErrInvalidReplyToken)
+		return
+	}
+
+	parent := lacr.ParentID
+	if parent == "" {
+		parent = fmt.Sprintf("t3_%s", la.ThreadID)
+	}
+
+	rac := a.reddit.NewAuthenticatedClient(la.RedditAccountID, la.RefreshToken, la.AccessToken)
+	if la.TokenExpiresAt.Before(time.Now().Add(5 * time.Minute)) {
+		tokens, err := rac.RefreshTokens(ctx)
+		if err != nil {
+			status := 500
+			if errors.Is(err, reddit.ErrOauthRevoked) {
+				status = 401
+			}
+			a.errorResponse(w, r, status, err)
+			return
+		}
+
+		la.AccessToken = tokens.AccessToken
+		la.RefreshToken = tokens.RefreshToken
+		la.TokenExpiresAt = time.Now().Add(tokens.Expiry)
+		_ = a.liveActivityRepo.UpdateTokens(ctx, &la)
+
+		rac = a.reddit.NewAuthenticatedClient(la.RedditAccountID, tokens.RefreshToken, tokens.AccessToken)
+	}
+
+	comment, err := rac.SubmitComment(ctx, parent, lacr.Text)
+	if err != nil {
+		a.requestLogger(ctx).Info("failed to submit live activity comment",
+			zap.Error(err),
+			zap.String("live_activity#apns_token", domain.ObfuscateToken(apns)),
+			zap.String("reddit#parent_id", parent),
+		)
+
+		status := 500
+		if errors.Is(err, reddit.ErrOauthRevoked) {
+			status = 401
+		}
+		a.errorResponse(w, r, status, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(comment)
 }
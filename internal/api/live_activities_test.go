@@ -0,0 +1,87 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+
+	"github.com/christianselig/apollo-backend/internal/api"
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestCommentLiveActivityHandler_Auth(t *testing.T) {
+	t.Parallel()
+
+	const replyToken = "5f1d0b9c3e6a4f7d8b2c1a0e9f8d7c6b5a4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c"
+
+	tt := map[string]struct {
+		token  string
+		header string
+		want   int
+	}{
+		"path token only": {oldToken, "Bearer " + oldToken, http.StatusUnauthorized},
+		"wrong token":     {oldToken, "Bearer " + strings.Repeat("0", 64), http.StatusUnauthorized},
+		"unknown device":  {newToken, "Bearer " + replyToken, http.StatusUnauthorized},
+		// Gets past auth and on to reddit, which fails here.
+		"reply token": {oldToken, "Bearer " + replyToken, http.StatusInternalServerError},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			conn := testhelper.NewTestPgxConn(t)
+
+			tx, err := conn.Begin(ctx)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+			la := &domain.LiveActivity{
+				APNSToken:      oldToken,
+				ThreadID:       "abc123",
+				TokenExpiresAt: time.Now().Add(time.Hour),
+				ReplyTokenHash: api.HashReplyToken(replyToken),
+			}
+			require.NoError(t, repository.NewPostgresLiveActivity(tx).Create(ctx, la))
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			t.Cleanup(srv.Close)
+
+			rc := reddit.NewClient("<ID>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1, reddit.WithOAuthBaseURL(srv.URL))
+
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v1/live_activities/%s/comment", tc.token), strings.NewReader(`{"text": "nice"}`))
+			req.Header.Set("Authorization", tc.header)
+			rr := httptest.NewRecorder()
+
+			api.NewTestRedditAPI(tx, rc).ServeHTTP(rr, req)
+			assert.Equal(t, tc.want, rr.Code, rr.Body.String())
+		})
+	}
+}
+
+func TestCommentLiveActivityHandler_MissingToken(t *testing.T) {
+	t.Parallel()
+
+	// Rejected before ever touching the database.
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v1/live_activities/%s/comment", oldToken), strings.NewReader(`{"text": "nice"}`))
+	rr := httptest.NewRecorder()
+
+	api.NewTestAPI(nil).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
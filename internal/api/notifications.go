@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -12,62 +13,104 @@ import (
 )
 
 const (
-	commentReplyNotificationTitleFormat    = "%s in %s"
-	postReplyNotificationTitleFormat       = "%s to %s"
-	privateMessageNotificationTitleFormat  = "Message from %s"
-	subredditNotificationBodyFormat        = "r/%s: \u201c%s\u201d"
-	subredditNotificationTitleFormat       = "📣 \u201c%s\u201d Watcher"
-	trendingNotificationTitleFormat        = "🔥 r/%s Trending"
-	usernameMentionNotificationTitleFormat = "Mention in \u201c%s\u201d"
+	subredditNotificationBodyFormat  = "r/%s: \u201c%s\u201d"
+	subredditNotificationTitleFormat = "📣 \u201c%s\u201d Watcher"
+	trendingNotificationTitleFormat  = "🔥 r/%s Trending"
+	userNotificationTitleFormat      = "👨\u200d🚀 %s"
 )
 
+var ErrUnknownNotificationCategory = errors.New("unknown notification category")
+
 type notificationGenerator func(*payload.Payload)
 
-func generateNotificationTester(a *api, fun notificationGenerator) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithCancel(r.Context())
-		defer cancel()
+// testNotifications builds a sample of each kind of notification the workers send,
+// keyed by the category in the test route.
+var testNotifications = map[string]func() *apns2.Notification{
+	"comment_reply":     func() *apns2.Notification { return alertNotification(commentReply) },
+	"post_reply":        func() *apns2.Notification { return alertNotification(postReply) },
+	"private_message":   func() *apns2.Notification { return alertNotification(privateMessage) },
+	"subreddit_watcher": func() *apns2.Notification { return alertNotification(subredditWatcher) },
+	"trending_post":     func() *apns2.Notification { return alertNotification(trendingPost) },
+	"user_watcher":      func() *apns2.Notification { return alertNotification(userWatcher) },
+	"username_mention":  func() *apns2.Notification { return alertNotification(usernameMention) },
+	"subscription_sync": func() *apns2.Notification { return silentNotification(subscriptionSync) },
+}
 
-		vars := mux.Vars(r)
-		tok := vars["apns"]
+func (a *api) testNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	build, ok := testNotifications[mux.Vars(r)["category"]]
+	if !ok {
+		a.errorResponse(w, r, 404, ErrUnknownNotificationCategory)
+		return
+	}
 
-		d, err := a.deviceRepo.GetByAPNSToken(ctx, tok)
-		if err != nil {
-			a.logger.Info("failed to fetch device from database", zap.Error(err))
-			a.errorResponse(w, r, 500, err)
-			return
-		}
+	a.sendTestNotification(w, r, build())
+}
 
-		p := payload.NewPayload()
+func (a *api) sendTestNotification(w http.ResponseWriter, r *http.Request, notification *apns2.Notification) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
 
-		p.MutableContent().
-			Sound("traloop.wav")
+	vars := mux.Vars(r)
+	tok := vars["apns"]
 
-		fun(p)
+	d, err := a.deviceRepo.GetByAPNSToken(ctx, tok)
+	if err != nil {
+		a.requestLogger(ctx).Info("failed to fetch device from database", zap.Error(err))
+		a.errorResponse(w, r, 500, err)
+		return
+	}
 
-		notification := &apns2.Notification{}
-		notification.Topic = "com.christianselig.Apollo"
-		notification.DeviceToken = d.APNSToken
-		notification.Payload = p
+	notification.DeviceToken = d.APNSToken
 
-		client := apns2.NewTokenClient(a.apns)
-		if !d.Sandbox {
-			client = client.Production()
-		}
+	client := apns2.NewTokenClient(a.apns)
+	if !d.Sandbox {
+		client = client.Production()
+	}
 
-		if _, err := client.Push(notification); err != nil {
-			a.logger.Info("failed to send test notification", zap.Error(err))
-			a.errorResponse(w, r, 500, err)
-			return
-		}
-		w.WriteHeader(http.StatusOK)
+	if _, err := client.Push(notification); err != nil {
+		a.requestLogger(ctx).Info("failed to send test notification", zap.Error(err))
+		a.errorResponse(w, r, 500, err)
+		return
 	}
+	w.WriteHeader(http.StatusOK)
 }
 
-func privateMessage(p *payload.Payload) {
-	title := fmt.Sprintf(privateMessageNotificationTitleFormat, "welcomebot")
+func alertNotification(fun notificationGenerator) *apns2.Notification {
+	p := payload.NewPayload()
 
-	p.AlertTitle(title).
+	p.MutableContent().
+		Sound("traloop.wav")
+
+	fun(p)
+
+	return &apns2.Notification{
+		Topic:   "com.christianselig.Apollo",
+		Payload: p,
+	}
+}
+
+// silentNotification wakes the app up in the background without alerting the user.
+func silentNotification(fun notificationGenerator) *apns2.Notification {
+	p := payload.NewPayload().ContentAvailable()
+
+	fun(p)
+
+	return &apns2.Notification{
+		Topic:    "com.christianselig.Apollo",
+		PushType: apns2.PushTypeBackground,
+		Priority: apns2.PriorityLow,
+		Payload:  p,
+	}
+}
+
+func subscriptionSync(p *payload.Payload) {
+	p.Custom("type", "subscription-sync")
+}
+
+func privateMessage(p *payload.Payload) {
+	p.AlertTitle("Message from welcomebot").
+		AlertTitleLocKey("INBOX_PRIVATE_MESSAGE_TITLE").
+		AlertTitleLocArgs([]string{"welcomebot"}).
 		AlertBody("**Welcome to r/GriefSupport!**\n\nWe're glad you found us, but sad you needed to.  We're here to support you during whatever difficulties you're going through.").
 		AlertSubtitle("Welcome to r/GriefSupport!").
 		AlertSummaryArg("welcomebot").
@@ -83,9 +126,9 @@ func privateMessage(p *payload.Payload) {
 }
 
 func commentReply(p *payload.Payload) {
-	title := fmt.Sprintf(commentReplyNotificationTitleFormat, "Equinox_Shift", "Protests set to disrupt Ottawa's downtown for 3rd straight weekend")
-
-	p.AlertTitle(title).
+	p.AlertTitle("Equinox_Shift in Protests set to disrupt Ottawa's downtown for 3rd straight weekend").
+		AlertTitleLocKey("INBOX_COMMENT_REPLY_TITLE").
+		AlertTitleLocArgs([]string{"Equinox_Shift", "Protests set to disrupt Ottawa's downtown for 3rd straight weekend"}).
 		AlertBody("They don't even go here.").
 		Category("inbox-comment-reply").
 		Custom("account_id", "1ia22").
@@ -103,9 +146,9 @@ func commentReply(p *payload.Payload) {
 }
 
 func postReply(p *payload.Payload) {
-	title := fmt.Sprintf(postReplyNotificationTitleFormat, "Ryfter", "Quest 2 use during chemo")
-
-	p.AlertTitle(title).
+	p.AlertTitle("Ryfter to Quest 2 use during chemo").
+		AlertTitleLocKey("INBOX_POST_REPLY_TITLE").
+		AlertTitleLocArgs([]string{"Ryfter", "Quest 2 use during chemo"}).
 		AlertBody("As others have said, [Real Fishing VR](https://www.oculus.com/experiences/quest/2582932495064035).  Especially if he likes to fish.  My dad and mom were blown away by it.").
 		Category("inbox-comment-reply").
 		Custom("account_id", "1ia22").
@@ -123,9 +166,9 @@ func postReply(p *payload.Payload) {
 }
 
 func usernameMention(p *payload.Payload) {
-	title := fmt.Sprintf(usernameMentionNotificationTitleFormat, "testimg")
-
-	p.AlertTitle(title).
+	p.AlertTitle("Mention in \u201ctestimg\u201d").
+		AlertTitleLocKey("INBOX_USERNAME_MENTION_TITLE").
+		AlertTitleLocArgs([]string{"testimg"}).
 		AlertBody("yo u/changelog what's good").
 		Category("inbox-username-mention-no-context").
 		Custom("account_id", "1ia22").
@@ -173,3 +216,18 @@ func trendingPost(p *payload.Payload) {
 		Custom("thumbnail", "https://a.thumbs.redditmedia.com/Lr4b-YHLTNu1LFuyUY1Zic8kHy3ojX06gLcZOuqxrr0.jpg").
 		ThreadID("trending-post")
 }
+
+func userWatcher(p *payload.Payload) {
+	title := fmt.Sprintf(userNotificationTitleFormat, "befarked247")
+
+	p.AlertTitle(title).
+		AlertBody("A Goliath Stick Insect. Aware of my presence she let me get close enough for a photo. (OC)").
+		AlertSubtitle("befarked247").
+		AlertSummaryArg("befarked247").
+		Category("user-watch").
+		Custom("author", "befarked247").
+		Custom("post_age", 1651409659.0).
+		Custom("post_id", "ufzaml").
+		Custom("post_title", "A Goliath Stick Insect. Aware of my presence she let me get close enough for a photo. (OC)").
+		Custom("subreddit", "pics")
+}
@@ -0,0 +1,151 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/payload"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/api"
+)
+
+func TestSilentNotification(t *testing.T) {
+	t.Parallel()
+
+	n := api.SilentNotification(api.SubscriptionSync)
+
+	assert.Equal(t, apns2.PushTypeBackground, n.PushType)
+	assert.Equal(t, apns2.PriorityLow, n.Priority)
+
+	bb, err := json.Marshal(n.Payload)
+	require.NoError(t, err)
+
+	var got struct {
+		APS  map[string]interface{} `json:"aps"`
+		Type string                 `json:"type"`
+	}
+	require.NoError(t, json.Unmarshal(bb, &got))
+
+	assert.Equal(t, float64(1), got.APS["content-available"])
+	assert.NotContains(t, got.APS, "alert")
+	assert.NotContains(t, got.APS, "sound")
+	assert.Equal(t, "subscription-sync", got.Type)
+}
+
+func TestAlertNotificationBuilders(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		builder func(*payload.Payload)
+		want    map[string]interface{}
+	}{
+		"private message": {
+			api.PrivateMessage,
+			map[string]interface{}{"type": "private-message", "category": "inbox-private-message", "comment_id": "1d2oouy"},
+		},
+		"comment reply": {
+			api.CommentReply,
+			map[string]interface{}{"type": "comment", "category": "inbox-comment-reply", "thread_id": "comment", "comment_id": "hwp66zg", "post_id": "sqqk29", "subreddit": "ottawa"},
+		},
+		"post reply": {
+			api.PostReply,
+			map[string]interface{}{"type": "post", "category": "inbox-comment-reply", "thread_id": "comment", "comment_id": "hyg01ip", "post_id": "t0qn4z", "subreddit": "OculusQuest2"},
+		},
+		"username mention": {
+			api.UsernameMention,
+			map[string]interface{}{"type": "username", "category": "inbox-username-mention-no-context", "comment_id": "i6xobpa", "post_id": "u02338", "subreddit": "calicosummer"},
+		},
+		"subreddit watcher": {
+			api.SubredditWatcher,
+			map[string]interface{}{"category": "subreddit-watcher", "thread_id": "subreddit-watcher", "post_id": "ufzaml", "subreddit": "pics"},
+		},
+		"trending post": {
+			api.TrendingPost,
+			map[string]interface{}{"category": "trending-post", "thread_id": "trending-post", "post_id": "ufzaml", "subreddit": "pics"},
+		},
+		"user watcher": {
+			api.UserWatcher,
+			map[string]interface{}{"category": "user-watch", "post_id": "ufzaml", "subreddit": "pics", "author": "befarked247"},
+		},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			n := api.AlertNotification(tc.builder)
+
+			bb, err := json.Marshal(n.Payload)
+			require.NoError(t, err)
+
+			fields := map[string]interface{}{}
+			require.NoError(t, json.Unmarshal(bb, &fields))
+
+			aps, ok := fields["aps"].(map[string]interface{})
+			require.True(t, ok)
+			fields["category"] = aps["category"]
+			fields["thread_id"] = aps["thread-id"]
+
+			for key, want := range tc.want {
+				assert.Equal(t, want, fields[key], key)
+			}
+		})
+	}
+}
+
+func TestTestNotifications(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]string{
+		"comment_reply":     "inbox-comment-reply",
+		"post_reply":        "inbox-comment-reply",
+		"private_message":   "inbox-private-message",
+		"subreddit_watcher": "subreddit-watcher",
+		"trending_post":     "trending-post",
+		"user_watcher":      "user-watch",
+		"username_mention":  "inbox-username-mention-no-context",
+		"subscription_sync": "",
+	}
+
+	assert.Len(t, api.TestNotifications, len(tt))
+
+	for category, want := range tt {
+		category, want := category, want
+		t.Run(category, func(t *testing.T) {
+			t.Parallel()
+
+			build, ok := api.TestNotifications[category]
+			require.True(t, ok)
+
+			bb, err := json.Marshal(build().Payload)
+			require.NoError(t, err)
+
+			var got struct {
+				APS struct {
+					Category string `json:"category"`
+				} `json:"aps"`
+			}
+			require.NoError(t, json.Unmarshal(bb, &got))
+			assert.Equal(t, want, got.APS.Category)
+		})
+	}
+}
+
+func TestTestNotificationHandler_UnknownCategory(t *testing.T) {
+	t.Parallel()
+
+	const token = "313a182b63224821f5595f42aa019de850a0e7b776253659a9aac8140bb8a3f2"
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/device/"+token+"/test/nonsense", nil)
+	rr := httptest.NewRecorder()
+
+	api.NewTestAPI(nil).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), api.ErrUnknownNotificationCategory.Error())
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -14,6 +15,8 @@ import (
 	"github.com/christianselig/apollo-backend/internal/itunes"
 )
 
+var ErrMissingReceipt = errors.New("no receipt stored for device")
+
 func (a *api) checkReceiptHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
@@ -22,53 +25,50 @@ func (a *api) checkReceiptHandler(w http.ResponseWriter, r *http.Request) {
 	apns := vars["apns"]
 
 	body, _ := ioutil.ReadAll(r.Body)
-	iapr, err := itunes.NewIAPResponse(string(body), true)
+	iapr, err := a.verifyReceipt(ctx, apns, string(body))
+	if err != nil {
+		a.requestLogger(ctx).Info("failed to verify receipt", zap.Error(err))
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	bb, _ := json.Marshal(iapr.VerificationInfo)
+	_, _ = w.Write(bb)
+}
+
+// refreshReceiptHandler re-verifies the receipt stored for a device, for support to use
+// after a billing issue. It sits behind the admin secret.
+func (a *api) refreshReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+	apns := vars["apns"]
 
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, apns)
 	if err != nil {
-		// treat as if it's a valid subscription, given that this is not the user's fault
-		if apns != "" {
-			dev, err := a.deviceRepo.GetByAPNSToken(ctx, apns)
-			if err != nil {
-				a.errorResponse(w, r, 500, err)
-				return
-			}
-
-			dev.ExpiresAt = time.Now().Add(domain.DeviceActiveAfterReceitCheckDuration)
-			dev.GracePeriodExpiresAt = dev.ExpiresAt.Add(domain.DeviceGracePeriodAfterReceiptExpiry)
-			_ = a.deviceRepo.Update(ctx, &dev)
-		}
+		a.errorResponse(w, r, 500, err)
+		return
+	}
 
-		a.logger.Info("failed to verify receipt", zap.Error(err))
+	receipt, err := a.deviceRepo.GetReceipt(ctx, &dev)
+	if err != nil {
 		a.errorResponse(w, r, 500, err)
 		return
 	}
 
-	if apns != "" {
-		dev, err := a.deviceRepo.GetByAPNSToken(ctx, apns)
-		if err != nil {
-			a.errorResponse(w, r, 500, err)
-			return
-		}
+	if receipt == "" {
+		a.errorResponse(w, r, 422, ErrMissingReceipt)
+		return
+	}
 
-		if iapr.DeleteDevice {
-			if dev.GracePeriodExpiresAt.Before(time.Now()) {
-				accs, err := a.accountRepo.GetByAPNSToken(ctx, apns)
-				if err != nil {
-					a.errorResponse(w, r, 500, err)
-					return
-				}
-
-				for _, acc := range accs {
-					_ = a.accountRepo.Disassociate(ctx, &acc, &dev)
-				}
-
-				_ = a.deviceRepo.Delete(ctx, apns)
-			}
-		} else {
-			dev.ExpiresAt = time.Now().Add(domain.DeviceActiveAfterReceitCheckDuration)
-			dev.GracePeriodExpiresAt = dev.ExpiresAt.Add(domain.DeviceGracePeriodAfterReceiptExpiry)
-			_ = a.deviceRepo.Update(ctx, &dev)
-		}
+	iapr, err := a.verifyReceipt(ctx, apns, receipt)
+	if err != nil {
+		a.requestLogger(ctx).Info("failed to refresh receipt", zap.Error(err))
+		a.errorResponse(w, r, 500, err)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -76,3 +76,68 @@ func (a *api) checkReceiptHandler(w http.ResponseWriter, r *http.Request) {
 	bb, _ := json.Marshal(iapr.VerificationInfo)
 	_, _ = w.Write(bb)
 }
+
+// verifyReceipt checks a receipt with Apple and, if it belongs to a device, stores it and
+// extends or expires the device's subscription accordingly.
+func (a *api) verifyReceipt(ctx context.Context, apns, receipt string) (*itunes.IAPResponse, error) {
+	iapr, err := a.receiptVerifier.NewIAPResponse(receipt, true)
+	if errors.Is(err, itunes.ErrCircuitOpen) {
+		_ = a.statsd.Incr("itunes.circuit_breaker.open", nil, 1)
+		a.requestLogger(ctx).Error("skipping receipt verification, apple has been failing consistently", zap.Error(err))
+	}
+
+	if apns == "" {
+		return iapr, err
+	}
+
+	dev, derr := a.deviceRepo.GetByAPNSToken(ctx, apns)
+	if derr != nil {
+		return nil, derr
+	}
+
+	_ = a.deviceRepo.SetReceipt(ctx, &dev, receipt)
+
+	if !applyReceiptVerification(&dev, iapr, err, time.Now()) {
+		_ = a.deviceRepo.Update(ctx, &dev)
+	} else {
+		accs, err := a.accountRepo.GetByAPNSToken(ctx, apns)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, acc := range accs {
+			_ = a.accountRepo.Disassociate(ctx, &acc, &dev)
+		}
+
+		_ = a.deviceRepo.Delete(ctx, apns)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return iapr, nil
+}
+
+// applyReceiptVerification updates a device's subscription from the outcome of a receipt
+// check and reports whether the device should be deleted. When Apple couldn't be reached
+// (or the circuit breaker is open) the device keeps its last known entitlement.
+func applyReceiptVerification(dev *domain.Device, iapr *itunes.IAPResponse, err error, now time.Time) bool {
+	if err != nil {
+		// treat as if it's a valid subscription, given that this is not the user's fault
+		dev.ExpiresAt = now.Add(domain.DeviceActiveAfterReceitCheckDuration)
+		dev.GracePeriodExpiresAt = dev.ExpiresAt.Add(domain.DeviceGracePeriodAfterReceiptExpiry)
+		return false
+	}
+
+	dev.EntitlementActive = !iapr.DeleteDevice
+	dev.ReceiptCheckedAt = now
+
+	if iapr.DeleteDevice {
+		return dev.GracePeriodExpiresAt.Before(now)
+	}
+
+	dev.ExpiresAt = now.Add(domain.DeviceActiveAfterReceitCheckDuration)
+	dev.GracePeriodExpiresAt = dev.ExpiresAt.Add(domain.DeviceGracePeriodAfterReceiptExpiry)
+	return false
+}
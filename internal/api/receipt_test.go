@@ -0,0 +1,109 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/api"
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/itunes"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestApplyReceiptVerification(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	tt := map[string]struct {
+		iapr *itunes.IAPResponse
+		err  error
+
+		wantDelete      bool
+		wantEntitlement bool
+	}{
+		"circuit open":    {nil, itunes.ErrCircuitOpen, false, true},
+		"apple down":      {nil, errors.New("connection reset"), false, true},
+		"valid receipt":   {&itunes.IAPResponse{}, nil, false, true},
+		"invalid receipt": {&itunes.IAPResponse{DeleteDevice: true}, nil, true, false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			// An existing subscriber whose grace period has lapsed.
+			dev := &domain.Device{
+				APNSToken:            "apns",
+				ExpiresAt:            now.Add(-2 * time.Hour),
+				GracePeriodExpiresAt: now.Add(-time.Hour),
+				EntitlementActive:    true,
+			}
+
+			assert.Equal(t, tc.wantDelete, api.ApplyReceiptVerification(dev, tc.iapr, tc.err, now))
+			assert.Equal(t, tc.wantEntitlement, dev.EntitlementActive)
+
+			if !tc.wantDelete {
+				assert.True(t, dev.GracePeriodExpiresAt.After(now))
+			}
+		})
+	}
+}
+
+func TestRefreshReceiptHandler_Unauthorized(t *testing.T) {
+	t.Parallel()
+
+	// Knowing the device's own token isn't enough to get in.
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v1/device/%s/receipt/refresh", oldToken), nil)
+	req.Header.Set("Authorization", "Bearer "+oldToken)
+	rr := httptest.NewRecorder()
+
+	api.NewTestAdminAPI(nil, "hunter2").ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestRefreshReceiptHandler_Errors(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		closed bool
+		want   int
+	}{
+		"unknown device": {false, http.StatusNotFound},
+		"database error": {true, http.StatusInternalServerError},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			conn := testhelper.NewTestPgxConn(t)
+
+			tx, err := conn.Begin(ctx)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+			if tc.closed {
+				require.NoError(t, tx.Rollback(ctx))
+			}
+
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v1/device/%s/receipt/refresh", newToken), nil)
+			req.Header.Set("X-Apollo-Admin-Secret", "hunter2")
+			rr := httptest.NewRecorder()
+
+			api.NewTestAdminAPI(tx, "hunter2").ServeHTTP(rr, req)
+			assert.Equal(t, tc.want, rr.Code, rr.Body.String())
+		})
+	}
+}
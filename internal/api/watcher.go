@@ -12,6 +12,7 @@ import (
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/gorilla/mux"
+	"go.uber.org/zap"
 
 	"github.com/christianselig/apollo-backend/internal/domain"
 	"github.com/christianselig/apollo-backend/internal/reddit"
@@ -24,6 +25,9 @@ type watcherCriteria struct {
 	Keyword   string
 	Flair     string
 	Domain    string
+
+	ExcludeNSFW       bool
+	ExcludeCrossposts bool
 }
 
 type createWatcherRequest struct {
@@ -31,6 +35,7 @@ type createWatcherRequest struct {
 	User      string
 	Subreddit string
 	Label     string
+	Sound     string
 	Criteria  watcherCriteria
 }
 
@@ -38,12 +43,103 @@ func (cwr *createWatcherRequest) Validate() error {
 	return validation.ValidateStruct(cwr,
 		validation.Field(&cwr.Type, validation.Required),
 		validation.Field(&cwr.User, validation.Required.When(cwr.Type == "user")),
-		validation.Field(&cwr.Subreddit, validation.Required.When(cwr.Type == "subreddit" || cwr.Type == "trending")),
+		validation.Field(&cwr.Subreddit, validation.When(cwr.Type == "subreddit" || cwr.Type == "trending", validation.By(validSubredditName))),
+		validation.Field(&cwr.Sound, validation.In(domain.NotificationSounds...)),
 	)
 }
 
+func validSubredditName(value interface{}) error {
+	name, _ := value.(string)
+	return domain.ValidateSubredditName(name)
+}
+
 type watcherCreatedResponse struct {
-	ID int64 `json:"id"`
+	ID      int64          `json:"id"`
+	Warning string         `json:"warning,omitempty"`
+	Matches []watcherMatch `json:"matches,omitempty"`
+}
+
+type watcherMatch struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Author    string    `json:"author"`
+	Score     int64     `json:"score"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// watcherBackfillTimeout bounds how long creating a watcher waits on reddit for its
+// backfill before answering without it.
+const watcherBackfillTimeout = 2 * time.Second
+
+// subredditRulesTimeout bounds how long creating a watcher waits on a restricted
+// subreddit's rules before warning without them.
+const subredditRulesTimeout = 2 * time.Second
+
+// subredditWarning explains why a watcher on the subreddit might rarely fire, if it's
+// restricted to approved submitters. rules may be nil if they couldn't be fetched.
+func subredditWarning(srr *reddit.SubredditResponse, rules *reddit.SubredditRulesResponse) string {
+	if srr.AllowsPosts() {
+		return ""
+	}
+
+	warning := fmt.Sprintf("r/%s is restricted, only approved users can post there.", srr.Name)
+	if rules == nil {
+		return warning
+	}
+
+	var names []string
+	for _, rule := range rules.PostRules() {
+		names = append(names, rule.ShortName)
+	}
+	if len(names) > 0 {
+		warning += fmt.Sprintf(" Its rules for posts: %s.", strings.Join(names, "; "))
+	}
+	return warning
+}
+
+// watchedPost returns what a watcher's criteria get matched against for post.
+func watchedPost(post *reddit.Thing) domain.WatchedPost {
+	return domain.WatchedPost{
+		Author:    post.Author,
+		Title:     post.Title,
+		Flair:     post.Flair,
+		URL:       post.URL,
+		Score:     post.Score,
+		NSFW:      post.Over18,
+		Crosspost: post.IsCrosspost,
+	}
+}
+
+// backfillWatcher looks through the subreddit's newest posts from the last
+// domain.WatcherBackfillWindow for the ones the watcher would have matched, had it
+// been around when they were posted.
+func backfillWatcher(ctx context.Context, rac *reddit.AuthenticatedClient, watcher *domain.Watcher, subreddit string, now time.Time) ([]watcherMatch, error) {
+	posts, err := rac.SubredditNew(ctx, subreddit, reddit.WithQuery("limit", "100"), reddit.WithQuery("show", "all"))
+	if err != nil {
+		return nil, err
+	}
+
+	since := now.Add(-domain.WatcherBackfillWindow)
+	matches := []watcherMatch{}
+	for _, post := range posts.Children {
+		if post.CreatedAt.Before(since) {
+			break
+		}
+
+		if !watcher.MatchesPost(watchedPost(post)) {
+			continue
+		}
+
+		matches = append(matches, watcherMatch{
+			ID:        post.ID,
+			Title:     post.Title,
+			Author:    post.Author,
+			Score:     post.Score,
+			CreatedAt: post.CreatedAt,
+		})
+	}
+
+	return matches, nil
 }
 
 func (a *api) createWatcherHandler(w http.ResponseWriter, r *http.Request) {
@@ -102,6 +198,7 @@ func (a *api) createWatcherHandler(w http.ResponseWriter, r *http.Request) {
 
 	watcher := domain.Watcher{
 		Label:     cwr.Label,
+		Sound:     cwr.Sound,
 		DeviceID:  dev.ID,
 		AccountID: account.ID,
 		Author:    strings.ToLower(cwr.Criteria.Author),
@@ -110,27 +207,32 @@ func (a *api) createWatcherHandler(w http.ResponseWriter, r *http.Request) {
 		Keyword:   strings.ToLower(cwr.Criteria.Keyword),
 		Flair:     strings.ToLower(cwr.Criteria.Flair),
 		Domain:    strings.ToLower(cwr.Criteria.Domain),
+
+		ExcludeNSFW:       cwr.Criteria.ExcludeNSFW,
+		ExcludeCrossposts: cwr.Criteria.ExcludeCrossposts,
 	}
 
+	var warning string
 	if cwr.Type == "subreddit" || cwr.Type == "trending" {
 		ac := a.reddit.NewAuthenticatedClient(account.AccountID, account.RefreshToken, account.AccessToken)
 		srr, err := ac.SubredditAbout(ctx, cwr.Subreddit)
 		if err != nil {
-			a.errorResponse(w, r, 500, err)
+			a.errorResponse(w, r, 500, fmt.Errorf("error watching %s: %w", cwr.Subreddit, err))
 			return
 		}
 		if !srr.Public {
-			a.errorResponse(w, r, 403, reddit.ErrSubredditIsPrivate)
+			a.errorResponse(w, r, 403, fmt.Errorf("error watching %s: %w", cwr.Subreddit, reddit.ErrSubredditIsPrivate))
 			return
-		} else if err != nil {
-			switch err {
-			case reddit.ErrSubredditIsPrivate, reddit.ErrSubredditIsQuarantined:
-				err = fmt.Errorf("error watching %s: %w", cwr.Subreddit, err)
-				a.errorResponse(w, r, 403, err)
-			default:
-				a.errorResponse(w, r, 422, err)
+		}
+
+		if !srr.AllowsPosts() {
+			rctx, rcancel := context.WithTimeout(ctx, subredditRulesTimeout)
+			rules, err := ac.SubredditRules(rctx, cwr.Subreddit)
+			rcancel()
+			if err != nil {
+				a.requestLogger(ctx).Warn("failed to fetch subreddit rules", zap.Error(err), zap.String("subreddit#name", cwr.Subreddit))
 			}
-			return
+			warning = subredditWarning(srr, rules)
 		}
 
 		sr, err := a.subredditRepo.GetByName(ctx, cwr.Subreddit)
@@ -139,7 +241,10 @@ func (a *api) createWatcherHandler(w http.ResponseWriter, r *http.Request) {
 			case domain.ErrNotFound:
 				// Might be that we don't know about that subreddit yet
 				sr = domain.Subreddit{SubredditID: srr.ID, Name: srr.Name}
-				_ = a.subredditRepo.CreateOrUpdate(ctx, &sr)
+				if err := a.subredditRepo.CreateOrUpdate(ctx, &sr); err != nil {
+					a.errorResponse(w, r, 422, err)
+					return
+				}
 			default:
 				a.errorResponse(w, r, 500, err)
 				return
@@ -190,9 +295,25 @@ func (a *api) createWatcherHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	res := watcherCreatedResponse{ID: watcher.ID, Warning: warning}
+
+	// Show what the watcher would have caught recently. Not worth failing over, or
+	// waiting long on, the watcher is already in place.
+	if watcher.Type == domain.SubredditWatcher {
+		bctx, bcancel := context.WithTimeout(ctx, watcherBackfillTimeout)
+		defer bcancel()
+
+		ac := a.reddit.NewAuthenticatedClient(account.AccountID, account.RefreshToken, account.AccessToken)
+		matches, err := backfillWatcher(bctx, ac, &watcher, cwr.Subreddit, time.Now())
+		if err != nil {
+			a.requestLogger(ctx).Warn("failed to backfill watcher", zap.Error(err), zap.Int64("watcher#id", watcher.ID))
+		}
+		res.Matches = matches
+	}
+
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(watcherCreatedResponse{ID: watcher.ID})
+	_ = json.NewEncoder(w).Encode(res)
 }
 
 func (a *api) editWatcherHandler(w http.ResponseWriter, r *http.Request) {
@@ -229,13 +350,21 @@ func (a *api) editWatcherHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := validation.Validate(ewr.Sound, validation.In(domain.NotificationSounds...)); err != nil {
+		a.errorResponse(w, r, 422, fmt.Errorf("sound: %w", err))
+		return
+	}
+
 	watcher.Label = ewr.Label
+	watcher.Sound = ewr.Sound
 	watcher.Author = strings.ToLower(ewr.User)
 	watcher.Subreddit = strings.ToLower(ewr.Subreddit)
 	watcher.Upvotes = ewr.Criteria.Upvotes
 	watcher.Keyword = strings.ToLower(ewr.Criteria.Keyword)
 	watcher.Flair = strings.ToLower(ewr.Criteria.Flair)
 	watcher.Domain = strings.ToLower(ewr.Criteria.Domain)
+	watcher.ExcludeNSFW = ewr.Criteria.ExcludeNSFW
+	watcher.ExcludeCrossposts = ewr.Criteria.ExcludeCrossposts
 
 	if watcher.Type == domain.SubredditWatcher {
 		lsr := strings.ToLower(watcher.Subreddit)
@@ -269,17 +398,12 @@ func (a *api) editWatcherHandler(w http.ResponseWriter, r *http.Request) {
 
 			ac := a.reddit.NewAuthenticatedClient(account.AccountID, account.RefreshToken, account.AccessToken)
 			srr, err := ac.SubredditAbout(ctx, lsr)
-			if !srr.Public {
-				a.errorResponse(w, r, 403, reddit.ErrSubredditIsPrivate)
+			if err != nil {
+				a.errorResponse(w, r, 500, fmt.Errorf("error watching %s: %w", lsr, err))
 				return
-			} else if err != nil {
-				switch err {
-				case reddit.ErrSubredditIsPrivate, reddit.ErrSubredditIsQuarantined:
-					err = fmt.Errorf("error watching %s: %w", lsr, err)
-					a.errorResponse(w, r, 403, err)
-				default:
-					a.errorResponse(w, r, 422, err)
-				}
+			}
+			if !srr.Public {
+				a.errorResponse(w, r, 403, fmt.Errorf("error watching %s: %w", lsr, reddit.ErrSubredditIsPrivate))
 				return
 			}
 
@@ -289,7 +413,10 @@ func (a *api) editWatcherHandler(w http.ResponseWriter, r *http.Request) {
 				case domain.ErrNotFound:
 					// Might be that we don't know about that subreddit yet
 					sr = domain.Subreddit{SubredditID: srr.ID, Name: srr.Name}
-					_ = a.subredditRepo.CreateOrUpdate(ctx, &sr)
+					if err := a.subredditRepo.CreateOrUpdate(ctx, &sr); err != nil {
+						a.errorResponse(w, r, 422, err)
+						return
+					}
 				default:
 					a.errorResponse(w, r, 500, err)
 					return
@@ -345,6 +472,10 @@ type watcherItem struct {
 	Domain      string    `json:"domain,omitempty"`
 	Hits        int64     `json:"hits"`
 	Author      string    `json:"author,omitempty"`
+	Sound       string    `json:"sound"`
+
+	ExcludeNSFW       bool `json:"exclude_nsfw,omitempty"`
+	ExcludeCrossposts bool `json:"exclude_crossposts,omitempty"`
 }
 
 func (a *api) listWatchersHandler(w http.ResponseWriter, r *http.Request) {
@@ -374,6 +505,10 @@ func (a *api) listWatchersHandler(w http.ResponseWriter, r *http.Request) {
 			Hits:        watcher.Hits,
 			Author:      watcher.Author,
 			Upvotes:     watcher.Upvotes,
+			Sound:       watcher.NotificationSound(),
+
+			ExcludeNSFW:       watcher.ExcludeNSFW,
+			ExcludeCrossposts: watcher.ExcludeCrossposts,
 		}
 
 		wis[i] = wi
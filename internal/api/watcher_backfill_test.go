@@ -0,0 +1,82 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+
+	"github.com/christianselig/apollo-backend/internal/api"
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+func TestBackfillWatcher(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	type post struct {
+		id, title string
+		age       time.Duration
+		score     int64
+	}
+	// Newest first, the way reddit lists them.
+	posts := []post{
+		{"p1", "Keyboard for sale", time.Hour, 5},
+		{"p2", "Mouse for sale", 2 * time.Hour, 50},
+		{"p3", "Another keyboard", 3 * time.Hour, 50},
+		{"p4", "Keyboard from the day before", 25 * time.Hour, 50},
+	}
+
+	children := make([]string, len(posts))
+	for i, p := range posts {
+		children[i] = fmt.Sprintf(`{"kind": "t3", "data": {"id": %q, "title": %q, "author": "janedoe", "score": %d, "created_utc": %d}}`,
+			p.id, p.title, p.score, now.Add(-p.age).Unix())
+	}
+	listing := fmt.Sprintf(`{"kind": "Listing", "data": {"children": [%s]}}`, strings.Join(children, ","))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/r/mechmarket/new", req.URL.Path)
+		_, _ = w.Write([]byte(listing))
+	}))
+	t.Cleanup(srv.Close)
+
+	rc := reddit.NewClient("<ID>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1, reddit.WithOAuthBaseURL(srv.URL))
+	rac := rc.NewAuthenticatedClient(reddit.SkipRateLimiting, "<REFRESH>", "<ACCESS>")
+
+	tt := map[string]struct {
+		watcher domain.Watcher
+		want    []string
+	}{
+		"keyword":              {domain.Watcher{Keyword: "keyboard"}, []string{"p1", "p3"}},
+		"keyword and upvotes":  {domain.Watcher{Keyword: "keyboard", Upvotes: 10}, []string{"p3"}},
+		"everything in window": {domain.Watcher{}, []string{"p1", "p2", "p3"}},
+		"nothing matches":      {domain.Watcher{Author: "johndoe"}, []string{}},
+		"older posts left out": {domain.Watcher{Keyword: "day before"}, []string{}},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			matches, err := api.BackfillWatcher(context.Background(), rac, &tc.watcher, "mechmarket", now)
+			require.NoError(t, err)
+
+			ids := []string{}
+			for _, m := range matches {
+				ids = append(ids, m.ID)
+			}
+			assert.Equal(t, tc.want, ids)
+		})
+	}
+}
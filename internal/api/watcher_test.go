@@ -0,0 +1,156 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+
+	"github.com/christianselig/apollo-backend/internal/api"
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestCreateWatcherHandler_UnwatchableSubreddits(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		status int
+		body   string
+		want   int
+		code   string
+	}{
+		"private":     {403, `{"reason": "private", "message": "Forbidden", "error": 403}`, http.StatusForbidden, "subreddit_private"},
+		"quarantined": {403, `{"reason": "quarantined", "message": "Forbidden", "error": 403}`, http.StatusForbidden, "subreddit_quarantined"},
+		"banned":      {404, `{"reason": "banned", "message": "Not Found", "error": 404}`, http.StatusNotFound, "subreddit_banned"},
+		"not found":   {404, `{"message": "Not Found", "error": 404}`, http.StatusNotFound, "subreddit_not_found"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			conn := testhelper.NewTestPgxConn(t)
+
+			tx, err := conn.Begin(ctx)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+			deviceRepo := repository.NewPostgresDevice(tx)
+			accountRepo := repository.NewPostgresAccount(tx)
+
+			dev := &domain.Device{APNSToken: oldToken, ExpiresAt: time.Now().Add(time.Hour), GracePeriodExpiresAt: time.Now().Add(time.Hour)}
+			require.NoError(t, deviceRepo.Create(ctx, dev))
+
+			acc := &domain.Account{Username: "janedoe", AccountID: "abc123", TokenExpiresAt: time.Now().Add(time.Hour)}
+			require.NoError(t, accountRepo.Create(ctx, acc))
+			require.NoError(t, accountRepo.Associate(ctx, acc, dev))
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			t.Cleanup(srv.Close)
+
+			rc := reddit.NewClient("<ID>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1, reddit.WithOAuthBaseURL(srv.URL))
+
+			body := strings.NewReader(`{"type": "subreddit", "subreddit": "pics", "label": "pics"}`)
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v1/device/%s/account/%s/watcher", oldToken, acc.AccountID), body)
+			rr := httptest.NewRecorder()
+
+			api.NewTestRedditAPI(tx, rc).ServeHTTP(rr, req)
+			assert.Equal(t, tc.want, rr.Code, rr.Body.String())
+
+			var res struct {
+				Error struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+			assert.Equal(t, tc.code, res.Error.Code)
+			assert.True(t, strings.HasPrefix(res.Error.Message, "error watching pics: "), res.Error.Message)
+		})
+	}
+}
+
+func TestSubredditWarning(t *testing.T) {
+	t.Parallel()
+
+	rules := &reddit.SubredditRulesResponse{Rules: []reddit.SubredditRule{
+		{Kind: "link", ShortName: "Sales only"},
+		{Kind: "comment", ShortName: "Be nice"},
+		{Kind: "all", ShortName: "No spam"},
+	}}
+
+	tt := map[string]struct {
+		restricted bool
+		rules      *reddit.SubredditRulesResponse
+		want       string
+	}{
+		"public":              {false, rules, ""},
+		"restricted":          {true, rules, "r/mechmarket is restricted, only approved users can post there. Its rules for posts: Sales only; No spam."},
+		"restricted no rules": {true, nil, "r/mechmarket is restricted, only approved users can post there."},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			srr := &reddit.SubredditResponse{Name: "mechmarket", Public: true, Restricted: tc.restricted}
+			assert.Equal(t, tc.want, api.SubredditWarning(srr, tc.rules))
+		})
+	}
+}
+
+func TestCreateWatcherHandler_InvalidSubredditName(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]string{
+		"subreddit":   `{"type": "subreddit", "subreddit": "r/pics", "label": "pics"}`,
+		"trending":    `{"type": "trending", "subreddit": "ask reddit"}`,
+		"blank":       `{"type": "subreddit", "subreddit": "", "label": "pics"}`,
+		"too long":    `{"type": "subreddit", "subreddit": "GamingLeaksAndRumours2", "label": "pics"}`,
+		"user prefix": `{"type": "subreddit", "subreddit": "u_iamthatis", "label": "pics"}`,
+	}
+
+	for scenario, body := range tt {
+		body := body
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			// Rejected before the database or reddit get involved.
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v1/device/%s/account/abc123/watcher", oldToken), strings.NewReader(body))
+			rr := httptest.NewRecorder()
+
+			api.NewTestRedditAPI(nil, nil).ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusUnprocessableEntity, rr.Code, rr.Body.String())
+		})
+	}
+}
+
+func TestCreateWatcherHandler_InvalidSound(t *testing.T) {
+	t.Parallel()
+
+	// Rejected before the database or reddit get involved.
+	body := `{"type": "subreddit", "subreddit": "buildapcsales", "label": "deals", "sound": "airhorn.wav"}`
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v1/device/%s/account/abc123/watcher", oldToken), strings.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	api.NewTestRedditAPI(nil, nil).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code, rr.Body.String())
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/repository"
+)
+
+type accountInspection struct {
+	ID                           int64     `json:"id"`
+	Username                     string    `json:"username"`
+	RedditID                     string    `json:"reddit_id"`
+	Development                  bool      `json:"development"`
+	LastMessageID                string    `json:"last_message_id"`
+	CheckCount                   int64     `json:"check_count"`
+	IdleCheckCount               int64     `json:"idle_check_count"`
+	RevocationConfirmations      int64     `json:"revocation_confirmations"`
+	NextNotificationCheckAt      time.Time `json:"next_notification_check_at"`
+	NextStuckNotificationCheckAt time.Time `json:"next_stuck_notification_check_at"`
+	TokenExpiresAt               time.Time `json:"token_expires_at"`
+
+	Devices []deviceInspection `json:"devices"`
+}
+
+type deviceInspection struct {
+	ID                   int64     `json:"id"`
+	APNSToken            string    `json:"apns_token"`
+	Sandbox              bool      `json:"sandbox"`
+	GracePeriodExpiresAt time.Time `json:"grace_period_expires_at"`
+	InboxNotifiable      bool      `json:"inbox_notifiable"`
+	WatcherNotifiable    bool      `json:"watcher_notifiable"`
+	GlobalMute           bool      `json:"global_mute"`
+}
+
+// inspectAccount gathers everything about an account's notification state, along
+// with the devices it's on. APNs tokens are obfuscated and OAuth tokens left out.
+func inspectAccount(ctx context.Context, accountRepo domain.AccountRepository, deviceRepo domain.DeviceRepository, redditID string) (accountInspection, error) {
+	acc, err := accountRepo.GetByRedditID(ctx, redditID)
+	if err != nil {
+		return accountInspection{}, fmt.Errorf("could not find account %s: %w", redditID, err)
+	}
+
+	devs, err := deviceRepo.GetByAccountID(ctx, acc.ID)
+	if err != nil {
+		return accountInspection{}, err
+	}
+
+	ai := accountInspection{
+		ID:                           acc.ID,
+		Username:                     acc.Username,
+		RedditID:                     acc.AccountID,
+		Development:                  acc.Development,
+		LastMessageID:                acc.LastMessageID,
+		CheckCount:                   acc.CheckCount,
+		IdleCheckCount:               acc.IdleCheckCount,
+		RevocationConfirmations:      acc.RevocationConfirmations,
+		NextNotificationCheckAt:      acc.NextNotificationCheckAt,
+		NextStuckNotificationCheckAt: acc.NextStuckNotificationCheckAt,
+		TokenExpiresAt:               acc.TokenExpiresAt,
+		Devices:                      make([]deviceInspection, 0, len(devs)),
+	}
+
+	for _, dev := range devs {
+		dev := dev
+
+		inbox, watcher, global, err := deviceRepo.GetNotifiable(ctx, &dev, &acc)
+		if err != nil {
+			return accountInspection{}, err
+		}
+
+		ai.Devices = append(ai.Devices, deviceInspection{
+			ID:                   dev.ID,
+			APNSToken:            dev.ObfuscatedToken(),
+			Sandbox:              dev.Sandbox,
+			GracePeriodExpiresAt: dev.GracePeriodExpiresAt,
+			InboxNotifiable:      inbox,
+			WatcherNotifiable:    watcher,
+			GlobalMute:           global,
+		})
+	}
+
+	return ai, nil
+}
+
+func (ai accountInspection) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	rows := []struct {
+		name  string
+		value interface{}
+	}{
+		{"id", ai.ID},
+		{"username", ai.Username},
+		{"reddit id", ai.RedditID},
+		{"development", ai.Development},
+		{"last message id", ai.LastMessageID},
+		{"check count", ai.CheckCount},
+		{"idle check count", ai.IdleCheckCount},
+		{"revocation confirmations", ai.RevocationConfirmations},
+		{"next notification check", ai.NextNotificationCheckAt.Format(time.RFC3339)},
+		{"next stuck notification check", ai.NextStuckNotificationCheckAt.Format(time.RFC3339)},
+		{"token expires", ai.TokenExpiresAt.Format(time.RFC3339)},
+	}
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%v\n", row.name, row.value)
+	}
+
+	fmt.Fprintf(tw, "\ndevice\ttoken\tsandbox\tgrace period expires\tinbox\twatcher\tglobal mute\n")
+	for _, dev := range ai.Devices {
+		fmt.Fprintf(tw, "%d\t%s\t%t\t%s\t%t\t%t\t%t\n",
+			dev.ID,
+			dev.APNSToken,
+			dev.Sandbox,
+			dev.GracePeriodExpiresAt.Format(time.RFC3339),
+			dev.InboxNotifiable,
+			dev.WatcherNotifiable,
+			dev.GlobalMute,
+		)
+	}
+
+	return tw.Flush()
+}
+
+func AccountCmd(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "account",
+		Short: "Look into accounts, for support and debugging.",
+	}
+
+	cmd.AddCommand(accountInspectCmd(ctx))
+
+	return cmd
+}
+
+func accountInspectCmd(ctx context.Context) *cobra.Command {
+	var redditID string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "inspect",
+		Args:  cobra.ExactArgs(0),
+		Short: "Show an account's notification state and the devices it's on.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if redditID == "" {
+				return fmt.Errorf("--reddit-id is required")
+			}
+
+			db, err := cmdutil.NewDatabasePool(ctx, 1)
+			if err != nil {
+				return fmt.Errorf("could not connect to database: %w", err)
+			}
+			defer db.Close()
+
+			ai, err := inspectAccount(ctx, repository.NewPostgresAccount(db), repository.NewPostgresDevice(db), redditID)
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(ai)
+			}
+
+			return ai.writeTable(cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().StringVar(&redditID, "reddit-id", "", "The Reddit ID of the account")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the account as JSON")
+
+	return cmd
+}
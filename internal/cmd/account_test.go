@@ -0,0 +1,87 @@
+package cmd_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/cmd"
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestInspectAccount(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	accountRepo := repository.NewPostgresAccount(tx)
+	deviceRepo := repository.NewPostgresDevice(tx)
+
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	acc := &domain.Account{
+		Username:       "janedoe",
+		AccountID:      "inspect-abc123",
+		AccessToken:    "<ACCESS>",
+		RefreshToken:   "<REFRESH>",
+		TokenExpiresAt: expiry,
+		LastMessageID:  "t4_xyz",
+	}
+	require.NoError(t, accountRepo.Create(ctx, acc))
+
+	dev := &domain.Device{APNSToken: "inspect-0123456789abcdef", Sandbox: true, ExpiresAt: expiry, GracePeriodExpiresAt: expiry}
+	require.NoError(t, deviceRepo.Create(ctx, dev))
+	require.NoError(t, accountRepo.Associate(ctx, acc, dev))
+	require.NoError(t, deviceRepo.SetNotifiable(ctx, dev, acc, true, false, false))
+
+	ai, err := cmd.InspectAccount(ctx, accountRepo, deviceRepo, "inspect-abc123")
+	require.NoError(t, err)
+
+	assert.Equal(t, acc.ID, ai.ID)
+	assert.Equal(t, "janedoe", ai.Username)
+	assert.Equal(t, "t4_xyz", ai.LastMessageID)
+	assert.True(t, expiry.Equal(ai.TokenExpiresAt))
+
+	require.Len(t, ai.Devices, 1)
+	assert.Equal(t, dev.ID, ai.Devices[0].ID)
+	assert.Equal(t, dev.ObfuscatedToken(), ai.Devices[0].APNSToken)
+	assert.True(t, ai.Devices[0].Sandbox)
+	assert.True(t, ai.Devices[0].InboxNotifiable)
+	assert.False(t, ai.Devices[0].WatcherNotifiable)
+
+	_, err = cmd.InspectAccount(ctx, accountRepo, deviceRepo, "inspect-missing")
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
+
+func TestAccountInspectionTable(t *testing.T) {
+	t.Parallel()
+
+	at := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+	ai := cmd.AccountInspection{
+		ID:                           1,
+		Username:                     "janedoe",
+		RedditID:                     "abc123",
+		LastMessageID:                "t4_xyz",
+		CheckCount:                   12,
+		NextNotificationCheckAt:      at,
+		NextStuckNotificationCheckAt: at,
+		TokenExpiresAt:               at,
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, ai.WriteTable(&out))
+
+	assert.Contains(t, out.String(), "last message id                t4_xyz\n")
+	assert.Contains(t, out.String(), "check count                    12\n")
+	assert.Contains(t, out.String(), "token expires                  2022-06-01T12:00:00Z\n")
+}
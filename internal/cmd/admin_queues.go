@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/adjust/rmq/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	adminSecretHeader = "X-Apollo-Admin-Secret"
+	adminQueuesPath   = "/admin/queues"
+)
+
+type queueStatItem struct {
+	Name     string `json:"name"`
+	Ready    int64  `json:"ready"`
+	Unacked  int64  `json:"unacked"`
+	Rejected int64  `json:"rejected"`
+}
+
+type queueDrainResponse struct {
+	Name   string `json:"name"`
+	Purged int64  `json:"purged"`
+}
+
+// queueAdminHandler serves the queue admin endpoints on the scheduler's server:
+//
+//	GET  /admin/queues              ready, unacked and rejected counts for every open queue
+//	POST /admin/queues/{name}/drain purges the rejected jobs of a queue
+//
+// Requests need the shared admin secret. Without one configured, nobody gets in.
+func queueAdminHandler(logger *zap.Logger, conn rmq.Connection, secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := r.Header.Get(adminSecretHeader)
+		if secret == "" || subtle.ConstantTimeCompare([]byte(given), []byte(secret)) != 1 {
+			adminError(w, http.StatusUnauthorized, "missing or invalid admin secret")
+			return
+		}
+
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		if path == adminQueuesPath {
+			if r.Method != http.MethodGet {
+				adminError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			queueStatsHandler(logger, conn, w)
+			return
+		}
+
+		name := strings.TrimPrefix(path, adminQueuesPath+"/")
+		name = strings.TrimSuffix(name, "/drain")
+		if name == "" || strings.Contains(name, "/") || !strings.HasSuffix(path, "/drain") {
+			adminError(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			adminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		queueDrainHandler(logger, conn, name, w)
+	})
+}
+
+func queueStatsHandler(logger *zap.Logger, conn rmq.Connection, w http.ResponseWriter) {
+	names, err := conn.GetOpenQueues()
+	if err != nil {
+		logger.Error("failed to list queues", zap.Error(err))
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	stats, err := conn.CollectStats(names)
+	if err != nil {
+		logger.Error("failed to collect queue stats", zap.Error(err))
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	items := make([]queueStatItem, 0, len(stats.QueueStats))
+	for name, stat := range stats.QueueStats {
+		items = append(items, queueStatItem{
+			Name:     name,
+			Ready:    stat.ReadyCount,
+			Unacked:  stat.UnackedCount(),
+			Rejected: stat.RejectedCount,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+
+	adminJSON(w, http.StatusOK, items)
+}
+
+func queueDrainHandler(logger *zap.Logger, conn rmq.Connection, name string, w http.ResponseWriter) {
+	// Only drain queues that already exist, opening anything else would create it.
+	names, err := conn.GetOpenQueues()
+	if err != nil {
+		logger.Error("failed to list queues", zap.Error(err))
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	found := false
+	for _, n := range names {
+		if n == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		adminError(w, http.StatusNotFound, "unknown queue")
+		return
+	}
+
+	queue, err := conn.OpenQueue(name)
+	if err != nil {
+		logger.Error("failed to open queue", zap.Error(err), zap.String("queue", name))
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	count, err := queue.PurgeRejected()
+	if err != nil {
+		logger.Error("failed to purge rejected jobs", zap.Error(err), zap.String("queue", name))
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.Info("purged rejected jobs", zap.Int64("count", count), zap.String("queue", name))
+	adminJSON(w, http.StatusOK, queueDrainResponse{Name: name, Purged: count})
+}
+
+func adminJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func adminError(w http.ResponseWriter, status int, message string) {
+	adminJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,149 @@
+package cmd_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/cmd"
+)
+
+type stubQueue struct {
+	*rmq.TestQueue
+
+	rejected int64
+}
+
+func (q *stubQueue) PurgeRejected() (int64, error) {
+	count := q.rejected
+	q.rejected = 0
+	return count, nil
+}
+
+type stubConnection struct {
+	rmq.Connection
+
+	queues map[string]*stubQueue
+}
+
+func (c *stubConnection) GetOpenQueues() ([]string, error) {
+	names := make([]string, 0, len(c.queues))
+	for name := range c.queues {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (c *stubConnection) CollectStats(names []string) (rmq.Stats, error) {
+	stats := rmq.NewStats()
+	for _, name := range names {
+		stats.QueueStats[name] = rmq.NewQueueStat(int64(len(name)), c.queues[name].rejected)
+	}
+	return stats, nil
+}
+
+func (c *stubConnection) OpenQueue(name string) (rmq.Queue, error) {
+	return c.queues[name], nil
+}
+
+func newStubConnection() *stubConnection {
+	return &stubConnection{
+		Connection: rmq.NewTestConnection(),
+		queues: map[string]*stubQueue{
+			"users":      {TestQueue: rmq.NewTestQueue("users"), rejected: 3},
+			"subreddits": {TestQueue: rmq.NewTestQueue("subreddits"), rejected: 0},
+		},
+	}
+}
+
+func TestQueueAdminHandler(t *testing.T) {
+	t.Parallel()
+
+	type request struct {
+		method string
+		path   string
+		secret string
+		status int
+	}
+
+	tests := map[string]struct {
+		secret string
+		req    request
+	}{
+		"no secret configured": {"", request{"GET", "/admin/queues", "", http.StatusUnauthorized}},
+		"missing secret":       {"s3cret", request{"GET", "/admin/queues", "", http.StatusUnauthorized}},
+		"wrong secret":         {"s3cret", request{"GET", "/admin/queues", "nope", http.StatusUnauthorized}},
+		"stats":                {"s3cret", request{"GET", "/admin/queues", "s3cret", http.StatusOK}},
+		"stats wrong method":   {"s3cret", request{"POST", "/admin/queues", "s3cret", http.StatusMethodNotAllowed}},
+		"drain":                {"s3cret", request{"POST", "/admin/queues/users/drain", "s3cret", http.StatusOK}},
+		"drain wrong method":   {"s3cret", request{"GET", "/admin/queues/users/drain", "s3cret", http.StatusMethodNotAllowed}},
+		"drain unknown queue":  {"s3cret", request{"POST", "/admin/queues/nope/drain", "s3cret", http.StatusNotFound}},
+		"unknown path":         {"s3cret", request{"POST", "/admin/queues/users/purge", "s3cret", http.StatusNotFound}},
+	}
+
+	for scenario, tc := range tests {
+		tc := tc
+
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			h := cmd.QueueAdminHandler(zap.NewNop(), newStubConnection(), tc.secret)
+
+			req := httptest.NewRequest(tc.req.method, tc.req.path, nil)
+			if tc.req.secret != "" {
+				req.Header.Set("X-Apollo-Admin-Secret", tc.req.secret)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.req.status, rr.Code)
+		})
+	}
+}
+
+func TestQueueAdminHandler_Stats(t *testing.T) {
+	t.Parallel()
+
+	h := cmd.QueueAdminHandler(zap.NewNop(), newStubConnection(), "s3cret")
+
+	req := httptest.NewRequest("GET", "/admin/queues", nil)
+	req.Header.Set("X-Apollo-Admin-Secret", "s3cret")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var body []map[string]interface{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+	assert.Equal(t, []map[string]interface{}{
+		{"name": "subreddits", "ready": float64(10), "unacked": float64(0), "rejected": float64(0)},
+		{"name": "users", "ready": float64(5), "unacked": float64(0), "rejected": float64(3)},
+	}, body)
+}
+
+func TestQueueAdminHandler_Drain(t *testing.T) {
+	t.Parallel()
+
+	conn := newStubConnection()
+	h := cmd.QueueAdminHandler(zap.NewNop(), conn, "s3cret")
+
+	drain := func() map[string]interface{} {
+		req := httptest.NewRequest("POST", "/admin/queues/users/drain", nil)
+		req.Header.Set("X-Apollo-Admin-Secret", "s3cret")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+		return body
+	}
+
+	assert.Equal(t, map[string]interface{}{"name": "users", "purged": float64(3)}, drain())
+	assert.Equal(t, map[string]interface{}{"name": "users", "purged": float64(0)}, drain())
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
+	"github.com/christianselig/apollo-backend/internal/worker"
+)
+
+// deadLetterReadyKey is where rmq keeps the jobs waiting in the dead-letter queue.
+var deadLetterReadyKey = fmt.Sprintf("rmq::queue::[%s]::ready", worker.DeadLetterQueue)
+
+func DeadLetterCmd(ctx context.Context) *cobra.Command {
+	var limit int64
+	var purge bool
+
+	cmd := &cobra.Command{
+		Use:   "dead-letter",
+		Args:  cobra.ExactArgs(0),
+		Short: "Inspect jobs that kept failing and were moved to the dead-letter queue.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			qredis, err := cmdutil.NewRedisQueueClient(ctx, 1)
+			if err != nil {
+				return err
+			}
+			defer qredis.Close()
+
+			if purge {
+				logger := cmdutil.NewLogger("dead-letter")
+				defer func() { _ = logger.Sync() }()
+
+				queue, err := cmdutil.NewQueueClient(logger, qredis, "dead-letter")
+				if err != nil {
+					return err
+				}
+
+				dlq, err := queue.OpenQueue(worker.DeadLetterQueue)
+				if err != nil {
+					return err
+				}
+
+				count, err := dlq.PurgeReady()
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "purged %d jobs\n", count)
+				return nil
+			}
+
+			// Oldest jobs are on the right.
+			payloads, err := qredis.LRange(ctx, deadLetterReadyKey, -limit, -1).Result()
+			if err != nil {
+				return err
+			}
+
+			for i := len(payloads) - 1; i >= 0; i-- {
+				var dl worker.DeadLetter
+				if err := json.Unmarshal([]byte(payloads[i]), &dl); err != nil {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s\n", payloads[i])
+					continue
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\t%s\t%d attempts\n", dl.FailedAt.Format("2006-01-02T15:04:05Z"), dl.Queue, dl.Payload, dl.Attempts)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Int64Var(&limit, "limit", 50, "The number of jobs to show, oldest first")
+	cmd.Flags().BoolVar(&purge, "purge", false, "Remove every job from the dead-letter queue")
+
+	return cmd
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// enqueueTrackerKey holds, per queue, when it last had a clean enqueue run.
+const enqueueTrackerKey = "scheduler:last-enqueues"
+
+// enqueueTracker remembers when each queue last had a clean enqueue run, so a
+// scheduler that's stuck or failing can be alerted on. It's kept in Redis so that it
+// doesn't matter which scheduler held the lock for a run.
+type enqueueTracker struct {
+	logger *zap.Logger
+	redis  *redis.Client
+}
+
+func newEnqueueTracker(logger *zap.Logger, redis *redis.Client) *enqueueTracker {
+	return &enqueueTracker{logger: logger, redis: redis}
+}
+
+func (et *enqueueTracker) mark(ctx context.Context, queue string, now time.Time) {
+	if err := et.redis.HSet(ctx, enqueueTrackerKey, queue, now.UnixMilli()).Err(); err != nil {
+		et.logger.Error("failed to track enqueue", zap.Error(err), zap.String("queue", queue))
+	}
+}
+
+// ages returns how long ago each tracked queue last had a clean enqueue run.
+func (et *enqueueTracker) ages(ctx context.Context, now time.Time) (map[string]time.Duration, error) {
+	at, err := et.redis.HGetAll(ctx, enqueueTrackerKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ages := make(map[string]time.Duration, len(at))
+	for queue, ms := range at {
+		ts, err := strconv.ParseInt(ms, 10, 64)
+		if err != nil {
+			continue
+		}
+		ages[queue] = now.Sub(time.UnixMilli(ts))
+	}
+	return ages, nil
+}
@@ -0,0 +1,43 @@
+package cmd_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/cmd"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestEnqueueTracker(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testhelper.NewTestRedisClient(t)
+
+	require.NoError(t, client.Del(ctx, cmd.EnqueueTrackerKey).Err())
+	t.Cleanup(func() { _ = client.Del(ctx, cmd.EnqueueTrackerKey).Err() })
+
+	now := time.UnixMilli(time.Now().UnixMilli())
+	et := cmd.NewEnqueueTracker(zap.NewNop(), client)
+
+	ages, err := et.Ages(ctx, now)
+	require.NoError(t, err)
+	assert.Empty(t, ages)
+
+	et.Mark(ctx, "notifications", now.Add(-time.Minute))
+	et.Mark(ctx, "users", now.Add(-time.Hour))
+	et.Mark(ctx, "users", now.Add(-5*time.Second))
+
+	// Another scheduler sees the same runs.
+	ages, err = cmd.NewEnqueueTracker(zap.NewNop(), client).Ages(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{
+		"notifications": time.Minute,
+		"users":         5 * time.Second,
+	}, ages)
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+var (
+	Jitter          = jitter
+	Jittered        = jittered
+	EveryWithJitter = everyWithJitter
+
+	Chunk         = chunk
+	Spread        = spread
+	EnqueueSpread = enqueueSpread
+	PublishSpread = publishSpread
+
+	NewEnqueueTracker = newEnqueueTracker
+
+	StuckBatches = stuckBatches
+)
+
+type StuckAccount = stuckAccount
+
+func NewStuckAccount(id int64, lastMessageID string) StuckAccount {
+	return stuckAccount{id: id, lastMessageID: lastMessageID}
+}
+
+type EnqueueTracker = enqueueTracker
+
+const EnqueueTrackerKey = enqueueTrackerKey
+
+func (et *EnqueueTracker) Mark(ctx context.Context, queue string, now time.Time) {
+	et.mark(ctx, queue, now)
+}
+
+func (et *EnqueueTracker) Ages(ctx context.Context, now time.Time) (map[string]time.Duration, error) {
+	return et.ages(ctx, now)
+}
+
+var QueueAdminHandler = queueAdminHandler
+
+var ClaimLiveActivities = claimLiveActivities
+
+var Singleton = singleton
+
+var InspectAccount = inspectAccount
+
+type AccountInspection = accountInspection
+
+func (ai AccountInspection) WriteTable(w io.Writer) error { return ai.writeTable(w) }
+
+var (
+	NewMigrator          = newMigrator
+	MigrationStatus      = migrationStatus
+	WriteMigrationStatus = writeMigrationStatus
+)
+
+type MigrationState = migrationState
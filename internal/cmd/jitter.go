@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/go-co-op/gocron"
+
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
+)
+
+const defaultSchedulerJitter = time.Second
+
+// jitter returns a random duration in [0, max).
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// jittered wraps fn so every run is delayed by a random offset of up to max, bailing
+// out if the context gets cancelled while waiting.
+func jittered(ctx context.Context, max time.Duration, fn func()) func() {
+	return func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jitter(max)):
+		}
+
+		fn()
+	}
+}
+
+// schedulerJitter returns the maximum jitter for a job, configurable through
+// SCHEDULER_JITTER_<NAME> (e.g. SCHEDULER_JITTER_ACCOUNTS=2s).
+func schedulerJitter(name string) time.Duration {
+	key := fmt.Sprintf("SCHEDULER_JITTER_%s", strings.ToUpper(name))
+	return cmdutil.DurationFromEnv(key, defaultSchedulerJitter)
+}
+
+// everyWithJitter schedules fn to run every interval, offsetting both its first run and
+// each subsequent run so jobs sharing an interval don't all fire on the same tick.
+func everyWithJitter(ctx context.Context, s *gocron.Scheduler, name string, interval time.Duration, fn func()) (*gocron.Job, error) {
+	max := schedulerJitter(name)
+
+	return s.Every(interval).
+		StartAt(time.Now().Add(jitter(max))).
+		Do(jittered(ctx, max, fn))
+}
@@ -0,0 +1,72 @@
+package cmd_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/cmd"
+)
+
+func TestJitter(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Duration(0), cmd.Jitter(0))
+	assert.Equal(t, time.Duration(0), cmd.Jitter(-time.Second))
+
+	for i := 0; i < 1000; i++ {
+		d := cmd.Jitter(time.Second)
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.Less(t, d, time.Second)
+	}
+}
+
+func TestJittered(t *testing.T) {
+	t.Parallel()
+
+	t.Run("runs within bounds", func(t *testing.T) {
+		t.Parallel()
+
+		ran := false
+		start := time.Now()
+		cmd.Jittered(context.Background(), 50*time.Millisecond, func() { ran = true })()
+
+		assert.True(t, ran)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	t.Run("skips when cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		ran := false
+		cmd.Jittered(ctx, time.Hour, func() { ran = true })()
+
+		assert.False(t, ran)
+	})
+}
+
+func TestEveryWithJitter(t *testing.T) {
+	t.Parallel()
+
+	s := gocron.NewScheduler(time.UTC)
+	interval := 5 * time.Second
+
+	start := time.Now()
+	job, err := cmd.EveryWithJitter(context.Background(), s, "test", interval, func() {})
+	require.NoError(t, err)
+
+	s.StartAsync()
+	defer s.Stop()
+
+	// The first run is offset by up to the default jitter, but never past a full interval.
+	next := job.NextRun()
+	assert.False(t, next.Before(start.Truncate(time.Second)))
+	assert.True(t, next.Before(start.Add(interval)))
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/spf13/cobra"
+
+	"github.com/christianselig/apollo-backend/migrations"
+)
+
+type migrationState struct {
+	Version uint
+	Name    string
+	Applied bool
+
+	// Dirty is set on the migration that failed halfway, leaving the database dirty.
+	Dirty bool
+}
+
+// newMigrator returns a migrator for the embedded migrations against the database at
+// databaseURL. It keeps track of them in schema_migrations, just like the migrate CLI.
+func newMigrator(databaseURL string) (*migrate.Migrate, error) {
+	u, err := url.Parse(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database url: %w", err)
+	}
+	u.Scheme = "postgres"
+
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.NewWithSourceInstance("iofs", src, u.String())
+}
+
+// migrationStatus lists every embedded migration, oldest first, with whether it has been
+// applied or failed halfway.
+func migrationStatus(m *migrate.Migrate) ([]migrationState, error) {
+	current, dirty, err := m.Version()
+	applied := true
+	if errors.Is(err, migrate.ErrNilVersion) {
+		applied = false
+	} else if err != nil {
+		return nil, err
+	}
+
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	states := []migrationState{}
+	version, err := src.First()
+	for err == nil {
+		var name string
+		if name, err = migrationName(src, version); err != nil {
+			return nil, err
+		}
+
+		failed := applied && dirty && version == current
+		states = append(states, migrationState{
+			Version: version,
+			Name:    name,
+			Applied: applied && version <= current && !failed,
+			Dirty:   failed,
+		})
+
+		version, err = src.Next(version)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return states, nil
+}
+
+func migrationName(src source.Driver, version uint) (string, error) {
+	r, name, err := src.ReadUp(version)
+	if err != nil {
+		return "", err
+	}
+	_ = r.Close()
+	return name, nil
+}
+
+func writeMigrationStatus(w io.Writer, states []migrationState) error {
+	dirty := false
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "version\tname\tstatus\n")
+	for _, s := range states {
+		status := "pending"
+		switch {
+		case s.Dirty:
+			status = "dirty"
+			dirty = true
+		case s.Applied:
+			status = "applied"
+		}
+		fmt.Fprintf(tw, "%06d\t%s\t%s\n", s.Version, s.Name, status)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if dirty {
+		fmt.Fprintln(w, "\nthe last applied migration failed halfway, the database is dirty")
+	}
+	return nil
+}
+
+func MigrateCmd(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Check on and apply the database migrations.",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Args:  cobra.ExactArgs(0),
+		Short: "List the migrations and whether they have been applied.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := newMigrator(os.Getenv("DATABASE_URL"))
+			if err != nil {
+				return err
+			}
+			defer m.Close()
+
+			states, err := migrationStatus(m)
+			if err != nil {
+				return err
+			}
+
+			return writeMigrationStatus(cmd.OutOrStdout(), states)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "up",
+		Args:  cobra.ExactArgs(0),
+		Short: "Apply every pending migration.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := newMigrator(os.Getenv("DATABASE_URL"))
+			if err != nil {
+				return err
+			}
+			defer m.Close()
+
+			done := make(chan struct{})
+			defer close(done)
+
+			go func() {
+				select {
+				case <-ctx.Done():
+					m.GracefulStop <- true
+				case <-done:
+				}
+			}()
+
+			switch err := m.Up(); {
+			case errors.Is(err, migrate.ErrNoChange):
+				fmt.Fprintln(cmd.OutOrStdout(), "no pending migrations")
+				return nil
+			case err != nil:
+				return err
+			}
+
+			version, _, err := m.Version()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "migrated to version %d\n", version)
+			return nil
+		},
+	})
+
+	return cmd
+}
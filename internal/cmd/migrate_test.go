@@ -0,0 +1,90 @@
+package cmd_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/cmd"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestMigrationStatus(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	// Run the migrations in a schema of their own so they don't clash with the test
+	// database's tables.
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	_, err := conn.Exec(ctx, fmt.Sprintf("CREATE SCHEMA %s", schema))
+	require.NoError(t, err)
+	t.Cleanup(func() { _, _ = conn.Exec(ctx, fmt.Sprintf("DROP SCHEMA %s CASCADE", schema)) })
+
+	u, err := url.Parse(os.Getenv("DATABASE_URL"))
+	require.NoError(t, err)
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	m, err := cmd.NewMigrator(u.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _, _ = m.Close() })
+
+	states, err := cmd.MigrationStatus(m)
+	require.NoError(t, err)
+	require.Greater(t, len(states), 2)
+	assert.Equal(t, cmd.MigrationState{Version: 1, Name: "create_accounts", Applied: false}, states[0])
+	for _, s := range states {
+		assert.False(t, s.Applied, "migration %d", s.Version)
+	}
+
+	require.NoError(t, m.Steps(2))
+
+	states, err = cmd.MigrationStatus(m)
+	require.NoError(t, err)
+	for i, s := range states {
+		assert.Equal(t, i < 2, s.Applied, "migration %d", s.Version)
+		assert.False(t, s.Dirty, "migration %d", s.Version)
+	}
+
+	// A migration that failed halfway shows up as dirty rather than applied.
+	require.NoError(t, m.Force(2))
+	_, err = conn.Exec(ctx, fmt.Sprintf("UPDATE %s.schema_migrations SET dirty = TRUE", schema))
+	require.NoError(t, err)
+
+	states, err = cmd.MigrationStatus(m)
+	require.NoError(t, err)
+	assert.True(t, states[0].Applied)
+	assert.Equal(t, cmd.MigrationState{Version: 2, Name: "create_devices", Applied: false, Dirty: true}, states[1])
+}
+
+func TestWriteMigrationStatus(t *testing.T) {
+	t.Parallel()
+
+	states := []cmd.MigrationState{
+		{Version: 1, Name: "create_accounts", Applied: true},
+		{Version: 2, Name: "create_devices", Applied: false},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, cmd.WriteMigrationStatus(&out, states))
+	assert.Equal(t, "version  name             status\n"+
+		"000001   create_accounts  applied\n"+
+		"000002   create_devices   pending\n", out.String())
+
+	states[1].Dirty = true
+
+	out.Reset()
+	require.NoError(t, cmd.WriteMigrationStatus(&out, states))
+	assert.Contains(t, out.String(), "000002   create_devices   dirty\n")
+	assert.Contains(t, out.String(), "the database is dirty")
+}
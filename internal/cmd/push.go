@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/payload"
+	"github.com/spf13/cobra"
+
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
+)
+
+const pushTopic = "com.christianselig.Apollo"
+
+type pushOptions struct {
+	token   string
+	sandbox bool
+	title   string
+	body    string
+	dryRun  bool
+}
+
+func (o pushOptions) notification() (*apns2.Notification, error) {
+	if o.token == "" {
+		return nil, errors.New("an APNs token is required")
+	}
+	if o.title == "" && o.body == "" {
+		return nil, errors.New("a title or a body is required")
+	}
+
+	return &apns2.Notification{
+		DeviceToken: o.token,
+		Topic:       pushTopic,
+		Payload:     payload.NewPayload().AlertTitle(o.title).AlertBody(o.body),
+	}, nil
+}
+
+func PushCmd(ctx context.Context) *cobra.Command {
+	var opts pushOptions
+
+	cmd := &cobra.Command{
+		Use:   "push",
+		Args:  cobra.ExactArgs(0),
+		Short: "Send a one-off notification to an APNs token.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			notification, err := opts.notification()
+			if err != nil {
+				return err
+			}
+
+			env := "production"
+			if opts.sandbox {
+				env = "sandbox"
+			}
+
+			if opts.dryRun {
+				bb, err := json.Marshal(notification.Payload)
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "would send to %s (%s): %s\n", notification.DeviceToken, env, bb)
+				return nil
+			}
+
+			tok, err := cmdutil.NewAPNSToken()
+			if err != nil {
+				return fmt.Errorf("could not load APNs key: %w", err)
+			}
+
+			client := apns2.NewTokenClient(tok).Production()
+			if opts.sandbox {
+				client = client.Development()
+			}
+
+			res, err := client.PushWithContext(ctx, notification)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: %d %s (apns-id %s)\n", env, res.StatusCode, res.Reason, res.ApnsID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.token, "token", "", "The APNs token to send the notification to")
+	cmd.Flags().BoolVar(&opts.sandbox, "sandbox", false, "Send through the APNs sandbox instead of production")
+	cmd.Flags().StringVar(&opts.title, "title", "", "The notification's title")
+	cmd.Flags().StringVar(&opts.body, "body", "", "The notification's body")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "Print the notification instead of sending it")
+
+	return cmd
+}
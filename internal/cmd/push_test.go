@@ -0,0 +1,58 @@
+package cmd_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/cmd"
+)
+
+func TestPushCmd(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		args []string
+		want string
+		err  string
+	}{
+		"production": {
+			args: []string{"--token", "abc123", "--title", "Hello", "--body", "World", "--dry-run"},
+			want: `would send to abc123 (production): {"aps":{"alert":{"body":"World","title":"Hello"}}}` + "\n",
+		},
+		"sandbox": {
+			args: []string{"--token", "abc123", "--sandbox", "--body", "World", "--dry-run"},
+			want: `would send to abc123 (sandbox): {"aps":{"alert":{"body":"World"}}}` + "\n",
+		},
+		"missing token":   {args: []string{"--title", "Hello", "--dry-run"}, err: "an APNs token is required"},
+		"missing content": {args: []string{"--token", "abc123", "--dry-run"}, err: "a title or a body is required"},
+		"extra arguments": {args: []string{"abc123", "--dry-run"}, err: "accepts 0 arg(s), received 1"},
+		"unknown flag":    {args: []string{"--tokn", "abc123"}, err: "unknown flag: --tokn"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			var out bytes.Buffer
+			c := cmd.PushCmd(context.Background())
+			c.SetArgs(tc.args)
+			c.SetOut(&out)
+			c.SetErr(&bytes.Buffer{})
+
+			err := c.Execute()
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, out.String())
+		})
+	}
+}
@@ -76,6 +76,10 @@ func Execute(ctx context.Context) int {
 	rootCmd.AddCommand(APICmd(ctx))
 	rootCmd.AddCommand(SchedulerCmd(ctx))
 	rootCmd.AddCommand(WorkerCmd(ctx))
+	rootCmd.AddCommand(DeadLetterCmd(ctx))
+	rootCmd.AddCommand(PushCmd(ctx))
+	rootCmd.AddCommand(AccountCmd(ctx))
+	rootCmd.AddCommand(MigrateCmd(ctx))
 
 	go func() {
 		_ = http.ListenAndServe("localhost:6060", nil)
@@ -6,6 +6,7 @@ import (
 	"math"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -14,25 +15,34 @@ import (
 	"github.com/adjust/rmq/v5"
 	"github.com/go-co-op/gocron"
 	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
 	"github.com/christianselig/apollo-backend/internal/cmdutil"
+	"github.com/christianselig/apollo-backend/internal/distributedlock"
 	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/metrics"
 	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/worker"
 )
 
 const (
 	batchSize             = 250
+	liveActivityBatchSize = 1000
 	accountEnqueueSeconds = 60
+	enqueueInterval       = 5 * time.Second
 )
 
 var (
 	enqueueAccountsMutex sync.Mutex
+	lastEnqueues         *enqueueTracker
 )
 
 func SchedulerCmd(ctx context.Context) *cobra.Command {
+	var pruneDryRun bool
+
 	cmd := &cobra.Command{
 		Use:   "scheduler",
 		Args:  cobra.ExactArgs(0),
@@ -70,6 +80,8 @@ func SchedulerCmd(ctx context.Context) *cobra.Command {
 				return err
 			}
 
+			lastEnqueues = newEnqueueTracker(logger, redis)
+
 			// Eval lua so that we don't keep parsing it
 			luaSha, err := evalScript(ctx, redis)
 			if err != nil {
@@ -106,31 +118,76 @@ func SchedulerCmd(ctx context.Context) *cobra.Command {
 				return err
 			}
 
+			// Opened up front so it shows up in the queue stats before anything fails.
+			if _, err := queue.OpenQueue(worker.DeadLetterQueue); err != nil {
+				return err
+			}
+
 			s := gocron.NewScheduler(time.UTC)
 			s.SetMaxConcurrentJobs(8, gocron.WaitMode)
 
-			_, _ = s.Every(5).Seconds().Do(func() { enqueueAccounts(ctx, logger, statsd, db, redis, luaSha, notifQueue) })
-			_, _ = s.Every(5).Seconds().Do(func() { enqueueSubreddits(ctx, logger, statsd, db, []rmq.Queue{subredditQueue, trendingQueue}) })
-			_, _ = s.Every(5).Seconds().Do(func() { enqueueUsers(ctx, logger, statsd, db, userQueue) })
-			_, _ = s.Every(5).Seconds().Do(func() { enqueueLiveActivities(ctx, logger, db, redis, luaSha, liveActivitiesQueue) })
+			dl := distributedlock.New(redis, schedulerLockTTL)
+
+			_, _ = everyWithJitter(ctx, s, "accounts", enqueueInterval, singleton(ctx, logger, dl, "enqueue-accounts", func() {
+				enqueueAccounts(ctx, logger, statsd, db, redis, luaSha, notifQueue)
+			}))
+			_, _ = everyWithJitter(ctx, s, "subreddits", enqueueInterval, singleton(ctx, logger, dl, "enqueue-subreddits", func() {
+				enqueueSubreddits(ctx, logger, statsd, db, []rmq.Queue{subredditQueue, trendingQueue})
+			}))
+			_, _ = everyWithJitter(ctx, s, "users", enqueueInterval, singleton(ctx, logger, dl, "enqueue-users", func() {
+				enqueueUsers(ctx, logger, statsd, db, userQueue)
+			}))
+			_, _ = everyWithJitter(ctx, s, "live_activities", enqueueInterval, singleton(ctx, logger, dl, "enqueue-live-activities", func() {
+				enqueueLiveActivities(ctx, logger, db, redis, luaSha, liveActivitiesQueue)
+			}))
 			_, _ = s.Every(5).Seconds().Do(func() { cleanQueues(logger, queue) })
-			_, _ = s.Every(5).Seconds().Do(func() { enqueueStuckAccounts(ctx, logger, statsd, db, stuckNotificationsQueue) })
-			_, _ = s.Every(1).Minute().Do(func() { reportStats(ctx, logger, statsd, db) })
-			//_, _ = s.Every(1).Minute().Do(func() { pruneAccounts(ctx, logger, db) })
-			//_, _ = s.Every(1).Minute().Do(func() { pruneDevices(ctx, logger, db) })
+			_, _ = s.Every(1).Minute().Do(func() {
+				returnRejected(logger, map[string]rmq.Queue{
+					"notifications":       notifQueue,
+					"live-activities":     liveActivitiesQueue,
+					"subreddits":          subredditQueue,
+					"trending":            trendingQueue,
+					"users":               userQueue,
+					"stuck-notifications": stuckNotificationsQueue,
+				})
+			})
+			_, _ = s.Every(5).Seconds().Do(singleton(ctx, logger, dl, "enqueue-stuck-accounts", func() {
+				enqueueStuckAccounts(ctx, logger, statsd, db, stuckNotificationsQueue)
+			}))
+			_, _ = s.Every(1).Minute().Do(func() { reportStats(ctx, logger, statsd, db, queue) })
+			_, _ = s.Every(1).Minute().Do(singleton(ctx, logger, dl, "report-enqueue-ages", func() {
+				reportEnqueueAges(ctx, logger, statsd)
+			}))
+			if prune, _ := strconv.ParseBool(os.Getenv("SCHEDULER_PRUNE_ENABLED")); prune {
+				_, _ = s.Every(1).Hour().Do(func() { pruneAccounts(ctx, logger, statsd, db, pruneDryRun) })
+				_, _ = s.Every(1).Hour().Do(func() { pruneDevices(ctx, logger, statsd, db, pruneDryRun) })
+			}
+			_, _ = s.Every(1).Hour().Do(func() { pruneSentNotifications(ctx, logger, db) })
+			_, _ = s.Every(1).Hour().Do(func() { recountWatchers(ctx, logger, db) })
 			s.StartAsync()
 
-			srv := &http.Server{Addr: ":8080"}
-			go func() { _ = srv.ListenAndServe() }()
+			if cmdutil.PrometheusEnabled() {
+				http.Handle("/metrics", metrics.Handler())
+			}
+
+			admin := queueAdminHandler(logger, queue, os.Getenv("ADMIN_SECRET"))
+			http.Handle(adminQueuesPath, admin)
+			http.Handle(adminQueuesPath+"/", admin)
+
+			srv := &http.Server{Addr: cmdutil.ListenAddr(defaultHTTPPort)}
+			cmdutil.ListenAndServe(logger, srv)
 
 			<-ctx.Done()
 
 			s.Stop()
+			_ = srv.Close()
 
 			return nil
 		},
 	}
 
+	cmd.Flags().BoolVar(&pruneDryRun, "prune-dry-run", false, "Report what pruning would remove without removing anything")
+
 	return cmd
 }
 
@@ -154,99 +211,153 @@ func evalScript(ctx context.Context, redis *redis.Client) (string, error) {
 	return redis.ScriptLoad(ctx, lua).Result()
 }
 
-func enqueueLiveActivities(ctx context.Context, logger *zap.Logger, pool *pgxpool.Pool, redisConn *redis.Client, luaSha string, queue rmq.Queue) {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// claimLiveActivities pushes out the next check of the live activities due before now and
+// locks them in Redis. Both happen in one transaction so that when Redis can't be reached
+// the claim is rolled back and the batch is retried on the next tick, instead of waiting
+// out a whole check interval.
+func claimLiveActivities(ctx context.Context, conn repository.Connection, redisConn *redis.Client, luaSha string, now time.Time) ([]string, error) {
+	var batch []string
+
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		ats, err := repository.NewPostgresLiveActivity(tx).ListDue(ctx, now, liveActivityBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to fetch batch of live activities: %w", err)
+		}
 
-	now := time.Now()
-	next := now.Add(domain.LiveActivityCheckInterval)
-
-	stmt := `UPDATE live_activities
-		SET next_check_at = $2
-		WHERE id IN (
-			SELECT id
-			FROM live_activities
-			WHERE next_check_at < $1
-			ORDER BY next_check_at
-			FOR UPDATE SKIP LOCKED
-			LIMIT 1000
-		)
-		RETURNING live_activities.apns_token`
+		if len(ats) == 0 {
+			return nil
+		}
 
-	ats := []string{}
+		batch, err = redisConn.EvalSha(ctx, luaSha, []string{"locks:live-activities"}, ats).StringSlice()
+		if err != nil {
+			return fmt.Errorf("failed to lock live activities: %w", err)
+		}
 
-	rows, err := pool.Query(ctx, stmt, now, next)
+		return nil
+	})
 	if err != nil {
-		logger.Error("failed to fetch batch of live activities", zap.Error(err))
-		return
+		return nil, err
 	}
-	for rows.Next() {
-		var at string
-		_ = rows.Scan(&at)
-		ats = append(ats, at)
-	}
-	rows.Close()
 
-	if len(ats) == 0 {
-		return
-	}
+	return batch, nil
+}
 
-	batch, err := redisConn.EvalSha(ctx, luaSha, []string{"locks:live-activities"}, ats).StringSlice()
+func enqueueLiveActivities(ctx context.Context, logger *zap.Logger, pool *pgxpool.Pool, redisConn *redis.Client, luaSha string, queue rmq.Queue) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	now := time.Now()
+
+	batch, err := claimLiveActivities(ctx, pool, redisConn, luaSha, now)
 	if err != nil {
-		logger.Error("failed to lock live activities", zap.Error(err))
+		logger.Error("failed to claim live activities", zap.Error(err))
 		return
 	}
 
 	if len(batch) == 0 {
+		lastEnqueues.mark(ctx, "live-activities", time.Now())
 		return
 	}
 
 	logger.Debug("enqueueing live activity batch", zap.Int("count", len(batch)), zap.Time("start", now))
 
-	if err = queue.Publish(batch...); err != nil {
-		logger.Error("failed to enqueue live activity batch", zap.Error(err))
+	if publishSpread(ctx, logger, "live_activities", []rmq.Queue{queue}, batch) {
+		lastEnqueues.mark(ctx, "live-activities", time.Now())
 	}
 }
 
-func pruneAccounts(ctx context.Context, logger *zap.Logger, pool *pgxpool.Pool) {
+func pruneAccounts(ctx context.Context, logger *zap.Logger, statsd statsd.ClientInterface, pool *pgxpool.Pool, dryRun bool) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	expiry := time.Now().Add(-domain.StaleTokenThreshold)
 	ar := repository.NewPostgresAccount(pool)
 
-	stale, err := ar.PruneStale(ctx, expiry)
+	pruneStale, pruneOrphaned := ar.PruneStale, ar.PruneOrphaned
+	if dryRun {
+		pruneStale, pruneOrphaned = ar.CountStale, ar.CountOrphaned
+	}
+
+	stale, err := pruneStale(ctx, expiry)
 	if err != nil {
 		logger.Error("failed to clean stale accounts", zap.Error(err))
 		return
 	}
 
-	orphaned, err := ar.PruneOrphaned(ctx)
+	orphaned, err := pruneOrphaned(ctx)
 	if err != nil {
 		logger.Error("failed to clean orphaned accounts", zap.Error(err))
 		return
 	}
 
+	tags := []string{fmt.Sprintf("dry_run:%t", dryRun)}
+	_ = statsd.Count("apollo.prune.accounts", stale, append(tags, "reason:stale"), 1)
+	_ = statsd.Count("apollo.prune.accounts", orphaned, append(tags, "reason:orphaned"), 1)
+
 	if count := stale + orphaned; count > 0 {
-		logger.Info("pruned accounts", zap.Int64("stale", stale), zap.Int64("orphaned", orphaned))
+		logger.Info("pruned accounts", zap.Int64("stale", stale), zap.Int64("orphaned", orphaned), zap.Bool("dry_run", dryRun))
 	}
 }
 
-func pruneDevices(ctx context.Context, logger *zap.Logger, pool *pgxpool.Pool) {
+func pruneDevices(ctx context.Context, logger *zap.Logger, statsd statsd.ClientInterface, pool *pgxpool.Pool, dryRun bool) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	now := time.Now()
 	dr := repository.NewPostgresDevice(pool)
 
-	count, err := dr.PruneStale(ctx, now)
+	pruneStale := dr.PruneStale
+	if dryRun {
+		pruneStale = dr.CountStale
+	}
+
+	count, err := pruneStale(ctx, now)
 	if err != nil {
 		logger.Error("failed to clean stale devices", zap.Error(err))
 		return
 	}
 
+	_ = statsd.Count("apollo.prune.devices", count, []string{fmt.Sprintf("dry_run:%t", dryRun)}, 1)
+
+	if count > 0 {
+		logger.Info("pruned devices", zap.Int64("count", count), zap.Bool("dry_run", dryRun))
+	}
+}
+
+func pruneSentNotifications(ctx context.Context, logger *zap.Logger, pool *pgxpool.Pool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	before := time.Now().Add(-domain.SentNotificationRetention)
+	snr := repository.NewPostgresSentNotification(pool)
+
+	count, err := snr.PruneStale(ctx, before)
+	if err != nil {
+		logger.Error("failed to clean stale sent notifications", zap.Error(err))
+		return
+	}
+
+	if count > 0 {
+		logger.Info("pruned sent notifications", zap.Int64("count", count))
+	}
+}
+
+// recountWatchers fixes watcher counts that drifted because watchers went away with
+// their device or account instead of through the watcher repository.
+func recountWatchers(ctx context.Context, logger *zap.Logger, pool *pgxpool.Pool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wr := repository.NewPostgresWatcher(pool)
+
+	count, err := wr.RecountWatchers(ctx)
+	if err != nil {
+		logger.Error("failed to recount watchers", zap.Error(err))
+		return
+	}
+
 	if count > 0 {
-		logger.Info("pruned devices", zap.Int64("count", count))
+		logger.Info("corrected watcher counts", zap.Int64("count", count))
 	}
 }
 
@@ -263,7 +374,23 @@ func cleanQueues(logger *zap.Logger, jobsConn rmq.Connection) {
 	}
 }
 
-func reportStats(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool) {
+// returnRejected puts rejected jobs back on their queues to be retried. Jobs that keep
+// failing get moved to the dead-letter queue by the workers instead of going round forever.
+func returnRejected(logger *zap.Logger, queues map[string]rmq.Queue) {
+	for name, queue := range queues {
+		count, err := queue.ReturnRejected(math.MaxInt64)
+		if err != nil {
+			logger.Error("failed to return rejected jobs", zap.Error(err), zap.String("queue", name))
+			continue
+		}
+
+		if count > 0 {
+			logger.Info("returned rejected jobs", zap.Int64("count", count), zap.String("queue", name))
+		}
+	}
+}
+
+func reportStats(ctx context.Context, logger *zap.Logger, statsd statsd.ClientInterface, pool *pgxpool.Pool, queue rmq.Connection) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -275,7 +402,7 @@ func reportStats(ctx context.Context, logger *zap.Logger, statsd *statsd.Client,
 			name  string
 		}{
 			{"SELECT COUNT(*) FROM accounts", "apollo.registrations.accounts"},
-			{"SELECT COUNT(*) FROM devices", "apollo.registrations.devices"},
+			{"SELECT COUNT(*) FROM devices WHERE is_deleted IS FALSE", "apollo.registrations.devices"},
 			{"SELECT COUNT(*) FROM subreddits", "apollo.registrations.subreddits"},
 			{"SELECT COUNT(*) FROM users", "apollo.registrations.users"},
 			{"SELECT COUNT(*) FROM live_activities", "apollo.registrations.live-activities"},
@@ -288,9 +415,55 @@ func reportStats(ctx context.Context, logger *zap.Logger, statsd *statsd.Client,
 
 		logger.Debug("fetched metrics", zap.String("metric", metric.name), zap.Int64("count", count))
 	}
+
+	stale, err := repository.NewPostgresSubreddit(pool).CountStale(ctx, time.Now().Add(-domain.SubredditStaleAfter))
+	if err != nil {
+		logger.Error("failed to count stale subreddits", zap.Error(err))
+	} else {
+		_ = statsd.Gauge("apollo.watcher.subreddits.stale", float64(stale), []string{}, 1)
+	}
+
+	cmdutil.ReportPoolStats(statsd, pool, nil)
+	reportQueueStats(logger, statsd, queue)
 }
 
-func enqueueUsers(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool, queue rmq.Queue) {
+func reportQueueStats(logger *zap.Logger, statsd statsd.ClientInterface, queue rmq.Connection) {
+	queues, err := queue.GetOpenQueues()
+	if err != nil {
+		logger.Error("failed to list queues", zap.Error(err))
+		return
+	}
+
+	stats, err := queue.CollectStats(queues)
+	if err != nil {
+		logger.Error("failed to collect queue stats", zap.Error(err))
+		return
+	}
+
+	for name, stat := range stats.QueueStats {
+		tags := []string{fmt.Sprintf("queue:%s", name)}
+		_ = statsd.Gauge("apollo.queue.ready", float64(stat.ReadyCount), tags, 1)
+		_ = statsd.Gauge("apollo.queue.rejected", float64(stat.RejectedCount), tags, 1)
+		_ = statsd.Gauge("apollo.queue.unacked", float64(stat.UnackedCount()), tags, 1)
+	}
+}
+
+// reportEnqueueAges reports how long ago each queue last had a clean enqueue run. It
+// runs under the scheduler lock, so only one scheduler reports them.
+func reportEnqueueAges(ctx context.Context, logger *zap.Logger, statsd statsd.ClientInterface) {
+	ages, err := lastEnqueues.ages(ctx, time.Now())
+	if err != nil {
+		logger.Error("failed to fetch enqueue ages", zap.Error(err))
+		return
+	}
+
+	for name, age := range ages {
+		tags := []string{fmt.Sprintf("queue:%s", name)}
+		_ = statsd.Gauge("apollo.queue.last_enqueue_age", age.Seconds(), tags, 1)
+	}
+}
+
+func enqueueUsers(ctx context.Context, logger *zap.Logger, statsd statsd.ClientInterface, pool *pgxpool.Pool, queue rmq.Queue) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -305,31 +478,14 @@ func enqueueUsers(ctx context.Context, logger *zap.Logger, statsd *statsd.Client
 		_ = statsd.Histogram("apollo.queue.runtime", float64(time.Since(now).Milliseconds()), tags, 1)
 	}()
 
-	stmt := `
-		UPDATE users
-		SET next_check_at = $2
-		WHERE id IN (
-			SELECT id
-			FROM users
-			WHERE next_check_at < $1
-			ORDER BY next_check_at
-			FOR UPDATE SKIP LOCKED
-			LIMIT 100
-		)
-		RETURNING users.id`
-	rows, err := pool.Query(ctx, stmt, now, next)
+	ids, err := repository.NewPostgresUser(pool).ClaimDue(ctx, now, next, 100)
 	if err != nil {
 		logger.Error("failed to fetch batch of users", zap.Error(err))
 		return
 	}
-	for rows.Next() {
-		var id int64
-		_ = rows.Scan(&id)
-		ids = append(ids, id)
-	}
-	rows.Close()
 
 	if len(ids) == 0 {
+		lastEnqueues.mark(ctx, "users", time.Now())
 		return
 	}
 
@@ -340,16 +496,18 @@ func enqueueUsers(ctx context.Context, logger *zap.Logger, statsd *statsd.Client
 		batchIds[i] = strconv.FormatInt(id, 10)
 	}
 
-	if err = queue.Publish(batchIds...); err != nil {
-		logger.Error("failed to enqueue user batch", zap.Error(err))
+	if publishSpread(ctx, logger, "users", []rmq.Queue{queue}, batchIds) {
+		lastEnqueues.mark(ctx, "users", time.Now())
 	}
 }
 
-func enqueueSubreddits(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool, queues []rmq.Queue) {
+func enqueueSubreddits(ctx context.Context, logger *zap.Logger, statsd statsd.ClientInterface, pool *pgxpool.Pool, queues []rmq.Queue) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	now := time.Now()
+	// The subreddit worker reschedules each subreddit based on how busy it is, this only
+	// holds it back until then (or until the next attempt, if the check fails).
 	next := now.Add(domain.SubredditCheckInterval)
 
 	ids := []int64{}
@@ -360,31 +518,14 @@ func enqueueSubreddits(ctx context.Context, logger *zap.Logger, statsd *statsd.C
 		_ = statsd.Histogram("apollo.queue.runtime", float64(time.Since(now).Milliseconds()), tags, 1)
 	}()
 
-	stmt := `
-			UPDATE subreddits
-			SET next_check_at = $2
-			WHERE subreddits.id IN(
-				SELECT id
-				FROM subreddits
-				WHERE next_check_at < $1
-				ORDER BY next_check_at
-				FOR UPDATE SKIP LOCKED
-				LIMIT 100
-			)
-			RETURNING subreddits.id`
-	rows, err := pool.Query(ctx, stmt, now, next)
+	ids, err := repository.NewPostgresSubreddit(pool).ClaimDue(ctx, now, next, 100)
 	if err != nil {
 		logger.Error("failed to fetch batch of subreddits", zap.Error(err))
 		return
 	}
-	for rows.Next() {
-		var id int64
-		_ = rows.Scan(&id)
-		ids = append(ids, id)
-	}
-	rows.Close()
 
 	if len(ids) == 0 {
+		lastEnqueues.mark(ctx, "subreddits", time.Now())
 		return
 	}
 
@@ -395,26 +536,49 @@ func enqueueSubreddits(ctx context.Context, logger *zap.Logger, statsd *statsd.C
 		batchIds[i] = strconv.FormatInt(id, 10)
 	}
 
-	for _, queue := range queues {
-		if err = queue.Publish(batchIds...); err != nil {
-			logger.Error("failed to enqueue subreddit batch", zap.Error(err))
+	if publishSpread(ctx, logger, "subreddits", queues, batchIds) {
+		lastEnqueues.mark(ctx, "subreddits", time.Now())
+	}
+}
+
+// publishSpread publishes ids to every queue, spread out as configured for name. It
+// reports whether everything got published.
+func publishSpread(ctx context.Context, logger *zap.Logger, name string, queues []rmq.Queue, ids []string) bool {
+	failed := false
+	chunks, interval := enqueueSpread(name)
+
+	err := spread(ctx, ids, chunks, interval, func(offset int, ids []string) {
+		if len(ids) == 0 {
+			return
 		}
+
+		for _, queue := range queues {
+			if err := queue.Publish(ids...); err != nil {
+				logger.Error("failed to enqueue batch", zap.Error(err), zap.String("queue", name), zap.Int("offset", offset))
+				failed = true
+			}
+		}
+	})
+	if err != nil {
+		logger.Info("stopped spreading enqueues", zap.Error(err), zap.String("queue", name))
+		return false
 	}
 
+	return !failed
 }
 
-func enqueueStuckAccounts(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool, queue rmq.Queue) {
+func enqueueStuckAccounts(ctx context.Context, logger *zap.Logger, statsd statsd.ClientInterface, pool *pgxpool.Pool, queue rmq.Queue) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	now := time.Now()
 	next := now.Add(domain.StuckNotificationCheckInterval)
 
-	ids := []int64{}
+	accounts := []stuckAccount{}
 
 	defer func() {
 		tags := []string{"queue:stuck-accounts"}
-		_ = statsd.Histogram("apollo.queue.enqueued", float64(len(ids)), tags, 1)
+		_ = statsd.Histogram("apollo.queue.enqueued", float64(len(accounts)), tags, 1)
 		_ = statsd.Histogram("apollo.queue.runtime", float64(time.Since(now).Milliseconds()), tags, 1)
 	}()
 
@@ -429,7 +593,7 @@ func enqueueStuckAccounts(ctx context.Context, logger *zap.Logger, statsd *stats
 				FOR UPDATE SKIP LOCKED
 				LIMIT 500
 			)
-			RETURNING accounts.id`
+			RETURNING accounts.id, COALESCE(accounts.last_message_id, '')`
 	rows, err := pool.Query(ctx, stmt, now, next)
 	if err != nil {
 		logger.Error("failed to fetch accounts", zap.Error(err))
@@ -437,29 +601,28 @@ func enqueueStuckAccounts(ctx context.Context, logger *zap.Logger, statsd *stats
 	}
 
 	for rows.Next() {
-		var id int64
-		_ = rows.Scan(&id)
-		ids = append(ids, id)
+		var acc stuckAccount
+		_ = rows.Scan(&acc.id, &acc.lastMessageID)
+		accounts = append(accounts, acc)
 	}
 	rows.Close()
 
-	if len(ids) == 0 {
+	if len(accounts) == 0 {
+		lastEnqueues.mark(ctx, "stuck-notifications", time.Now())
 		return
 	}
 
-	logger.Debug("enqueueing stuck account batch", zap.Int("count", len(ids)), zap.Time("start", now))
+	logger.Debug("enqueueing stuck account batch", zap.Int("count", len(accounts)), zap.Time("start", now))
 
-	batchIds := make([]string, len(ids))
-	for i, id := range ids {
-		batchIds[i] = strconv.FormatInt(id, 10)
-	}
-
-	if err = queue.Publish(batchIds...); err != nil {
+	if err = queue.Publish(stuckBatches(accounts, stuckBatchSize())...); err != nil {
 		logger.Error("failed to enqueue stuck account batch", zap.Error(err))
+		return
 	}
+
+	lastEnqueues.mark(ctx, "stuck-notifications", time.Now())
 }
 
-func enqueueAccounts(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool, redisConn *redis.Client, luaSha string, queue rmq.Queue) {
+func enqueueAccounts(ctx context.Context, logger *zap.Logger, statsd statsd.ClientInterface, pool *pgxpool.Pool, redisConn *redis.Client, luaSha string, queue rmq.Queue) {
 	if enqueueAccountsMutex.TryLock() {
 		defer enqueueAccountsMutex.Unlock()
 	} else {
@@ -476,10 +639,14 @@ func enqueueAccounts(ctx context.Context, logger *zap.Logger, statsd *statsd.Cli
 		INNER JOIN devices_accounts ON devices_accounts.account_id = accounts.id
 		INNER JOIN devices ON devices.id = devices_accounts.device_id
 		WHERE grace_period_expires_at >= NOW()
+		AND devices.is_deleted IS FALSE
 		AND accounts.is_deleted IS FALSE
+		AND accounts.next_notification_check_at < $1
 		ORDER BY reddit_account_id
 	`
-	rows, err := pool.Query(ctx, query)
+	// Anything due before the end of this spread window gets picked up now, otherwise it
+	// would have to wait for the following run.
+	rows, err := pool.Query(ctx, query, now.Add(accountEnqueueSeconds*time.Second))
 	if err != nil {
 		logger.Error("failed to fetch accounts", zap.Error(err))
 		return
@@ -494,61 +661,46 @@ func enqueueAccounts(ctx context.Context, logger *zap.Logger, statsd *statsd.Cli
 	// Use this instead of deferring as we're going to take a while to get out of this method.
 	rows.Close()
 
-	chunks := [][]string{}
-	chunkSize := int(math.Ceil(float64(len(ids)) / float64(accountEnqueueSeconds)))
-	for i := 0; i < accountEnqueueSeconds; i++ {
-		left := i * chunkSize
-		right := (i + 1) * chunkSize
-		if right > len(ids) {
-			right = len(ids)
-		}
-		chunks = append(chunks, ids[left:right])
-	}
-
 	_ = statsd.Histogram("apollo.queue.runtime", float64(time.Since(now).Milliseconds()), []string{"queue:notifications"}, 1)
 
-	wg := sync.WaitGroup{}
-	for i := 0; i < accountEnqueueSeconds; i++ {
-		wg.Add(1)
-		go func(ctx context.Context, offset int) {
-			defer wg.Done()
-
-			candidates := chunks[offset]
-			select {
-			case <-ctx.Done(): //context cancelled
-			case <-time.After(time.Duration(offset) * time.Second): //timeout
-			}
-
-			enqueued, err := redisConn.EvalSha(ctx, luaSha, []string{"locks:accounts"}, candidates).StringSlice()
-			if err != nil {
-				logger.Error("failed to check for locked accounts", zap.Error(err))
-			}
-
-			if len(enqueued) == 0 {
-				logger.Info("no viable candidates to enqueue",
-					zap.Int("offset", offset),
-					zap.Int("candidates", len(candidates)),
-					zap.Int("enqueued", len(enqueued)),
-				)
-				return
-			}
+	failed := false
+	err = spread(ctx, ids, accountEnqueueSeconds, time.Second, func(offset int, candidates []string) {
+		enqueued, err := redisConn.EvalSha(ctx, luaSha, []string{"locks:accounts"}, candidates).StringSlice()
+		if err != nil {
+			// Nothing has been claimed yet, these accounts get picked up again next run.
+			logger.Error("failed to check for locked accounts", zap.Error(err), zap.Int("offset", offset))
+			failed = true
+			return
+		}
 
-			if err = queue.Publish(enqueued...); err != nil {
-				logger.Error("failed to enqueue account batch",
-					zap.Error(err),
-					zap.Int("offset", offset),
-					zap.Int("candidates", len(candidates)),
-					zap.Int("enqueued", len(enqueued)),
-				)
-				return
-			}
+		if len(enqueued) == 0 {
+			logger.Info("no viable candidates to enqueue",
+				zap.Int("offset", offset),
+				zap.Int("candidates", len(candidates)),
+				zap.Int("enqueued", len(enqueued)),
+			)
+			return
+		}
 
-			logger.Info("enqueued account batch",
+		if err = queue.Publish(enqueued...); err != nil {
+			logger.Error("failed to enqueue account batch",
+				zap.Error(err),
 				zap.Int("offset", offset),
 				zap.Int("candidates", len(candidates)),
 				zap.Int("enqueued", len(enqueued)),
 			)
-		}(ctx, i)
+			failed = true
+			return
+		}
+
+		logger.Info("enqueued account batch",
+			zap.Int("offset", offset),
+			zap.Int("candidates", len(candidates)),
+			zap.Int("enqueued", len(enqueued)),
+		)
+	})
+
+	if err == nil && !failed {
+		lastEnqueues.mark(ctx, "notifications", time.Now())
 	}
-	wg.Wait()
 }
@@ -0,0 +1,49 @@
+package cmd_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/cmd"
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestClaimLiveActivities_RedisUnavailable(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	// Far enough in the past that nothing outside this test is due before it.
+	now := time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+	dueAt := now.Add(-time.Minute)
+
+	repo := repository.NewPostgresLiveActivity(tx)
+	la := &domain.LiveActivity{APNSToken: "claim-live-activities-redis-unavailable", ThreadID: "t3_abc"}
+	require.NoError(t, repo.Create(ctx, la))
+	la.NextCheckAt = dueAt
+	require.NoError(t, repo.Update(ctx, la))
+
+	redisConn := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0", MaxRetries: -1})
+	t.Cleanup(func() { _ = redisConn.Close() })
+
+	batch, err := cmd.ClaimLiveActivities(ctx, tx, redisConn, "sha", now)
+	assert.Error(t, err)
+	assert.Empty(t, batch)
+
+	// The claim was rolled back, so the next run still sees it as due.
+	got, err := repo.Get(ctx, la.APNSToken)
+	require.NoError(t, err)
+	assert.True(t, dueAt.Equal(got.NextCheckAt), "next check moved to %v", got.NextCheckAt)
+}
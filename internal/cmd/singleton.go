@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/distributedlock"
+)
+
+// schedulerLockTTL outlives the longest enqueue spread, so a lock only ever expires on
+// its own when the scheduler holding it died.
+const schedulerLockTTL = 2 * time.Minute
+
+// singleton wraps fn so it only runs while holding the scheduler lock for name. That
+// keeps a second scheduler, e.g. one starting up during a deploy, from enqueueing the
+// same work twice.
+func singleton(ctx context.Context, logger *zap.Logger, dl *distributedlock.DistributedLock, name string, fn func()) func() {
+	key := fmt.Sprintf("locks:scheduler:%s", name)
+
+	return func() {
+		lock, err := dl.AcquireLock(ctx, key)
+		if err != nil {
+			if !errors.Is(err, distributedlock.ErrLockAlreadyAcquired) {
+				logger.Error("failed to acquire scheduler lock", zap.Error(err), zap.String("job", name))
+			}
+			return
+		}
+
+		defer func() {
+			if err := lock.Release(ctx); err != nil {
+				logger.Error("failed to release scheduler lock", zap.Error(err), zap.String("job", name))
+			}
+		}()
+
+		fn()
+	}
+}
@@ -0,0 +1,44 @@
+package cmd_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/cmd"
+	"github.com/christianselig/apollo-backend/internal/distributedlock"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestSingleton(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testhelper.NewTestRedisClient(t)
+	t.Cleanup(func() { _ = client.Del(ctx, "locks:scheduler:test-singleton").Err() })
+
+	dl := distributedlock.New(client, time.Minute)
+
+	runs := 0
+	second := cmd.Singleton(ctx, zap.NewNop(), dl, "test-singleton", func() { runs++ })
+	first := cmd.Singleton(ctx, zap.NewNop(), dl, "test-singleton", func() {
+		// Another scheduler ticking while this one is still enqueueing gets nowhere.
+		second()
+		runs++
+	})
+
+	first()
+	assert.Equal(t, 1, runs)
+
+	// Once released, the next tick runs normally.
+	second()
+	assert.Equal(t, 2, runs)
+
+	lock, err := dl.AcquireLock(ctx, "locks:scheduler:test-singleton")
+	require.NoError(t, err)
+	require.NoError(t, lock.Release(ctx))
+}
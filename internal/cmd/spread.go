@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
+)
+
+// spreadInterval is how often a chunk gets published when a queue's enqueues are spread.
+const spreadInterval = 500 * time.Millisecond
+
+// chunk splits ids into n slices of near-equal size, some of which may be empty.
+func chunk(ids []string, n int) [][]string {
+	if n < 1 {
+		n = 1
+	}
+
+	size := (len(ids) + n - 1) / n
+	chunks := make([][]string, n)
+	for i := range chunks {
+		left, right := i*size, (i+1)*size
+		if left > len(ids) {
+			left = len(ids)
+		}
+		if right > len(ids) {
+			right = len(ids)
+		}
+		chunks[i] = ids[left:right]
+	}
+	return chunks
+}
+
+// spread hands ids to publish in n chunks, one every interval starting right away,
+// so a batch gets smeared over n*interval instead of landing all at once. It stops
+// early and returns the context's error if ctx is cancelled mid-spread.
+func spread(ctx context.Context, ids []string, n int, interval time.Duration, publish func(offset int, ids []string)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for offset, ids := range chunk(ids, n) {
+		if offset > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		publish(offset, ids)
+	}
+
+	return nil
+}
+
+// enqueueSpread returns how to spread a queue's enqueues, configured through
+// SCHEDULER_SPREAD_<NAME> (e.g. SCHEDULER_SPREAD_USERS=3s). The window is capped to the
+// enqueue interval so runs don't overlap, and unset means publishing in one go.
+func enqueueSpread(name string) (int, time.Duration) {
+	key := fmt.Sprintf("SCHEDULER_SPREAD_%s", strings.ToUpper(name))
+	window := cmdutil.DurationFromEnv(key, 0)
+	if window > enqueueInterval {
+		window = enqueueInterval
+	}
+
+	chunks := int(window / spreadInterval)
+	if chunks < 1 {
+		chunks = 1
+	}
+	return chunks, spreadInterval
+}
@@ -0,0 +1,127 @@
+package cmd_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/cmd"
+)
+
+func TestChunk(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		ids  []string
+		n    int
+		want [][]string
+	}{
+		"even split":    {[]string{"a", "b", "c", "d"}, 2, [][]string{{"a", "b"}, {"c", "d"}}},
+		"uneven split":  {[]string{"a", "b", "c"}, 2, [][]string{{"a", "b"}, {"c"}}},
+		"more chunks":   {[]string{"a"}, 3, [][]string{{"a"}, {}, {}}},
+		"no ids":        {[]string{}, 2, [][]string{{}, {}}},
+		"invalid count": {[]string{"a", "b"}, 0, [][]string{{"a", "b"}}},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, cmd.Chunk(tc.ids, tc.n))
+		})
+	}
+}
+
+func TestSpread(t *testing.T) {
+	t.Parallel()
+
+	ids := make([]string, 95)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("id-%d", i)
+	}
+
+	t.Run("publishes everything once", func(t *testing.T) {
+		t.Parallel()
+
+		var got []string
+		offsets := 0
+		err := cmd.Spread(context.Background(), ids, 10, time.Millisecond, func(offset int, chunk []string) {
+			assert.Equal(t, offsets, offset)
+			offsets++
+			got = append(got, chunk...)
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 10, offsets)
+		assert.Equal(t, ids, got)
+	})
+
+	t.Run("stops when cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		published := 0
+		err := cmd.Spread(ctx, ids, 10, 10*time.Millisecond, func(offset int, chunk []string) {
+			published++
+			if offset == 2 {
+				cancel()
+			}
+		})
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 3, published)
+	})
+}
+
+func TestEnqueueSpread(t *testing.T) { //nolint:paralleltest
+	tt := map[string]struct {
+		window string
+		chunks int
+	}{
+		"unset":             {"", 1},
+		"invalid":           {"soon", 1},
+		"shorter than tick": {"100ms", 1},
+		"two seconds":       {"2s", 4},
+		"capped":            {"1m", 10},
+	}
+
+	for scenario, tc := range tt { //nolint:paralleltest
+		t.Run(scenario, func(t *testing.T) {
+			t.Setenv("SCHEDULER_SPREAD_TEST", tc.window)
+
+			chunks, interval := cmd.EnqueueSpread("test")
+			assert.Equal(t, tc.chunks, chunks)
+			assert.Equal(t, 500*time.Millisecond, interval)
+		})
+	}
+}
+
+func TestPublishSpread(t *testing.T) { //nolint:paralleltest
+	ids := []string{"1", "2", "3", "4", "5", "6", "7", "8"}
+
+	t.Run("publishes in one go when unset", func(t *testing.T) {
+		queue := rmq.NewTestQueue("test")
+
+		assert.True(t, cmd.PublishSpread(context.Background(), zap.NewNop(), "unset", []rmq.Queue{queue}, ids))
+		assert.Equal(t, ids, queue.LastDeliveries)
+	})
+
+	t.Run("stops when cancelled mid-spread", func(t *testing.T) {
+		t.Setenv("SCHEDULER_SPREAD_CANCELLED", "2s")
+
+		queue := rmq.NewTestQueue("test")
+		ctx, cancel := context.WithTimeout(context.Background(), 700*time.Millisecond)
+		defer cancel()
+
+		assert.False(t, cmd.PublishSpread(ctx, zap.NewNop(), "cancelled", []rmq.Queue{queue}, ids))
+		assert.Equal(t, ids[:4], queue.LastDeliveries)
+	})
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// stuckAccount is what the scheduler needs to know about an account to batch its stuck
+// notification check.
+type stuckAccount struct {
+	id            int64
+	lastMessageID string
+}
+
+// stuckBatchSize reads how many accounts go into one stuck notification job. It
+// defaults to one account per job.
+func stuckBatchSize() int {
+	size, err := strconv.Atoi(os.Getenv("STUCK_NOTIFICATIONS_BATCH_SIZE"))
+	if err != nil || size < 1 {
+		return 1
+	}
+	return size
+}
+
+// stuckBatches groups accounts whose last message is of the same kind into payloads of
+// at most size comma separated account IDs, so the worker can look their last things
+// up together.
+func stuckBatches(accounts []stuckAccount, size int) []string {
+	if size < 1 {
+		size = 1
+	}
+
+	kinds := []string{}
+	byKind := map[string][]string{}
+	for _, acc := range accounts {
+		kind := ""
+		if len(acc.lastMessageID) >= 2 {
+			kind = acc.lastMessageID[:2]
+		}
+
+		if _, ok := byKind[kind]; !ok {
+			kinds = append(kinds, kind)
+		}
+		byKind[kind] = append(byKind[kind], strconv.FormatInt(acc.id, 10))
+	}
+	sort.Strings(kinds)
+
+	payloads := []string{}
+	for _, kind := range kinds {
+		ids := byKind[kind]
+		for len(ids) > 0 {
+			n := size
+			if len(ids) < n {
+				n = len(ids)
+			}
+
+			payloads = append(payloads, strings.Join(ids[:n], ","))
+			ids = ids[n:]
+		}
+	}
+	return payloads
+}
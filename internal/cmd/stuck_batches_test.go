@@ -0,0 +1,46 @@
+package cmd_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/cmd"
+)
+
+func TestStuckBatches(t *testing.T) {
+	t.Parallel()
+
+	accounts := []cmd.StuckAccount{
+		cmd.NewStuckAccount(1, "t1_a"),
+		cmd.NewStuckAccount(2, "t4_b"),
+		cmd.NewStuckAccount(3, "t1_c"),
+		cmd.NewStuckAccount(4, "t1_d"),
+		cmd.NewStuckAccount(5, "t4_e"),
+		cmd.NewStuckAccount(6, ""),
+	}
+
+	tt := map[string]struct {
+		size int
+		want []string
+	}{
+		"one per job":  {1, []string{"6", "1", "3", "4", "2", "5"}},
+		"grouped":      {2, []string{"6", "1,3", "4", "2,5"}},
+		"whole kinds":  {10, []string{"6", "1,3,4", "2,5"}},
+		"invalid size": {0, []string{"6", "1", "3", "4", "2", "5"}},
+		"no accounts":  {2, []string{}},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			in := accounts
+			if len(tc.want) == 0 {
+				in = nil
+			}
+			assert.Equal(t, tc.want, cmd.StuckBatches(in, tc.size))
+		})
+	}
+}
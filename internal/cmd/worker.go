@@ -3,15 +3,30 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"runtime"
+	"time"
 
 	"github.com/spf13/cobra"
 	"go.opentelemetry.io/otel"
 
 	"github.com/christianselig/apollo-backend/internal/cmdutil"
+	"github.com/christianselig/apollo-backend/internal/metrics"
+	"github.com/christianselig/apollo-backend/internal/reddit"
 	"github.com/christianselig/apollo-backend/internal/worker"
 )
 
+// defaultHTTPPort is where health checks and metrics are served when $PORT isn't set.
+const defaultHTTPPort = 8080
+
+// poolStatsInterval is how often a worker samples its database pool usage.
+const poolStatsInterval = 10 * time.Second
+
+// redditRequestTimeout bounds each attempt at a Reddit request, so a response trickling
+// in can't tie up a consumer. It sits under the Reddit client's own 4s timeout, which
+// would otherwise always go first. Override with REDDIT_REQUEST_TIMEOUT.
+const redditRequestTimeout = 3 * time.Second
+
 var (
 	queues = map[string]worker.NewWorkerFn{
 		"live-activities":     worker.NewLiveActivitiesWorker,
@@ -52,19 +67,19 @@ func WorkerCmd(ctx context.Context) *cobra.Command {
 
 			tracer := otel.Tracer(tag)
 
-			db, err := cmdutil.NewDatabasePool(ctx, consumers/16)
+			db, err := cmdutil.NewDatabasePool(ctx, cmdutil.PoolSize(consumers, 16))
 			if err != nil {
 				return err
 			}
 			defer db.Close()
 
-			redis, err := cmdutil.NewRedisLocksClient(ctx, consumers/4)
+			redis, err := cmdutil.NewRedisLocksClient(ctx, cmdutil.PoolSize(consumers, 4))
 			if err != nil {
 				return err
 			}
 			defer redis.Close()
 
-			qredis, err := cmdutil.NewRedisQueueClient(ctx, consumers/16)
+			qredis, err := cmdutil.NewRedisQueueClient(ctx, cmdutil.PoolSize(consumers, 16))
 			if err != nil {
 				return err
 			}
@@ -80,14 +95,48 @@ func WorkerCmd(ctx context.Context) *cobra.Command {
 				return fmt.Errorf("invalid queue: %s", queueID)
 			}
 
-			worker := workerFn(ctx, logger, tracer, statsd, db, redis, queue, consumers)
+			health := worker.NewHealthHandler(map[string]worker.HealthCheck{
+				"postgres": worker.PostgresHealthCheck(db),
+				"redis":    worker.RedisHealthCheck(redis),
+				"queue":    worker.QueueHealthCheck(queue, queueID),
+			})
+
+			rc := cmdutil.NewRedditClient(tracer, statsd, redis, consumers,
+				reddit.WithTimeout(cmdutil.DurationFromEnv("REDDIT_REQUEST_TIMEOUT", redditRequestTimeout)),
+			)
+
+			worker := workerFn(ctx, logger, tracer, statsd, db, redis, rc, queue, consumers)
 			if err := worker.Start(); err != nil {
 				return err
 			}
 
+			mux := http.NewServeMux()
+			mux.Handle("/health/", health)
+			if cmdutil.PrometheusEnabled() {
+				mux.Handle("/metrics", metrics.Handler())
+			}
+
+			srv := &http.Server{Addr: cmdutil.ListenAddr(defaultHTTPPort), Handler: mux}
+			cmdutil.ListenAndServe(logger, srv)
+
+			go func() {
+				ticker := time.NewTicker(poolStatsInterval)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						cmdutil.ReportPoolStats(statsd, db, nil)
+					}
+				}
+			}()
+
 			<-ctx.Done()
 
 			worker.Stop()
+			_ = srv.Close()
 
 			return nil
 		},
@@ -2,8 +2,11 @@ package cmdutil
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
@@ -12,8 +15,13 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
-
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sideshow/apns2/token"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/metrics"
+	"github.com/christianselig/apollo-backend/internal/reddit"
 )
 
 func NewLogger(service string) *zap.Logger {
@@ -30,12 +38,26 @@ func NewLogger(service string) *zap.Logger {
 	return logger
 }
 
-func NewStatsdClient(tags ...string) (*statsd.Client, error) {
+func NewStatsdClient(tags ...string) (statsd.ClientInterface, error) {
 	if env := os.Getenv("ENV"); env != "" {
 		tags = append(tags, fmt.Sprintf("env:%s", env))
 	}
 
-	return statsd.New(os.Getenv("STATSD_URL"), statsd.WithTags(tags))
+	client, err := statsd.New(os.Getenv("STATSD_URL"), statsd.WithTags(tags))
+	if err != nil {
+		return nil, err
+	}
+
+	if !PrometheusEnabled() {
+		return client, nil
+	}
+	return metrics.NewClient(client, prometheus.DefaultRegisterer), nil
+}
+
+// PrometheusEnabled reports whether metrics should also be exposed on /metrics for
+// Prometheus to scrape, configured through PROMETHEUS_METRICS=true.
+func PrometheusEnabled() bool {
+	return os.Getenv("PROMETHEUS_METRICS") == "true"
 }
 
 func NewRedisLocksClient(ctx context.Context, maxConns int) (*redis.Client, error) {
@@ -63,8 +85,22 @@ func newRedisClient(ctx context.Context, env string, maxConns int) (*redis.Clien
 	return client, nil
 }
 
+// PoolSize shares out connections between consumers, one for every perConn of them,
+// and never hands out less than one.
+func PoolSize(consumers, perConn int) int {
+	if perConn < 1 {
+		perConn = 1
+	}
+
+	size := consumers / perConn
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
 func NewDatabasePool(ctx context.Context, maxConns int) (*pgxpool.Pool, error) {
-	if maxConns == 0 {
+	if maxConns < 1 {
 		maxConns = 1
 	}
 
@@ -86,6 +122,33 @@ func NewDatabasePool(ctx context.Context, maxConns int) (*pgxpool.Pool, error) {
 	return pgxpool.NewWithConfig(ctx, config)
 }
 
+// PoolStats samples a database pool's usage, keyed by metric name. Acquires that had to
+// wait for a connection count towards empty_acquires, and acquire_wait is the average
+// time spent waiting for one.
+func PoolStats(stat *pgxpool.Stat) map[string]float64 {
+	stats := map[string]float64{
+		"apollo.db.pool.max":            float64(stat.MaxConns()),
+		"apollo.db.pool.total":          float64(stat.TotalConns()),
+		"apollo.db.pool.in_use":         float64(stat.AcquiredConns()),
+		"apollo.db.pool.idle":           float64(stat.IdleConns()),
+		"apollo.db.pool.acquires":       float64(stat.AcquireCount()),
+		"apollo.db.pool.empty_acquires": float64(stat.EmptyAcquireCount()),
+		"apollo.db.pool.acquire_wait":   0,
+	}
+
+	if n := stat.AcquireCount(); n > 0 {
+		stats["apollo.db.pool.acquire_wait"] = float64(stat.AcquireDuration().Milliseconds()) / float64(n)
+	}
+	return stats
+}
+
+// ReportPoolStats sends a sample of a database pool's usage as gauges.
+func ReportPoolStats(statsd statsd.ClientInterface, pool *pgxpool.Pool, tags []string) {
+	for name, val := range PoolStats(pool.Stat()) {
+		_ = statsd.Gauge(name, val, tags, 1)
+	}
+}
+
 func NewQueueClient(logger *zap.Logger, conn *redis.Client, identifier string) (rmq.Connection, error) {
 	errChan := make(chan error, 10)
 	go func() {
@@ -96,3 +159,63 @@ func NewQueueClient(logger *zap.Logger, conn *redis.Client, identifier string) (
 
 	return rmq.OpenConnectionWithRedisClient(identifier, conn, errChan)
 }
+
+// ListenAddr is the address a process serves its HTTP endpoints on: the port in $PORT
+// when the platform hands one out, def otherwise.
+func ListenAddr(def int) string {
+	port := def
+	if p, err := strconv.Atoi(os.Getenv("PORT")); err == nil && p > 0 {
+		port = p
+	}
+	return fmt.Sprintf(":%d", port)
+}
+
+// ListenAndServe serves srv in the background, logging why it stopped unless it was
+// shut down.
+func ListenAndServe(logger *zap.Logger, srv *http.Server) {
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("http server stopped", zap.Error(err), zap.String("addr", srv.Addr))
+		}
+	}()
+}
+
+// DurationFromEnv parses a duration such as "1500ms" from the given environment variable,
+// falling back to def when it's unset or invalid.
+func DurationFromEnv(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d < 0 {
+		return def
+	}
+	return d
+}
+
+// NewRedditClient builds the Reddit client for the app's REDDIT_CLIENT_ID and
+// REDDIT_CLIENT_SECRET, sized for connLimit concurrent requests. A process should only
+// need one, shared by everything in it that talks to Reddit. opts apply to every request.
+func NewRedditClient(tracer trace.Tracer, statsd statsd.ClientInterface, redis *redis.Client, connLimit int, opts ...reddit.RequestOption) *reddit.Client {
+	return reddit.NewClient(
+		os.Getenv("REDDIT_CLIENT_ID"),
+		os.Getenv("REDDIT_CLIENT_SECRET"),
+		tracer,
+		statsd,
+		redis,
+		connLimit,
+		opts...,
+	)
+}
+
+// NewAPNSToken loads the APNs signing key from APPLE_KEY_PATH, to authenticate with as
+// APPLE_KEY_ID of team APPLE_TEAM_ID.
+func NewAPNSToken() (*token.Token, error) {
+	authKey, err := token.AuthKeyFromFile(os.Getenv("APPLE_KEY_PATH"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &token.Token{
+		AuthKey: authKey,
+		KeyID:   os.Getenv("APPLE_KEY_ID"),
+		TeamID:  os.Getenv("APPLE_TEAM_ID"),
+	}, nil
+}
@@ -0,0 +1,71 @@
+package cmdutil_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
+)
+
+func TestPoolSize(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		consumers int
+		perConn   int
+		want      int
+	}{
+		"evenly shared":       {512, 16, 32},
+		"rounds down":         {100, 16, 6},
+		"fewer than per conn": {3, 4, 1},
+		"no consumers":        {0, 4, 1},
+		"invalid per conn":    {8, 0, 8},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, cmdutil.PoolSize(tc.consumers, tc.perConn))
+		})
+	}
+}
+
+func TestListenAddr(t *testing.T) { //nolint:paralleltest
+	tt := map[string]struct {
+		port string
+		want string
+	}{
+		"unset":   {"", ":8080"},
+		"set":     {"10000", ":10000"},
+		"garbage": {"http", ":8080"},
+	}
+
+	for scenario, tc := range tt { //nolint:paralleltest
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Setenv("PORT", tc.port)
+			assert.Equal(t, tc.want, cmdutil.ListenAddr(8080))
+		})
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	t.Parallel()
+
+	// Pools connect lazily, so this never reaches the database.
+	pool, err := pgxpool.New(context.Background(), "postgres://localhost:1/apollo?pool_max_conns=4")
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	stats := cmdutil.PoolStats(pool.Stat())
+	assert.Equal(t, float64(4), stats["apollo.db.pool.max"])
+	assert.Equal(t, float64(0), stats["apollo.db.pool.in_use"])
+	assert.Equal(t, float64(0), stats["apollo.db.pool.acquire_wait"])
+	assert.Contains(t, stats, "apollo.db.pool.empty_acquires")
+}
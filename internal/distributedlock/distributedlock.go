@@ -0,0 +1,98 @@
+// Package distributedlock hands out locks shared between processes through Redis.
+package distributedlock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	ErrLockAlreadyAcquired    = errors.New("lock already acquired")
+	ErrLockAcquisitionTimeout = errors.New("timed out acquiring lock")
+)
+
+// releaseScript only deletes the key when it still holds our token, so a lock that
+// expired and got picked up by someone else is left alone.
+var releaseScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+	return 0
+`)
+
+type DistributedLock struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// New returns a DistributedLock whose locks expire after ttl, so a holder that dies
+// doesn't keep everyone else out forever.
+func New(client *redis.Client, ttl time.Duration) *DistributedLock {
+	return &DistributedLock{client: client, ttl: ttl}
+}
+
+type Lock struct {
+	client *redis.Client
+	key    string
+	token  string
+}
+
+// AcquireLock takes the lock on key, returning ErrLockAlreadyAcquired when someone
+// else is holding it.
+func (d *DistributedLock) AcquireLock(ctx context.Context, key string) (*Lock, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := d.client.SetNX(ctx, key, token, d.ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLockAlreadyAcquired
+	}
+
+	return &Lock{client: d.client, key: key, token: token}, nil
+}
+
+// AcquireWithRetry tries to take the lock on key up to attempts times, waiting backoff
+// between tries. It returns ErrLockAcquisitionTimeout once all attempts found the lock held.
+func (d *DistributedLock) AcquireWithRetry(ctx context.Context, key string, attempts int, backoff time.Duration) (*Lock, error) {
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+
+		lock, err := d.AcquireLock(ctx, key)
+		if err == nil {
+			return lock, nil
+		}
+		if !errors.Is(err, ErrLockAlreadyAcquired) {
+			return nil, err
+		}
+	}
+
+	return nil, ErrLockAcquisitionTimeout
+}
+
+func (l *Lock) Release(ctx context.Context) error {
+	return releaseScript.Run(ctx, l.client, []string{l.key}, l.token).Err()
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
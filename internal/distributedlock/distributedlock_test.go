@@ -0,0 +1,110 @@
+package distributedlock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/distributedlock"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestDistributedLock(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testhelper.NewTestRedisClient(t)
+	key := "locks:test:distributedlock"
+	t.Cleanup(func() { _ = client.Del(ctx, key).Err() })
+
+	dl := distributedlock.New(client, time.Minute)
+
+	lock, err := dl.AcquireLock(ctx, key)
+	require.NoError(t, err)
+
+	_, err = dl.AcquireLock(ctx, key)
+	assert.ErrorIs(t, err, distributedlock.ErrLockAlreadyAcquired)
+
+	require.NoError(t, lock.Release(ctx))
+
+	lock, err = dl.AcquireLock(ctx, key)
+	require.NoError(t, err)
+	require.NoError(t, lock.Release(ctx))
+}
+
+func TestDistributedLock_ReleaseAfterExpiry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testhelper.NewTestRedisClient(t)
+	key := "locks:test:distributedlock-expiry"
+	t.Cleanup(func() { _ = client.Del(ctx, key).Err() })
+
+	stale, err := distributedlock.New(client, 10*time.Millisecond).AcquireLock(ctx, key)
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	fresh, err := distributedlock.New(client, time.Minute).AcquireLock(ctx, key)
+	require.NoError(t, err)
+
+	// Releasing the expired lock must not free up the one taken after it.
+	require.NoError(t, stale.Release(ctx))
+	_, err = distributedlock.New(client, time.Minute).AcquireLock(ctx, key)
+	assert.ErrorIs(t, err, distributedlock.ErrLockAlreadyAcquired)
+
+	require.NoError(t, fresh.Release(ctx))
+}
+
+func TestDistributedLock_AcquireWithRetry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testhelper.NewTestRedisClient(t)
+	key := "locks:test:distributedlock-retry"
+	t.Cleanup(func() { _ = client.Del(ctx, key).Err() })
+
+	dl := distributedlock.New(client, time.Minute)
+
+	held, err := dl.AcquireLock(ctx, key)
+	require.NoError(t, err)
+
+	_, err = dl.AcquireWithRetry(ctx, key, 3, time.Millisecond)
+	assert.ErrorIs(t, err, distributedlock.ErrLockAcquisitionTimeout)
+
+	// Let go of the lock between the first and second attempt.
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		time.Sleep(5 * time.Millisecond)
+		_ = held.Release(ctx)
+	}()
+
+	lock, err := dl.AcquireWithRetry(ctx, key, 100, 10*time.Millisecond)
+	<-released
+	require.NoError(t, err)
+	require.NoError(t, lock.Release(ctx))
+}
+
+func TestDistributedLock_AcquireWithRetryCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testhelper.NewTestRedisClient(t)
+	key := "locks:test:distributedlock-retry-cancelled"
+	t.Cleanup(func() { _ = client.Del(ctx, key).Err() })
+
+	dl := distributedlock.New(client, time.Minute)
+
+	held, err := dl.AcquireLock(ctx, key)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = held.Release(ctx) })
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = dl.AcquireWithRetry(cctx, key, 3, time.Hour)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -10,9 +10,14 @@ import (
 
 const (
 	NotificationCheckInterval      = 60 * time.Second // time between notification checks
+	NotificationCheckMaxInterval   = 10 * time.Minute // upper bound for backing off idle accounts
 	NotificationCheckTimeout       = 5 * time.Minute  // time before we give up an account check lock
 	StuckNotificationCheckInterval = 2 * time.Minute  // time between stuck notification checks
 	StaleTokenThreshold            = 2 * time.Hour    // time an oauth token has to be expired for to be stale
+
+	// RevocationConfirmationThreshold is how many checks in a row reddit has to report
+	// an account's tokens as revoked before we believe it and delete the account.
+	RevocationConfirmationThreshold = 3
 )
 
 // Account represents an account we need to periodically check in the notifications worker.
@@ -32,12 +37,58 @@ type Account struct {
 	NextNotificationCheckAt      time.Time
 	NextStuckNotificationCheckAt time.Time
 	CheckCount                   int64
+	IdleCheckCount               int64
+
+	// How many checks in a row reddit reported the account's tokens as revoked
+	RevocationConfirmations int64
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 func (acct *Account) NormalizedUsername() string {
 	return strings.ToLower(acct.Username)
 }
 
+// NotificationCheckBackoff returns how long to wait before checking an inbox that came up
+// empty the given number of times in a row. It doubles with every idle check up to a cap.
+func NotificationCheckBackoff(idleChecks int64) time.Duration {
+	interval := NotificationCheckInterval
+	for i := int64(0); i < idleChecks && interval < NotificationCheckMaxInterval; i++ {
+		interval *= 2
+	}
+
+	if interval > NotificationCheckMaxInterval {
+		return NotificationCheckMaxInterval
+	}
+	return interval
+}
+
+// ScheduleNextNotificationCheck backs off accounts with quiet inboxes and resets the
+// cadence as soon as new messages show up.
+func (acct *Account) ScheduleNextNotificationCheck(now time.Time, hadMessages bool) {
+	if hadMessages {
+		acct.IdleCheckCount = 0
+	} else {
+		acct.IdleCheckCount++
+	}
+
+	acct.NextNotificationCheckAt = now.Add(NotificationCheckBackoff(acct.IdleCheckCount))
+}
+
+// ConfirmRevocation records reddit reporting the account's tokens as revoked and reports
+// whether that's happened often enough to delete the account. Until then the next check
+// gets pushed out further every time, in case reddit was just having a bad moment.
+func (acct *Account) ConfirmRevocation(now time.Time) bool {
+	acct.RevocationConfirmations++
+	if acct.RevocationConfirmations >= RevocationConfirmationThreshold {
+		return true
+	}
+
+	acct.NextNotificationCheckAt = now.Add(NotificationCheckBackoff(acct.RevocationConfirmations))
+	return false
+}
+
 func (acct *Account) Validate() error {
 	return validation.ValidateStruct(acct,
 		validation.Field(&acct.Username, validation.Required, validation.Length(3, 32)),
@@ -45,19 +96,34 @@ func (acct *Account) Validate() error {
 	)
 }
 
+// UpsertOutcome is what upserting an account ended up doing.
+type UpsertOutcome int
+
+const (
+	UpsertUpdated UpsertOutcome = iota
+	UpsertCreated
+	// UpsertReactivated means a deleted account registered again and was brought back.
+	UpsertReactivated
+)
+
 // AccountRepository represents the account's repository contract
 type AccountRepository interface {
 	GetByID(ctx context.Context, id int64) (Account, error)
 	GetByRedditID(ctx context.Context, id string) (Account, error)
+	GetByIDs(ctx context.Context, ids []int64) ([]Account, error)
+	GetByRedditIDs(ctx context.Context, ids []string) ([]Account, error)
 	GetByAPNSToken(ctx context.Context, token string) ([]Account, error)
 
-	CreateOrUpdate(ctx context.Context, acc *Account) error
+	CreateOrUpdate(ctx context.Context, acc *Account) (UpsertOutcome, error)
 	Update(ctx context.Context, acc *Account) error
 	Create(ctx context.Context, acc *Account) error
 	Delete(ctx context.Context, id int64) error
 	Associate(ctx context.Context, acc *Account, dev *Device) error
 	Disassociate(ctx context.Context, acc *Account, dev *Device) error
+	UpsertAndAssociate(ctx context.Context, acc *Account, dev *Device) (UpsertOutcome, error)
 
 	PruneOrphaned(ctx context.Context) (int64, error)
 	PruneStale(ctx context.Context, expiry time.Time) (int64, error)
+	CountOrphaned(ctx context.Context) (int64, error)
+	CountStale(ctx context.Context, expiry time.Time) (int64, error)
 }
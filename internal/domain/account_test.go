@@ -0,0 +1,71 @@
+package domain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+func TestNotificationCheckBackoff(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		idleChecks int64
+		want       time.Duration
+	}{
+		"active account":     {0, domain.NotificationCheckInterval},
+		"one idle check":     {1, 2 * domain.NotificationCheckInterval},
+		"two idle checks":    {2, 4 * domain.NotificationCheckInterval},
+		"three idle checks":  {3, 8 * domain.NotificationCheckInterval},
+		"reaches the cap":    {4, domain.NotificationCheckMaxInterval},
+		"stays at the cap":   {100, domain.NotificationCheckMaxInterval},
+		"negative is active": {-1, domain.NotificationCheckInterval},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, domain.NotificationCheckBackoff(tc.idleChecks))
+		})
+	}
+}
+
+func TestAccountScheduleNextNotificationCheck(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	acct := &domain.Account{}
+
+	acct.ScheduleNextNotificationCheck(now, false)
+	acct.ScheduleNextNotificationCheck(now, false)
+	assert.Equal(t, int64(2), acct.IdleCheckCount)
+	assert.Equal(t, now.Add(4*domain.NotificationCheckInterval), acct.NextNotificationCheckAt)
+
+	acct.ScheduleNextNotificationCheck(now, true)
+	assert.Equal(t, int64(0), acct.IdleCheckCount)
+	assert.Equal(t, now.Add(domain.NotificationCheckInterval), acct.NextNotificationCheckAt)
+}
+
+func TestAccountConfirmRevocation(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, time.October, 1, 12, 0, 0, 0, time.UTC)
+	acct := &domain.Account{}
+
+	for i := int64(1); i < domain.RevocationConfirmationThreshold; i++ {
+		assert.False(t, acct.ConfirmRevocation(now), "deleted after %d confirmations", i)
+		assert.Equal(t, i, acct.RevocationConfirmations)
+		assert.Equal(t, now.Add(domain.NotificationCheckBackoff(i)), acct.NextNotificationCheckAt)
+	}
+
+	assert.True(t, acct.ConfirmRevocation(now))
+
+	// A successful check in between starts the count over.
+	acct.RevocationConfirmations = 0
+	assert.False(t, acct.ConfirmRevocation(now))
+}
@@ -2,6 +2,8 @@ package domain
 
 import (
 	"context"
+	"errors"
+	"regexp"
 	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
@@ -11,6 +13,7 @@ const (
 	DeviceReceiptCheckPeriodDuration     = 4 * time.Hour
 	DeviceActiveAfterReceitCheckDuration = 30 * 24 * time.Hour // ~1 month
 	DeviceGracePeriodAfterReceiptExpiry  = 30 * 24 * time.Hour // ~1 month
+	DeviceEntitlementFreshness           = 7 * 24 * time.Hour  // how long an active entitlement protects a device from pruning
 )
 
 type Device struct {
@@ -19,27 +22,120 @@ type Device struct {
 	Sandbox              bool
 	ExpiresAt            time.Time
 	GracePeriodExpiresAt time.Time
+
+	// Result of the last receipt verification
+	EntitlementActive bool
+	ReceiptCheckedAt  time.Time
+
+	QuietHours QuietHours
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ObfuscatedToken is the device's APNs token in a form that's safe to log.
+func (dev *Device) ObfuscatedToken() string {
+	return ObfuscateToken(dev.APNSToken)
+}
+
+// ObfuscateToken keeps just enough of an APNs token to line logs and traces up with
+// each other, without writing the token itself anywhere.
+func ObfuscateToken(token string) string {
+	if len(token) <= 8 {
+		return "********"
+	}
+	return token[:4] + "..." + token[len(token)-4:]
 }
 
+// InQuietHours reports whether low priority notifications to the device should be held
+// back at now.
+func (dev *Device) InQuietHours(now time.Time) bool {
+	return dev.QuietHours.Contains(now)
+}
+
+// apnsTokenPattern is what APNs tokens look like: hex-encoded bytes.
+var apnsTokenPattern = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
 func (dev *Device) Validate() error {
 	return validation.ValidateStruct(dev,
-		validation.Field(&dev.APNSToken, validation.Required, validation.Length(64, 200)),
+		validation.Field(&dev.APNSToken, validation.Required, validation.Length(64, 200), validation.Match(apnsTokenPattern)),
 	)
 }
 
+const minutesPerDay = 24 * 60
+
+// QuietHours is a daily window, in minutes since midnight in Timezone, during which
+// only urgent notifications get delivered. It may wrap around midnight, and a window
+// that starts where it ends is off.
+type QuietHours struct {
+	Start    int
+	End      int
+	Timezone string
+}
+
+func (qh QuietHours) Enabled() bool {
+	return qh.Start != qh.End
+}
+
+// Contains reports whether t falls within the window. An unknown timezone is treated
+// as UTC.
+func (qh QuietHours) Contains(t time.Time) bool {
+	if !qh.Enabled() {
+		return false
+	}
+
+	loc, err := time.LoadLocation(qh.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	if qh.Start < qh.End {
+		return minute >= qh.Start && minute < qh.End
+	}
+	return minute >= qh.Start || minute < qh.End
+}
+
+func (qh QuietHours) Validate() error {
+	return validation.ValidateStruct(&qh,
+		validation.Field(&qh.Start, validation.Min(0), validation.Max(minutesPerDay-1)),
+		validation.Field(&qh.End, validation.Min(0), validation.Max(minutesPerDay-1)),
+		validation.Field(&qh.Timezone, validation.By(validTimezone)),
+	)
+}
+
+func validTimezone(value interface{}) error {
+	tz, _ := value.(string)
+	if _, err := time.LoadLocation(tz); err != nil {
+		return errors.New("unknown timezone")
+	}
+	return nil
+}
+
 type DeviceRepository interface {
 	GetByID(ctx context.Context, id int64) (Device, error)
+	GetByIDs(ctx context.Context, ids []int64) ([]Device, error)
 	GetByAPNSToken(ctx context.Context, token string) (Device, error)
 	GetInboxNotifiableByAccountID(ctx context.Context, id int64) ([]Device, error)
 	GetWatcherNotifiableByAccountID(ctx context.Context, id int64) ([]Device, error)
 	GetByAccountID(ctx context.Context, id int64) ([]Device, error)
 
-	CreateOrUpdate(ctx context.Context, dev *Device) error
+	CreateOrUpdate(ctx context.Context, dev *Device) (bool, error)
 	Update(ctx context.Context, dev *Device) error
+	UpdateToken(ctx context.Context, dev *Device, token string) error
+	Migrate(ctx context.Context, oldToken, newToken string) (Device, error)
+	SetSandbox(ctx context.Context, dev *Device, sandbox bool) error
 	Create(ctx context.Context, dev *Device) error
 	Delete(ctx context.Context, token string) error
 	SetNotifiable(ctx context.Context, dev *Device, acct *Account, inbox, watcher, global bool) error
+	SetQuietHours(ctx context.Context, dev *Device, qh QuietHours) error
+	SetNotifiableForAllAccounts(ctx context.Context, dev *Device, inbox, watcher, global *bool) error
 	GetNotifiable(ctx context.Context, dev *Device, acct *Account) (bool, bool, bool, error)
+	GetReceipt(ctx context.Context, dev *Device) (string, error)
+	SetReceipt(ctx context.Context, dev *Device, receipt string) error
 
 	PruneStale(ctx context.Context, expiry time.Time) (int64, error)
+	CountStale(ctx context.Context, expiry time.Time) (int64, error)
 }
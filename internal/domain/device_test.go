@@ -0,0 +1,117 @@
+package domain_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+func TestObfuscateToken(t *testing.T) {
+	t.Parallel()
+
+	const token = "313a182b63224821f5595f42aa019de850a0e7b776253659a9aac8140bb8a3f2"
+
+	tt := map[string]struct {
+		token string
+		want  string
+	}{
+		"full token":  {token, "313a...a3f2"},
+		"short token": {"abc123", "********"},
+		"empty token": {"", "********"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			got := domain.ObfuscateToken(tc.token)
+			assert.Equal(t, tc.want, got)
+
+			dev := &domain.Device{APNSToken: tc.token}
+			assert.Equal(t, got, dev.ObfuscatedToken())
+
+			if len(tc.token) > 8 {
+				assert.False(t, strings.Contains(got, tc.token[4:len(tc.token)-4]))
+			}
+		})
+	}
+}
+
+func TestDeviceInQuietHours(t *testing.T) {
+	t.Parallel()
+
+	at := func(hour, minute int) time.Time {
+		return time.Date(2022, 3, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	overnight := domain.QuietHours{Start: 22 * 60, End: 7 * 60}
+	daytime := domain.QuietHours{Start: 9 * 60, End: 17 * 60}
+	toronto := domain.QuietHours{Start: 22 * 60, End: 7 * 60, Timezone: "America/Toronto"}
+
+	tt := map[string]struct {
+		qh   domain.QuietHours
+		now  time.Time
+		want bool
+	}{
+		"disabled":                 {domain.QuietHours{Start: 60, End: 60}, at(1, 0), false},
+		"zero value":               {domain.QuietHours{}, at(0, 0), false},
+		"overnight before start":   {overnight, at(21, 59), false},
+		"overnight at start":       {overnight, at(22, 0), true},
+		"overnight past midnight":  {overnight, at(3, 30), true},
+		"overnight last minute":    {overnight, at(6, 59), true},
+		"overnight at end":         {overnight, at(7, 0), false},
+		"daytime inside":           {daytime, at(12, 0), true},
+		"daytime at end":           {daytime, at(17, 0), false},
+		"daytime before start":     {daytime, at(8, 59), false},
+		"timezone local night":     {toronto, at(4, 0), true},   // 23:00 in Toronto
+		"timezone local morning":   {toronto, at(12, 0), false}, // 07:00 in Toronto
+		"timezone utc night only":  {toronto, at(23, 0), false}, // 18:00 in Toronto
+		"unknown timezone is utc":  {domain.QuietHours{Start: 22 * 60, End: 7 * 60, Timezone: "Nowhere/Special"}, at(23, 0), true},
+		"timezone daylight saving": {toronto, time.Date(2022, 7, 1, 3, 0, 0, 0, time.UTC), true}, // 23:00 EDT
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			dev := &domain.Device{QuietHours: tc.qh}
+			assert.Equal(t, tc.want, dev.InQuietHours(tc.now))
+		})
+	}
+}
+
+func TestQuietHoursValidate(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		qh    domain.QuietHours
+		valid bool
+	}{
+		"valid":            {domain.QuietHours{Start: 1320, End: 420, Timezone: "Europe/Paris"}, true},
+		"no timezone":      {domain.QuietHours{Start: 1320, End: 420}, true},
+		"last minute":      {domain.QuietHours{Start: 0, End: 1439}, true},
+		"past midnight":    {domain.QuietHours{Start: 0, End: 1440}, false},
+		"negative":         {domain.QuietHours{Start: -1, End: 420}, false},
+		"unknown timezone": {domain.QuietHours{Start: 1320, End: 420, Timezone: "Nowhere/Special"}, false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.qh.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
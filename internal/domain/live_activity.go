@@ -8,6 +8,18 @@ import (
 const (
 	LiveActivityDuration      = 75 * time.Minute
 	LiveActivityCheckInterval = 30 * time.Second
+
+	// LiveActivityRefreshInterval is how long after its last push a live activity can
+	// get one that only refreshes its post stats, without a new comment to show.
+	LiveActivityRefreshInterval = 2 * time.Minute
+
+	// LiveActivityRenewalWindow is how close to expiring a live activity that renews
+	// on activity has to be before new comments extend it.
+	LiveActivityRenewalWindow = 10 * time.Minute
+
+	// LiveActivityMaxDuration caps how long a live activity can be kept going for,
+	// renewals included. Apple ends live activities after 8 hours regardless.
+	LiveActivityMaxDuration = 8 * time.Hour
 )
 
 type LiveActivity struct {
@@ -22,16 +34,59 @@ type LiveActivity struct {
 
 	ThreadID    string `json:"thread_id"`
 	Subreddit   string `json:"subreddit"`
+	Keyword     string `json:"keyword"`
 	NextCheckAt time.Time
 	ExpiresAt   time.Time
+
+	RenewOnActivity bool `json:"renew_on_activity"`
+	CreatedAt       time.Time
+
+	// LastCommentID is the comment shown in the last update pushed for the live activity.
+	LastCommentID string
+
+	// ReplyTokenHash is the SHA-256 of the token handed to the app when the live
+	// activity was created, which quick replies have to present.
+	ReplyTokenHash string `json:"-"`
+}
+
+// CommentMatches reports whether a comment body matches the live activity's
+// keyword. Live activities without a keyword don't match on comments.
+func (la *LiveActivity) CommentMatches(body string) bool {
+	if la.Keyword == "" {
+		return false
+	}
+	return KeywordMatches(la.Keyword, body)
+}
+
+// RenewedExpiry returns the expiry a live activity should be extended to when new
+// comments came in at now, and whether it should be extended at all. Only live
+// activities opted into renewal and close to expiring get extended, and never past
+// LiveActivityMaxDuration from when they were created.
+func (la *LiveActivity) RenewedExpiry(now time.Time) (time.Time, bool) {
+	if !la.RenewOnActivity || la.ExpiresAt.After(now.Add(LiveActivityRenewalWindow)) {
+		return la.ExpiresAt, false
+	}
+
+	expiry := now.Add(LiveActivityDuration)
+	if limit := la.CreatedAt.Add(LiveActivityMaxDuration); expiry.After(limit) {
+		expiry = limit
+	}
+
+	if !expiry.After(la.ExpiresAt) {
+		return la.ExpiresAt, false
+	}
+	return expiry, true
 }
 
 type LiveActivityRepository interface {
 	Get(ctx context.Context, apnsToken string) (LiveActivity, error)
 	List(ctx context.Context) ([]LiveActivity, error)
+	ListDue(ctx context.Context, before time.Time, limit int) ([]string, error)
 
 	Create(ctx context.Context, la *LiveActivity) error
 	Update(ctx context.Context, la *LiveActivity) error
+	UpdateTokens(ctx context.Context, la *LiveActivity) error
+	Extend(ctx context.Context, apnsToken string, expiresAt time.Time) error
 
 	RemoveStale(ctx context.Context) error
 	Delete(ctx context.Context, apns_token string) error
@@ -0,0 +1,64 @@
+package domain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+func TestLiveActivity_RenewedExpiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, time.October, 1, 12, 0, 0, 0, time.UTC)
+
+	tt := map[string]struct {
+		la     domain.LiveActivity
+		want   time.Time
+		renews bool
+	}{
+		"not opted in": {
+			domain.LiveActivity{ExpiresAt: now.Add(time.Minute), CreatedAt: now.Add(-time.Hour)},
+			now.Add(time.Minute),
+			false,
+		},
+		"far from expiring": {
+			domain.LiveActivity{RenewOnActivity: true, ExpiresAt: now.Add(time.Hour), CreatedAt: now.Add(-15 * time.Minute)},
+			now.Add(time.Hour),
+			false,
+		},
+		"near expiry": {
+			domain.LiveActivity{RenewOnActivity: true, ExpiresAt: now.Add(time.Minute), CreatedAt: now.Add(-time.Hour)},
+			now.Add(domain.LiveActivityDuration),
+			true,
+		},
+		"already expired": {
+			domain.LiveActivity{RenewOnActivity: true, ExpiresAt: now.Add(-time.Second), CreatedAt: now.Add(-time.Hour)},
+			now.Add(domain.LiveActivityDuration),
+			true,
+		},
+		"capped": {
+			domain.LiveActivity{RenewOnActivity: true, ExpiresAt: now.Add(time.Minute), CreatedAt: now.Add(-7 * time.Hour)},
+			now.Add(time.Hour),
+			true,
+		},
+		"at the cap": {
+			domain.LiveActivity{RenewOnActivity: true, ExpiresAt: now.Add(time.Minute), CreatedAt: now.Add(time.Minute - domain.LiveActivityMaxDuration)},
+			now.Add(time.Minute),
+			false,
+		},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			got, renews := tc.la.RenewedExpiry(now)
+			assert.Equal(t, tc.renews, renews)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
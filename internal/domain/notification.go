@@ -0,0 +1,27 @@
+package domain
+
+// NotificationPriority is how urgently a notification should reach the user.
+type NotificationPriority int
+
+const (
+	// NotificationPriorityLow is for discovery, like trending posts and subreddit
+	// watchers, which can wait for a convenient time to be delivered.
+	NotificationPriorityLow NotificationPriority = iota
+	NotificationPriorityNormal
+	// NotificationPriorityHigh is for people talking to the user: inbox replies,
+	// mentions and private messages.
+	NotificationPriorityHigh
+)
+
+func (np NotificationPriority) String() string {
+	switch np {
+	case NotificationPriorityLow:
+		return "low"
+	case NotificationPriorityNormal:
+		return "normal"
+	case NotificationPriorityHigh:
+		return "high"
+	}
+
+	return "unknown"
+}
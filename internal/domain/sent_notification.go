@@ -0,0 +1,32 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	SentNotificationRetention    = 7 * 24 * time.Hour // how long we keep a record of delivered notifications
+	SentNotificationHistoryLimit = 50                 // how many records we return for a device
+)
+
+// SentNotification is a summary of a notification that was delivered to a device.
+type SentNotification struct {
+	ID        int64
+	DeviceID  int64
+	Type      string
+	Title     string
+	CreatedAt time.Time
+}
+
+type SentNotificationRepository interface {
+	GetByDeviceID(ctx context.Context, id int64, limit int) ([]SentNotification, error)
+
+	// CountByDeviceID returns how many notifications of each type went to a device since
+	// the given time.
+	CountByDeviceID(ctx context.Context, id int64, since time.Time) (map[string]int64, error)
+
+	Create(ctx context.Context, sn *SentNotification) error
+
+	PruneStale(ctx context.Context, before time.Time) (int64, error)
+}
@@ -10,11 +10,28 @@ import (
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 )
 
-const SubredditCheckInterval = 2 * time.Minute
+const (
+	SubredditCheckInterval    = 2 * time.Minute
+	SubredditMinCheckInterval = 30 * time.Second
+	SubredditMaxCheckInterval = 10 * time.Minute
+
+	// A watched subreddit that hasn't been checked in this long is falling behind,
+	// whatever its own interval is.
+	SubredditStaleAfter = 2 * SubredditMaxCheckInterval
+
+	// Aim to see about this many new posts every time a subreddit gets checked.
+	subredditPostsPerCheck = 1
+)
 
 type Subreddit struct {
-	ID          int64
-	NextCheckAt time.Time
+	ID           int64
+	NextCheckAt  time.Time
+	PostsPerHour float64
+	WatcherCount int64
+
+	// When the subreddit was last checked, and the newest post seen then.
+	LastCheckedAt time.Time
+	LastPostID    string
 
 	// Reddit information
 	SubredditID string
@@ -25,6 +42,23 @@ func (sr *Subreddit) NormalizedName() string {
 	return strings.ToLower(sr.Name)
 }
 
+// CheckInterval returns how long to wait before checking the subreddit again, so that
+// busy subreddits are polled often and quiet ones aren't polled needlessly.
+func (sr *Subreddit) CheckInterval() time.Duration {
+	if sr.PostsPerHour <= 0 {
+		return SubredditMaxCheckInterval
+	}
+
+	interval := time.Duration(float64(time.Hour) * subredditPostsPerCheck / sr.PostsPerHour)
+	if interval < SubredditMinCheckInterval {
+		return SubredditMinCheckInterval
+	}
+	if interval > SubredditMaxCheckInterval {
+		return SubredditMaxCheckInterval
+	}
+	return interval
+}
+
 func validPrefix(value interface{}) error {
 	s, _ := value.(string)
 	if len(s) < 2 {
@@ -37,16 +71,41 @@ func validPrefix(value interface{}) error {
 	return errors.New("invalid subreddit format")
 }
 
+// subredditNameRules follow reddit's own: 2 to 21 letters, digits or underscores, not
+// starting with an underscore. Names starting with u_ are user profiles.
+var subredditNameRules = []validation.Rule{
+	validation.Required,
+	validation.Length(2, 21),
+	validation.By(validPrefix),
+	validation.Match(regexp.MustCompile(`^[a-zA-Z0-9]\w*$`)),
+}
+
+// ValidateSubredditName checks that name could be a subreddit before anything goes
+// looking for it.
+func ValidateSubredditName(name string) error {
+	return validation.Validate(name, subredditNameRules...)
+}
+
 func (sr *Subreddit) Validate() error {
 	return validation.ValidateStruct(sr,
-		validation.Field(&sr.Name, validation.Required, validation.Length(2, 21), validation.By(validPrefix), validation.Match(regexp.MustCompile(`^[a-zA-Z0-9]\w*$`))),
+		validation.Field(&sr.Name, subredditNameRules...),
 		validation.Field(&sr.SubredditID, validation.Required, validation.Length(4, 9)),
 	)
 }
 
 type SubredditRepository interface {
 	GetByID(ctx context.Context, id int64) (Subreddit, error)
+	GetByIDs(ctx context.Context, ids []int64) ([]Subreddit, error)
 	GetByName(ctx context.Context, name string) (Subreddit, error)
 
 	CreateOrUpdate(ctx context.Context, sr *Subreddit) error
+	Update(ctx context.Context, sr *Subreddit) error
+
+	// ClaimDue pushes the next check of up to limit subreddits that are due by now and
+	// still have watchers back to next, and returns their IDs.
+	ClaimDue(ctx context.Context, now, next time.Time, limit int) ([]int64, error)
+
+	// CountStale returns how many subreddits with watchers haven't been checked since
+	// before.
+	CountStale(ctx context.Context, before time.Time) (int64, error)
 }
@@ -3,6 +3,7 @@ package domain_test
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -41,3 +42,69 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateSubredditName(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		name  string
+		valid bool
+	}{
+		"simple":               {"pics", true},
+		"mixed case":           {"AskReddit", true},
+		"digits":               {"2007scape", true},
+		"underscores":          {"p_i_x_a_r", true},
+		"two letters":          {"de", true},
+		"21 letters":           {"GamingLeaksAndRumours", true},
+		"empty":                {"", false},
+		"one letter":           {"a", false},
+		"22 letters":           {"GamingLeaksAndRumours2", false},
+		"leading underscore":   {"_pics", false},
+		"prefixed with r/":     {"r/pics", false},
+		"spaces":               {"ask reddit", false},
+		"dashes":               {"ask-reddit", false},
+		"punctuation":          {"pics!", false},
+		"user profile":         {"u_iamthatis", false},
+		"non-ascii characters": {"pícs", false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			err := domain.ValidateSubredditName(tc.name)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestSubredditCheckInterval(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		postsPerHour float64
+		want         time.Duration
+	}{
+		"no posts":             {0, domain.SubredditMaxCheckInterval},
+		"one post a day":       {1.0 / 24, domain.SubredditMaxCheckInterval},
+		"six posts an hour":    {6, 10 * time.Minute},
+		"thirty posts an hour": {30, 2 * time.Minute},
+		"sixty posts an hour":  {60, time.Minute},
+		"very busy":            {1000, domain.SubredditMinCheckInterval},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			sr := &domain.Subreddit{PostsPerHour: tc.postsPerHour}
+			assert.Equal(t, tc.want, sr.CheckInterval())
+		})
+	}
+}
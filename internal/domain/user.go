@@ -11,8 +11,9 @@ import (
 const UserRefreshInterval = 2 * time.Minute
 
 type User struct {
-	ID          int64
-	NextCheckAt time.Time
+	ID           int64
+	NextCheckAt  time.Time
+	WatcherCount int64
 
 	// Reddit information
 	UserID string
@@ -32,8 +33,13 @@ func (u *User) Validate() error {
 
 type UserRepository interface {
 	GetByID(context.Context, int64) (User, error)
+	GetByIDs(context.Context, []int64) ([]User, error)
 	GetByName(context.Context, string) (User, error)
 
 	CreateOrUpdate(context.Context, *User) error
 	Delete(context.Context, int64) error
+
+	// ClaimDue pushes the next check of up to limit users that are due by now and still
+	// have a watcher back to next, and returns their IDs.
+	ClaimDue(ctx context.Context, now, next time.Time, limit int) ([]int64, error)
 }
@@ -16,6 +16,26 @@ const (
 	TrendingWatcher
 )
 
+// How long a device isn't notified about the same post again, by default.
+const (
+	SubredditWatcherDedupTTL = 24 * time.Hour
+	TrendingWatcherDedupTTL  = 48 * time.Hour
+)
+
+// WatcherBackfillWindow is how far back a new subreddit watcher looks for posts it
+// would have matched.
+const WatcherBackfillWindow = 24 * time.Hour
+
+// DefaultNotificationSound is what watchers that didn't pick a sound play.
+const DefaultNotificationSound = "traloop.wav"
+
+// NotificationSounds are the sounds a watcher can pick from: the one bundled with the
+// app, and "default", the system sound. Others can be added as the app ships them.
+var NotificationSounds = []interface{}{
+	"default",
+	"traloop.wav",
+}
+
 func (wt WatcherType) String() string {
 	switch wt {
 	case SubredditWatcher:
@@ -49,17 +69,81 @@ type Watcher struct {
 	Domain    string
 	Hits      int64
 
+	ExcludeNSFW       bool
+	ExcludeCrossposts bool
+
+	Sound string
+
 	// Related models
 	Device  Device
 	Account Account
 }
 
+// NotificationSound is the sound the watcher's notifications play.
+func (w *Watcher) NotificationSound() string {
+	if w.Sound == "" {
+		return DefaultNotificationSound
+	}
+	return w.Sound
+}
+
+// AllowsContent reports whether a post passes the watcher's NSFW and crosspost filters.
+func (w *Watcher) AllowsContent(nsfw, crosspost bool) bool {
+	if w.ExcludeNSFW && nsfw {
+		return false
+	}
+	return !(w.ExcludeCrossposts && crosspost)
+}
+
+// WatchedPost is what a subreddit watcher's criteria are matched against.
+type WatchedPost struct {
+	Author    string
+	Title     string
+	Flair     string
+	URL       string
+	Score     int64
+	NSFW      bool
+	Crosspost bool
+}
+
+// MatchesPost reports whether a post meets all of the watcher's criteria. It doesn't
+// look at when either was created, that's up to the caller.
+func (w *Watcher) MatchesPost(p WatchedPost) bool {
+	if !w.KeywordMatches(p.Title) {
+		return false
+	}
+
+	if w.Author != "" && strings.ToLower(p.Author) != w.Author {
+		return false
+	}
+
+	if w.Upvotes > 0 && p.Score < w.Upvotes {
+		return false
+	}
+
+	if w.Flair != "" && !strings.Contains(strings.ToLower(p.Flair), w.Flair) {
+		return false
+	}
+
+	if w.Domain != "" && !strings.Contains(strings.ToLower(p.URL), w.Domain) {
+		return false
+	}
+
+	return w.AllowsContent(p.NSFW, p.Crosspost)
+}
+
 func (w *Watcher) KeywordMatches(haystack string) bool {
-	if w.Keyword == "" {
+	return KeywordMatches(w.Keyword, haystack)
+}
+
+// KeywordMatches reports whether haystack contains every word in keyword, which
+// may be separated by '+' or ','. An empty keyword matches everything.
+func KeywordMatches(keyword, haystack string) bool {
+	if keyword == "" {
 		return true
 	}
 
-	keywords := strings.FieldsFunc(w.Keyword, func(r rune) bool {
+	keywords := strings.FieldsFunc(keyword, func(r rune) bool {
 		return r == '+' || r == ','
 	})
 
@@ -79,6 +163,7 @@ func (w *Watcher) Validate() error {
 		validation.Field(&w.Label, validation.Required, validation.Length(1, 64)),
 		validation.Field(&w.Type, validation.In(SubredditWatcher, UserWatcher, TrendingWatcher)),
 		validation.Field(&w.WatcheeID, validation.Required),
+		validation.Field(&w.Sound, validation.In(NotificationSounds...)),
 	)
 }
 
@@ -89,9 +174,17 @@ type WatcherRepository interface {
 	GetByTrendingSubredditID(ctx context.Context, id int64) ([]Watcher, error)
 	GetByDeviceAPNSTokenAndAccountRedditID(ctx context.Context, apns string, rid string) ([]Watcher, error)
 
+	// Search returns up to limit watchers whose label, keyword or domain contains query,
+	// across all devices.
+	Search(ctx context.Context, query string, limit int) ([]Watcher, error)
+
 	Create(ctx context.Context, watcher *Watcher) error
 	Update(ctx context.Context, watcher *Watcher) error
 	IncrementHits(ctx context.Context, id int64) error
 	Delete(ctx context.Context, id int64) error
 	DeleteByTypeAndWatcheeID(context.Context, WatcherType, int64) error
+
+	// RecountWatchers recomputes every watcher count from scratch, fixing drift from
+	// watchers that went away without going through the repository.
+	RecountWatchers(ctx context.Context) (int64, error)
 }
@@ -35,3 +35,103 @@ func TestWatcherKeywordMatches(t *testing.T) {
 		})
 	}
 }
+
+func TestWatcherAllowsContent(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		watcher   domain.Watcher
+		nsfw      bool
+		crosspost bool
+
+		want bool
+	}{
+		"no filters, nsfw crosspost": {domain.Watcher{}, true, true, true},
+		"exclude nsfw, sfw":          {domain.Watcher{ExcludeNSFW: true}, false, true, true},
+		"exclude nsfw, nsfw":         {domain.Watcher{ExcludeNSFW: true}, true, false, false},
+		"exclude crossposts, plain":  {domain.Watcher{ExcludeCrossposts: true}, true, false, true},
+		"exclude crossposts, xpost":  {domain.Watcher{ExcludeCrossposts: true}, false, true, false},
+		"exclude both, plain sfw":    {domain.Watcher{ExcludeNSFW: true, ExcludeCrossposts: true}, false, false, true},
+		"exclude both, nsfw xpost":   {domain.Watcher{ExcludeNSFW: true, ExcludeCrossposts: true}, true, true, false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, tc.watcher.AllowsContent(tc.nsfw, tc.crosspost))
+		})
+	}
+}
+
+func TestWatcherMatchesPost(t *testing.T) {
+	t.Parallel()
+
+	post := domain.WatchedPost{
+		Author: "JaneDoe",
+		Title:  "Mechanical Keyboard for sale",
+		Flair:  "Selling",
+		URL:    "https://i.imgur.com/abc.png",
+		Score:  42,
+	}
+
+	tt := map[string]struct {
+		watcher domain.Watcher
+		post    domain.WatchedPost
+		want    bool
+	}{
+		"no criteria":         {domain.Watcher{}, post, true},
+		"keyword":             {domain.Watcher{Keyword: "keyboard+sale"}, post, true},
+		"keyword mismatch":    {domain.Watcher{Keyword: "mouse"}, post, false},
+		"author":              {domain.Watcher{Author: "janedoe"}, post, true},
+		"author mismatch":     {domain.Watcher{Author: "johndoe"}, post, false},
+		"upvotes":             {domain.Watcher{Upvotes: 42}, post, true},
+		"too few upvotes":     {domain.Watcher{Upvotes: 43}, post, false},
+		"flair":               {domain.Watcher{Flair: "sell"}, post, true},
+		"flair mismatch":      {domain.Watcher{Flair: "buying"}, post, false},
+		"domain":              {domain.Watcher{Domain: "imgur.com"}, post, true},
+		"domain mismatch":     {domain.Watcher{Domain: "reddit.com"}, post, false},
+		"nsfw excluded":       {domain.Watcher{ExcludeNSFW: true}, domain.WatchedPost{NSFW: true}, false},
+		"crosspost excluded":  {domain.Watcher{ExcludeCrossposts: true}, domain.WatchedPost{Crosspost: true}, false},
+		"all criteria":        {domain.Watcher{Keyword: "keyboard", Author: "janedoe", Upvotes: 10, Flair: "selling", Domain: "imgur"}, post, true},
+		"one criteria misses": {domain.Watcher{Keyword: "keyboard", Author: "janedoe", Upvotes: 100}, post, false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, tc.watcher.MatchesPost(tc.post))
+		})
+	}
+}
+
+func TestWatcherSound(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		sound string
+
+		want  string
+		valid bool
+	}{
+		"unset":          {"", domain.DefaultNotificationSound, true},
+		"bundled":        {"traloop.wav", "traloop.wav", true},
+		"system":         {"default", "default", true},
+		"unknown":        {"airhorn.wav", "airhorn.wav", false},
+		"not in the app": {"chime.wav", "chime.wav", false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			watcher := domain.Watcher{Label: "deals", WatcheeID: 1, Sound: tc.sound}
+			assert.Equal(t, tc.want, watcher.NotificationSound())
+			assert.Equal(t, tc.valid, watcher.Validate() == nil)
+		})
+	}
+}
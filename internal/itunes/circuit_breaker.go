@@ -0,0 +1,94 @@
+package itunes
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCircuitBreakerThreshold = 5
+	DefaultCircuitBreakerCooldown  = time.Minute
+)
+
+var ErrCircuitOpen = errors.New("receipt verification circuit is open")
+
+// CircuitBreaker stops sending receipts to Apple after too many consecutive
+// failures, so an outage on their end doesn't get treated as invalid receipts.
+// After the cooldown a single call is let through to probe whether Apple is back.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	verify    func(receipt string, production bool) (*IAPResponse, error)
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = DefaultCircuitBreakerThreshold
+	}
+
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		verify:    NewIAPResponse,
+	}
+}
+
+// Open reports whether receipt verifications are currently being short-circuited.
+func (cb *CircuitBreaker) Open() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return cb.failures >= cb.threshold
+}
+
+// NewIAPResponse verifies a receipt with Apple, failing fast with ErrCircuitOpen
+// while the circuit is open.
+func (cb *CircuitBreaker) NewIAPResponse(receipt string, production bool) (*IAPResponse, error) {
+	if !cb.allow(time.Now()) {
+		return nil, ErrCircuitOpen
+	}
+
+	iapr, err := cb.verify(receipt, production)
+	cb.record(err, time.Now())
+
+	return iapr, err
+}
+
+func (cb *CircuitBreaker) allow(now time.Time) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.failures < cb.threshold {
+		return true
+	}
+
+	if cb.probing || now.Before(cb.openedAt.Add(cb.cooldown)) {
+		return false
+	}
+
+	cb.probing = true
+	return true
+}
+
+func (cb *CircuitBreaker) record(err error, now time.Time) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+
+	if err == nil {
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		cb.openedAt = now
+	}
+}
@@ -0,0 +1,54 @@
+package itunes_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/itunes"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	appleErr := errors.New("apple is down")
+	calls := 0
+	failing := true
+
+	cb := itunes.NewCircuitBreaker(3, 20*time.Millisecond)
+	cb.SetVerifier(func(string, bool) (*itunes.IAPResponse, error) {
+		calls++
+		if failing {
+			return nil, appleErr
+		}
+		return &itunes.IAPResponse{}, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := cb.NewIAPResponse("receipt", true)
+		assert.ErrorIs(t, err, appleErr)
+	}
+	assert.True(t, cb.Open())
+
+	// Calls fail fast without reaching Apple while open.
+	_, err := cb.NewIAPResponse("receipt", true)
+	assert.ErrorIs(t, err, itunes.ErrCircuitOpen)
+	assert.Equal(t, 3, calls)
+
+	// A failed probe after the cooldown keeps it open.
+	time.Sleep(25 * time.Millisecond)
+	_, err = cb.NewIAPResponse("receipt", true)
+	assert.ErrorIs(t, err, appleErr)
+	assert.Equal(t, 4, calls)
+	_, err = cb.NewIAPResponse("receipt", true)
+	assert.ErrorIs(t, err, itunes.ErrCircuitOpen)
+
+	// A successful probe closes it again.
+	failing = false
+	time.Sleep(25 * time.Millisecond)
+	_, err = cb.NewIAPResponse("receipt", true)
+	assert.NoError(t, err)
+	assert.False(t, cb.Open())
+}
@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Client forwards everything to statsd as usual and mirrors counters, gauges and
+// histograms into Prometheus collectors, so both backends see the same events.
+//
+// Collectors are created the first time a metric is seen, labelled with that call's
+// tag keys. Later calls for the same metric with a different set of tag keys, or a
+// metric name reused for a different type, only go to statsd.
+type Client struct {
+	statsd.ClientInterface
+
+	registerer prometheus.Registerer
+
+	mu         sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	gauges     map[string]*prometheus.GaugeVec
+	histograms map[string]*prometheus.HistogramVec
+	labelNames map[string][]string
+}
+
+func NewClient(client statsd.ClientInterface, registerer prometheus.Registerer) *Client {
+	return &Client{
+		ClientInterface: client,
+		registerer:      registerer,
+		counters:        map[string]*prometheus.CounterVec{},
+		gauges:          map[string]*prometheus.GaugeVec{},
+		histograms:      map[string]*prometheus.HistogramVec{},
+		labelNames:      map[string][]string{},
+	}
+}
+
+// Handler serves whatever has been registered with the default Prometheus registry.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func (c *Client) Incr(name string, tags []string, rate float64) error {
+	c.add(name, tags, 1)
+	return c.ClientInterface.Incr(name, tags, rate)
+}
+
+func (c *Client) Count(name string, value int64, tags []string, rate float64) error {
+	if value >= 0 {
+		c.add(name, tags, float64(value))
+	}
+	return c.ClientInterface.Count(name, value, tags, rate)
+}
+
+func (c *Client) Gauge(name string, value float64, tags []string, rate float64) error {
+	c.set(name, tags, value)
+	return c.ClientInterface.Gauge(name, value, tags, rate)
+}
+
+func (c *Client) Histogram(name string, value float64, tags []string, rate float64) error {
+	c.observe(name, tags, value)
+	return c.ClientInterface.Histogram(name, value, tags, rate)
+}
+
+func (c *Client) Distribution(name string, value float64, tags []string, rate float64) error {
+	c.observe(name, tags, value)
+	return c.ClientInterface.Distribution(name, value, tags, rate)
+}
+
+func (c *Client) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	c.observe(name, tags, value.Seconds())
+	return c.ClientInterface.Timing(name, value, tags, rate)
+}
+
+func (c *Client) add(name string, tags []string, value float64) {
+	names, labels := parseTags(tags)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vec, ok := c.counters[name]
+	if !ok {
+		if !c.claim(name, names) {
+			return
+		}
+
+		vec = prometheus.NewCounterVec(prometheus.CounterOpts{Name: metricName(name)}, names)
+		if err := c.registerer.Register(vec); err != nil {
+			return
+		}
+		c.counters[name] = vec
+	}
+
+	if c.matches(name, names) {
+		vec.With(labels).Add(value)
+	}
+}
+
+func (c *Client) set(name string, tags []string, value float64) {
+	names, labels := parseTags(tags)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vec, ok := c.gauges[name]
+	if !ok {
+		if !c.claim(name, names) {
+			return
+		}
+
+		vec = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName(name)}, names)
+		if err := c.registerer.Register(vec); err != nil {
+			return
+		}
+		c.gauges[name] = vec
+	}
+
+	if c.matches(name, names) {
+		vec.With(labels).Set(value)
+	}
+}
+
+func (c *Client) observe(name string, tags []string, value float64) {
+	names, labels := parseTags(tags)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vec, ok := c.histograms[name]
+	if !ok {
+		if !c.claim(name, names) {
+			return
+		}
+
+		vec = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: metricName(name)}, names)
+		if err := c.registerer.Register(vec); err != nil {
+			return
+		}
+		c.histograms[name] = vec
+	}
+
+	if c.matches(name, names) {
+		vec.With(labels).Observe(value)
+	}
+}
+
+// claim reserves a metric name for a collector with the given label names, failing if
+// a collector of another type already has it.
+func (c *Client) claim(name string, names []string) bool {
+	if _, ok := c.labelNames[name]; ok {
+		return false
+	}
+	c.labelNames[name] = names
+	return true
+}
+
+func (c *Client) matches(name string, names []string) bool {
+	return strings.Join(c.labelNames[name], ",") == strings.Join(names, ",")
+}
+
+// parseTags turns statsd "key:value" tags into sorted label names and their values.
+func parseTags(tags []string) ([]string, prometheus.Labels) {
+	labels := prometheus.Labels{}
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(tag, ":")
+		labels[metricName(key)] = value
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, labels
+}
+
+// metricName maps a statsd name like "apollo.queue.ready" to "apollo_queue_ready".
+func metricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
@@ -0,0 +1,91 @@
+package metrics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/metrics"
+)
+
+func gather(t *testing.T, reg *prometheus.Registry) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+
+	families := map[string]*dto.MetricFamily{}
+	for _, mf := range mfs {
+		families[mf.GetName()] = mf
+	}
+	return families
+}
+
+func TestClientMirrorsMetrics(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+	client := metrics.NewClient(&statsd.NoOpClient{}, reg)
+
+	require.NoError(t, client.Incr("reddit.api.calls", []string{"endpoint:me"}, 0.1))
+	require.NoError(t, client.Incr("reddit.api.calls", []string{"endpoint:me"}, 0.1))
+	require.NoError(t, client.Count("apns.notification.sent", 3, nil, 1))
+	require.NoError(t, client.Gauge("apollo.queue.ready", 42, []string{"queue:users"}, 1))
+	require.NoError(t, client.Histogram("apollo.queue.runtime", 0.5, []string{"queue:users"}, 1))
+	require.NoError(t, client.Timing("apollo.consumer.runtime", 250*time.Millisecond, nil, 1))
+
+	families := gather(t, reg)
+
+	calls := families["reddit_api_calls"]
+	require.NotNil(t, calls)
+	assert.Equal(t, dto.MetricType_COUNTER, calls.GetType())
+	require.Len(t, calls.GetMetric(), 1)
+	assert.Equal(t, "endpoint", calls.GetMetric()[0].GetLabel()[0].GetName())
+	assert.Equal(t, "me", calls.GetMetric()[0].GetLabel()[0].GetValue())
+	assert.Equal(t, float64(2), calls.GetMetric()[0].GetCounter().GetValue())
+
+	sent := families["apns_notification_sent"]
+	require.NotNil(t, sent)
+	assert.Equal(t, float64(3), sent.GetMetric()[0].GetCounter().GetValue())
+
+	ready := families["apollo_queue_ready"]
+	require.NotNil(t, ready)
+	assert.Equal(t, dto.MetricType_GAUGE, ready.GetType())
+	assert.Equal(t, float64(42), ready.GetMetric()[0].GetGauge().GetValue())
+
+	runtime := families["apollo_queue_runtime"]
+	require.NotNil(t, runtime)
+	assert.Equal(t, dto.MetricType_HISTOGRAM, runtime.GetType())
+	assert.Equal(t, uint64(1), runtime.GetMetric()[0].GetHistogram().GetSampleCount())
+
+	timing := families["apollo_consumer_runtime"]
+	require.NotNil(t, timing)
+	assert.Equal(t, 0.25, timing.GetMetric()[0].GetHistogram().GetSampleSum())
+}
+
+func TestClientSkipsConflictingMetrics(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+	client := metrics.NewClient(&statsd.NoOpClient{}, reg)
+
+	require.NoError(t, client.Incr("api.calls", []string{"route:health"}, 1))
+
+	// Different tag keys and a different type for the same name still reach statsd,
+	// they just can't be mirrored.
+	assert.NoError(t, client.Incr("api.calls", []string{"route:health", "status:200"}, 1))
+	assert.NoError(t, client.Gauge("api.calls", 1, nil, 1))
+
+	families := gather(t, reg)
+	require.Len(t, families, 1)
+
+	calls := families["api_calls"]
+	require.NotNil(t, calls)
+	assert.Equal(t, dto.MetricType_COUNTER, calls.GetType())
+	assert.Equal(t, float64(1), calls.GetMetric()[0].GetCounter().GetValue())
+}
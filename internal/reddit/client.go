@@ -2,8 +2,10 @@ package reddit
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -24,6 +26,16 @@ const (
 	RateLimitRemainingHeader = "x-ratelimit-remaining"
 	RateLimitUsedHeader      = "x-ratelimit-used"
 	RateLimitResetHeader     = "x-ratelimit-reset"
+
+	// AboutInfoBatchSize is how many fullnames /api/info takes in one request.
+	AboutInfoBatchSize = 100
+
+	// MaxResponseBytes is the most of a response body that gets read. A full listing is
+	// a fraction of this.
+	MaxResponseBytes = 10 << 20
+
+	// SubredditListingCacheTTL is how long a fetched subreddit listing gets reused for.
+	SubredditListingCacheTTL = 30 * time.Second
 )
 
 type Client struct {
@@ -46,6 +58,10 @@ type RateLimitingInfo struct {
 }
 
 var (
+	// RequiredScopes are the OAuth scopes an account has to grant for the backend to
+	// check its inbox and watchers.
+	RequiredScopes = []string{"identity", "history", "privatemessages", "read"}
+
 	backoffSchedule = []time.Duration{
 		200 * time.Millisecond,
 		500 * time.Millisecond,
@@ -59,6 +75,20 @@ var (
 		404: ErrSubredditNotFound,
 		429: ErrTooManyRequests,
 	}
+
+	// subredditErrorMap leaves 403s and 404s to subredditError, which tells them apart
+	// by the reason Reddit gives.
+	subredditErrorMap = map[int]error{
+		401: ErrOauthRevoked,
+		429: ErrTooManyRequests,
+	}
+
+	threadErrorMap = map[int]error{
+		401: ErrOauthRevoked,
+		403: ErrOauthRevoked,
+		404: ErrThreadNotFound,
+		429: ErrTooManyRequests,
+	}
 )
 
 func SplitID(id string) (string, string) {
@@ -97,13 +127,8 @@ func NewClient(id, secret string, tracer trace.Tracer, statsd statsd.ClientInter
 		pool.Put(parsers[i])
 	}
 
-	t := http.DefaultTransport.(*http.Transport).Clone()
-	t.MaxIdleConns = 100
-	t.MaxConnsPerHost = 100
-	t.MaxIdleConnsPerHost = 100
-
 	httpClient := &http.Client{
-		Transport: otelhttp.NewTransport(t),
+		Transport: otelhttp.NewTransport(newTransport(connLimit)),
 		Timeout:   4 * time.Second,
 	}
 
@@ -119,6 +144,23 @@ func NewClient(id, secret string, tracer trace.Tracer, statsd statsd.ClientInter
 	}
 }
 
+// defaultConnLimit is used when the caller doesn't say how many requests it makes at once.
+const defaultConnLimit = 100
+
+// newTransport keeps enough idle connections around for the number of concurrent
+// requests the client is expected to make, since nearly all of them go to the same host.
+// Bursts past that still get a connection rather than queueing behind the others.
+func newTransport(connLimit int) *http.Transport {
+	if connLimit <= 0 {
+		connLimit = defaultConnLimit
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = connLimit
+	t.MaxIdleConnsPerHost = connLimit
+	return t
+}
+
 type AuthenticatedClient struct {
 	client *Client
 
@@ -143,7 +185,21 @@ func (rc *Client) NewAuthenticatedClient(redditId, refreshToken, accessToken str
 	return &AuthenticatedClient{rc, redditId, refreshToken, accessToken}
 }
 
+// isTimeout reports whether err is a request running out of time, be it the request's
+// own deadline or the HTTP client's timeout, which isn't a DeadlineExceeded when it hits
+// while the body is being read.
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &nerr) && nerr.Timeout())
+}
+
 func (rc *Client) doRequest(ctx context.Context, r *Request, errmap map[int]error) ([]byte, *RateLimitingInfo, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
 	req, err := r.HTTPRequest(ctx)
 	if err != nil {
 		return nil, nil, err
@@ -151,21 +207,39 @@ func (rc *Client) doRequest(ctx context.Context, r *Request, errmap map[int]erro
 
 	start := time.Now()
 
-	resp, err := rc.client.Do(req)
+	client := rc.client
+	if r.client != nil {
+		client = r.client
+	}
+
+	resp, err := client.Do(req)
 
 	_ = rc.statsd.Incr("reddit.api.calls", r.tags, 0.1)
 
 	if err != nil {
 		_ = rc.statsd.Incr("reddit.api.errors", r.tags, 0.1)
-		if strings.Contains(err.Error(), "http2: timeout awaiting response headers") {
+		if strings.Contains(err.Error(), "http2: timeout awaiting response headers") || isTimeout(err) {
 			return nil, nil, ErrTimeout
 		}
 		return nil, nil, err
 	}
-	bb, err := io.ReadAll(resp.Body)
+	bb, err := io.ReadAll(io.LimitReader(resp.Body, MaxResponseBytes+1))
 	resp.Body.Close()
 	_ = rc.statsd.Histogram("reddit.api.latency", float64(time.Since(start).Milliseconds()), r.tags, 0.1)
 
+	// A body cut short, say by the deadline, is no good even if the status was fine.
+	if err != nil {
+		_ = rc.statsd.Incr("reddit.api.errors", r.tags, 0.1)
+		if isTimeout(err) {
+			return nil, nil, ErrTimeout
+		}
+		return nil, nil, err
+	}
+	if len(bb) > MaxResponseBytes {
+		_ = rc.statsd.Incr("reddit.api.errors", r.tags, 0.1)
+		return nil, nil, ErrResponseTooLarge
+	}
+
 	rli := &RateLimitingInfo{Present: false}
 	if resp.Header.Get(RateLimitRemainingHeader) != "" {
 		rli.Present = true
@@ -182,15 +256,80 @@ func (rc *Client) doRequest(ctx context.Context, r *Request, errmap map[int]erro
 	_ = rc.statsd.Incr("reddit.api.errors", r.tags, 0.1)
 	if err, ok := errmap[resp.StatusCode]; ok {
 		return nil, rli, err
-	} else {
-		return nil, rli, ServerError{string(bb), resp.StatusCode}
 	}
+	return nil, rli, rc.parseError(bb, resp.StatusCode)
+}
+
+// parseError turns a non-200 response body into a *Error when Reddit sent its usual
+// {message, error} JSON, and a ServerError carrying the raw body otherwise.
+func (rc *Client) parseError(bb []byte, status int) error {
+	parser := rc.pool.Get()
+	defer rc.pool.Put(parser)
+
+	val, err := parser.ParseBytes(bb)
+	if err != nil || val.Type() != fastjson.TypeObject || !val.Exists("message") {
+		return ServerError{string(bb), status}
+	}
+
+	return NewError(val, status)
+}
+
+// subredditError tells apart the ways looking up a subreddit can be refused. Reddit
+// answers 403 for private and quarantined subreddits and 404 for banned and missing
+// ones, with the reason in the body.
+func subredditError(err error) error {
+	reason := ""
+	var rerr *Error
+	if errors.As(err, &rerr) {
+		reason = rerr.Reason
+	}
+
+	switch statusCode(err) {
+	case 403:
+		if reason == "quarantined" {
+			return ErrSubredditIsQuarantined
+		}
+		return ErrSubredditIsPrivate
+	case 404:
+		if reason == "banned" {
+			return ErrSubredditBanned
+		}
+		return ErrSubredditNotFound
+	}
+
+	return err
+}
+
+// retryable reports whether a failed request might go through when tried again. Client
+// errors other than being throttled won't.
+func retryable(err error) bool {
+	if err == ErrOauthRevoked || err == ErrResponseTooLarge {
+		return false
+	}
+
+	status := statusCode(err)
+	return status < 400 || status >= 500
+}
+
+// statusCode returns the HTTP status a request failed with, if err carries one.
+func statusCode(err error) int {
+	var serr ServerError
+	if errors.As(err, &serr) {
+		return serr.StatusCode
+	}
+
+	var rerr *Error
+	if errors.As(err, &rerr) {
+		return rerr.StatusCode
+	}
+
+	return 0
 }
 
 func (rc *Client) request(ctx context.Context, r *Request, errmap map[int]error, rh ResponseHandler, empty interface{}) (interface{}, error) {
 	bb, _, err := rc.doRequest(ctx, r, errmap)
 
-	if err != nil && err != ErrOauthRevoked && r.retry {
+	if err != nil && retryable(err) && r.retry {
 		for _, backoff := range backoffSchedule {
 			done := make(chan struct{})
 
@@ -232,11 +371,11 @@ func (rc *Client) request(ctx context.Context, r *Request, errmap map[int]error,
 }
 
 func (rc *Client) subredditPosts(ctx context.Context, subreddit string, sort string, opts ...RequestOption) (*ListingResponse, error) {
-	url := fmt.Sprintf("https://oauth.reddit.com/r/%s/%s.json", subreddit, sort)
+	path := fmt.Sprintf("/r/%s/%s.json", subreddit, sort)
 	opts = append(rc.defaultOpts, opts...)
 	opts = append(opts, []RequestOption{
 		WithMethod("GET"),
-		WithURL(url),
+		WithOAuthPath(path),
 	}...)
 	req := NewRequest(opts...)
 
@@ -261,24 +400,16 @@ func (rc *Client) SubredditNew(ctx context.Context, subreddit string, opts ...Re
 }
 
 func (rc *Client) SubredditAbout(ctx context.Context, subreddit string, opts ...RequestOption) (*SubredditResponse, error) {
-	url := fmt.Sprintf("https://oauth.reddit.com/r/%s/about.json", subreddit)
+	path := fmt.Sprintf("/r/%s/about.json", subreddit)
 	opts = append(rc.defaultOpts, opts...)
 	opts = append(opts, []RequestOption{
 		WithMethod("GET"),
-		WithURL(url),
+		WithOAuthPath(path),
 	}...)
 	req := NewRequest(opts...)
-	srr, err := rc.request(ctx, req, defaultErrorMap, NewSubredditResponse, nil)
-
+	srr, err := rc.request(ctx, req, subredditErrorMap, NewSubredditResponse, nil)
 	if err != nil {
-		if err == ErrOauthRevoked {
-			return nil, ErrSubredditIsPrivate
-		} else if serr, ok := err.(ServerError); ok {
-			if serr.StatusCode == 404 {
-				return nil, ErrSubredditNotFound
-			}
-		}
-		return nil, err
+		return nil, subredditError(err)
 	}
 
 	sr := srr.(*SubredditResponse)
@@ -317,7 +448,7 @@ func (rac *AuthenticatedClient) request(ctx context.Context, r *Request, errmap
 
 	bb, rli, err := rac.client.doRequest(ctx, r, errmap)
 
-	if err != nil && err != ErrOauthRevoked && r.retry {
+	if err != nil && retryable(err) && r.retry {
 		for _, backoff := range backoffSchedule {
 			done := make(chan struct{})
 
@@ -434,7 +565,7 @@ func (rac *AuthenticatedClient) RefreshTokens(ctx context.Context, opts ...Reque
 	opts = append(opts, []RequestOption{
 		WithTags([]string{"url:/api/v1/access_token"}),
 		WithMethod("POST"),
-		WithURL("https://www.reddit.com/api/v1/access_token"),
+		WithPath("/api/v1/access_token"),
 		WithBody("grant_type", "refresh_token"),
 		WithBody("refresh_token", rac.refreshToken),
 		WithBasicAuth(rac.client.id, rac.client.secret),
@@ -459,7 +590,7 @@ func (rac *AuthenticatedClient) AboutInfo(ctx context.Context, fullname string,
 	opts = append(opts, []RequestOption{
 		WithMethod("GET"),
 		WithToken(rac.accessToken),
-		WithURL("https://oauth.reddit.com/api/info"),
+		WithOAuthPath("/api/info"),
 		WithQuery("id", fullname),
 	}...)
 	req := NewRequest(opts...)
@@ -472,13 +603,64 @@ func (rac *AuthenticatedClient) AboutInfo(ctx context.Context, fullname string,
 	return lr.(*ListingResponse), nil
 }
 
+// AboutInfoMany looks up fullnames through /api/info, AboutInfoBatchSize at a time, and
+// returns everything Reddit knew about in one listing.
+func (rac *AuthenticatedClient) AboutInfoMany(ctx context.Context, fullnames []string, opts ...RequestOption) (*ListingResponse, error) {
+	ret := &ListingResponse{}
+
+	for _, chunk := range chunkFullnames(fullnames, AboutInfoBatchSize) {
+		chunkOpts := append([]RequestOption{}, opts...)
+		chunkOpts = append(chunkOpts, WithQuery("limit", strconv.Itoa(len(chunk))))
+
+		lr, err := rac.AboutInfo(ctx, strings.Join(chunk, ","), chunkOpts...)
+		if err != nil {
+			return nil, err
+		}
+
+		ret.Count += lr.Count
+		ret.Children = append(ret.Children, lr.Children...)
+	}
+
+	return ret, nil
+}
+
+func chunkFullnames(fullnames []string, size int) [][]string {
+	var chunks [][]string
+	for len(fullnames) > size {
+		chunks = append(chunks, fullnames[:size])
+		fullnames = fullnames[size:]
+	}
+	if len(fullnames) > 0 {
+		chunks = append(chunks, fullnames)
+	}
+	return chunks
+}
+
 func (rac *AuthenticatedClient) UserPosts(ctx context.Context, user string, opts ...RequestOption) (*ListingResponse, error) {
-	url := fmt.Sprintf("https://oauth.reddit.com/u/%s/submitted", user)
+	path := fmt.Sprintf("/u/%s/submitted", user)
 	opts = append(rac.client.defaultOpts, opts...)
 	opts = append(opts, []RequestOption{
 		WithMethod("GET"),
 		WithToken(rac.accessToken),
-		WithURL(url),
+		WithOAuthPath(path),
+	}...)
+	req := NewRequest(opts...)
+
+	lr, err := rac.request(ctx, req, defaultErrorMap, NewListingResponse, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return lr.(*ListingResponse), nil
+}
+
+func (rac *AuthenticatedClient) UserComments(ctx context.Context, user string, opts ...RequestOption) (*ListingResponse, error) {
+	path := fmt.Sprintf("/u/%s/comments", user)
+	opts = append(rac.client.defaultOpts, opts...)
+	opts = append(opts, []RequestOption{
+		WithMethod("GET"),
+		WithToken(rac.accessToken),
+		WithOAuthPath(path),
 	}...)
 	req := NewRequest(opts...)
 
@@ -491,12 +673,12 @@ func (rac *AuthenticatedClient) UserPosts(ctx context.Context, user string, opts
 }
 
 func (rac *AuthenticatedClient) UserAbout(ctx context.Context, user string, opts ...RequestOption) (*UserResponse, error) {
-	url := fmt.Sprintf("https://oauth.reddit.com/u/%s/about", user)
+	path := fmt.Sprintf("/u/%s/about", user)
 	opts = append(rac.client.defaultOpts, opts...)
 	opts = append(opts, []RequestOption{
 		WithMethod("GET"),
 		WithToken(rac.accessToken),
-		WithURL(url),
+		WithOAuthPath(path),
 	}...)
 	req := NewRequest(opts...)
 	ur, err := rac.request(ctx, req, defaultErrorMap, NewUserResponse, nil)
@@ -509,28 +691,42 @@ func (rac *AuthenticatedClient) UserAbout(ctx context.Context, user string, opts
 
 }
 
-func (rac *AuthenticatedClient) SubredditAbout(ctx context.Context, subreddit string, opts ...RequestOption) (*SubredditResponse, error) {
-	url := fmt.Sprintf("https://oauth.reddit.com/r/%s/about", subreddit)
+func (rac *AuthenticatedClient) SubredditRules(ctx context.Context, subreddit string, opts ...RequestOption) (*SubredditRulesResponse, error) {
+	path := fmt.Sprintf("/r/%s/about/rules", subreddit)
 	opts = append(rac.client.defaultOpts, opts...)
 	opts = append(opts, []RequestOption{
+		WithTags([]string{"url:/r/about/rules"}),
 		WithMethod("GET"),
 		WithToken(rac.accessToken),
-		WithURL(url),
+		WithOAuthPath(path),
 	}...)
 	req := NewRequest(opts...)
-	srr, err := rac.request(ctx, req, defaultErrorMap, NewSubredditResponse, nil)
 
+	srr, err := rac.request(ctx, req, defaultErrorMap, NewSubredditRulesResponse, nil)
 	if err != nil {
 		if err == ErrOauthRevoked {
 			return nil, ErrSubredditIsPrivate
-		} else if serr, ok := err.(ServerError); ok {
-			if serr.StatusCode == 404 {
-				return nil, ErrSubredditNotFound
-			}
 		}
 		return nil, err
 	}
 
+	return srr.(*SubredditRulesResponse), nil
+}
+
+func (rac *AuthenticatedClient) SubredditAbout(ctx context.Context, subreddit string, opts ...RequestOption) (*SubredditResponse, error) {
+	path := fmt.Sprintf("/r/%s/about", subreddit)
+	opts = append(rac.client.defaultOpts, opts...)
+	opts = append(opts, []RequestOption{
+		WithMethod("GET"),
+		WithToken(rac.accessToken),
+		WithOAuthPath(path),
+	}...)
+	req := NewRequest(opts...)
+	srr, err := rac.request(ctx, req, subredditErrorMap, NewSubredditResponse, nil)
+	if err != nil {
+		return nil, subredditError(err)
+	}
+
 	sr := srr.(*SubredditResponse)
 	if sr.Quarantined {
 		return nil, ErrSubredditIsQuarantined
@@ -540,12 +736,12 @@ func (rac *AuthenticatedClient) SubredditAbout(ctx context.Context, subreddit st
 }
 
 func (rac *AuthenticatedClient) subredditPosts(ctx context.Context, subreddit string, sort string, opts ...RequestOption) (*ListingResponse, error) {
-	url := fmt.Sprintf("https://oauth.reddit.com/r/%s/%s", subreddit, sort)
+	path := fmt.Sprintf("/r/%s/%s", subreddit, sort)
 	opts = append(rac.client.defaultOpts, opts...)
 	opts = append(opts, []RequestOption{
 		WithMethod("GET"),
 		WithToken(rac.accessToken),
-		WithURL(url),
+		WithOAuthPath(path),
 	}...)
 	req := NewRequest(opts...)
 
@@ -557,6 +753,31 @@ func (rac *AuthenticatedClient) subredditPosts(ctx context.Context, subreddit st
 	return lr.(*ListingResponse), nil
 }
 
+// CachedSubredditPosts returns a subreddit listing from a short-lived cache shared by all
+// clients, only hitting Reddit on a miss. Since the cache is keyed by subreddit and sort
+// alone, everyone reading the same listing must pass the same options.
+func (rac *AuthenticatedClient) CachedSubredditPosts(ctx context.Context, subreddit string, sort string, opts ...RequestOption) (*ListingResponse, error) {
+	key := fmt.Sprintf("reddit:listing:%s:%s", strings.ToLower(subreddit), sort)
+	tags := []string{fmt.Sprintf("sort:%s", sort)}
+
+	cached := &ListingResponse{}
+	if err := rac.client.redis.Get(ctx, key).Scan(cached); err == nil {
+		_ = rac.client.statsd.Incr("reddit.listing_cache.hits", tags, 0.1)
+		return cached, nil
+	}
+
+	_ = rac.client.statsd.Incr("reddit.listing_cache.misses", tags, 0.1)
+
+	lr, err := rac.subredditPosts(ctx, subreddit, sort, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = rac.client.redis.Set(ctx, key, lr, SubredditListingCacheTTL).Err()
+
+	return lr, nil
+}
+
 func (rac *AuthenticatedClient) SubredditHot(ctx context.Context, subreddit string, opts ...RequestOption) (*ListingResponse, error) {
 	return rac.subredditPosts(ctx, subreddit, "hot", opts...)
 }
@@ -569,13 +790,37 @@ func (rac *AuthenticatedClient) SubredditNew(ctx context.Context, subreddit stri
 	return rac.subredditPosts(ctx, subreddit, "new", opts...)
 }
 
+// SubredditSearch runs a search restricted to a subreddit, newest first across all time
+// unless the "sort" and "t" queries are given as options.
+func (rac *AuthenticatedClient) SubredditSearch(ctx context.Context, subreddit, query string, opts ...RequestOption) (*ListingResponse, error) {
+	path := fmt.Sprintf("/r/%s/search", subreddit)
+	opts = append([]RequestOption{WithQuery("sort", "new"), WithQuery("t", "all")}, opts...)
+	opts = append(rac.client.defaultOpts, opts...)
+	opts = append(opts, []RequestOption{
+		WithTags([]string{"url:/r/search"}),
+		WithMethod("GET"),
+		WithToken(rac.accessToken),
+		WithOAuthPath(path),
+		WithQuery("q", query),
+		WithQuery("restrict_sr", "1"),
+	}...)
+	req := NewRequest(opts...)
+
+	lr, err := rac.request(ctx, req, defaultErrorMap, NewListingResponse, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return lr.(*ListingResponse), nil
+}
+
 func (rac *AuthenticatedClient) MessageInbox(ctx context.Context, opts ...RequestOption) (*ListingResponse, error) {
 	opts = append(rac.client.defaultOpts, opts...)
 	opts = append(opts, []RequestOption{
 		WithTags([]string{"url:/api/v1/message/inbox"}),
 		WithMethod("GET"),
 		WithToken(rac.accessToken),
-		WithURL("https://oauth.reddit.com/message/inbox"),
+		WithOAuthPath("/message/inbox"),
 		WithEmptyResponseBytes(122),
 	}...)
 	req := NewRequest(opts...)
@@ -593,7 +838,7 @@ func (rac *AuthenticatedClient) MessageUnread(ctx context.Context, opts ...Reque
 		WithTags([]string{"url:/api/v1/message/unread"}),
 		WithMethod("GET"),
 		WithToken(rac.accessToken),
-		WithURL("https://oauth.reddit.com/message/unread"),
+		WithOAuthPath("/message/unread"),
 		WithEmptyResponseBytes(122),
 	}...)
 
@@ -612,7 +857,7 @@ func (rac *AuthenticatedClient) Me(ctx context.Context, opts ...RequestOption) (
 		WithTags([]string{"url:/api/v1/me"}),
 		WithMethod("GET"),
 		WithToken(rac.accessToken),
-		WithURL("https://oauth.reddit.com/api/v1/me"),
+		WithOAuthPath("/api/v1/me"),
 	}...)
 
 	req := NewRequest(opts...)
@@ -623,24 +868,69 @@ func (rac *AuthenticatedClient) Me(ctx context.Context, opts ...RequestOption) (
 	return mr.(*MeResponse), nil
 }
 
+// SubmitComment posts a comment as a reply to the post or comment with the given
+// fullname (e.g. t3_abc123). Comments aren't retried so they can't get posted twice.
+func (rac *AuthenticatedClient) SubmitComment(ctx context.Context, parentFullname, text string, opts ...RequestOption) (*Thing, error) {
+	errmap := map[int]error{
+		401: ErrOauthRevoked,
+		403: ErrOauthRevoked,
+		429: ErrTooManyRequests,
+	}
+
+	opts = append(rac.client.defaultOpts, opts...)
+	opts = append(opts, []RequestOption{
+		WithTags([]string{"url:/api/comment"}),
+		WithMethod("POST"),
+		WithToken(rac.accessToken),
+		WithOAuthPath("/api/comment"),
+		WithBody("api_type", "json"),
+		WithBody("thing_id", parentFullname),
+		WithBody("text", text),
+		WithRetry(false),
+	}...)
+
+	req := NewRequest(opts...)
+	ret, err := rac.request(ctx, req, errmap, NewSubmitCommentResponse, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	scr := ret.(*SubmitCommentResponse)
+	for _, code := range scr.Errors {
+		if code == "RATELIMIT" {
+			return nil, ErrRateLimited
+		}
+	}
+
+	if len(scr.Errors) > 0 || scr.Thing == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCommentRejected, strings.Join(scr.Errors, ", "))
+	}
+	return scr.Thing, nil
+}
+
 func (rac *AuthenticatedClient) TopLevelComments(ctx context.Context, subreddit string, threadID string, opts ...RequestOption) (*ThreadResponse, error) {
-	url := fmt.Sprintf("https://oauth.reddit.com/r/%s/comments/%s/.json", subreddit, threadID)
+	path := fmt.Sprintf("/r/%s/comments/%s/.json", subreddit, threadID)
 
 	opts = append(rac.client.defaultOpts, opts...)
 	opts = append(opts, []RequestOption{
 		WithTags([]string{"url:/comments"}),
 		WithMethod("GET"),
 		WithToken(rac.accessToken),
-		WithURL(url),
+		WithOAuthPath(path),
 		WithQuery("sort", "new"),
 		WithQuery("limit", "100"),
 		WithQuery("depth", "1"),
 	}...)
 
 	req := NewRequest(opts...)
-	tr, err := rac.request(ctx, req, defaultErrorMap, NewThreadResponse, nil)
+	ret, err := rac.request(ctx, req, threadErrorMap, NewThreadResponse, nil)
 	if err != nil {
 		return nil, err
 	}
-	return tr.(*ThreadResponse), nil
+
+	tr := ret.(*ThreadResponse)
+	if tr.Gone() {
+		return nil, ErrThreadNotFound
+	}
+	return tr, nil
 }
@@ -1,12 +1,26 @@
 package reddit_test
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/DataDog/datadog-go/statsd"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/otel"
 
 	"github.com/christianselig/apollo-backend/internal/reddit"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
 )
 
 func TestAuthenticatedClientObfuscatedToken(t *testing.T) {
@@ -33,3 +47,581 @@ func TestAuthenticatedClientObfuscatedToken(t *testing.T) {
 		assert.Equal(t, tc.want, got)
 	}
 }
+
+func TestAuthenticatedClientCachedSubredditPosts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	rdb := testhelper.NewTestRedisClient(t)
+
+	subreddit := fmt.Sprintf("cachetest%d", time.Now().UnixNano())
+	key := fmt.Sprintf("reddit:listing:%s:hot", subreddit)
+	t.Cleanup(func() { _ = rdb.Del(ctx, key).Err() })
+
+	cached := &reddit.ListingResponse{
+		Count:    1,
+		Children: []*reddit.Thing{{Kind: "t3", ID: "abc123", Title: "cached post"}},
+	}
+	require.NoError(t, rdb.Set(ctx, key, cached, reddit.SubredditListingCacheTTL).Err())
+
+	ttl, err := rdb.TTL(ctx, key).Result()
+	require.NoError(t, err)
+	assert.LessOrEqual(t, ttl, reddit.SubredditListingCacheTTL)
+
+	// Rate limiting the client means any request that reaches Reddit would fail.
+	rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, rdb, 1)
+	rac := rc.NewAuthenticatedClient("<ID>", "<REFRESH>", "<ACCESS>")
+
+	for i := 0; i < 2; i++ {
+		lr, err := rac.CachedSubredditPosts(ctx, subreddit, "hot")
+		require.NoError(t, err)
+		require.Len(t, lr.Children, 1)
+		assert.Equal(t, "abc123", lr.Children[0].ID)
+	}
+}
+
+func TestThreadErrorMap(t *testing.T) {
+	t.Parallel()
+
+	tt := map[int]error{
+		401: reddit.ErrOauthRevoked,
+		403: reddit.ErrOauthRevoked,
+		404: reddit.ErrThreadNotFound,
+		429: reddit.ErrTooManyRequests,
+	}
+
+	for status, want := range tt {
+		assert.Equal(t, want, reddit.ThreadErrorMap[status], "status %d", status)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func NewTestHTTPClient(t *testing.T, status int, body string, handle func(*http.Request)) *http.Client {
+	t.Helper()
+
+	return &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			handle(req)
+
+			return &http.Response{
+				StatusCode: status,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		}),
+	}
+}
+
+func TestAuthenticatedClientSubmitComment(t *testing.T) {
+	t.Parallel()
+
+	bb, err := os.ReadFile("testdata/comment.json")
+	require.NoError(t, err)
+
+	rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1)
+	rac := rc.NewAuthenticatedClient("<ID>", "<REFRESH>", "<ACCESS>")
+
+	var sent *http.Request
+	var form url.Values
+	client := NewTestHTTPClient(t, 200, string(bb), func(req *http.Request) {
+		sent = req
+		body, _ := io.ReadAll(req.Body)
+		form, _ = url.ParseQuery(string(body))
+	})
+
+	comment, err := rac.SubmitComment(context.Background(), "t3_y4l1cs", "Replying from the Dynamic Island!", reddit.WithClient(client))
+	require.NoError(t, err)
+	assert.Equal(t, "iszq2a1", comment.ID)
+
+	assert.Equal(t, "POST", sent.Method)
+	assert.Equal(t, "https://oauth.reddit.com/api/comment", fmt.Sprintf("%s://%s%s", sent.URL.Scheme, sent.URL.Host, sent.URL.Path))
+	assert.Equal(t, "Bearer <ACCESS>", sent.Header.Get("Authorization"))
+	assert.Equal(t, "application/x-www-form-urlencoded", sent.Header.Get("Content-Type"))
+	assert.Equal(t, "json", form.Get("api_type"))
+	assert.Equal(t, "t3_y4l1cs", form.Get("thing_id"))
+	assert.Equal(t, "Replying from the Dynamic Island!", form.Get("text"))
+}
+
+func TestAuthenticatedClientSubmitCommentErrors(t *testing.T) {
+	t.Parallel()
+
+	rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1)
+	rac := rc.NewAuthenticatedClient("<ID>", "<REFRESH>", "<ACCESS>")
+
+	tt := map[string]struct {
+		status int
+		body   string
+		err    error
+	}{
+		"revoked":      {403, "", reddit.ErrOauthRevoked},
+		"throttled":    {429, "", reddit.ErrTooManyRequests},
+		"rate limited": {200, `{"json": {"errors": [["RATELIMIT", "you are doing that too much", "ratelimit"]]}}`, reddit.ErrRateLimited},
+		"locked":       {200, `{"json": {"errors": [["THREAD_LOCKED", "comments are locked", "parent"]]}}`, reddit.ErrCommentRejected},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			client := NewTestHTTPClient(t, tc.status, tc.body, func(*http.Request) {})
+			_, err := rac.SubmitComment(context.Background(), "t3_y4l1cs", "hello", reddit.WithClient(client))
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestAuthenticatedClientSubredditSearch(t *testing.T) {
+	t.Parallel()
+
+	bb, err := os.ReadFile("testdata/subreddit_new.json")
+	require.NoError(t, err)
+
+	rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1)
+	rac := rc.NewAuthenticatedClient("<ID>", "<REFRESH>", "<ACCESS>")
+
+	tt := map[string]struct {
+		opts []reddit.RequestOption
+		sort string
+		t    string
+	}{
+		"defaults":  {nil, "new", "all"},
+		"overrides": {[]reddit.RequestOption{reddit.WithQuery("sort", "top"), reddit.WithQuery("t", "week")}, "top", "week"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			var sent *http.Request
+			client := NewTestHTTPClient(t, 200, string(bb), func(req *http.Request) { sent = req })
+
+			opts := append([]reddit.RequestOption{reddit.WithClient(client)}, tc.opts...)
+			lr, err := rac.SubredditSearch(context.Background(), "DestinyTheGame", `title:"thorn" flair:question`, opts...)
+			require.NoError(t, err)
+			assert.Equal(t, 100, lr.Count)
+			assert.Equal(t, "Thorn damage profile", lr.Children[0].Title)
+
+			assert.Equal(t, "GET", sent.Method)
+			assert.Equal(t, "https://oauth.reddit.com/r/DestinyTheGame/search", fmt.Sprintf("%s://%s%s", sent.URL.Scheme, sent.URL.Host, sent.URL.Path))
+
+			query := sent.URL.Query()
+			assert.Equal(t, `title:"thorn" flair:question`, query.Get("q"))
+			assert.Equal(t, "1", query.Get("restrict_sr"))
+			assert.Equal(t, tc.sort, query.Get("sort"))
+			assert.Equal(t, tc.t, query.Get("t"))
+		})
+	}
+}
+
+func TestAuthenticatedClientMessageInboxBaseURL(t *testing.T) {
+	t.Parallel()
+
+	bb, err := os.ReadFile("testdata/message_inbox.json")
+	require.NoError(t, err)
+
+	var sent *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sent = req
+		_, _ = w.Write(bb)
+	}))
+	t.Cleanup(srv.Close)
+
+	rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1, reddit.WithOAuthBaseURL(srv.URL))
+	rac := rc.NewAuthenticatedClient("<ID>", "<REFRESH>", "<ACCESS>")
+
+	mi, err := rac.MessageInbox(context.Background(), reddit.WithQuery("limit", "1"))
+	require.NoError(t, err)
+	assert.Equal(t, 25, mi.Count)
+	assert.Equal(t, "t4_138z6ke", mi.Children[0].FullName())
+
+	assert.Equal(t, "GET", sent.Method)
+	assert.Equal(t, "/message/inbox", sent.URL.Path)
+	assert.Equal(t, "1", sent.URL.Query().Get("limit"))
+	assert.Equal(t, "1", sent.URL.Query().Get("raw_json"))
+	assert.Equal(t, "Bearer <ACCESS>", sent.Header.Get("Authorization"))
+}
+
+func TestAuthenticatedClientRequest(t *testing.T) {
+	t.Parallel()
+
+	inbox, err := os.ReadFile("testdata/message_inbox.json")
+	require.NoError(t, err)
+
+	type response struct {
+		status int
+		body   string
+	}
+
+	tt := map[string]struct {
+		responses []response
+		calls     int32
+		err       error
+		empty     bool
+	}{
+		"unauthorized":       {[]response{{401, ""}}, 1, reddit.ErrOauthRevoked, false},
+		"forbidden":          {[]response{{403, ""}}, 1, reddit.ErrOauthRevoked, false},
+		"server error retry": {[]response{{500, "oops"}, {200, string(inbox)}}, 2, nil, false},
+		"empty inbox":        {[]response{{200, strings.Repeat(" ", 122)}}, 1, nil, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				res := tc.responses[len(tc.responses)-1]
+				if int(n) <= len(tc.responses) {
+					res = tc.responses[n-1]
+				}
+
+				w.WriteHeader(res.status)
+				_, _ = w.Write([]byte(res.body))
+			}))
+			t.Cleanup(srv.Close)
+
+			rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1, reddit.WithOAuthBaseURL(srv.URL))
+			rac := rc.NewAuthenticatedClient(reddit.SkipRateLimiting, "<REFRESH>", "<ACCESS>")
+
+			mi, err := rac.MessageInbox(context.Background())
+			assert.Equal(t, tc.calls, atomic.LoadInt32(&calls))
+
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			if tc.empty {
+				assert.Same(t, reddit.EmptyListingResponse, mi)
+				return
+			}
+			assert.Equal(t, 25, mi.Count)
+		})
+	}
+}
+
+func TestClientRateLimitingInfo(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		headers map[string]string
+		want    reddit.RateLimitingInfo
+	}{
+		"present": {
+			map[string]string{
+				reddit.RateLimitRemainingHeader: "598.0",
+				reddit.RateLimitUsedHeader:      "2",
+				reddit.RateLimitResetHeader:     "341",
+			},
+			reddit.RateLimitingInfo{Present: true, Remaining: 598, Used: 2, Reset: 341},
+		},
+		"missing": {
+			map[string]string{},
+			reddit.RateLimitingInfo{Present: false},
+		},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				for k, v := range tc.headers {
+					w.Header().Set(k, v)
+				}
+				_, _ = w.Write([]byte("{}"))
+			}))
+			t.Cleanup(srv.Close)
+
+			rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1)
+			req := reddit.NewRequest(reddit.WithOAuthBaseURL(srv.URL), reddit.WithOAuthPath("/api/v1/me"))
+
+			_, rli, err := rc.DoRequest(context.Background(), req, nil)
+			require.NoError(t, err)
+
+			rli.Timestamp = ""
+			assert.Equal(t, tc.want, *rli)
+		})
+	}
+}
+
+func TestClientErrorBodies(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		status int
+		body   string
+		want   error
+	}{
+		"json":       {500, `{"message": "Internal Server Error", "error": 500}`, &reddit.Error{Message: "Internal Server Error", Code: 500, StatusCode: 500}},
+		"html":       {502, "<html><body>Bad Gateway</body></html>", reddit.ServerError{Body: "<html><body>Bad Gateway</body></html>", StatusCode: 502}},
+		"empty":      {503, "", reddit.ServerError{Body: "", StatusCode: 503}},
+		"other json": {500, `{"reason": "nope"}`, reddit.ServerError{Body: `{"reason": "nope"}`, StatusCode: 500}},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			t.Cleanup(srv.Close)
+
+			rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1)
+			req := reddit.NewRequest(reddit.WithOAuthBaseURL(srv.URL), reddit.WithOAuthPath("/api/v1/me"))
+
+			_, _, err := rc.DoRequest(context.Background(), req, map[int]error{})
+			assert.Equal(t, tc.want, err)
+		})
+	}
+}
+
+func TestClientRequestTimeout(t *testing.T) {
+	t.Parallel()
+
+	// Sends the headers and the start of the body straight away, then stalls.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(`{"kind": "Listing", `))
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+
+	rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1)
+	req := reddit.NewRequest(
+		reddit.WithOAuthBaseURL(srv.URL),
+		reddit.WithOAuthPath("/r/pics/new"),
+		reddit.WithClient(&http.Client{}),
+		reddit.WithTimeout(50*time.Millisecond),
+	)
+
+	start := time.Now()
+	bb, _, err := rc.DoRequest(context.Background(), req, nil)
+	assert.Equal(t, reddit.ErrTimeout, err)
+	assert.Nil(t, bb)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestClientResponseSizeLimit(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		size int
+		err  error
+	}{
+		"at the limit":   {reddit.MaxResponseBytes, nil},
+		"over the limit": {reddit.MaxResponseBytes + 1, reddit.ErrResponseTooLarge},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			body := strings.Repeat("a", tc.size)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				_, _ = io.WriteString(w, body)
+			}))
+			t.Cleanup(srv.Close)
+
+			rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1)
+			req := reddit.NewRequest(reddit.WithOAuthBaseURL(srv.URL), reddit.WithOAuthPath("/r/pics/new"))
+
+			bb, _, err := rc.DoRequest(context.Background(), req, nil)
+			assert.Equal(t, tc.err, err)
+			if tc.err == nil {
+				assert.Len(t, bb, tc.size)
+			}
+		})
+	}
+}
+
+func TestClientTimeoutReadingBody(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(`{"kind": "Listing", `))
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+
+	// Only the HTTP client's timeout, which hits while the body is still coming in.
+	rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1)
+	req := reddit.NewRequest(
+		reddit.WithOAuthBaseURL(srv.URL),
+		reddit.WithOAuthPath("/r/pics/new"),
+		reddit.WithClient(&http.Client{Timeout: 50 * time.Millisecond}),
+	)
+
+	bb, _, err := rc.DoRequest(context.Background(), req, nil)
+	assert.Equal(t, reddit.ErrTimeout, err)
+	assert.Nil(t, bb)
+}
+
+func TestNewTransport(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		connLimit int
+		want      int
+	}{
+		"api":      {16, 16},
+		"busy":     {512, 512},
+		"single":   {1, 1},
+		"unset":    {0, 100},
+		"negative": {-1, 100},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			tr := reddit.NewTransport(tc.connLimit)
+			assert.Equal(t, tc.want, tr.MaxIdleConns)
+			assert.Equal(t, tc.want, tr.MaxIdleConnsPerHost)
+			assert.Zero(t, tr.MaxConnsPerHost)
+		})
+	}
+}
+
+func TestChunkFullnames(t *testing.T) {
+	t.Parallel()
+
+	fullnames := func(n int) []string {
+		ids := make([]string, n)
+		for i := range ids {
+			ids[i] = fmt.Sprintf("t3_%d", i)
+		}
+		return ids
+	}
+
+	tt := map[string]struct {
+		count int
+		sizes []int
+	}{
+		"none":           {0, nil},
+		"one":            {1, []int{1}},
+		"exactly full":   {100, []int{100}},
+		"one over":       {101, []int{100, 1}},
+		"several chunks": {250, []int{100, 100, 50}},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			ids := fullnames(tc.count)
+			chunks := reddit.ChunkFullnames(ids, reddit.AboutInfoBatchSize)
+
+			var sizes []int
+			var flattened []string
+			for _, chunk := range chunks {
+				sizes = append(sizes, len(chunk))
+				flattened = append(flattened, chunk...)
+			}
+
+			assert.Equal(t, tc.sizes, sizes)
+			if tc.count > 0 {
+				assert.Equal(t, ids, flattened)
+			}
+		})
+	}
+}
+
+func TestAuthenticatedClientAboutInfoMany(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requested [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ids := strings.Split(req.URL.Query().Get("id"), ",")
+
+		mu.Lock()
+		requested = append(requested, ids)
+		mu.Unlock()
+
+		children := make([]string, len(ids))
+		for i, id := range ids {
+			children[i] = fmt.Sprintf(`{"kind": "t3", "data": {"id": %q}}`, strings.TrimPrefix(id, "t3_"))
+		}
+		_, _ = fmt.Fprintf(w, `{"kind": "Listing", "data": {"children": [%s]}}`, strings.Join(children, ","))
+	}))
+	t.Cleanup(srv.Close)
+
+	rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1, reddit.WithOAuthBaseURL(srv.URL))
+	rac := rc.NewAuthenticatedClient(reddit.SkipRateLimiting, "<REFRESH>", "<ACCESS>")
+
+	ids := make([]string, 150)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("t3_%d", i)
+	}
+
+	lr, err := rac.AboutInfoMany(context.Background(), ids)
+	require.NoError(t, err)
+
+	require.Len(t, requested, 2)
+	assert.Len(t, requested[0], 100)
+	assert.Len(t, requested[1], 50)
+
+	assert.Equal(t, 150, lr.Count)
+	assert.Equal(t, "t3_0", lr.Children[0].FullName())
+	assert.Equal(t, "t3_149", lr.Children[149].FullName())
+}
+
+func TestAuthenticatedClientSubredditAboutErrors(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		status int
+		body   string
+		err    error
+	}{
+		"revoked":     {401, "", reddit.ErrOauthRevoked},
+		"private":     {403, `{"reason": "private", "message": "Forbidden", "error": 403}`, reddit.ErrSubredditIsPrivate},
+		"quarantined": {403, `{"reason": "quarantined", "message": "Forbidden", "error": 403}`, reddit.ErrSubredditIsQuarantined},
+		"banned":      {404, `{"reason": "banned", "message": "Not Found", "error": 404}`, reddit.ErrSubredditBanned},
+		"not found":   {404, `{"message": "Not Found", "error": 404}`, reddit.ErrSubredditNotFound},
+		"html 404":    {404, "<html>Not Found</html>", reddit.ErrSubredditNotFound},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			t.Cleanup(srv.Close)
+
+			rc := reddit.NewClient("<SECRET>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1, reddit.WithOAuthBaseURL(srv.URL))
+			rac := rc.NewAuthenticatedClient(reddit.SkipRateLimiting, "<REFRESH>", "<ACCESS>")
+
+			_, err := rac.SubredditAbout(context.Background(), "pics")
+			assert.ErrorIs(t, err, tc.err)
+
+			// None of these get any better by asking again.
+			assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+		})
+	}
+}
@@ -31,6 +31,16 @@ var (
 	ErrSubredditIsQuarantined = errors.New("subreddit is quarantined")
 	// ErrSubredditNotFound .
 	ErrSubredditNotFound = errors.New("subreddit not found")
+	// ErrSubredditBanned .
+	ErrSubredditBanned = errors.New("subreddit is banned")
+	// ErrThreadNotFound .
+	ErrThreadNotFound = errors.New("thread not found")
 	// ErrTooManyRequests .
 	ErrTooManyRequests = errors.New("too many requests")
+	// ErrCommentRejected .
+	ErrCommentRejected = errors.New("comment rejected")
+	// ErrMissingScopes .
+	ErrMissingScopes = errors.New("missing required scopes")
+	// ErrResponseTooLarge is returned for response bodies over MaxResponseBytes.
+	ErrResponseTooLarge = errors.New("response too large")
 )
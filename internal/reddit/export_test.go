@@ -0,0 +1,14 @@
+package reddit
+
+import "context"
+
+var (
+	ThreadErrorMap = threadErrorMap
+	ChunkFullnames = chunkFullnames
+	NewTransport   = newTransport
+)
+
+// DoRequest performs a single request through the client, without retries.
+func (rc *Client) DoRequest(ctx context.Context, r *Request, errmap map[int]error) ([]byte, *RateLimitingInfo, error) {
+	return rc.doRequest(ctx, r, errmap)
+}
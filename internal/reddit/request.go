@@ -7,9 +7,15 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
-const userAgent = "server:apollo-backend:v1.0 (by /u/iamthatis) contact me@christianselig.com"
+const (
+	userAgent = "server:apollo-backend:v1.0 (by /u/iamthatis) contact me@christianselig.com"
+
+	DefaultBaseURL      = "https://www.reddit.com"
+	DefaultOAuthBaseURL = "https://oauth.reddit.com"
+)
 
 type Request struct {
 	body               url.Values
@@ -17,11 +23,16 @@ type Request struct {
 	method             string
 	token              string
 	url                string
+	path               string
+	oauth              bool
+	baseURL            string
+	oauthBaseURL       string
 	auth               string
 	tags               []string
 	emptyResponseBytes int
 	retry              bool
 	client             *http.Client
+	timeout            time.Duration
 }
 
 type RequestOption func(*Request)
@@ -33,6 +44,9 @@ func NewRequest(opts ...RequestOption) *Request {
 		method: "GET",
 		url:    "",
 
+		baseURL:      DefaultBaseURL,
+		oauthBaseURL: DefaultOAuthBaseURL,
+
 		token: "",
 		auth:  "",
 
@@ -41,6 +55,7 @@ func NewRequest(opts ...RequestOption) *Request {
 		emptyResponseBytes: 0,
 		retry:              true,
 		client:             nil,
+		timeout:            0,
 	}
 
 	req.query.Set("raw_json", "1")
@@ -53,12 +68,19 @@ func NewRequest(opts ...RequestOption) *Request {
 }
 
 func (r *Request) HTTPRequest(ctx context.Context) (*http.Request, error) {
-	req, err := http.NewRequestWithContext(ctx, r.method, r.url, strings.NewReader(r.body.Encode()))
+	req, err := http.NewRequestWithContext(ctx, r.method, r.URL(), strings.NewReader(r.body.Encode()))
+	if err != nil {
+		return nil, err
+	}
 	req.URL.RawQuery = r.query.Encode()
 
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("User-Agent", userAgent)
 
+	if len(r.body) > 0 {
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+
 	if r.token != "" {
 		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", r.token))
 	}
@@ -67,7 +89,20 @@ func (r *Request) HTTPRequest(ctx context.Context) (*http.Request, error) {
 		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", r.auth))
 	}
 
-	return req, err
+	return req, nil
+}
+
+// URL returns the URL the request goes to, resolving a path against whichever base URL
+// it was set up for.
+func (r *Request) URL() string {
+	if r.path == "" {
+		return r.url
+	}
+
+	if r.oauth {
+		return r.oauthBaseURL + r.path
+	}
+	return r.baseURL + r.path
 }
 
 func WithTags(tags []string) RequestOption {
@@ -85,6 +120,40 @@ func WithMethod(method string) RequestOption {
 func WithURL(url string) RequestOption {
 	return func(req *Request) {
 		req.url = url
+		req.path = ""
+	}
+}
+
+// WithPath points the request at path on the base URL.
+func WithPath(path string) RequestOption {
+	return func(req *Request) {
+		req.url = ""
+		req.path = path
+		req.oauth = false
+	}
+}
+
+// WithOAuthPath points the request at path on the OAuth base URL.
+func WithOAuthPath(path string) RequestOption {
+	return func(req *Request) {
+		req.url = ""
+		req.path = path
+		req.oauth = true
+	}
+}
+
+// WithBaseURL replaces https://www.reddit.com for requests built with WithPath.
+func WithBaseURL(baseURL string) RequestOption {
+	return func(req *Request) {
+		req.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithOAuthBaseURL replaces https://oauth.reddit.com for requests built with
+// WithOAuthPath.
+func WithOAuthBaseURL(baseURL string) RequestOption {
+	return func(req *Request) {
+		req.oauthBaseURL = strings.TrimSuffix(baseURL, "/")
 	}
 }
 
@@ -134,3 +203,11 @@ func WithClient(client *http.Client) RequestOption {
 		req.client = client
 	}
 }
+
+// WithTimeout gives up on each attempt at the request after d, including reading the
+// response body. Zero means only the HTTP client's own timeout applies.
+func WithTimeout(d time.Duration) RequestOption {
+	return func(req *Request) {
+		req.timeout = d
+	}
+}
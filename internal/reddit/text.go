@@ -0,0 +1,25 @@
+package reddit
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	markdownLink  = regexp.MustCompile(`\[([^\[\]]+)\]\([^()\s]+\)`)
+	markdownQuote = regexp.MustCompile(`(?m)^[ \t]*(?:>[ \t]?)+`)
+
+	markdownEmphasis = strings.NewReplacer("**", "", "~~", "")
+)
+
+// PlainText turns a body as Reddit returns it into something that reads well outside
+// of a markdown renderer, like a notification banner. It unescapes HTML entities and
+// flattens links, bold, strikethrough and quotes, leaving anything else as written.
+func PlainText(s string) string {
+	s = html.UnescapeString(s)
+	s = markdownLink.ReplaceAllString(s, "$1")
+	s = markdownQuote.ReplaceAllString(s, "")
+	s = markdownEmphasis.Replace(s)
+	return strings.TrimSpace(s)
+}
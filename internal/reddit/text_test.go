@@ -0,0 +1,42 @@
+package reddit_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+func TestPlainText(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		in   string
+		want string
+	}{
+		"plain":             {"They don't even go here.", "They don't even go here."},
+		"entities":          {"Fish &amp; chips &lt;3", "Fish & chips <3"},
+		"numeric entities":  {"it&#39;s fine", "it's fine"},
+		"link":              {"Try [Real Fishing VR](https://www.oculus.com/experiences/quest/2582932495064035).", "Try Real Fishing VR."},
+		"bare url":          {"See https://reddit.com/r/pics", "See https://reddit.com/r/pics"},
+		"bold":              {"**Welcome to r/GriefSupport!**", "Welcome to r/GriefSupport!"},
+		"strikethrough":     {"~~wrong~~ right", "wrong right"},
+		"escaped quote":     {"&gt; you said this\n\nI disagree", "you said this\n\nI disagree"},
+		"nested quote":      {">> deep\n> shallow\nreply", "deep\nshallow\nreply"},
+		"greater than":      {"5 &gt; 3", "5 > 3"},
+		"single asterisks":  {"2*3*4 = 24", "2*3*4 = 24"},
+		"underscores":       {"call __init__ first", "call __init__ first"},
+		"brackets, no link": {"[serious] question", "[serious] question"},
+		"surrounding space": {"\n  hello  \n", "hello"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, reddit.PlainText(tc.in))
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package reddit
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -13,6 +14,7 @@ type ResponseHandler func(*fastjson.Value) interface{}
 type Error struct {
 	Message    string `json:"message"`
 	Code       int    `json:"error"`
+	Reason     string `json:"reason"`
 	StatusCode int
 }
 
@@ -25,6 +27,8 @@ func NewError(val *fastjson.Value, status int) *Error {
 
 	err.Message = string(val.GetStringBytes("message"))
 	err.Code = val.GetInt("error")
+	err.Reason = string(val.GetStringBytes("reason"))
+	err.StatusCode = status
 
 	return err
 }
@@ -33,6 +37,7 @@ type RefreshTokenResponse struct {
 	AccessToken  string        `json:"access_token"`
 	RefreshToken string        `json:"refresh_token"`
 	Expiry       time.Duration `json:"expires_in"`
+	Scopes       []string      `json:"scope"`
 }
 
 func NewRefreshTokenResponse(val *fastjson.Value) interface{} {
@@ -41,10 +46,31 @@ func NewRefreshTokenResponse(val *fastjson.Value) interface{} {
 	rtr.AccessToken = string(val.GetStringBytes("access_token"))
 	rtr.RefreshToken = string(val.GetStringBytes("refresh_token"))
 	rtr.Expiry = time.Duration(val.GetInt("expires_in")) * time.Second
+	rtr.Scopes = strings.Fields(string(val.GetStringBytes("scope")))
 
 	return rtr
 }
 
+// MissingScopes returns the scopes in required that weren't granted to the token, in
+// the order they were asked for. A token granted "*" has every scope.
+func (rtr *RefreshTokenResponse) MissingScopes(required []string) []string {
+	granted := make(map[string]struct{}, len(rtr.Scopes))
+	for _, scope := range rtr.Scopes {
+		if scope == "*" {
+			return nil
+		}
+		granted[scope] = struct{}{}
+	}
+
+	var missing []string
+	for _, scope := range required {
+		if _, ok := granted[scope]; !ok {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
 type MeResponse struct {
 	ID   string `json:"id"`
 	Name string
@@ -63,6 +89,25 @@ func NewMeResponse(val *fastjson.Value) interface{} {
 	return mr
 }
 
+type SubmitCommentResponse struct {
+	Errors []string
+	Thing  *Thing
+}
+
+func NewSubmitCommentResponse(val *fastjson.Value) interface{} {
+	scr := &SubmitCommentResponse{}
+
+	// Errors come as [code, message, field] triplets
+	for _, e := range val.GetArray("json", "errors") {
+		scr.Errors = append(scr.Errors, string(e.GetStringBytes("0")))
+	}
+
+	if things := val.GetArray("json", "data", "things"); len(things) > 0 {
+		scr.Thing = NewThing(things[0])
+	}
+	return scr
+}
+
 type ThreadResponse struct {
 	Post     *Thing
 	Children []*Thing
@@ -86,6 +131,12 @@ func NewThreadResponse(val *fastjson.Value) interface{} {
 	return t
 }
 
+// Gone reports whether the thread was removed, deleted or locked, meaning it won't
+// be getting any new comments.
+func (tr *ThreadResponse) Gone() bool {
+	return tr.Post.Locked || tr.Post.RemovedBy != ""
+}
+
 type Thing struct {
 	Kind          string    `json:"kind"`
 	ID            string    `json:"id"`
@@ -96,6 +147,7 @@ type Thing struct {
 	CreatedAt     time.Time `json:"created_utc"`
 	Context       string    `json:"context"`
 	ParentID      string    `json:"parent_id"`
+	LinkID        string    `json:"link_id"`
 	LinkTitle     string    `json:"link_title"`
 	Destination   string    `json:"dest"`
 	Subreddit     string    `json:"subreddit"`
@@ -108,6 +160,9 @@ type Thing struct {
 	Thumbnail     string    `json:"thumbnail"`
 	Over18        bool      `json:"over_18"`
 	NumComments   int       `json:"num_comments"`
+	Locked        bool      `json:"locked"`
+	RemovedBy     string    `json:"removed_by_category"`
+	IsCrosspost   bool      `json:"is_crosspost"`
 }
 
 func (t *Thing) FullName() string {
@@ -134,6 +189,7 @@ func NewThing(val *fastjson.Value) *Thing {
 	t.CreatedAt = time.Unix(unix, 0).UTC()
 	t.Context = string(data.GetStringBytes("context"))
 	t.ParentID = string(data.GetStringBytes("parent_id"))
+	t.LinkID = string(data.GetStringBytes("link_id"))
 	t.LinkTitle = string(data.GetStringBytes("link_title"))
 	t.Destination = string(data.GetStringBytes("dest"))
 	t.Subreddit = string(data.GetStringBytes("subreddit"))
@@ -147,6 +203,9 @@ func NewThing(val *fastjson.Value) *Thing {
 	t.Thumbnail = string(data.GetStringBytes("thumbnail"))
 	t.Over18 = data.GetBool("over_18")
 	t.NumComments = data.GetInt("num_comments")
+	t.Locked = data.GetBool("locked")
+	t.RemovedBy = string(data.GetStringBytes("removed_by_category"))
+	t.IsCrosspost = len(data.GetArray("crosspost_parent_list")) > 0
 
 	return t
 }
@@ -158,6 +217,15 @@ type ListingResponse struct {
 	Before   string
 }
 
+// MarshalBinary lets a listing be stored in Redis as is.
+func (lr *ListingResponse) MarshalBinary() ([]byte, error) {
+	return json.Marshal(lr)
+}
+
+func (lr *ListingResponse) UnmarshalBinary(bb []byte) error {
+	return json.Unmarshal(bb, lr)
+}
+
 func NewListingResponse(val *fastjson.Value) interface{} {
 	lr := &ListingResponse{}
 
@@ -183,9 +251,11 @@ func NewListingResponse(val *fastjson.Value) interface{} {
 type SubredditResponse struct {
 	Thing
 
-	Name        string
-	Quarantined bool
-	Public      bool
+	Name           string
+	Quarantined    bool
+	Public         bool
+	Restricted     bool
+	SubmissionType string
 }
 
 func NewSubredditResponse(val *fastjson.Value) interface{} {
@@ -200,9 +270,61 @@ func NewSubredditResponse(val *fastjson.Value) interface{} {
 
 	sr_type := string(data.GetStringBytes("subreddit_type"))
 	sr.Public = sr_type == "public" || sr_type == "restricted" || sr_type == "archived"
+	sr.Restricted = sr_type == "restricted"
+	sr.SubmissionType = string(data.GetStringBytes("submission_type"))
 	return sr
 }
 
+// AllowsPosts reports whether anyone can submit posts for watchers to match, as opposed
+// to only approved users.
+func (sr *SubredditResponse) AllowsPosts() bool {
+	return !sr.Restricted
+}
+
+type SubredditRule struct {
+	Kind            string
+	ShortName       string
+	Description     string
+	ViolationReason string
+	Priority        int
+}
+
+// AppliesToPosts reports whether the rule covers posts rather than only comments.
+func (r SubredditRule) AppliesToPosts() bool {
+	return r.Kind == "link" || r.Kind == "all"
+}
+
+type SubredditRulesResponse struct {
+	Rules []SubredditRule
+}
+
+// PostRules returns the rules that cover posts, in the subreddit's order.
+func (srr *SubredditRulesResponse) PostRules() []SubredditRule {
+	var rules []SubredditRule
+	for _, rule := range srr.Rules {
+		if rule.AppliesToPosts() {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func NewSubredditRulesResponse(val *fastjson.Value) interface{} {
+	srr := &SubredditRulesResponse{}
+
+	for _, r := range val.GetArray("rules") {
+		srr.Rules = append(srr.Rules, SubredditRule{
+			Kind:            string(r.GetStringBytes("kind")),
+			ShortName:       string(r.GetStringBytes("short_name")),
+			Description:     string(r.GetStringBytes("description")),
+			ViolationReason: string(r.GetStringBytes("violation_reason")),
+			Priority:        r.GetInt("priority"),
+		})
+	}
+
+	return srr
+}
+
 type UserResponse struct {
 	Thing
 
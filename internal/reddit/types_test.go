@@ -60,6 +60,15 @@ func TestRefreshTokenResponseParsing(t *testing.T) {
 	assert.Equal(t, "xxx", rtr.AccessToken)
 	assert.Equal(t, "yyy", rtr.RefreshToken)
 	assert.Equal(t, 1*time.Hour, rtr.Expiry)
+	assert.Contains(t, rtr.Scopes, "privatemessages")
+	assert.Len(t, rtr.Scopes, 28)
+	assert.Empty(t, rtr.MissingScopes(reddit.RequiredScopes))
+
+	rtr.Scopes = []string{"identity", "read"}
+	assert.Equal(t, []string{"history", "privatemessages"}, rtr.MissingScopes(reddit.RequiredScopes))
+
+	rtr.Scopes = []string{"*"}
+	assert.Empty(t, rtr.MissingScopes(reddit.RequiredScopes))
 }
 
 func TestListingResponseParsing(t *testing.T) {
@@ -139,6 +148,40 @@ func TestSubredditResponseParsing(t *testing.T) {
 	assert.Equal(t, "DestinyTheGame", s.Name)
 	assert.Equal(t, false, s.Quarantined)
 	assert.Equal(t, true, s.Public)
+	assert.Equal(t, false, s.Restricted)
+	assert.Equal(t, "self", s.SubmissionType)
+	assert.True(t, s.AllowsPosts())
+}
+
+func TestSubredditRulesResponseParsing(t *testing.T) {
+	t.Parallel()
+
+	bb, err := ioutil.ReadFile("testdata/subreddit_rules.json")
+	assert.NoError(t, err)
+
+	parser := NewTestParser(t)
+	val, err := parser.ParseBytes(bb)
+	assert.NoError(t, err)
+
+	ret := reddit.NewSubredditRulesResponse(val)
+	srr := ret.(*reddit.SubredditRulesResponse)
+	assert.NotNil(t, srr)
+
+	assert.Len(t, srr.Rules, 3)
+	assert.Equal(t, reddit.SubredditRule{
+		Kind:            "link",
+		ShortName:       "Posts must be about Apollo",
+		Description:     "Posts must be about Apollo. Posts about other apps belong in their own subreddits.",
+		ViolationReason: "Off-topic",
+		Priority:        0,
+	}, srr.Rules[0])
+	assert.Equal(t, 2, srr.Rules[2].Priority)
+
+	var names []string
+	for _, rule := range srr.PostRules() {
+		names = append(names, rule.ShortName)
+	}
+	assert.Equal(t, []string{"Posts must be about Apollo", "Be civil"}, names)
 }
 
 func TestUserResponseParsing(t *testing.T) {
@@ -180,6 +223,64 @@ func TestUserPostsParsing(t *testing.T) {
 	assert.Equal(t, "public", post.SubredditType)
 }
 
+func TestUserCommentsParsing(t *testing.T) {
+	t.Parallel()
+
+	bb, err := ioutil.ReadFile("testdata/user_comments.json")
+	assert.NoError(t, err)
+
+	parser := NewTestParser(t)
+	val, err := parser.ParseBytes(bb)
+	assert.NoError(t, err)
+
+	ret := reddit.NewListingResponse(val)
+	cs := ret.(*reddit.ListingResponse)
+	assert.NotNil(t, cs)
+	assert.Equal(t, 2, cs.Count)
+
+	comment := cs.Children[0]
+
+	assert.Equal(t, "t1", comment.Kind)
+	assert.Equal(t, "iszq2a1", comment.ID)
+	assert.Equal(t, "changelog", comment.Author)
+	assert.Equal(t, "Replying from the Dynamic Island!", comment.Body)
+	assert.Equal(t, "t3_y4l1cs", comment.LinkID)
+	assert.Equal(t, "Apollo 1.15 is out with Live Activities", comment.LinkTitle)
+	assert.Equal(t, "apolloapp", comment.Subreddit)
+	assert.Equal(t, "public", comment.SubredditType)
+	assert.Equal(t, time.Unix(1665849600, 0).UTC(), comment.CreatedAt)
+
+	assert.Equal(t, "restricted", cs.Children[1].SubredditType)
+}
+
+func TestCrosspostParsing(t *testing.T) {
+	t.Parallel()
+
+	bb, err := ioutil.ReadFile("testdata/subreddit_crossposts.json")
+	assert.NoError(t, err)
+
+	parser := NewTestParser(t)
+	val, err := parser.ParseBytes(bb)
+	assert.NoError(t, err)
+
+	lr := reddit.NewListingResponse(val).(*reddit.ListingResponse)
+	assert.Equal(t, 3, lr.Count)
+
+	tt := []struct {
+		crosspost bool
+		nsfw      bool
+	}{
+		{false, false},
+		{true, false},
+		{true, true},
+	}
+
+	for i, tc := range tt {
+		assert.Equal(t, tc.crosspost, lr.Children[i].IsCrosspost, lr.Children[i].ID)
+		assert.Equal(t, tc.nsfw, lr.Children[i].Over18, lr.Children[i].ID)
+	}
+}
+
 func TestThreadResponseParsing(t *testing.T) {
 	t.Parallel()
 
@@ -199,6 +300,7 @@ func TestThreadResponseParsing(t *testing.T) {
 
 	assert.Equal(t, "The Deck is a lot more portable than the Pi though.", tr.Children[0].Body)
 	assert.Equal(t, "PhonicUK", tr.Children[1].Author)
+	assert.False(t, tr.Gone())
 }
 
 func TestEmptyThreadResponseParsing(t *testing.T) {
@@ -217,4 +319,64 @@ func TestEmptyThreadResponseParsing(t *testing.T) {
 
 	assert.Equal(t, "So many knives… so little time.", tr.Post.Title)
 	assert.Equal(t, 0, len(tr.Children))
+
+	// This thread was removed by reddit.
+	assert.Equal(t, "reddit", tr.Post.RemovedBy)
+	assert.True(t, tr.Gone())
+}
+
+func TestSubmitCommentResponseParsing(t *testing.T) {
+	t.Parallel()
+
+	bb, err := ioutil.ReadFile("testdata/comment.json")
+	assert.NoError(t, err)
+
+	parser := NewTestParser(t)
+	val, err := parser.ParseBytes(bb)
+	assert.NoError(t, err)
+
+	ret := reddit.NewSubmitCommentResponse(val)
+	scr := ret.(*reddit.SubmitCommentResponse)
+	assert.NotNil(t, scr)
+
+	assert.Empty(t, scr.Errors)
+	assert.Equal(t, "t1", scr.Thing.Kind)
+	assert.Equal(t, "iszq2a1", scr.Thing.ID)
+	assert.Equal(t, "t3_y4l1cs", scr.Thing.ParentID)
+	assert.Equal(t, "Replying from the Dynamic Island!", scr.Thing.Body)
+
+	val, err = parser.Parse(`{"json": {"errors": [["RATELIMIT", "you are doing that too much. try again in 5 minutes.", "ratelimit"]]}}`)
+	assert.NoError(t, err)
+
+	scr = reddit.NewSubmitCommentResponse(val).(*reddit.SubmitCommentResponse)
+	assert.Equal(t, []string{"RATELIMIT"}, scr.Errors)
+	assert.Nil(t, scr.Thing)
+}
+
+func TestListingResponseBinaryRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	bb, err := ioutil.ReadFile("testdata/subreddit_new.json")
+	assert.NoError(t, err)
+
+	parser := NewTestParser(t)
+	val, err := parser.ParseBytes(bb)
+	assert.NoError(t, err)
+
+	lr := reddit.NewListingResponse(val).(*reddit.ListingResponse)
+
+	data, err := lr.MarshalBinary()
+	assert.NoError(t, err)
+
+	got := &reddit.ListingResponse{}
+	assert.NoError(t, got.UnmarshalBinary(data))
+
+	assert.Equal(t, lr.Count, got.Count)
+	assert.Equal(t, lr.After, got.After)
+	assert.Equal(t, len(lr.Children), len(got.Children))
+	for i := range lr.Children {
+		assert.Equal(t, lr.Children[i].ID, got.Children[i].ID)
+		assert.Equal(t, lr.Children[i].Title, got.Children[i].Title)
+		assert.True(t, lr.Children[i].CreatedAt.Equal(got.Children[i].CreatedAt))
+	}
 }
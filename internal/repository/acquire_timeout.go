@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultAcquireTimeout is how long a query waits for a free pool connection before
+// giving up with ErrPoolExhausted.
+const DefaultAcquireTimeout = 5 * time.Second
+
+var ErrPoolExhausted = errors.New("timed out waiting for a database connection")
+
+type acquireTimeoutConnection struct {
+	pool    *pgxpool.Pool
+	timeout time.Duration
+}
+
+// WithAcquireTimeout wraps a pool so queries fail with ErrPoolExhausted once they've
+// waited longer than timeout for a connection, instead of queueing up behind a busy
+// pool indefinitely. The timeout only bounds the wait, not the query itself.
+func WithAcquireTimeout(pool *pgxpool.Pool, timeout time.Duration) Connection {
+	return &acquireTimeoutConnection{pool: pool, timeout: timeout}
+}
+
+func (c *acquireTimeoutConnection) acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	actx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	conn, err := c.pool.Acquire(actx)
+	if err != nil && ctx.Err() == nil && errors.Is(actx.Err(), context.DeadlineExceeded) {
+		return nil, ErrPoolExhausted
+	}
+	return conn, err
+}
+
+func (c *acquireTimeoutConnection) Begin(ctx context.Context) (pgx.Tx, error) {
+	conn, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		conn.Release()
+		return nil, err
+	}
+	return &releaseTx{Tx: tx, conn: conn}, nil
+}
+
+func (c *acquireTimeoutConnection) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	conn, err := c.acquire(ctx)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	defer conn.Release()
+
+	return conn.Exec(ctx, sql, args...)
+}
+
+func (c *acquireTimeoutConnection) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	conn, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		conn.Release()
+		return nil, err
+	}
+	return &releaseRows{Rows: rows, conn: conn}, nil
+}
+
+func (c *acquireTimeoutConnection) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	conn, err := c.acquire(ctx)
+	if err != nil {
+		return errRow{err}
+	}
+
+	return &releaseRow{row: conn.QueryRow(ctx, sql, args...), conn: conn}
+}
+
+// releaseRows hands the connection back to the pool once the rows are done with.
+type releaseRows struct {
+	pgx.Rows
+	conn *pgxpool.Conn
+}
+
+func (r *releaseRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.release()
+	return false
+}
+
+func (r *releaseRows) Close() {
+	r.Rows.Close()
+	r.release()
+}
+
+func (r *releaseRows) release() {
+	if r.conn != nil {
+		r.conn.Release()
+		r.conn = nil
+	}
+}
+
+type releaseRow struct {
+	row  pgx.Row
+	conn *pgxpool.Conn
+}
+
+func (r *releaseRow) Scan(dest ...interface{}) error {
+	defer r.conn.Release()
+	return r.row.Scan(dest...)
+}
+
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...interface{}) error {
+	return r.err
+}
+
+// releaseTx hands the connection back to the pool once the transaction is over.
+type releaseTx struct {
+	pgx.Tx
+	conn *pgxpool.Conn
+}
+
+func (tx *releaseTx) Commit(ctx context.Context) error {
+	err := tx.Tx.Commit(ctx)
+	tx.release()
+	return err
+}
+
+func (tx *releaseTx) Rollback(ctx context.Context) error {
+	err := tx.Tx.Rollback(ctx)
+	tx.release()
+	return err
+}
+
+func (tx *releaseTx) release() {
+	if tx.conn != nil {
+		tx.conn.Release()
+		tx.conn = nil
+	}
+}
@@ -0,0 +1,47 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestWithAcquireTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	pool := testhelper.NewTestPgxPool(t, 1)
+	conn := repository.WithAcquireTimeout(pool, 50*time.Millisecond)
+
+	var one int
+	require.NoError(t, conn.QueryRow(ctx, "SELECT 1").Scan(&one))
+	assert.Equal(t, 1, one)
+
+	// Connections go back to the pool once rows are read.
+	rows, err := conn.Query(ctx, "SELECT generate_series(1, 3)")
+	require.NoError(t, err)
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, 3, count)
+
+	held, err := pool.Acquire(ctx)
+	require.NoError(t, err)
+
+	_, err = conn.Exec(ctx, "SELECT 1")
+	assert.ErrorIs(t, err, repository.ErrPoolExhausted)
+	assert.ErrorIs(t, conn.QueryRow(ctx, "SELECT 1").Scan(&one), repository.ErrPoolExhausted)
+
+	held.Release()
+
+	_, err = conn.Exec(ctx, "SELECT 1")
+	assert.NoError(t, err)
+}
@@ -9,7 +9,11 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
+// uniqueViolation is the SQLSTATE Postgres reports when a unique constraint is violated.
+const uniqueViolation = "23505"
+
 type Connection interface {
+	Begin(context.Context) (pgx.Tx, error)
 	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
 	Query(context.Context, string, ...interface{}) (pgx.Rows, error)
 	QueryRow(context.Context, string, ...interface{}) pgx.Row
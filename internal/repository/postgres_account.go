@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -12,6 +13,8 @@ import (
 	"github.com/christianselig/apollo-backend/internal/domain"
 )
 
+var _ domain.AccountRepository = (*postgresAccountRepository)(nil)
+
 type postgresAccountRepository struct {
 	conn   Connection
 	tracer trace.Tracer
@@ -49,6 +52,10 @@ func (p *postgresAccountRepository) fetch(ctx context.Context, query string, arg
 			&acc.NextStuckNotificationCheckAt,
 			&acc.CheckCount,
 			&acc.Development,
+			&acc.IdleCheckCount,
+			&acc.RevocationConfirmations,
+			&acc.CreatedAt,
+			&acc.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -61,7 +68,7 @@ func (p *postgresAccountRepository) GetByID(ctx context.Context, id int64) (doma
 	query := `
 		SELECT id, username, reddit_account_id, access_token, refresh_token, token_expires_at,
 			last_message_id, next_notification_check_at, next_stuck_notification_check_at,
-			check_count, development
+			check_count, development, idle_check_count, revocation_confirmations, created_at, updated_at
 		FROM accounts
 		WHERE id = $1 AND is_deleted IS FALSE`
 
@@ -80,7 +87,7 @@ func (p *postgresAccountRepository) GetByRedditID(ctx context.Context, id string
 	query := `
 		SELECT id, username, reddit_account_id, access_token, refresh_token, token_expires_at,
 			last_message_id, next_notification_check_at, next_stuck_notification_check_at,
-			check_count, development
+			check_count, development, idle_check_count, revocation_confirmations, created_at, updated_at
 		FROM accounts
 		WHERE reddit_account_id = $1 AND is_deleted IS FALSE`
 
@@ -95,22 +102,66 @@ func (p *postgresAccountRepository) GetByRedditID(ctx context.Context, id string
 
 	return accs[0], nil
 }
-func (p *postgresAccountRepository) CreateOrUpdate(ctx context.Context, acc *domain.Account) error {
+
+func (p *postgresAccountRepository) GetByIDs(ctx context.Context, ids []int64) ([]domain.Account, error) {
+	if len(ids) == 0 {
+		return []domain.Account{}, nil
+	}
+
+	query := `
+		SELECT id, username, reddit_account_id, access_token, refresh_token, token_expires_at,
+			last_message_id, next_notification_check_at, next_stuck_notification_check_at,
+			check_count, development, idle_check_count, revocation_confirmations, created_at, updated_at
+		FROM accounts
+		WHERE id = ANY($1) AND is_deleted IS FALSE`
+
+	return p.fetch(ctx, query, ids)
+}
+
+func (p *postgresAccountRepository) GetByRedditIDs(ctx context.Context, ids []string) ([]domain.Account, error) {
+	if len(ids) == 0 {
+		return []domain.Account{}, nil
+	}
+
+	query := `
+		SELECT id, username, reddit_account_id, access_token, refresh_token, token_expires_at,
+			last_message_id, next_notification_check_at, next_stuck_notification_check_at,
+			check_count, development, idle_check_count, revocation_confirmations, created_at, updated_at
+		FROM accounts
+		WHERE reddit_account_id = ANY($1) AND is_deleted IS FALSE`
+
+	return p.fetch(ctx, query, ids)
+}
+
+// CreateOrUpdate upserts an account by username. A deleted account registering again gets
+// reactivated, starting its checks over so it doesn't get notified about everything it
+// missed while it was gone.
+func (p *postgresAccountRepository) CreateOrUpdate(ctx context.Context, acc *domain.Account) (domain.UpsertOutcome, error) {
 	query := `
+		WITH previous AS (
+			SELECT is_deleted FROM accounts WHERE username = $1
+		)
 		INSERT INTO accounts (username, reddit_account_id, access_token, refresh_token, token_expires_at,
-			last_message_id, next_notification_check_at, next_stuck_notification_check_at, is_deleted, development)
-		VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW(), FALSE, $7)
+			last_message_id, next_notification_check_at, next_stuck_notification_check_at, is_deleted, development,
+			created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW(), FALSE, $7, $8, $8)
 		ON CONFLICT(username) DO
 			UPDATE SET access_token = $3,
 				refresh_token = $4,
 				token_expires_at = $5,
 				last_message_id = $6,
-				is_deleted = FALSE
-		RETURNING id`
+				check_count = CASE WHEN accounts.is_deleted THEN 0 ELSE accounts.check_count END,
+				idle_check_count = CASE WHEN accounts.is_deleted THEN 0 ELSE accounts.idle_check_count END,
+				next_notification_check_at = CASE WHEN accounts.is_deleted THEN NOW() ELSE accounts.next_notification_check_at END,
+				revocation_confirmations = 0,
+				is_deleted = FALSE,
+				updated_at = $8
+		RETURNING id, created_at, updated_at, (xmax = 0), COALESCE((SELECT is_deleted FROM previous), FALSE)`
 
 	ctx, span := spanWithQuery(ctx, p.tracer, query)
 	defer span.End()
 
+	var created, reactivated bool
 	if err := p.conn.QueryRow(
 		ctx,
 		query,
@@ -121,26 +172,37 @@ func (p *postgresAccountRepository) CreateOrUpdate(ctx context.Context, acc *dom
 		acc.TokenExpiresAt,
 		acc.LastMessageID,
 		acc.Development,
-	).Scan(&acc.ID); err != nil {
+		time.Now(),
+	).Scan(&acc.ID, &acc.CreatedAt, &acc.UpdatedAt, &created, &reactivated); err != nil {
 		span.SetStatus(codes.Error, "failed upserting account")
 		span.RecordError(err)
-		return err
+		return domain.UpsertUpdated, err
 	}
 
-	return nil
+	switch {
+	case created:
+		return domain.UpsertCreated, nil
+	case reactivated:
+		return domain.UpsertReactivated, nil
+	default:
+		return domain.UpsertUpdated, nil
+	}
 }
 
 func (p *postgresAccountRepository) Create(ctx context.Context, acc *domain.Account) error {
 	query := `
 		INSERT INTO accounts
 			(username, reddit_account_id, access_token, refresh_token, token_expires_at,
-			last_message_id, next_notification_check_at, next_stuck_notification_check_at, is_deleted, development)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, FALSE, $9)
+			last_message_id, next_notification_check_at, next_stuck_notification_check_at, is_deleted, development,
+			created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, FALSE, $9, $10, $10)
 		RETURNING id`
 
 	ctx, span := spanWithQuery(ctx, p.tracer, query)
 	defer span.End()
 
+	now := time.Now()
+
 	if err := p.conn.QueryRow(
 		ctx,
 		query,
@@ -153,12 +215,14 @@ func (p *postgresAccountRepository) Create(ctx context.Context, acc *domain.Acco
 		acc.NextNotificationCheckAt,
 		acc.NextStuckNotificationCheckAt,
 		acc.Development,
+		now,
 	).Scan(&acc.ID); err != nil {
 		span.SetStatus(codes.Error, "failed inserting account")
 		span.RecordError(err)
 		return err
 	}
 
+	acc.CreatedAt, acc.UpdatedAt = now, now
 	return nil
 }
 
@@ -174,12 +238,17 @@ func (p *postgresAccountRepository) Update(ctx context.Context, acc *domain.Acco
 			next_notification_check_at = $8,
 			next_stuck_notification_check_at = $9,
 			check_count = $10,
-			development = $11
+			development = $11,
+			idle_check_count = $12,
+			revocation_confirmations = $13,
+			updated_at = $14
 		WHERE id = $1`
 
 	ctx, span := spanWithQuery(ctx, p.tracer, query)
 	defer span.End()
 
+	now := time.Now()
+
 	if _, err := p.conn.Exec(
 		ctx,
 		query,
@@ -194,22 +263,26 @@ func (p *postgresAccountRepository) Update(ctx context.Context, acc *domain.Acco
 		acc.NextStuckNotificationCheckAt,
 		acc.CheckCount,
 		acc.Development,
+		acc.IdleCheckCount,
+		acc.RevocationConfirmations,
+		now,
 	); err != nil {
 		span.SetStatus(codes.Error, "failed to update account")
 		span.RecordError(err)
 		return err
 	}
 
+	acc.UpdatedAt = now
 	return nil
 }
 
 func (p *postgresAccountRepository) Delete(ctx context.Context, id int64) error {
-	query := `UPDATE accounts SET is_deleted = TRUE WHERE id = $1`
+	query := `UPDATE accounts SET is_deleted = TRUE, updated_at = $2 WHERE id = $1`
 
 	ctx, span := spanWithQuery(ctx, p.tracer, query)
 	defer span.End()
 
-	if _, err := p.conn.Exec(ctx, query, id); err != nil {
+	if _, err := p.conn.Exec(ctx, query, id, time.Now()); err != nil {
 		span.SetStatus(codes.Error, "failed to delete account")
 		span.RecordError(err)
 		return err
@@ -249,15 +322,35 @@ func (p *postgresAccountRepository) Disassociate(ctx context.Context, acc *domai
 	return nil
 }
 
+// UpsertAndAssociate upserts an account and links it to a device in a single transaction,
+// so an account never gets left behind without the device that registered it.
+func (p *postgresAccountRepository) UpsertAndAssociate(ctx context.Context, acc *domain.Account, dev *domain.Device) (domain.UpsertOutcome, error) {
+	var outcome domain.UpsertOutcome
+
+	err := pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		repo := &postgresAccountRepository{conn: tx, tracer: p.tracer}
+
+		var err error
+		if outcome, err = repo.CreateOrUpdate(ctx, acc); err != nil {
+			return err
+		}
+
+		return repo.Associate(ctx, acc, dev)
+	})
+
+	return outcome, err
+}
+
 func (p *postgresAccountRepository) GetByAPNSToken(ctx context.Context, token string) ([]domain.Account, error) {
 	query := `
 		SELECT accounts.id, username, accounts.reddit_account_id, access_token, refresh_token, token_expires_at,
 			last_message_id, next_notification_check_at, next_stuck_notification_check_at,
-			check_count, development
+			check_count, development, idle_check_count, revocation_confirmations, accounts.created_at, accounts.updated_at
 		FROM accounts
 		INNER JOIN devices_accounts ON accounts.id = devices_accounts.account_id
 		INNER JOIN devices ON devices.id = devices_accounts.device_id
 		WHERE devices.apns_token = $1
+		AND devices.is_deleted IS FALSE
 		AND accounts.is_deleted IS FALSE`
 
 	return p.fetch(ctx, query, token)
@@ -286,9 +379,10 @@ func (p *postgresAccountRepository) PruneStale(ctx context.Context, expiry time.
 func (p *postgresAccountRepository) PruneOrphaned(ctx context.Context) (int64, error) {
 	query := `
 		WITH accounts_with_device_count AS (
-			SELECT accounts.id, COUNT(device_id) AS device_count
+			SELECT accounts.id, COUNT(devices.id) AS device_count
 			FROM accounts
 			LEFT JOIN devices_accounts ON accounts.id = devices_accounts.account_id
+			LEFT JOIN devices ON devices.id = devices_accounts.device_id AND devices.is_deleted IS FALSE
 			GROUP BY accounts.id
 		)
 		UPDATE accounts
@@ -312,3 +406,37 @@ func (p *postgresAccountRepository) PruneOrphaned(ctx context.Context) (int64, e
 
 	return res.RowsAffected(), err
 }
+
+func (p *postgresAccountRepository) CountStale(ctx context.Context, expiry time.Time) (int64, error) {
+	query := `SELECT COUNT(*) FROM accounts WHERE token_expires_at < $1`
+
+	return p.count(ctx, query, expiry)
+}
+
+func (p *postgresAccountRepository) CountOrphaned(ctx context.Context) (int64, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM accounts
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM devices_accounts
+			INNER JOIN devices ON devices.id = devices_accounts.device_id
+			WHERE devices_accounts.account_id = accounts.id AND devices.is_deleted IS FALSE
+		)`
+
+	return p.count(ctx, query)
+}
+
+func (p *postgresAccountRepository) count(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	ctx, span := spanWithQuery(ctx, p.tracer, query)
+	defer span.End()
+
+	var count int64
+	if err := p.conn.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		span.SetStatus(codes.Error, "failed to count accounts")
+		span.RecordError(err)
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,315 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func NewTestPostgresAccount(t *testing.T) domain.AccountRepository {
+	t.Helper()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+
+	repo := repository.NewPostgresAccount(tx)
+
+	t.Cleanup(func() {
+		_ = tx.Rollback(ctx)
+	})
+
+	return repo
+}
+
+func TestPostgresAccount_Update(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresAccount(t)
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	acc := &domain.Account{
+		Username:       "janedoe",
+		AccountID:      "abc123",
+		AccessToken:    "access",
+		RefreshToken:   "refresh",
+		TokenExpiresAt: now,
+	}
+	require.NoError(t, repo.Create(ctx, acc))
+
+	acc.AccessToken = "new-access"
+	acc.RefreshToken = "new-refresh"
+	acc.TokenExpiresAt = now.Add(time.Hour)
+	acc.LastMessageID = "t4_xyz"
+	acc.NextNotificationCheckAt = now.Add(2 * time.Minute)
+	acc.NextStuckNotificationCheckAt = now.Add(3 * time.Minute)
+	acc.CheckCount = 7
+	acc.Development = true
+
+	require.NoError(t, repo.Update(ctx, acc))
+
+	got, err := repo.GetByID(ctx, acc.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, "janedoe", got.Username)
+	assert.Equal(t, "abc123", got.AccountID)
+	assert.Equal(t, "new-access", got.AccessToken)
+	assert.Equal(t, "new-refresh", got.RefreshToken)
+	assert.Equal(t, "t4_xyz", got.LastMessageID)
+	assert.Equal(t, int64(7), got.CheckCount)
+	assert.True(t, got.Development)
+	assert.WithinDuration(t, acc.TokenExpiresAt, got.TokenExpiresAt, time.Second)
+	assert.WithinDuration(t, acc.NextNotificationCheckAt, got.NextNotificationCheckAt, time.Second)
+	assert.WithinDuration(t, acc.NextStuckNotificationCheckAt, got.NextStuckNotificationCheckAt, time.Second)
+}
+
+func TestPostgresAccount_PruneStale(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresAccount(t)
+
+	now := time.Now().UTC()
+	expiry := now.Add(-domain.StaleTokenThreshold)
+
+	fresh := &domain.Account{Username: "fresh", AccountID: "fresh", TokenExpiresAt: now}
+	stale := &domain.Account{Username: "stale", AccountID: "stale", TokenExpiresAt: expiry.Add(-time.Hour)}
+	require.NoError(t, repo.Create(ctx, fresh))
+	require.NoError(t, repo.Create(ctx, stale))
+
+	wouldPrune, err := repo.CountStale(ctx, expiry)
+	require.NoError(t, err)
+
+	// Counting leaves everything in place.
+	_, err = repo.GetByID(ctx, stale.ID)
+	require.NoError(t, err)
+
+	pruned, err := repo.PruneStale(ctx, expiry)
+	require.NoError(t, err)
+	assert.Equal(t, wouldPrune, pruned)
+
+	_, err = repo.GetByID(ctx, stale.ID)
+	assert.Equal(t, domain.ErrNotFound, err)
+
+	_, err = repo.GetByID(ctx, fresh.ID)
+	assert.NoError(t, err)
+}
+
+func TestPostgresAccount_PruneOrphaned(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresAccount(t)
+
+	acc := &domain.Account{Username: "orphan", AccountID: "orphan", TokenExpiresAt: time.Now()}
+	require.NoError(t, repo.Create(ctx, acc))
+
+	wouldPrune, err := repo.CountOrphaned(ctx)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, wouldPrune, int64(1))
+
+	_, err = repo.GetByID(ctx, acc.ID)
+	require.NoError(t, err)
+
+	pruned, err := repo.PruneOrphaned(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, wouldPrune, pruned)
+
+	_, err = repo.GetByID(ctx, acc.ID)
+	assert.Equal(t, domain.ErrNotFound, err)
+}
+
+func TestPostgresAccount_GetByIDs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresAccount(t)
+
+	first := &domain.Account{Username: "first", AccountID: "first", TokenExpiresAt: time.Now()}
+	require.NoError(t, repo.Create(ctx, first))
+
+	second := &domain.Account{Username: "second", AccountID: "second", TokenExpiresAt: time.Now()}
+	require.NoError(t, repo.Create(ctx, second))
+
+	tt := map[string]struct {
+		ids       []int64
+		redditIDs []string
+		want      []string
+	}{
+		"empty":   {[]int64{}, []string{}, []string{}},
+		"partial": {[]int64{first.ID, -1}, []string{"first", "missing"}, []string{"first"}},
+		"full":    {[]int64{first.ID, second.ID}, []string{"first", "second"}, []string{"first", "second"}},
+	}
+
+	for scenario, tc := range tt { //nolint:paralleltest
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			accs, err := repo.GetByIDs(ctx, tc.ids)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tc.want, usernames(accs))
+
+			accs, err = repo.GetByRedditIDs(ctx, tc.redditIDs)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tc.want, usernames(accs))
+		})
+	}
+}
+
+func usernames(accs []domain.Account) []string {
+	names := make([]string, len(accs))
+	for i, acc := range accs {
+		names[i] = acc.Username
+	}
+	return names
+}
+
+func TestPostgresAccount_CreateOrUpdate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresAccount(t)
+
+	acc := &domain.Account{Username: "upserted", AccountID: "upserted", TokenExpiresAt: time.Now()}
+	outcome, err := repo.CreateOrUpdate(ctx, acc)
+	require.NoError(t, err)
+	assert.Equal(t, domain.UpsertCreated, outcome)
+
+	refreshed := &domain.Account{Username: "upserted", AccountID: "upserted", AccessToken: "new-access", TokenExpiresAt: time.Now()}
+	outcome, err = repo.CreateOrUpdate(ctx, refreshed)
+	require.NoError(t, err)
+	assert.Equal(t, domain.UpsertUpdated, outcome)
+	assert.Equal(t, acc.ID, refreshed.ID)
+}
+
+func TestPostgresAccount_Reactivate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	accRepo := repository.NewPostgresAccount(tx)
+	devRepo := repository.NewPostgresDevice(tx)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, devRepo.Create(ctx, dev))
+
+	acc := &domain.Account{Username: "returning", AccountID: "returning", TokenExpiresAt: time.Now()}
+	_, err = accRepo.UpsertAndAssociate(ctx, acc, dev)
+	require.NoError(t, err)
+
+	acc.CheckCount = 42
+	require.NoError(t, accRepo.Update(ctx, acc))
+	require.NoError(t, accRepo.Delete(ctx, acc.ID))
+
+	returning := &domain.Account{Username: "returning", AccountID: "returning", LastMessageID: "t4_new", TokenExpiresAt: time.Now()}
+	outcome, err := accRepo.UpsertAndAssociate(ctx, returning, dev)
+	require.NoError(t, err)
+	assert.Equal(t, domain.UpsertReactivated, outcome)
+	assert.Equal(t, acc.ID, returning.ID)
+
+	got, err := accRepo.GetByRedditID(ctx, "returning")
+	require.NoError(t, err)
+	assert.Equal(t, acc.ID, got.ID)
+	assert.Equal(t, int64(0), got.CheckCount)
+	assert.Equal(t, "t4_new", got.LastMessageID)
+
+	accs, err := accRepo.GetByAPNSToken(ctx, testToken)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"returning"}, usernames(accs))
+
+	// Registering again while active is a plain update.
+	outcome, err = accRepo.UpsertAndAssociate(ctx, returning, dev)
+	require.NoError(t, err)
+	assert.Equal(t, domain.UpsertUpdated, outcome)
+}
+
+func TestPostgresAccount_UpsertAndAssociate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	accRepo := repository.NewPostgresAccount(tx)
+	devRepo := repository.NewPostgresDevice(tx)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, devRepo.Create(ctx, dev))
+
+	acc := &domain.Account{Username: "associated", AccountID: "associated", TokenExpiresAt: time.Now()}
+	outcome, err := accRepo.UpsertAndAssociate(ctx, acc, dev)
+	require.NoError(t, err)
+	assert.Equal(t, domain.UpsertCreated, outcome)
+
+	accs, err := accRepo.GetByAPNSToken(ctx, testToken)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"associated"}, usernames(accs))
+
+	// Associating with a device that doesn't exist fails after the upsert went through,
+	// which should take the upsert down with it.
+	orphan := &domain.Account{Username: "orphaned", AccountID: "orphaned", TokenExpiresAt: time.Now()}
+	_, err = accRepo.UpsertAndAssociate(ctx, orphan, &domain.Device{ID: -1})
+	require.Error(t, err)
+
+	_, err = accRepo.GetByRedditID(ctx, "orphaned")
+	assert.Equal(t, domain.ErrNotFound, err)
+}
+
+func TestPostgresAccount_Timestamps(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresAccount(t)
+
+	acc := &domain.Account{Username: "timestamped", AccountID: "timestamped", TokenExpiresAt: time.Now()}
+	require.NoError(t, repo.Create(ctx, acc))
+
+	created, err := repo.GetByID(ctx, acc.ID)
+	require.NoError(t, err)
+	assert.False(t, created.CreatedAt.IsZero())
+	assert.Equal(t, created.CreatedAt, created.UpdatedAt)
+
+	time.Sleep(time.Millisecond)
+	require.NoError(t, repo.Update(ctx, &created))
+
+	updated, err := repo.GetByID(ctx, acc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, created.CreatedAt, updated.CreatedAt)
+	assert.True(t, updated.UpdatedAt.After(created.UpdatedAt))
+}
+
+func TestPostgresAccount_RevocationConfirmations(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresAccount(t)
+
+	acc := &domain.Account{Username: "revoked", AccountID: "rvk1", AccessToken: "access", RefreshToken: "refresh"}
+	_, err := repo.CreateOrUpdate(ctx, acc)
+	require.NoError(t, err)
+
+	acc.ConfirmRevocation(time.Now())
+	require.NoError(t, repo.Update(ctx, acc))
+
+	got, err := repo.GetByID(ctx, acc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), got.RevocationConfirmations)
+}
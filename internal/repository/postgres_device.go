@@ -2,8 +2,12 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
 	"github.com/christianselig/apollo-backend/internal/domain"
 )
 
@@ -31,6 +35,13 @@ func (p *postgresDeviceRepository) fetch(ctx context.Context, query string, args
 			&dev.Sandbox,
 			&dev.ExpiresAt,
 			&dev.GracePeriodExpiresAt,
+			&dev.EntitlementActive,
+			&dev.ReceiptCheckedAt,
+			&dev.QuietHours.Start,
+			&dev.QuietHours.End,
+			&dev.QuietHours.Timezone,
+			&dev.CreatedAt,
+			&dev.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -41,9 +52,11 @@ func (p *postgresDeviceRepository) fetch(ctx context.Context, query string, args
 
 func (p *postgresDeviceRepository) GetByID(ctx context.Context, id int64) (domain.Device, error) {
 	query := `
-		SELECT id, apns_token, sandbox, expires_at, grace_period_expires_at
+		SELECT id, apns_token, sandbox, expires_at, grace_period_expires_at,
+			entitlement_active, receipt_checked_at, quiet_hours_start, quiet_hours_end, quiet_hours_timezone,
+			devices.created_at, devices.updated_at
 		FROM devices
-		WHERE id = $1`
+		WHERE id = $1 AND is_deleted IS FALSE`
 
 	devs, err := p.fetch(ctx, query, id)
 
@@ -56,11 +69,28 @@ func (p *postgresDeviceRepository) GetByID(ctx context.Context, id int64) (domai
 	return devs[0], nil
 }
 
+func (p *postgresDeviceRepository) GetByIDs(ctx context.Context, ids []int64) ([]domain.Device, error) {
+	if len(ids) == 0 {
+		return []domain.Device{}, nil
+	}
+
+	query := `
+		SELECT id, apns_token, sandbox, expires_at, grace_period_expires_at,
+			entitlement_active, receipt_checked_at, quiet_hours_start, quiet_hours_end, quiet_hours_timezone,
+			devices.created_at, devices.updated_at
+		FROM devices
+		WHERE id = ANY($1) AND is_deleted IS FALSE`
+
+	return p.fetch(ctx, query, ids)
+}
+
 func (p *postgresDeviceRepository) GetByAPNSToken(ctx context.Context, token string) (domain.Device, error) {
 	query := `
-		SELECT id, apns_token, sandbox, expires_at, grace_period_expires_at
+		SELECT id, apns_token, sandbox, expires_at, grace_period_expires_at,
+			entitlement_active, receipt_checked_at, quiet_hours_start, quiet_hours_end, quiet_hours_timezone,
+			devices.created_at, devices.updated_at
 		FROM devices
-		WHERE apns_token = $1`
+		WHERE apns_token = $1 AND is_deleted IS FALSE`
 
 	devs, err := p.fetch(ctx, query, token)
 
@@ -75,21 +105,27 @@ func (p *postgresDeviceRepository) GetByAPNSToken(ctx context.Context, token str
 
 func (p *postgresDeviceRepository) GetByAccountID(ctx context.Context, id int64) ([]domain.Device, error) {
 	query := `
-		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at
+		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at,
+			entitlement_active, receipt_checked_at, quiet_hours_start, quiet_hours_end, quiet_hours_timezone,
+			devices.created_at, devices.updated_at
 		FROM devices
 		INNER JOIN devices_accounts ON devices.id = devices_accounts.device_id
-		WHERE devices_accounts.account_id = $1`
+		WHERE devices_accounts.account_id = $1 AND
+		devices.is_deleted IS FALSE`
 
 	return p.fetch(ctx, query, id)
 }
 
 func (p *postgresDeviceRepository) GetInboxNotifiableByAccountID(ctx context.Context, id int64) ([]domain.Device, error) {
 	query := `
-		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at
+		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at,
+			entitlement_active, receipt_checked_at, quiet_hours_start, quiet_hours_end, quiet_hours_timezone,
+			devices.created_at, devices.updated_at
 		FROM devices
 		INNER JOIN devices_accounts ON devices.id = devices_accounts.device_id
 		WHERE devices_accounts.account_id = $1 AND
 		devices_accounts.inbox_notifiable = TRUE AND
+		devices.is_deleted IS FALSE AND
 		grace_period_expires_at > NOW()`
 
 	return p.fetch(ctx, query, id)
@@ -97,32 +133,44 @@ func (p *postgresDeviceRepository) GetInboxNotifiableByAccountID(ctx context.Con
 
 func (p *postgresDeviceRepository) GetWatcherNotifiableByAccountID(ctx context.Context, id int64) ([]domain.Device, error) {
 	query := `
-		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at
+		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at,
+			entitlement_active, receipt_checked_at, quiet_hours_start, quiet_hours_end, quiet_hours_timezone,
+			devices.created_at, devices.updated_at
 		FROM devices
 		INNER JOIN devices_accounts ON devices.id = devices_accounts.device_id
 		WHERE devices_accounts.account_id = $1 AND
 		devices_accounts.watcher_notifiable = TRUE AND
+		devices_accounts.global_mute = FALSE AND
+		devices.is_deleted IS FALSE AND
 		grace_period_expires_at > NOW()`
 
 	return p.fetch(ctx, query, id)
 }
 
-func (p *postgresDeviceRepository) CreateOrUpdate(ctx context.Context, dev *domain.Device) error {
+// CreateOrUpdate upserts a device by token, reporting whether a new row was inserted.
+func (p *postgresDeviceRepository) CreateOrUpdate(ctx context.Context, dev *domain.Device) (bool, error) {
 	query := `
-		INSERT INTO devices (apns_token, sandbox, expires_at, grace_period_expires_at)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO devices
+			(apns_token, sandbox, expires_at, grace_period_expires_at, entitlement_active, receipt_checked_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
 		ON CONFLICT(apns_token) DO
-			UPDATE SET expires_at = $3, grace_period_expires_at = $4
-		RETURNING id`
+			UPDATE SET sandbox = $2, expires_at = $3, grace_period_expires_at = $4, is_deleted = FALSE, updated_at = $7
+		RETURNING id, created_at, updated_at, (xmax = 0)`
 
-	return p.conn.QueryRow(
+	var created bool
+	err := p.conn.QueryRow(
 		ctx,
 		query,
 		dev.APNSToken,
 		dev.Sandbox,
 		&dev.ExpiresAt,
 		&dev.GracePeriodExpiresAt,
-	).Scan(&dev.ID)
+		dev.EntitlementActive,
+		dev.ReceiptCheckedAt,
+		time.Now(),
+	).Scan(&dev.ID, &dev.CreatedAt, &dev.UpdatedAt, &created)
+
+	return created, err
 }
 
 func (p *postgresDeviceRepository) Create(ctx context.Context, dev *domain.Device) error {
@@ -132,18 +180,34 @@ func (p *postgresDeviceRepository) Create(ctx context.Context, dev *domain.Devic
 
 	query := `
 		INSERT INTO devices
-			(apns_token, sandbox, expires_at, grace_period_expires_at)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id`
+			(apns_token, sandbox, expires_at, grace_period_expires_at, entitlement_active, receipt_checked_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		RETURNING id, created_at, updated_at`
 
-	return p.conn.QueryRow(
-		ctx,
-		query,
-		dev.APNSToken,
-		dev.Sandbox,
-		dev.ExpiresAt,
-		dev.GracePeriodExpiresAt,
-	).Scan(&dev.ID)
+	return pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		if err := purgeTombstone(ctx, tx, dev.APNSToken); err != nil {
+			return err
+		}
+
+		return tx.QueryRow(
+			ctx,
+			query,
+			dev.APNSToken,
+			dev.Sandbox,
+			dev.ExpiresAt,
+			dev.GracePeriodExpiresAt,
+			dev.EntitlementActive,
+			dev.ReceiptCheckedAt,
+			time.Now(),
+		).Scan(&dev.ID, &dev.CreatedAt, &dev.UpdatedAt)
+	})
+}
+
+// purgeTombstone removes a deleted device still holding token, so the token can be
+// given to another device.
+func purgeTombstone(ctx context.Context, tx pgx.Tx, token string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM devices WHERE apns_token = $1 AND is_deleted IS TRUE`, token)
+	return err
 }
 
 func (p *postgresDeviceRepository) Update(ctx context.Context, dev *domain.Device) error {
@@ -153,18 +217,188 @@ func (p *postgresDeviceRepository) Update(ctx context.Context, dev *domain.Devic
 
 	query := `
 		UPDATE devices
-		SET expires_at = $2, grace_period_expires_at = $3
+		SET expires_at = $2, grace_period_expires_at = $3, entitlement_active = $4, receipt_checked_at = $5, updated_at = $6
 		WHERE id = $1`
 
-	_, err := p.conn.Exec(ctx, query, dev.ID, dev.ExpiresAt, dev.GracePeriodExpiresAt)
-	return err
+	now := time.Now()
+	if _, err := p.conn.Exec(ctx, query, dev.ID, dev.ExpiresAt, dev.GracePeriodExpiresAt, dev.EntitlementActive, dev.ReceiptCheckedAt, now); err != nil {
+		return err
+	}
+
+	dev.UpdatedAt = now
+	return nil
 }
 
+// UpdateToken swaps a device's APNs token in place, keeping its ID and everything that
+// references it.
+func (p *postgresDeviceRepository) UpdateToken(ctx context.Context, dev *domain.Device, token string) error {
+	rotated := *dev
+	rotated.APNSToken = token
+	if err := rotated.Validate(); err != nil {
+		return err
+	}
+
+	query := `UPDATE devices SET apns_token = $2, updated_at = $3 WHERE id = $1`
+
+	now := time.Now()
+	err := pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		if err := purgeTombstone(ctx, tx, token); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, query, dev.ID, token, now)
+		return err
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return domain.ErrConflict
+		}
+		return err
+	}
+
+	dev.APNSToken = token
+	dev.UpdatedAt = now
+	return nil
+}
+
+// Migrate moves everything attached to the device on oldToken over to newToken in one
+// transaction: its accounts and their notification settings, watchers, notification
+// history, quiet hours and receipt. If newToken isn't registered yet the device simply
+// takes it over. Otherwise the old device is merged into the new one and removed, with
+// the old device's per-account settings winning. It returns the device now on newToken.
+func (p *postgresDeviceRepository) Migrate(ctx context.Context, oldToken, newToken string) (domain.Device, error) {
+	if err := (&domain.Device{APNSToken: newToken}).Validate(); err != nil {
+		return domain.Device{}, err
+	}
+
+	var id int64
+
+	err := pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		var from int64
+		if err := tx.QueryRow(ctx, `SELECT id FROM devices WHERE apns_token = $1 AND is_deleted IS FALSE FOR UPDATE`, oldToken).Scan(&from); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return domain.ErrNotFound
+			}
+			return err
+		}
+
+		var to int64
+		err := tx.QueryRow(ctx, `SELECT id FROM devices WHERE apns_token = $1 FOR UPDATE`, newToken).Scan(&to)
+		if errors.Is(err, pgx.ErrNoRows) {
+			id = from
+			_, err := tx.Exec(ctx, `UPDATE devices SET apns_token = $2, updated_at = $3 WHERE id = $1`, from, newToken, time.Now())
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		id = to
+
+		queries := []string{
+			`INSERT INTO devices_accounts (account_id, device_id, inbox_notifiable, watcher_notifiable, global_mute)
+				SELECT account_id, $2::integer, inbox_notifiable, watcher_notifiable, global_mute
+				FROM devices_accounts
+				WHERE device_id = $1
+			ON CONFLICT (account_id, device_id) DO
+				UPDATE SET inbox_notifiable = EXCLUDED.inbox_notifiable, watcher_notifiable = EXCLUDED.watcher_notifiable, global_mute = EXCLUDED.global_mute`,
+			`UPDATE watchers SET device_id = $2 WHERE device_id = $1`,
+			`UPDATE sent_notifications SET device_id = $2 WHERE device_id = $1`,
+			`UPDATE devices AS dst
+			SET expires_at = GREATEST(dst.expires_at, src.expires_at),
+				grace_period_expires_at = GREATEST(dst.grace_period_expires_at, src.grace_period_expires_at),
+				entitlement_active = src.entitlement_active,
+				receipt_checked_at = src.receipt_checked_at,
+				receipt = src.receipt,
+				quiet_hours_start = src.quiet_hours_start,
+				quiet_hours_end = src.quiet_hours_end,
+				quiet_hours_timezone = src.quiet_hours_timezone,
+				is_deleted = FALSE,
+				updated_at = NOW()
+			FROM devices AS src
+			WHERE src.id = $1 AND dst.id = $2`,
+		}
+
+		for _, query := range queries {
+			if _, err := tx.Exec(ctx, query, from, to); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM devices WHERE id = $1`, from)
+		return err
+	})
+	if err != nil {
+		return domain.Device{}, err
+	}
+
+	return p.GetByID(ctx, id)
+}
+
+// SetSandbox records which APNs environment a device's token belongs to.
+func (p *postgresDeviceRepository) SetSandbox(ctx context.Context, dev *domain.Device, sandbox bool) error {
+	query := `UPDATE devices SET sandbox = $2, updated_at = $3 WHERE id = $1`
+
+	now := time.Now()
+	if _, err := p.conn.Exec(ctx, query, dev.ID, sandbox, now); err != nil {
+		return err
+	}
+
+	dev.Sandbox = sandbox
+	dev.UpdatedAt = now
+	return nil
+}
+
+// Delete tombstones a device rather than removing it, so re-registering the same token
+// picks its history back up through CreateOrUpdate. Its accounts and watchers are
+// detached, the same as they would have been had the row gone away.
 func (p *postgresDeviceRepository) Delete(ctx context.Context, token string) error {
-	query := `DELETE FROM devices WHERE apns_token = $1`
+	return pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		var id int64
+		err := tx.QueryRow(ctx, `SELECT id FROM devices WHERE apns_token = $1 AND is_deleted IS FALSE FOR UPDATE`, token).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 
-	_, err := p.conn.Exec(ctx, query, token)
-	return err
+		rows, err := tx.Query(ctx, `DELETE FROM watchers WHERE device_id = $1 RETURNING type, watchee_id`, id)
+		if err != nil {
+			return err
+		}
+
+		type watchee struct {
+			typ domain.WatcherType
+			id  int64
+		}
+		removed := map[watchee]int64{}
+		for rows.Next() {
+			var w watchee
+			if err := rows.Scan(&w.typ, &w.id); err != nil {
+				rows.Close()
+				return err
+			}
+			removed[w]++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for w, count := range removed {
+			if err := adjustWatcherCount(ctx, tx, w.typ, w.id, -count); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM devices_accounts WHERE device_id = $1`, id); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE devices SET is_deleted = TRUE, updated_at = $2 WHERE id = $1`, id, time.Now())
+		return err
+	})
 }
 
 func (p *postgresDeviceRepository) SetNotifiable(ctx context.Context, dev *domain.Device, acct *domain.Account, inbox, watcher, global bool) error {
@@ -181,6 +415,41 @@ func (p *postgresDeviceRepository) SetNotifiable(ctx context.Context, dev *domai
 
 }
 
+func (p *postgresDeviceRepository) SetQuietHours(ctx context.Context, dev *domain.Device, qh domain.QuietHours) error {
+	if err := qh.Validate(); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE devices
+		SET quiet_hours_start = $2, quiet_hours_end = $3, quiet_hours_timezone = $4, updated_at = $5
+		WHERE id = $1`
+
+	now := time.Now()
+	if _, err := p.conn.Exec(ctx, query, dev.ID, qh.Start, qh.End, qh.Timezone, now); err != nil {
+		return err
+	}
+
+	dev.QuietHours = qh
+	dev.UpdatedAt = now
+	return nil
+}
+
+// SetNotifiableForAllAccounts sets the notification settings of every account on a
+// device in one go. Only the settings that are given get changed.
+func (p *postgresDeviceRepository) SetNotifiableForAllAccounts(ctx context.Context, dev *domain.Device, inbox, watcher, global *bool) error {
+	query := `
+		UPDATE devices_accounts
+		SET
+			inbox_notifiable = COALESCE($1, inbox_notifiable),
+			watcher_notifiable = COALESCE($2, watcher_notifiable),
+			global_mute = COALESCE($3, global_mute)
+		WHERE device_id = $4`
+
+	_, err := p.conn.Exec(ctx, query, inbox, watcher, global, dev.ID)
+	return err
+}
+
 func (p *postgresDeviceRepository) GetNotifiable(ctx context.Context, dev *domain.Device, acct *domain.Account) (bool, bool, bool, error) {
 	query := `
 		SELECT inbox_notifiable, watcher_notifiable, global_mute
@@ -195,10 +464,46 @@ func (p *postgresDeviceRepository) GetNotifiable(ctx context.Context, dev *domai
 	return inbox, watcher, global, nil
 }
 
+func (p *postgresDeviceRepository) GetReceipt(ctx context.Context, dev *domain.Device) (string, error) {
+	query := `SELECT receipt FROM devices WHERE id = $1`
+
+	var receipt string
+	if err := p.conn.QueryRow(ctx, query, dev.ID).Scan(&receipt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", domain.ErrNotFound
+		}
+		return "", err
+	}
+
+	return receipt, nil
+}
+
+func (p *postgresDeviceRepository) SetReceipt(ctx context.Context, dev *domain.Device, receipt string) error {
+	query := `UPDATE devices SET receipt = $2 WHERE id = $1`
+
+	_, err := p.conn.Exec(ctx, query, dev.ID, receipt)
+	return err
+}
+
 func (p *postgresDeviceRepository) PruneStale(ctx context.Context, expiry time.Time) (int64, error) {
-	query := `DELETE FROM devices WHERE grace_period_expires_at < $1`
+	query := `
+		DELETE FROM devices
+		WHERE grace_period_expires_at < $1 AND
+		NOT (entitlement_active AND receipt_checked_at > $2)`
 
-	res, err := p.conn.Exec(ctx, query, expiry)
+	res, err := p.conn.Exec(ctx, query, expiry, expiry.Add(-domain.DeviceEntitlementFreshness))
 
 	return res.RowsAffected(), err
 }
+
+func (p *postgresDeviceRepository) CountStale(ctx context.Context, expiry time.Time) (int64, error) {
+	query := `
+		SELECT COUNT(*) FROM devices
+		WHERE grace_period_expires_at < $1 AND
+		NOT (entitlement_active AND receipt_checked_at > $2)`
+
+	var count int64
+	err := p.conn.QueryRow(ctx, query, expiry, expiry.Add(-domain.DeviceEntitlementFreshness)).Scan(&count)
+
+	return count, err
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -41,7 +43,8 @@ func TestPostgresDevice_GetByID(t *testing.T) {
 	repo := NewTestPostgresDevice(t)
 
 	dev := &domain.Device{APNSToken: testToken}
-	require.NoError(t, repo.CreateOrUpdate(ctx, dev))
+	_, err := repo.CreateOrUpdate(ctx, dev)
+	require.NoError(t, err)
 
 	testCases := map[string]struct {
 		id   int64
@@ -131,3 +134,358 @@ func TestPostgresDevice_Update(t *testing.T) {
 		})
 	}
 }
+
+func TestPostgresDevice_PruneStale(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresDevice(t)
+
+	now := time.Now().UTC()
+
+	testCases := map[string]struct {
+		dev    domain.Device
+		pruned bool
+	}{
+		"within grace period": {
+			domain.Device{GracePeriodExpiresAt: now.Add(time.Hour)},
+			false,
+		},
+		"past grace period": {
+			domain.Device{GracePeriodExpiresAt: now.Add(-time.Hour)},
+			true,
+		},
+		"past grace period with recent active entitlement": {
+			domain.Device{GracePeriodExpiresAt: now.Add(-time.Hour), EntitlementActive: true, ReceiptCheckedAt: now.Add(-time.Hour)},
+			false,
+		},
+		"past grace period with stale active entitlement": {
+			domain.Device{GracePeriodExpiresAt: now.Add(-time.Hour), EntitlementActive: true, ReceiptCheckedAt: now.Add(-2 * domain.DeviceEntitlementFreshness)},
+			true,
+		},
+		"past grace period with recent inactive entitlement": {
+			domain.Device{GracePeriodExpiresAt: now.Add(-time.Hour), ReceiptCheckedAt: now.Add(-time.Hour)},
+			true,
+		},
+	}
+
+	for scenario, tc := range testCases { //nolint:paralleltest
+		t.Run(scenario, func(t *testing.T) {
+			b := make([]byte, 32)
+			_, err := rand.Read(b)
+			require.NoError(t, err)
+
+			dev := tc.dev
+			dev.APNSToken = hex.EncodeToString(b)
+			require.NoError(t, repo.Create(ctx, &dev))
+
+			wouldPrune, err := repo.CountStale(ctx, now)
+			require.NoError(t, err)
+
+			_, err = repo.GetByID(ctx, dev.ID)
+			require.NoError(t, err)
+
+			pruned, err := repo.PruneStale(ctx, now)
+			require.NoError(t, err)
+			assert.Equal(t, wouldPrune, pruned)
+
+			_, err = repo.GetByID(ctx, dev.ID)
+			if tc.pruned {
+				assert.Equal(t, domain.ErrNotFound, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPostgresDevice_Receipt(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresDevice(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, repo.Create(ctx, dev))
+
+	receipt, err := repo.GetReceipt(ctx, dev)
+	require.NoError(t, err)
+	assert.Equal(t, "", receipt)
+
+	require.NoError(t, repo.SetReceipt(ctx, dev, "MIIT0gYJKoZIhvcNAQcCoIITwzCCE78CAQEx"))
+
+	receipt, err = repo.GetReceipt(ctx, dev)
+	require.NoError(t, err)
+	assert.Equal(t, "MIIT0gYJKoZIhvcNAQcCoIITwzCCE78CAQEx", receipt)
+
+	_, err = repo.GetReceipt(ctx, &domain.Device{})
+	assert.Equal(t, domain.ErrNotFound, err)
+}
+
+func TestPostgresDevice_UpdateToken(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresDevice(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, repo.Create(ctx, dev))
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+	newToken := hex.EncodeToString(b)
+
+	require.NoError(t, repo.UpdateToken(ctx, dev, newToken))
+	assert.Equal(t, newToken, dev.APNSToken)
+
+	got, err := repo.GetByAPNSToken(ctx, newToken)
+	require.NoError(t, err)
+	assert.Equal(t, dev.ID, got.ID)
+
+	_, err = repo.GetByAPNSToken(ctx, testToken)
+	assert.Equal(t, domain.ErrNotFound, err)
+
+	assert.Error(t, repo.UpdateToken(ctx, dev, "short"))
+
+	// A deleted device doesn't keep hold of its token.
+	gone := &domain.Device{APNSToken: testToken}
+	require.NoError(t, repo.Create(ctx, gone))
+	require.NoError(t, repo.Delete(ctx, testToken))
+	require.NoError(t, repo.UpdateToken(ctx, dev, testToken))
+
+	require.NoError(t, repo.Delete(ctx, testToken))
+	require.NoError(t, repo.Create(ctx, &domain.Device{APNSToken: testToken}))
+}
+
+func TestPostgresDevice_MigrateInvalidToken(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresDevice(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, repo.Create(ctx, dev))
+
+	_, err := repo.Migrate(ctx, testToken, strings.Repeat("z", 64))
+	assert.Error(t, err)
+
+	got, err := repo.GetByAPNSToken(ctx, testToken)
+	require.NoError(t, err)
+	assert.Equal(t, dev.ID, got.ID)
+}
+
+func TestPostgresDevice_SetQuietHours(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresDevice(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, repo.Create(ctx, dev))
+
+	qh := domain.QuietHours{Start: 22 * 60, End: 7 * 60, Timezone: "America/Toronto"}
+	require.NoError(t, repo.SetQuietHours(ctx, dev, qh))
+	assert.Equal(t, qh, dev.QuietHours)
+
+	got, err := repo.GetByAPNSToken(ctx, testToken)
+	require.NoError(t, err)
+	assert.Equal(t, qh, got.QuietHours)
+
+	assert.Error(t, repo.SetQuietHours(ctx, dev, domain.QuietHours{Start: 1440}))
+}
+
+func TestPostgresDevice_GetByIDs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresDevice(t)
+
+	first := &domain.Device{APNSToken: testToken}
+	require.NoError(t, repo.Create(ctx, first))
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+
+	second := &domain.Device{APNSToken: hex.EncodeToString(b)}
+	require.NoError(t, repo.Create(ctx, second))
+
+	testCases := map[string]struct {
+		ids  []int64
+		want []int64
+	}{
+		"empty":   {[]int64{}, []int64{}},
+		"partial": {[]int64{first.ID, 0}, []int64{first.ID}},
+		"full":    {[]int64{first.ID, second.ID}, []int64{first.ID, second.ID}},
+	}
+
+	for scenario, tc := range testCases { //nolint:paralleltest
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			devs, err := repo.GetByIDs(ctx, tc.ids)
+			require.NoError(t, err)
+
+			got := []int64{}
+			for _, dev := range devs {
+				got = append(got, dev.ID)
+			}
+			assert.ElementsMatch(t, tc.want, got)
+		})
+	}
+}
+
+func TestPostgresDevice_Delete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresDevice(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	_, err := repo.CreateOrUpdate(ctx, dev)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.Delete(ctx, testToken))
+
+	_, err = repo.GetByAPNSToken(ctx, testToken)
+	assert.Equal(t, domain.ErrNotFound, err)
+
+	_, err = repo.GetByID(ctx, dev.ID)
+	assert.Equal(t, domain.ErrNotFound, err)
+
+	devs, err := repo.GetByIDs(ctx, []int64{dev.ID})
+	require.NoError(t, err)
+	assert.Empty(t, devs)
+
+	// Re-registering the token brings back the same device
+	reregistered := &domain.Device{APNSToken: testToken}
+	created, err := repo.CreateOrUpdate(ctx, reregistered)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, dev.ID, reregistered.ID)
+
+	got, err := repo.GetByAPNSToken(ctx, testToken)
+	require.NoError(t, err)
+	assert.Equal(t, dev.ID, got.ID)
+}
+
+func TestPostgresDevice_DeleteDetaches(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	accRepo := repository.NewPostgresAccount(tx)
+	srRepo := repository.NewPostgresSubreddit(tx)
+	watcherRepo := repository.NewPostgresWatcher(tx)
+	repo := repository.NewPostgresDevice(tx)
+
+	dev := &domain.Device{APNSToken: testToken, GracePeriodExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, repo.Create(ctx, dev))
+
+	acc := &domain.Account{Username: "detaching", AccountID: "detaching", TokenExpiresAt: time.Now()}
+	require.NoError(t, accRepo.Create(ctx, acc))
+	require.NoError(t, accRepo.Associate(ctx, acc, dev))
+
+	sr := &domain.Subreddit{SubredditID: "detaching", Name: "detaching"}
+	require.NoError(t, srRepo.CreateOrUpdate(ctx, sr))
+
+	w := &domain.Watcher{Label: "zzdetaching", DeviceID: dev.ID, AccountID: acc.ID, Type: domain.SubredditWatcher, WatcheeID: sr.ID}
+	require.NoError(t, watcherRepo.Create(ctx, w))
+
+	require.NoError(t, repo.Delete(ctx, testToken))
+
+	_, err = watcherRepo.GetByID(ctx, w.ID)
+	assert.Equal(t, domain.ErrNotFound, err)
+
+	got, err := srRepo.GetByID(ctx, sr.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), got.WatcherCount)
+
+	found, err := watcherRepo.Search(ctx, "zzdetaching", 10)
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	accs, err := accRepo.GetByAPNSToken(ctx, testToken)
+	require.NoError(t, err)
+	assert.Empty(t, accs)
+
+	// With its only device gone, the account is an orphan.
+	_, err = accRepo.PruneOrphaned(ctx)
+	require.NoError(t, err)
+	_, err = accRepo.GetByID(ctx, acc.ID)
+	assert.Equal(t, domain.ErrNotFound, err)
+}
+
+func TestPostgresDevice_CreateOrUpdate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresDevice(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	created, err := repo.CreateOrUpdate(ctx, dev)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	refreshed := &domain.Device{APNSToken: testToken}
+	created, err = repo.CreateOrUpdate(ctx, refreshed)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, dev.ID, refreshed.ID)
+}
+
+func TestPostgresDevice_Timestamps(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresDevice(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, repo.Create(ctx, dev))
+
+	created, err := repo.GetByID(ctx, dev.ID)
+	require.NoError(t, err)
+	assert.False(t, created.CreatedAt.IsZero())
+	assert.Equal(t, created.CreatedAt, created.UpdatedAt)
+
+	time.Sleep(time.Millisecond)
+	require.NoError(t, repo.Update(ctx, &created))
+
+	updated, err := repo.GetByID(ctx, dev.ID)
+	require.NoError(t, err)
+	assert.Equal(t, created.CreatedAt, updated.CreatedAt)
+	assert.True(t, updated.UpdatedAt.After(created.UpdatedAt))
+}
+
+func TestPostgresDevice_SetSandbox(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresDevice(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	_, err := repo.CreateOrUpdate(ctx, dev)
+	require.NoError(t, err)
+
+	// A sandbox receipt moves the device over.
+	require.NoError(t, repo.SetSandbox(ctx, dev, true))
+	assert.True(t, dev.Sandbox)
+
+	got, err := repo.GetByAPNSToken(ctx, testToken)
+	require.NoError(t, err)
+	assert.True(t, got.Sandbox)
+
+	// Registering again records whatever environment the app says it's in now.
+	_, err = repo.CreateOrUpdate(ctx, &domain.Device{APNSToken: testToken, Sandbox: false})
+	require.NoError(t, err)
+
+	got, err = repo.GetByAPNSToken(ctx, testToken)
+	require.NoError(t, err)
+	assert.False(t, got.Sandbox)
+}
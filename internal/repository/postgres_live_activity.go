@@ -34,9 +34,14 @@ func (p *postgresLiveActivityRepository) fetch(ctx context.Context, query string
 			&la.TokenExpiresAt,
 			&la.ThreadID,
 			&la.Subreddit,
+			&la.Keyword,
 			&la.NextCheckAt,
 			&la.ExpiresAt,
 			&la.Development,
+			&la.RenewOnActivity,
+			&la.CreatedAt,
+			&la.LastCommentID,
+			&la.ReplyTokenHash,
 		); err != nil {
 			return nil, err
 		}
@@ -47,7 +52,7 @@ func (p *postgresLiveActivityRepository) fetch(ctx context.Context, query string
 
 func (p *postgresLiveActivityRepository) Get(ctx context.Context, apnsToken string) (domain.LiveActivity, error) {
 	query := `
-		SELECT id, apns_token, reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, next_check_at, expires_at, development
+		SELECT id, apns_token, reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, keyword, next_check_at, expires_at, development, renew_on_activity, created_at, last_comment_id, reply_token_hash
 		FROM live_activities
 		WHERE apns_token = $1`
 
@@ -64,19 +69,57 @@ func (p *postgresLiveActivityRepository) Get(ctx context.Context, apnsToken stri
 
 func (p *postgresLiveActivityRepository) List(ctx context.Context) ([]domain.LiveActivity, error) {
 	query := `
-		SELECT id, apns_token, reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, next_check_at, expires_at, development
+		SELECT id, apns_token, reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, keyword, next_check_at, expires_at, development, renew_on_activity, created_at, last_comment_id, reply_token_hash
 		FROM live_activities
 		WHERE expires_at > NOW()`
 
 	return p.fetch(ctx, query)
 }
 
+// ListDue claims up to limit live activities due for a check before the given time,
+// pushing their next check out by the check interval, and returns their APNs tokens
+// with the longest overdue first. Rows another caller is claiming are skipped.
+func (p *postgresLiveActivityRepository) ListDue(ctx context.Context, before time.Time, limit int) ([]string, error) {
+	query := `
+		WITH due AS (
+			SELECT id, next_check_at
+			FROM live_activities
+			WHERE next_check_at < $1
+			ORDER BY next_check_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		), claimed AS (
+			UPDATE live_activities
+			SET next_check_at = $2
+			FROM due
+			WHERE live_activities.id = due.id
+			RETURNING live_activities.apns_token, due.next_check_at AS due_at
+		)
+		SELECT apns_token FROM claimed ORDER BY due_at`
+
+	rows, err := p.conn.Query(ctx, query, before, before.Add(domain.LiveActivityCheckInterval), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ats := []string{}
+	for rows.Next() {
+		var at string
+		if err := rows.Scan(&at); err != nil {
+			return nil, err
+		}
+		ats = append(ats, at)
+	}
+	return ats, rows.Err()
+}
+
 func (p *postgresLiveActivityRepository) Create(ctx context.Context, la *domain.LiveActivity) error {
 	query := `
-		INSERT INTO live_activities (apns_token, reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, next_check_at, expires_at, development)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-		ON CONFLICT (apns_token) DO UPDATE SET expires_at = $9
-		RETURNING id`
+		INSERT INTO live_activities (apns_token, reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, keyword, next_check_at, expires_at, development, renew_on_activity, created_at, reply_token_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (apns_token) DO UPDATE SET expires_at = $10, renew_on_activity = $12, reply_token_hash = $14
+		RETURNING id, created_at`
 
 	return p.conn.QueryRow(ctx, query,
 		la.APNSToken,
@@ -86,28 +129,61 @@ func (p *postgresLiveActivityRepository) Create(ctx context.Context, la *domain.
 		la.TokenExpiresAt,
 		la.ThreadID,
 		la.Subreddit,
+		la.Keyword,
 		time.Now(),
 		time.Now().Add(domain.LiveActivityDuration),
 		la.Development,
-	).Scan(&la.ID)
+		la.RenewOnActivity,
+		time.Now(),
+		la.ReplyTokenHash,
+	).Scan(&la.ID, &la.CreatedAt)
 }
 
 func (p *postgresLiveActivityRepository) Update(ctx context.Context, la *domain.LiveActivity) error {
 	query := `
 		UPDATE live_activities
-		SET access_token = $1, refresh_token = $2, token_expires_at = $3, next_check_at = $4
-		WHERE id = $5`
+		SET access_token = $1, refresh_token = $2, token_expires_at = $3, next_check_at = $4, last_comment_id = $5
+		WHERE id = $6`
 
 	_, err := p.conn.Exec(ctx, query,
 		la.AccessToken,
 		la.RefreshToken,
 		la.TokenExpiresAt,
 		la.NextCheckAt,
+		la.LastCommentID,
 		la.ID,
 	)
 	return err
 }
 
+// UpdateTokens only writes a live activity's Reddit tokens, leaving what the worker
+// keeps track of alone.
+func (p *postgresLiveActivityRepository) UpdateTokens(ctx context.Context, la *domain.LiveActivity) error {
+	query := `
+		UPDATE live_activities
+		SET access_token = $1, refresh_token = $2, token_expires_at = $3
+		WHERE id = $4`
+
+	_, err := p.conn.Exec(ctx, query,
+		la.AccessToken,
+		la.RefreshToken,
+		la.TokenExpiresAt,
+		la.ID,
+	)
+	return err
+}
+
+// Extend pushes a live activity's expiry out to expiresAt. It never brings it forward.
+func (p *postgresLiveActivityRepository) Extend(ctx context.Context, apnsToken string, expiresAt time.Time) error {
+	query := `
+		UPDATE live_activities
+		SET expires_at = $2
+		WHERE apns_token = $1 AND expires_at < $2`
+
+	_, err := p.conn.Exec(ctx, query, apnsToken, expiresAt)
+	return err
+}
+
 func (p *postgresLiveActivityRepository) RemoveStale(ctx context.Context) error {
 	query := `DELETE FROM live_activities WHERE expires_at < NOW()`
 
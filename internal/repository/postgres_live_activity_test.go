@@ -0,0 +1,141 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func NewTestPostgresLiveActivity(t *testing.T) domain.LiveActivityRepository {
+	t.Helper()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+
+	repo := repository.NewPostgresLiveActivity(tx)
+
+	t.Cleanup(func() {
+		_ = tx.Rollback(ctx)
+	})
+
+	return repo
+}
+
+func TestPostgresLiveActivity_ListDue(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresLiveActivity(t)
+
+	// Far enough in the past that nothing outside this test is due before it.
+	before := time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	offsets := map[string]time.Duration{
+		"la-2":      -2 * time.Minute,
+		"la-3":      -1 * time.Minute,
+		"la-1":      -3 * time.Minute,
+		"la-future": time.Minute,
+	}
+	for name, offset := range offsets {
+		la := &domain.LiveActivity{APNSToken: fmt.Sprintf("%s-%s", name, testToken), ThreadID: "t3_abc"}
+		require.NoError(t, repo.Create(ctx, la))
+
+		la.NextCheckAt = before.Add(offset)
+		require.NoError(t, repo.Update(ctx, la))
+	}
+
+	ats, err := repo.ListDue(ctx, before, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"la-1-" + testToken, "la-2-" + testToken}, ats)
+
+	// Claimed live activities get pushed out, so only the remaining one is due.
+	ats, err = repo.ListDue(ctx, before, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"la-3-" + testToken}, ats)
+
+	la, err := repo.Get(ctx, "la-1-"+testToken)
+	require.NoError(t, err)
+	assert.WithinDuration(t, before.Add(domain.LiveActivityCheckInterval), la.NextCheckAt, time.Second)
+}
+
+func TestPostgresLiveActivity_Extend(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresLiveActivity(t)
+
+	require.NoError(t, repo.Create(ctx, &domain.LiveActivity{APNSToken: testToken, ThreadID: "t3_abc", RenewOnActivity: true}))
+
+	la, err := repo.Get(ctx, testToken)
+	require.NoError(t, err)
+	assert.True(t, la.RenewOnActivity)
+	assert.False(t, la.CreatedAt.IsZero())
+
+	later := la.ExpiresAt.Add(time.Hour)
+	require.NoError(t, repo.Extend(ctx, testToken, later))
+
+	la, err = repo.Get(ctx, testToken)
+	require.NoError(t, err)
+	assert.WithinDuration(t, later, la.ExpiresAt, time.Millisecond)
+
+	// Extending never brings the expiry forward.
+	require.NoError(t, repo.Extend(ctx, testToken, later.Add(-2*time.Hour)))
+
+	la, err = repo.Get(ctx, testToken)
+	require.NoError(t, err)
+	assert.WithinDuration(t, later, la.ExpiresAt, time.Millisecond)
+}
+
+func TestPostgresLiveActivity_UpdateLastCommentID(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresLiveActivity(t)
+
+	la := &domain.LiveActivity{APNSToken: testToken, ThreadID: "t3_abc"}
+	require.NoError(t, repo.Create(ctx, la))
+
+	la.LastCommentID = "issn7pe"
+	require.NoError(t, repo.Update(ctx, la))
+
+	got, err := repo.Get(ctx, testToken)
+	require.NoError(t, err)
+	assert.Equal(t, "issn7pe", got.LastCommentID)
+}
+
+func TestPostgresLiveActivity_UpdateTokens(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresLiveActivity(t)
+
+	la := &domain.LiveActivity{APNSToken: testToken, ThreadID: "t3_abc", AccessToken: "old", RefreshToken: "old"}
+	require.NoError(t, repo.Create(ctx, la))
+
+	// A copy read before the worker moved on doesn't rewind it.
+	stale := *la
+	la.LastCommentID = "issn7pe"
+	require.NoError(t, repo.Update(ctx, la))
+
+	expiry := time.Now().Add(time.Hour)
+	stale.AccessToken, stale.RefreshToken, stale.TokenExpiresAt = "access", "refresh", expiry
+	require.NoError(t, repo.UpdateTokens(ctx, &stale))
+
+	got, err := repo.Get(ctx, testToken)
+	require.NoError(t, err)
+	assert.Equal(t, "access", got.AccessToken)
+	assert.Equal(t, "refresh", got.RefreshToken)
+	assert.WithinDuration(t, expiry, got.TokenExpiresAt, time.Millisecond)
+	assert.Equal(t, "issn7pe", got.LastCommentID)
+}
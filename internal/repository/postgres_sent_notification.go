@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+type postgresSentNotificationRepository struct {
+	conn Connection
+}
+
+func NewPostgresSentNotification(conn Connection) domain.SentNotificationRepository {
+	return &postgresSentNotificationRepository{conn: conn}
+}
+
+func (p *postgresSentNotificationRepository) fetch(ctx context.Context, query string, args ...interface{}) ([]domain.SentNotification, error) {
+	rows, err := p.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sns []domain.SentNotification
+	for rows.Next() {
+		var sn domain.SentNotification
+		if err := rows.Scan(
+			&sn.ID,
+			&sn.DeviceID,
+			&sn.Type,
+			&sn.Title,
+			&sn.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		sns = append(sns, sn)
+	}
+	return sns, nil
+}
+
+func (p *postgresSentNotificationRepository) GetByDeviceID(ctx context.Context, id int64, limit int) ([]domain.SentNotification, error) {
+	query := `
+		SELECT id, device_id, type, title, created_at
+		FROM sent_notifications
+		WHERE device_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2`
+
+	return p.fetch(ctx, query, id, limit)
+}
+
+func (p *postgresSentNotificationRepository) CountByDeviceID(ctx context.Context, id int64, since time.Time) (map[string]int64, error) {
+	query := `
+		SELECT type, COUNT(*)
+		FROM sent_notifications
+		WHERE device_id = $1 AND created_at >= $2
+		GROUP BY type`
+
+	rows, err := p.conn.Query(ctx, query, id, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int64{}
+	for rows.Next() {
+		var typ string
+		var count int64
+		if err := rows.Scan(&typ, &count); err != nil {
+			return nil, err
+		}
+		counts[typ] = count
+	}
+	return counts, rows.Err()
+}
+
+func (p *postgresSentNotificationRepository) Create(ctx context.Context, sn *domain.SentNotification) error {
+	if sn.CreatedAt.IsZero() {
+		sn.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO sent_notifications (device_id, type, title, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`
+
+	return p.conn.QueryRow(ctx, query, sn.DeviceID, sn.Type, sn.Title, sn.CreatedAt).Scan(&sn.ID)
+}
+
+func (p *postgresSentNotificationRepository) PruneStale(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM sent_notifications WHERE created_at < $1`
+
+	res, err := p.conn.Exec(ctx, query, before)
+
+	return res.RowsAffected(), err
+}
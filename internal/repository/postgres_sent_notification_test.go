@@ -0,0 +1,118 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func NewTestPostgresSentNotification(t *testing.T) (domain.SentNotificationRepository, domain.DeviceRepository) {
+	t.Helper()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = tx.Rollback(ctx)
+	})
+
+	return repository.NewPostgresSentNotification(tx), repository.NewPostgresDevice(tx)
+}
+
+func TestPostgresSentNotification_GetByDeviceID(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo, devRepo := NewTestPostgresSentNotification(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, devRepo.Create(ctx, dev))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, title := range []string{"first", "second", "third"} {
+		sn := &domain.SentNotification{
+			DeviceID:  dev.ID,
+			Type:      "subreddit-watcher",
+			Title:     title,
+			CreatedAt: now.Add(time.Duration(i) * time.Minute),
+		}
+		require.NoError(t, repo.Create(ctx, sn))
+		assert.NotEqual(t, int64(0), sn.ID)
+	}
+
+	sns, err := repo.GetByDeviceID(ctx, dev.ID, 2)
+	require.NoError(t, err)
+	require.Len(t, sns, 2)
+
+	assert.Equal(t, "third", sns[0].Title)
+	assert.Equal(t, "second", sns[1].Title)
+	assert.Equal(t, "subreddit-watcher", sns[0].Type)
+	assert.Equal(t, dev.ID, sns[0].DeviceID)
+
+	sns, err = repo.GetByDeviceID(ctx, 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, sns)
+}
+
+func TestPostgresSentNotification_PruneStale(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo, devRepo := NewTestPostgresSentNotification(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, devRepo.Create(ctx, dev))
+
+	now := time.Now().UTC()
+	old := &domain.SentNotification{DeviceID: dev.ID, Title: "old", CreatedAt: now.Add(-2 * domain.SentNotificationRetention)}
+	recent := &domain.SentNotification{DeviceID: dev.ID, Title: "recent", CreatedAt: now.Add(-time.Hour)}
+	require.NoError(t, repo.Create(ctx, old))
+	require.NoError(t, repo.Create(ctx, recent))
+
+	count, err := repo.PruneStale(ctx, now.Add(-domain.SentNotificationRetention))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	sns, err := repo.GetByDeviceID(ctx, dev.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, sns, 1)
+	assert.Equal(t, "recent", sns[0].Title)
+}
+
+func TestPostgresSentNotification_CountByDeviceID(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo, devRepo := NewTestPostgresSentNotification(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, devRepo.Create(ctx, dev))
+
+	now := time.Now().UTC()
+	for _, sn := range []*domain.SentNotification{
+		{DeviceID: dev.ID, Type: "subreddit-watcher", Title: "a", CreatedAt: now.Add(-time.Hour)},
+		{DeviceID: dev.ID, Type: "subreddit-watcher", Title: "b", CreatedAt: now.Add(-2 * time.Hour)},
+		{DeviceID: dev.ID, Type: "inbox-item", Title: "c", CreatedAt: now.Add(-time.Minute)},
+		{DeviceID: dev.ID, Type: "inbox-item", Title: "too old", CreatedAt: now.Add(-48 * time.Hour)},
+	} {
+		require.NoError(t, repo.Create(ctx, sn))
+	}
+
+	counts, err := repo.CountByDeviceID(ctx, dev.ID, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"subreddit-watcher": 2, "inbox-item": 1}, counts)
+
+	counts, err = repo.CountByDeviceID(ctx, 0, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+}
@@ -2,7 +2,11 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
 
 	"github.com/christianselig/apollo-backend/internal/domain"
 )
@@ -30,6 +34,10 @@ func (p *postgresSubredditRepository) fetch(ctx context.Context, query string, a
 			&sr.SubredditID,
 			&sr.Name,
 			&sr.NextCheckAt,
+			&sr.PostsPerHour,
+			&sr.WatcherCount,
+			&sr.LastCheckedAt,
+			&sr.LastPostID,
 		); err != nil {
 			return nil, err
 		}
@@ -40,7 +48,7 @@ func (p *postgresSubredditRepository) fetch(ctx context.Context, query string, a
 
 func (p *postgresSubredditRepository) GetByID(ctx context.Context, id int64) (domain.Subreddit, error) {
 	query := `
-		SELECT id, subreddit_id, name, next_check_at
+		SELECT id, subreddit_id, name, next_check_at, posts_per_hour, watcher_count, last_checked_at, last_post_id
 		FROM subreddits
 		WHERE id = $1`
 
@@ -55,11 +63,24 @@ func (p *postgresSubredditRepository) GetByID(ctx context.Context, id int64) (do
 	return srs[0], nil
 }
 
+func (p *postgresSubredditRepository) GetByIDs(ctx context.Context, ids []int64) ([]domain.Subreddit, error) {
+	if len(ids) == 0 {
+		return []domain.Subreddit{}, nil
+	}
+
+	query := `
+		SELECT id, subreddit_id, name, next_check_at, posts_per_hour, watcher_count, last_checked_at, last_post_id
+		FROM subreddits
+		WHERE id = ANY($1)`
+
+	return p.fetch(ctx, query, ids)
+}
+
 func (p *postgresSubredditRepository) GetByName(ctx context.Context, name string) (domain.Subreddit, error) {
 	query := `
-		SELECT id, subreddit_id, name, next_check_at
+		SELECT id, subreddit_id, name, next_check_at, posts_per_hour, watcher_count, last_checked_at, last_post_id
 		FROM subreddits
-		WHERE name = $1`
+		WHERE LOWER(name) = $1`
 
 	name = strings.ToLower(name)
 
@@ -82,13 +103,74 @@ func (p *postgresSubredditRepository) CreateOrUpdate(ctx context.Context, sr *do
 	query := `
 		INSERT INTO subreddits (subreddit_id, name, next_check_at)
 		VALUES ($1, $2, NOW())
-		ON CONFLICT(subreddit_id) DO NOTHING
+		ON CONFLICT DO NOTHING
 		RETURNING id`
 
-	return p.conn.QueryRow(
-		ctx,
-		query,
-		sr.SubredditID,
-		sr.NormalizedName(),
-	).Scan(&sr.ID)
+	err := p.conn.QueryRow(ctx, query, sr.SubredditID, sr.NormalizedName()).Scan(&sr.ID)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	// Already there, either under the same Reddit ID or the same name in another case.
+	query = `
+		SELECT id
+		FROM subreddits
+		WHERE subreddit_id = $1 OR LOWER(name) = $2
+		ORDER BY subreddit_id = $1 DESC
+		LIMIT 1`
+
+	return p.conn.QueryRow(ctx, query, sr.SubredditID, sr.NormalizedName()).Scan(&sr.ID)
+}
+
+func (p *postgresSubredditRepository) Update(ctx context.Context, sr *domain.Subreddit) error {
+	query := `
+		UPDATE subreddits
+		SET next_check_at = $2, posts_per_hour = $3, last_checked_at = $4, last_post_id = $5
+		WHERE id = $1`
+
+	_, err := p.conn.Exec(ctx, query, sr.ID, sr.NextCheckAt, sr.PostsPerHour, sr.LastCheckedAt, sr.LastPostID)
+	return err
+}
+
+func (p *postgresSubredditRepository) CountStale(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM subreddits
+		WHERE watcher_count > 0 AND last_checked_at < $1`
+
+	var count int64
+	err := p.conn.QueryRow(ctx, query, before).Scan(&count)
+	return count, err
+}
+
+func (p *postgresSubredditRepository) ClaimDue(ctx context.Context, now, next time.Time, limit int) ([]int64, error) {
+	query := `
+		UPDATE subreddits
+		SET next_check_at = $2
+		WHERE id IN (
+			SELECT id
+			FROM subreddits
+			WHERE next_check_at < $1
+			AND watcher_count > 0
+			ORDER BY next_check_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT $3
+		)
+		RETURNING subreddits.id`
+
+	rows, err := p.conn.Query(ctx, query, now, next, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
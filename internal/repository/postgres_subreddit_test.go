@@ -0,0 +1,192 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func NewTestPostgresSubreddit(t *testing.T) domain.SubredditRepository {
+	t.Helper()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+
+	repo := repository.NewPostgresSubreddit(tx)
+
+	t.Cleanup(func() {
+		_ = tx.Rollback(ctx)
+	})
+
+	return repo
+}
+
+func TestPostgresSubreddit_ClaimDue(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	repo := repository.NewPostgresSubreddit(tx)
+	past := time.Now().Add(-time.Hour)
+
+	subreddit := func(name string, watchers int) int64 {
+		var id int64
+		query := `INSERT INTO subreddits (subreddit_id, name, next_check_at, watcher_count) VALUES ($1, $1, $2, $3) RETURNING id`
+		require.NoError(t, tx.QueryRow(ctx, query, name, past, watchers).Scan(&id))
+		return id
+	}
+
+	watched := subreddit("cdwatched", 2)
+	unwatched := subreddit("cdunwatched", 0)
+
+	now := time.Now()
+	ids, err := repo.ClaimDue(ctx, now, now.Add(time.Minute), 1000)
+	require.NoError(t, err)
+
+	assert.Contains(t, ids, watched)
+	assert.NotContains(t, ids, unwatched)
+
+	// Claimed subreddits aren't due again until next.
+	ids, err = repo.ClaimDue(ctx, now, now.Add(time.Minute), 1000)
+	require.NoError(t, err)
+	assert.NotContains(t, ids, watched)
+}
+
+func TestPostgresSubreddit_CreateOrUpdate_Existing(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresSubreddit(t)
+
+	created := &domain.Subreddit{SubredditID: "t5_cu1", Name: "CaseUpsert"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, created))
+	require.NotZero(t, created.ID)
+
+	// Same Reddit ID.
+	again := &domain.Subreddit{SubredditID: "t5_cu1", Name: "caseupsert"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, again))
+	assert.Equal(t, created.ID, again.ID)
+
+	// Same name in another case, under a different Reddit ID.
+	renamed := &domain.Subreddit{SubredditID: "t5_cu2", Name: "CASEUPSERT"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, renamed))
+	assert.Equal(t, created.ID, renamed.ID)
+
+	got, err := repo.GetByName(ctx, "CaseUpsert")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, got.ID)
+}
+
+func TestPostgresSubreddit_CreateOrUpdate_New(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresSubreddit(t)
+
+	created := &domain.Subreddit{SubredditID: "t5_new1", Name: "FreshInsert"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, created))
+	require.NotZero(t, created.ID)
+
+	got, err := repo.GetByID(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "t5_new1", got.SubredditID)
+	assert.Equal(t, "freshinsert", got.Name)
+}
+
+func TestPostgresSubreddit_GetByIDs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresSubreddit(t)
+
+	first := &domain.Subreddit{SubredditID: "t5_ids1", Name: "byidsfirst"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, first))
+
+	second := &domain.Subreddit{SubredditID: "t5_ids2", Name: "byidssecond"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, second))
+
+	testCases := map[string]struct {
+		ids  []int64
+		want []int64
+	}{
+		"empty":   {[]int64{}, []int64{}},
+		"missing": {[]int64{0}, []int64{}},
+		"partial": {[]int64{first.ID, 0}, []int64{first.ID}},
+		"full":    {[]int64{first.ID, second.ID}, []int64{first.ID, second.ID}},
+	}
+
+	for scenario, tc := range testCases { //nolint:paralleltest
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			got, err := repo.GetByIDs(ctx, tc.ids)
+			require.NoError(t, err)
+
+			ids := []int64{}
+			for _, v := range got {
+				ids = append(ids, v.ID)
+			}
+			assert.ElementsMatch(t, tc.want, ids)
+		})
+	}
+}
+
+func TestPostgresSubreddit_LastChecked(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	repo := repository.NewPostgresSubreddit(tx)
+
+	// Far enough in the past that nothing outside this test is staler.
+	checkedAt := time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+	before := checkedAt.Add(time.Minute)
+
+	baseline, err := repo.CountStale(ctx, before)
+	require.NoError(t, err)
+
+	sr := &domain.Subreddit{SubredditID: "t5_stale", Name: "stalesubreddit"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, sr))
+	_, err = tx.Exec(ctx, `UPDATE subreddits SET watcher_count = 1 WHERE id = $1`, sr.ID)
+	require.NoError(t, err)
+
+	sr.LastCheckedAt = checkedAt
+	sr.LastPostID = "t3_newest"
+	require.NoError(t, repo.Update(ctx, sr))
+
+	got, err := repo.GetByID(ctx, sr.ID)
+	require.NoError(t, err)
+	assert.True(t, checkedAt.Equal(got.LastCheckedAt), "last checked at %v", got.LastCheckedAt)
+	assert.Equal(t, "t3_newest", got.LastPostID)
+
+	stale, err := repo.CountStale(ctx, before)
+	require.NoError(t, err)
+	assert.Equal(t, baseline+1, stale)
+
+	// Checking it again means it's no longer stale.
+	sr.LastCheckedAt = before.Add(time.Minute)
+	require.NoError(t, repo.Update(ctx, sr))
+
+	stale, err = repo.CountStale(ctx, before)
+	require.NoError(t, err)
+	assert.Equal(t, baseline, stale)
+}
@@ -2,7 +2,11 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
 
 	"github.com/christianselig/apollo-backend/internal/domain"
 )
@@ -30,6 +34,7 @@ func (p *postgresUserRepository) fetch(ctx context.Context, query string, args .
 			&u.UserID,
 			&u.Name,
 			&u.NextCheckAt,
+			&u.WatcherCount,
 		); err != nil {
 			return nil, err
 		}
@@ -40,7 +45,7 @@ func (p *postgresUserRepository) fetch(ctx context.Context, query string, args .
 
 func (p *postgresUserRepository) GetByID(ctx context.Context, id int64) (domain.User, error) {
 	query := `
-		SELECT id, user_id, name, next_check_at
+		SELECT id, user_id, name, next_check_at, watcher_count
 		FROM users
 		WHERE id = $1`
 
@@ -55,11 +60,24 @@ func (p *postgresUserRepository) GetByID(ctx context.Context, id int64) (domain.
 	return srs[0], nil
 }
 
+func (p *postgresUserRepository) GetByIDs(ctx context.Context, ids []int64) ([]domain.User, error) {
+	if len(ids) == 0 {
+		return []domain.User{}, nil
+	}
+
+	query := `
+		SELECT id, user_id, name, next_check_at, watcher_count
+		FROM users
+		WHERE id = ANY($1)`
+
+	return p.fetch(ctx, query, ids)
+}
+
 func (p *postgresUserRepository) GetByName(ctx context.Context, name string) (domain.User, error) {
 	query := `
-		SELECT id, user_id, name, next_check_at
+		SELECT id, user_id, name, next_check_at, watcher_count
 		FROM users
-		WHERE name = $1`
+		WHERE LOWER(name) = $1`
 
 	name = strings.ToLower(name)
 
@@ -82,16 +100,23 @@ func (p *postgresUserRepository) CreateOrUpdate(ctx context.Context, u *domain.U
 	query := `
 		INSERT INTO users (user_id, name, next_check_at)
 		VALUES ($1, $2, NOW())
-		ON CONFLICT(user_id) DO NOTHING
+		ON CONFLICT DO NOTHING
 		RETURNING id`
 
-	return p.conn.QueryRow(
-		ctx,
-		query,
-		u.UserID,
-		u.NormalizedName(),
-		u.NextCheckAt,
-	).Scan(&u.ID)
+	err := p.conn.QueryRow(ctx, query, u.UserID, u.NormalizedName()).Scan(&u.ID)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	// Already there, either under the same Reddit ID or the same name in another case.
+	query = `
+		SELECT id
+		FROM users
+		WHERE user_id = $1 OR LOWER(name) = $2
+		ORDER BY user_id = $1 DESC
+		LIMIT 1`
+
+	return p.conn.QueryRow(ctx, query, u.UserID, u.NormalizedName()).Scan(&u.ID)
 }
 
 func (p *postgresUserRepository) Delete(ctx context.Context, id int64) error {
@@ -99,3 +124,35 @@ func (p *postgresUserRepository) Delete(ctx context.Context, id int64) error {
 	_, err := p.conn.Exec(ctx, query, id)
 	return err
 }
+
+func (p *postgresUserRepository) ClaimDue(ctx context.Context, now, next time.Time, limit int) ([]int64, error) {
+	query := `
+		UPDATE users
+		SET next_check_at = $2
+		WHERE id IN (
+			SELECT id
+			FROM users
+			WHERE next_check_at < $1
+			AND watcher_count > 0
+			ORDER BY next_check_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT $3
+		)
+		RETURNING users.id`
+
+	rows, err := p.conn.Query(ctx, query, now, next, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
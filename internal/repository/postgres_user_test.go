@@ -0,0 +1,141 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func NewTestPostgresUser(t *testing.T) domain.UserRepository {
+	t.Helper()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+
+	repo := repository.NewPostgresUser(tx)
+
+	t.Cleanup(func() {
+		_ = tx.Rollback(ctx)
+	})
+
+	return repo
+}
+
+func TestPostgresUser_ClaimDue(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	repo := repository.NewPostgresUser(tx)
+	past := time.Now().Add(-time.Hour)
+
+	user := func(name string, watchers int) int64 {
+		var id int64
+		query := `INSERT INTO users (user_id, name, next_check_at, watcher_count) VALUES ($1, $1, $2, $3) RETURNING id`
+		require.NoError(t, tx.QueryRow(ctx, query, name, past, watchers).Scan(&id))
+		return id
+	}
+
+	watched := user("cdwatched", 1)
+	unwatched := user("cdunwatched", 0)
+
+	now := time.Now()
+	ids, err := repo.ClaimDue(ctx, now, now.Add(time.Minute), 1000)
+	require.NoError(t, err)
+
+	assert.Contains(t, ids, watched)
+	assert.NotContains(t, ids, unwatched)
+}
+
+func TestPostgresUser_CreateOrUpdate_Existing(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresUser(t)
+
+	created := &domain.User{UserID: "t2_cu1", Name: "CaseUpsert"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, created))
+	require.NotZero(t, created.ID)
+
+	// Same Reddit ID.
+	again := &domain.User{UserID: "t2_cu1", Name: "caseupsert"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, again))
+	assert.Equal(t, created.ID, again.ID)
+
+	// Same name in another case, under a different Reddit ID.
+	renamed := &domain.User{UserID: "t2_cu2", Name: "CASEUPSERT"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, renamed))
+	assert.Equal(t, created.ID, renamed.ID)
+
+	got, err := repo.GetByName(ctx, "CaseUpsert")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, got.ID)
+}
+
+func TestPostgresUser_CreateOrUpdate_New(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresUser(t)
+
+	created := &domain.User{UserID: "t2_new1", Name: "FreshInsert"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, created))
+	require.NotZero(t, created.ID)
+
+	got, err := repo.GetByID(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "t2_new1", got.UserID)
+	assert.Equal(t, "freshinsert", got.Name)
+}
+
+func TestPostgresUser_GetByIDs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresUser(t)
+
+	first := &domain.User{UserID: "t2_ids1", Name: "byidsfirst"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, first))
+
+	second := &domain.User{UserID: "t2_ids2", Name: "byidssecond"}
+	require.NoError(t, repo.CreateOrUpdate(ctx, second))
+
+	testCases := map[string]struct {
+		ids  []int64
+		want []int64
+	}{
+		"empty":   {[]int64{}, []int64{}},
+		"missing": {[]int64{0}, []int64{}},
+		"partial": {[]int64{first.ID, 0}, []int64{first.ID}},
+		"full":    {[]int64{first.ID, second.ID}, []int64{first.ID, second.ID}},
+	}
+
+	for scenario, tc := range testCases { //nolint:paralleltest
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			got, err := repo.GetByIDs(ctx, tc.ids)
+			require.NoError(t, err)
+
+			ids := []int64{}
+			for _, v := range got {
+				ids = append(ids, v.ID)
+			}
+			assert.ElementsMatch(t, tc.want, ids)
+		})
+	}
+}
@@ -2,8 +2,13 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+
 	"github.com/christianselig/apollo-backend/internal/domain"
 )
 
@@ -43,13 +48,20 @@ func (p *postgresWatcherRepository) fetch(ctx context.Context, query string, arg
 			&watcher.Flair,
 			&watcher.Domain,
 			&watcher.Hits,
+			&watcher.ExcludeNSFW,
+			&watcher.ExcludeCrossposts,
+			&watcher.Sound,
 			&watcher.Device.ID,
 			&watcher.Device.APNSToken,
 			&watcher.Device.Sandbox,
+			&watcher.Device.QuietHours.Start,
+			&watcher.Device.QuietHours.End,
+			&watcher.Device.QuietHours.Timezone,
 			&watcher.Account.ID,
 			&watcher.Account.AccountID,
 			&watcher.Account.AccessToken,
 			&watcher.Account.RefreshToken,
+			&watcher.Account.TokenExpiresAt,
 			&subredditLabel,
 			&userLabel,
 		); err != nil {
@@ -86,13 +98,20 @@ func (p *postgresWatcherRepository) GetByID(ctx context.Context, id int64) (doma
 			watchers.flair,
 			watchers.domain,
 			watchers.hits,
+			watchers.exclude_nsfw,
+			watchers.exclude_crossposts,
+			watchers.sound,
 			devices.id,
 			devices.apns_token,
 			devices.sandbox,
+			devices.quiet_hours_start,
+			devices.quiet_hours_end,
+			devices.quiet_hours_timezone,
 			accounts.id,
 			accounts.reddit_account_id,
 			accounts.access_token,
 			accounts.refresh_token,
+			COALESCE(accounts.token_expires_at, 'epoch'),
 			COALESCE(subreddits.name, '') AS subreddit_label,
 			COALESCE(users.name, '') AS user_label
 		FROM watchers
@@ -100,7 +119,7 @@ func (p *postgresWatcherRepository) GetByID(ctx context.Context, id int64) (doma
 		INNER JOIN accounts ON watchers.account_id = accounts.id
 		LEFT JOIN subreddits ON watchers.type IN(0,2) AND watchers.watchee_id = subreddits.id
 		LEFT JOIN users ON watchers.type = 1 AND watchers.watchee_id = users.id
-		WHERE watchers.id = $1`
+		WHERE watchers.id = $1 AND devices.is_deleted IS FALSE`
 
 	watchers, err := p.fetch(ctx, query, id)
 
@@ -131,13 +150,20 @@ func (p *postgresWatcherRepository) GetByTypeAndWatcheeID(ctx context.Context, t
 			watchers.flair,
 			watchers.domain,
 			watchers.hits,
+			watchers.exclude_nsfw,
+			watchers.exclude_crossposts,
+			watchers.sound,
 			devices.id,
 			devices.apns_token,
 			devices.sandbox,
+			devices.quiet_hours_start,
+			devices.quiet_hours_end,
+			devices.quiet_hours_timezone,
 			accounts.id,
 			accounts.reddit_account_id,
 			accounts.access_token,
 			accounts.refresh_token,
+			COALESCE(accounts.token_expires_at, 'epoch'),
 			COALESCE(subreddits.name, '') AS subreddit_label,
 			COALESCE(users.name, '') AS user_label
 		FROM watchers
@@ -149,7 +175,8 @@ func (p *postgresWatcherRepository) GetByTypeAndWatcheeID(ctx context.Context, t
 		WHERE watchers.type = $1 AND
 		watchers.watchee_id = $2 AND
 		devices_accounts.watcher_notifiable = TRUE AND
-		devices_accounts.global_mute = FALSE`
+		devices_accounts.global_mute = FALSE AND
+		devices.is_deleted IS FALSE`
 
 	return p.fetch(ctx, query, int64(typ), id)
 }
@@ -184,13 +211,20 @@ func (p *postgresWatcherRepository) GetByDeviceAPNSTokenAndAccountRedditID(ctx c
 			watchers.flair,
 			watchers.domain,
 			watchers.hits,
+			watchers.exclude_nsfw,
+			watchers.exclude_crossposts,
+			watchers.sound,
 			devices.id,
 			devices.apns_token,
 			devices.sandbox,
+			devices.quiet_hours_start,
+			devices.quiet_hours_end,
+			devices.quiet_hours_timezone,
 			accounts.id,
 			accounts.reddit_account_id,
 			accounts.access_token,
 			accounts.refresh_token,
+			COALESCE(accounts.token_expires_at, 'epoch'),
 			COALESCE(subreddits.name, '') AS subreddit_label,
 			COALESCE(users.name, '') AS user_label
 		FROM watchers
@@ -200,11 +234,62 @@ func (p *postgresWatcherRepository) GetByDeviceAPNSTokenAndAccountRedditID(ctx c
 		LEFT JOIN users ON watchers.type = 1 AND watchers.watchee_id = users.id
 		WHERE
 			devices.apns_token = $1 AND
+			devices.is_deleted IS FALSE AND
 			accounts.reddit_account_id = $2`
 
 	return p.fetch(ctx, query, apns, rid)
 }
 
+func (p *postgresWatcherRepository) Search(ctx context.Context, query string, limit int) ([]domain.Watcher, error) {
+	stmt := `
+		SELECT
+			watchers.id,
+			watchers.created_at,
+			watchers.last_notified_at,
+			watchers.label,
+			watchers.device_id,
+			watchers.account_id,
+			watchers.type,
+			watchers.watchee_id,
+			watchers.author,
+			watchers.subreddit,
+			watchers.upvotes,
+			watchers.keyword,
+			watchers.flair,
+			watchers.domain,
+			watchers.hits,
+			watchers.exclude_nsfw,
+			watchers.exclude_crossposts,
+			watchers.sound,
+			devices.id,
+			devices.apns_token,
+			devices.sandbox,
+			devices.quiet_hours_start,
+			devices.quiet_hours_end,
+			devices.quiet_hours_timezone,
+			accounts.id,
+			accounts.reddit_account_id,
+			accounts.access_token,
+			accounts.refresh_token,
+			COALESCE(accounts.token_expires_at, 'epoch'),
+			COALESCE(subreddits.name, '') AS subreddit_label,
+			COALESCE(users.name, '') AS user_label
+		FROM watchers
+		INNER JOIN devices ON watchers.device_id = devices.id
+		INNER JOIN accounts ON watchers.account_id = accounts.id
+		LEFT JOIN subreddits ON watchers.type IN(0,2) AND watchers.watchee_id = subreddits.id
+		LEFT JOIN users ON watchers.type = 1 AND watchers.watchee_id = users.id
+		WHERE (watchers.label ILIKE $1 OR watchers.keyword ILIKE $1 OR watchers.domain ILIKE $1) AND
+			devices.is_deleted IS FALSE
+		ORDER BY watchers.id
+		LIMIT $2`
+
+	return p.fetch(ctx, stmt, "%"+likeEscaper.Replace(query)+"%", limit)
+}
+
+// likeEscaper escapes the characters LIKE patterns treat specially, so they match literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
 func (p *postgresWatcherRepository) Create(ctx context.Context, watcher *domain.Watcher) error {
 	if err := watcher.Validate(); err != nil {
 		return err
@@ -214,27 +299,37 @@ func (p *postgresWatcherRepository) Create(ctx context.Context, watcher *domain.
 
 	query := `
 		INSERT INTO watchers
-			(created_at, last_notified_at, label, device_id, account_id, type, watchee_id, author, subreddit, upvotes, keyword, flair, domain)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			(created_at, last_notified_at, label, device_id, account_id, type, watchee_id, author, subreddit, upvotes, keyword, flair, domain,
+			exclude_nsfw, exclude_crossposts, sound)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 		RETURNING id`
 
-	return p.conn.QueryRow(
-		ctx,
-		query,
-		now,
-		now,
-		watcher.Label,
-		watcher.DeviceID,
-		watcher.AccountID,
-		int64(watcher.Type),
-		watcher.WatcheeID,
-		watcher.Author,
-		watcher.Subreddit,
-		watcher.Upvotes,
-		watcher.Keyword,
-		watcher.Flair,
-		watcher.Domain,
-	).Scan(&watcher.ID)
+	return pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(
+			ctx,
+			query,
+			now,
+			now,
+			watcher.Label,
+			watcher.DeviceID,
+			watcher.AccountID,
+			int64(watcher.Type),
+			watcher.WatcheeID,
+			watcher.Author,
+			watcher.Subreddit,
+			watcher.Upvotes,
+			watcher.Keyword,
+			watcher.Flair,
+			watcher.Domain,
+			watcher.ExcludeNSFW,
+			watcher.ExcludeCrossposts,
+			watcher.Sound,
+		).Scan(&watcher.ID); err != nil {
+			return err
+		}
+
+		return adjustWatcherCount(ctx, tx, watcher.Type, watcher.WatcheeID, 1)
+	})
 }
 
 func (p *postgresWatcherRepository) Update(ctx context.Context, watcher *domain.Watcher) error {
@@ -251,24 +346,49 @@ func (p *postgresWatcherRepository) Update(ctx context.Context, watcher *domain.
 			keyword = $6,
 			flair = $7,
 			domain = $8,
-			label = $9
+			label = $9,
+			exclude_nsfw = $10,
+			exclude_crossposts = $11,
+			sound = $12
 		WHERE id = $1`
 
-	_, err := p.conn.Exec(
-		ctx,
-		query,
-		watcher.ID,
-		watcher.WatcheeID,
-		watcher.Author,
-		watcher.Subreddit,
-		watcher.Upvotes,
-		watcher.Keyword,
-		watcher.Flair,
-		watcher.Domain,
-		watcher.Label,
-	)
+	return pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		var typ domain.WatcherType
+		var previous int64
+		if err := tx.QueryRow(ctx, `SELECT type, watchee_id FROM watchers WHERE id = $1 FOR UPDATE`, watcher.ID).Scan(&typ, &previous); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
 
-	return err
+		if _, err := tx.Exec(
+			ctx,
+			query,
+			watcher.ID,
+			watcher.WatcheeID,
+			watcher.Author,
+			watcher.Subreddit,
+			watcher.Upvotes,
+			watcher.Keyword,
+			watcher.Flair,
+			watcher.Domain,
+			watcher.Label,
+			watcher.ExcludeNSFW,
+			watcher.ExcludeCrossposts,
+			watcher.Sound,
+		); err != nil {
+			return err
+		}
+
+		if previous == watcher.WatcheeID {
+			return nil
+		}
+		if err := adjustWatcherCount(ctx, tx, typ, previous, -1); err != nil {
+			return err
+		}
+		return adjustWatcherCount(ctx, tx, typ, watcher.WatcheeID, 1)
+	})
 }
 
 func (p *postgresWatcherRepository) IncrementHits(ctx context.Context, id int64) error {
@@ -278,13 +398,89 @@ func (p *postgresWatcherRepository) IncrementHits(ctx context.Context, id int64)
 }
 
 func (p *postgresWatcherRepository) Delete(ctx context.Context, id int64) error {
-	query := `DELETE FROM watchers WHERE id = $1`
-	_, err := p.conn.Exec(ctx, query, id)
-	return err
+	query := `DELETE FROM watchers WHERE id = $1 RETURNING type, watchee_id`
+
+	return pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		var typ domain.WatcherType
+		var watcheeID int64
+		if err := tx.QueryRow(ctx, query, id).Scan(&typ, &watcheeID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+
+		return adjustWatcherCount(ctx, tx, typ, watcheeID, -1)
+	})
 }
 
 func (p *postgresWatcherRepository) DeleteByTypeAndWatcheeID(ctx context.Context, typ domain.WatcherType, id int64) error {
 	query := `DELETE FROM watchers WHERE type = $1 AND watchee_id = $2`
-	_, err := p.conn.Exec(ctx, query, int64(typ), id)
+
+	return pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		res, err := tx.Exec(ctx, query, int64(typ), id)
+		if err != nil {
+			return err
+		}
+
+		return adjustWatcherCount(ctx, tx, typ, id, -res.RowsAffected())
+	})
+}
+
+// RecountWatchers recomputes every subreddit's and user's watcher count from the
+// watchers of live devices, returning how many counts were corrected.
+func (p *postgresWatcherRepository) RecountWatchers(ctx context.Context) (int64, error) {
+	var total int64
+
+	for _, recount := range []struct {
+		table string
+		types string
+	}{
+		{"subreddits", "0, 2"},
+		{"users", "1"},
+	} {
+		query := fmt.Sprintf(`
+			UPDATE %[1]s
+			SET watcher_count = counts.total
+			FROM (
+				SELECT %[1]s.id, COUNT(live.id) AS total
+				FROM %[1]s
+				LEFT JOIN (
+					SELECT watchers.id, watchers.watchee_id
+					FROM watchers
+					INNER JOIN devices ON watchers.device_id = devices.id
+					WHERE watchers.type IN (%[2]s) AND devices.is_deleted IS FALSE
+				) AS live ON live.watchee_id = %[1]s.id
+				GROUP BY %[1]s.id
+			) AS counts
+			WHERE %[1]s.id = counts.id AND %[1]s.watcher_count IS DISTINCT FROM counts.total`, recount.table, recount.types)
+
+		res, err := p.conn.Exec(ctx, query)
+		if err != nil {
+			return total, err
+		}
+		total += res.RowsAffected()
+	}
+
+	return total, nil
+}
+
+// adjustWatcherCount moves the watcher count of whatever a watcher of type typ watches
+// by delta. Subreddit and trending watchers both count towards their subreddit.
+//
+// Watchers that go away with their device or account through a cascade aren't
+// accounted for here; RecountWatchers picks those up.
+func adjustWatcherCount(ctx context.Context, tx pgx.Tx, typ domain.WatcherType, watcheeID int64, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+
+	table := "subreddits"
+	if typ == domain.UserWatcher {
+		table = "users"
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET watcher_count = GREATEST(watcher_count + $2, 0) WHERE id = $1`, table)
+	_, err := tx.Exec(ctx, query, watcheeID, delta)
 	return err
 }
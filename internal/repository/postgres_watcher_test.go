@@ -3,10 +3,12 @@ package repository_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/christianselig/apollo-backend/internal/domain"
 	"github.com/christianselig/apollo-backend/internal/repository"
 	"github.com/christianselig/apollo-backend/internal/testhelper"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -31,3 +33,307 @@ func NewTestPostgresWatcher(t *testing.T) domain.WatcherRepository {
 func TestPostgresWatcher_GetByID(t *testing.T) {
 	t.Parallel()
 }
+
+func TestPostgresWatcher_GetByTypeAndWatcheeID_Muted(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	accRepo := repository.NewPostgresAccount(tx)
+	devRepo := repository.NewPostgresDevice(tx)
+	repo := repository.NewPostgresWatcher(tx)
+
+	dev := &domain.Device{APNSToken: testToken, GracePeriodExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, devRepo.Create(ctx, dev))
+
+	acc := &domain.Account{Username: "watching", AccountID: "watching", TokenExpiresAt: time.Now()}
+	require.NoError(t, accRepo.Create(ctx, acc))
+	require.NoError(t, accRepo.Associate(ctx, acc, dev))
+
+	types := []domain.WatcherType{domain.SubredditWatcher, domain.UserWatcher, domain.TrendingWatcher}
+	for _, typ := range types {
+		require.NoError(t, repo.Create(ctx, &domain.Watcher{Label: typ.String(), DeviceID: dev.ID, AccountID: acc.ID, Type: typ, WatcheeID: 1}))
+	}
+
+	tests := []struct {
+		name     string
+		watcher  bool
+		global   bool
+		expected int
+	}{
+		{"notifiable", true, false, 1},
+		{"watchers muted", false, false, 0},
+		{"globally muted", true, true, 0},
+	}
+
+	for _, tc := range tests {
+		require.NoError(t, devRepo.SetNotifiable(ctx, dev, acc, true, tc.watcher, tc.global))
+
+		for typ, fetch := range map[domain.WatcherType]func(context.Context, int64) ([]domain.Watcher, error){
+			domain.SubredditWatcher: repo.GetBySubredditID,
+			domain.UserWatcher:      repo.GetByUserID,
+			domain.TrendingWatcher:  repo.GetByTrendingSubredditID,
+		} {
+			watchers, err := fetch(ctx, 1)
+			require.NoError(t, err)
+			assert.Len(t, watchers, tc.expected, "%s: %s", tc.name, typ)
+		}
+
+		devs, err := devRepo.GetWatcherNotifiableByAccountID(ctx, acc.ID)
+		require.NoError(t, err)
+		assert.Len(t, devs, tc.expected, tc.name)
+	}
+}
+
+func TestPostgresWatcher_ContentFilters(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	accRepo := repository.NewPostgresAccount(tx)
+	devRepo := repository.NewPostgresDevice(tx)
+	repo := repository.NewPostgresWatcher(tx)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, devRepo.Create(ctx, dev))
+
+	acc := &domain.Account{Username: "filtering", AccountID: "filtering", TokenExpiresAt: time.Now()}
+	require.NoError(t, accRepo.Create(ctx, acc))
+
+	watcher := &domain.Watcher{Label: "sfw pics", DeviceID: dev.ID, AccountID: acc.ID, WatcheeID: 1, ExcludeNSFW: true}
+	require.NoError(t, repo.Create(ctx, watcher))
+
+	got, err := repo.GetByID(ctx, watcher.ID)
+	require.NoError(t, err)
+	assert.True(t, got.ExcludeNSFW)
+	assert.False(t, got.ExcludeCrossposts)
+
+	got.ExcludeNSFW = false
+	got.ExcludeCrossposts = true
+	require.NoError(t, repo.Update(ctx, &got))
+
+	got, err = repo.GetByID(ctx, watcher.ID)
+	require.NoError(t, err)
+	assert.False(t, got.ExcludeNSFW)
+	assert.True(t, got.ExcludeCrossposts)
+}
+
+func TestPostgresWatcher_WatcherCount(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	accRepo := repository.NewPostgresAccount(tx)
+	devRepo := repository.NewPostgresDevice(tx)
+	srRepo := repository.NewPostgresSubreddit(tx)
+	userRepo := repository.NewPostgresUser(tx)
+	repo := repository.NewPostgresWatcher(tx)
+
+	dev := &domain.Device{APNSToken: testToken, GracePeriodExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, devRepo.Create(ctx, dev))
+
+	acc := &domain.Account{Username: "counting", AccountID: "counting", TokenExpiresAt: time.Now()}
+	require.NoError(t, accRepo.Create(ctx, acc))
+
+	first := &domain.Subreddit{SubredditID: "wcfirst", Name: "wcfirst"}
+	require.NoError(t, srRepo.CreateOrUpdate(ctx, first))
+	second := &domain.Subreddit{SubredditID: "wcsecond", Name: "wcsecond"}
+	require.NoError(t, srRepo.CreateOrUpdate(ctx, second))
+
+	var userID int64
+	require.NoError(t, tx.QueryRow(ctx, `INSERT INTO users (user_id, name) VALUES ('wcuser', 'wcuser') RETURNING id`).Scan(&userID))
+
+	subredditCount := func(id int64) int64 {
+		sr, err := srRepo.GetByID(ctx, id)
+		require.NoError(t, err)
+		return sr.WatcherCount
+	}
+	userCount := func(id int64) int64 {
+		u, err := userRepo.GetByID(ctx, id)
+		require.NoError(t, err)
+		return u.WatcherCount
+	}
+	watcher := func(typ domain.WatcherType, watcheeID int64) *domain.Watcher {
+		w := &domain.Watcher{Label: typ.String(), DeviceID: dev.ID, AccountID: acc.ID, Type: typ, WatcheeID: watcheeID}
+		require.NoError(t, repo.Create(ctx, w))
+		return w
+	}
+
+	keyword := watcher(domain.SubredditWatcher, first.ID)
+	trending := watcher(domain.TrendingWatcher, first.ID)
+	watcher(domain.UserWatcher, userID)
+	assert.Equal(t, int64(2), subredditCount(first.ID))
+	assert.Equal(t, int64(1), userCount(userID))
+
+	// Moving a watcher to another subreddit moves its count along.
+	keyword.WatcheeID = second.ID
+	require.NoError(t, repo.Update(ctx, keyword))
+	assert.Equal(t, int64(1), subredditCount(first.ID))
+	assert.Equal(t, int64(1), subredditCount(second.ID))
+
+	require.NoError(t, repo.Delete(ctx, trending.ID))
+	assert.Equal(t, int64(0), subredditCount(first.ID))
+
+	// Deleting a watcher twice doesn't count it twice.
+	require.NoError(t, repo.Delete(ctx, trending.ID))
+	assert.Equal(t, int64(0), subredditCount(first.ID))
+
+	require.NoError(t, repo.DeleteByTypeAndWatcheeID(ctx, domain.UserWatcher, userID))
+	assert.Equal(t, int64(0), userCount(userID))
+}
+
+func TestPostgresWatcher_RecountWatchers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	accRepo := repository.NewPostgresAccount(tx)
+	devRepo := repository.NewPostgresDevice(tx)
+	srRepo := repository.NewPostgresSubreddit(tx)
+	repo := repository.NewPostgresWatcher(tx)
+
+	dev := &domain.Device{APNSToken: testToken, GracePeriodExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, devRepo.Create(ctx, dev))
+
+	acc := &domain.Account{Username: "recount", AccountID: "recount", TokenExpiresAt: time.Now()}
+	require.NoError(t, accRepo.Create(ctx, acc))
+
+	sr := &domain.Subreddit{SubredditID: "wcrecount", Name: "wcrecount"}
+	require.NoError(t, srRepo.CreateOrUpdate(ctx, sr))
+
+	w := &domain.Watcher{Label: "recount", DeviceID: dev.ID, AccountID: acc.ID, Type: domain.SubredditWatcher, WatcheeID: sr.ID}
+	require.NoError(t, repo.Create(ctx, w))
+
+	// Watchers that cascade away with their device leave the count behind...
+	_, err = tx.Exec(ctx, `DELETE FROM devices WHERE id = $1`, dev.ID)
+	require.NoError(t, err)
+
+	got, err := srRepo.GetByID(ctx, sr.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), got.WatcherCount)
+
+	// ...until they're recounted.
+	corrected, err := repo.RecountWatchers(ctx)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, corrected, int64(1))
+
+	got, err = srRepo.GetByID(ctx, sr.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), got.WatcherCount)
+}
+
+func TestPostgresWatcher_Search(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	accRepo := repository.NewPostgresAccount(tx)
+	devRepo := repository.NewPostgresDevice(tx)
+	repo := repository.NewPostgresWatcher(tx)
+
+	dev := &domain.Device{APNSToken: testToken, GracePeriodExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, devRepo.Create(ctx, dev))
+
+	acc := &domain.Account{Username: "searching", AccountID: "searching", TokenExpiresAt: time.Now()}
+	require.NoError(t, accRepo.Create(ctx, acc))
+
+	watchers := map[string]*domain.Watcher{
+		"label":   {Label: "Zzsearch deals"},
+		"keyword": {Label: "keyword", Keyword: "zzsearch"},
+		"domain":  {Label: "domain", Domain: "zzsearch.example"},
+		"percent": {Label: "zz100%off"},
+		"none":    {Label: "nothing to see"},
+	}
+	for _, w := range watchers {
+		w.DeviceID, w.AccountID, w.Type, w.WatcheeID = dev.ID, acc.ID, domain.SubredditWatcher, 1
+		require.NoError(t, repo.Create(ctx, w))
+	}
+
+	ids := func(ws []domain.Watcher) []int64 {
+		var ret []int64
+		for _, w := range ws {
+			ret = append(ret, w.ID)
+		}
+		return ret
+	}
+
+	found, err := repo.Search(ctx, "ZZSEARCH", 10)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int64{watchers["label"].ID, watchers["keyword"].ID, watchers["domain"].ID}, ids(found))
+
+	found, err = repo.Search(ctx, "zzsearch", 2)
+	require.NoError(t, err)
+	assert.Len(t, found, 2)
+
+	// LIKE wildcards in the query are matched literally.
+	found, err = repo.Search(ctx, "zz100%", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{watchers["percent"].ID}, ids(found))
+
+	found, err = repo.Search(ctx, "zz1_0", 10)
+	require.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+func TestPostgresWatcher_Sound(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	accRepo := repository.NewPostgresAccount(tx)
+	devRepo := repository.NewPostgresDevice(tx)
+	repo := repository.NewPostgresWatcher(tx)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, devRepo.Create(ctx, dev))
+
+	acc := &domain.Account{Username: "sounding", AccountID: "sounding", TokenExpiresAt: time.Now()}
+	require.NoError(t, accRepo.Create(ctx, acc))
+
+	watcher := &domain.Watcher{Label: "deals", DeviceID: dev.ID, AccountID: acc.ID, WatcheeID: 1, Sound: "default"}
+	require.NoError(t, repo.Create(ctx, watcher))
+
+	got, err := repo.GetByID(ctx, watcher.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "default", got.Sound)
+
+	got.Sound = ""
+	require.NoError(t, repo.Update(ctx, &got))
+
+	got, err = repo.GetByID(ctx, watcher.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.DefaultNotificationSound, got.NotificationSound())
+
+	got.Sound = "airhorn.wav"
+	assert.Error(t, repo.Update(ctx, &got))
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/require"
 )
 
@@ -32,3 +33,26 @@ func NewTestPgxConn(t *testing.T) *pgx.Conn {
 
 	return conn
 }
+
+func NewTestPgxPool(t *testing.T, maxConns int) *pgxpool.Pool {
+	t.Helper()
+
+	ctx := context.Background()
+
+	connString := os.Getenv("DATABASE_URL")
+
+	if connString == "" {
+		t.Skipf("skipping due to missing environment variable %v", "DATABASE_URL")
+	}
+
+	config, err := pgxpool.ParseConfig(connString)
+	require.NoError(t, err)
+	config.MaxConns = int32(maxConns)
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	require.NoError(t, err)
+
+	t.Cleanup(pool.Close)
+
+	return pool
+}
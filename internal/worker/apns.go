@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/payload"
+	"github.com/sideshow/apns2/token"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+// APNs advertises up to 1000 concurrent streams per HTTP/2 connection.
+const defaultAPNSStreamsPerConnection = 1000
+
+// apnsClientPool spreads consumers over a fixed number of HTTP/2 connections
+// per APNs environment, rather than having every consumer dial its own.
+type apnsClientPool struct {
+	productionClients  []*apns2.Client
+	developmentClients []*apns2.Client
+}
+
+func newAPNSClientPool(tok *token.Token, consumers int) *apnsClientPool {
+	conns := apnsConnections(consumers, apnsStreamsPerConnection())
+
+	return &apnsClientPool{
+		productionClients:  newAPNSClients(tok, apns2.HostProduction, conns),
+		developmentClients: newAPNSClients(tok, apns2.HostDevelopment, conns),
+	}
+}
+
+func (p *apnsClientPool) production(tag int) *apns2.Client {
+	return p.productionClients[tag%len(p.productionClients)]
+}
+
+func (p *apnsClientPool) development(tag int) *apns2.Client {
+	return p.developmentClients[tag%len(p.developmentClients)]
+}
+
+// newAPNSClients builds n clients, each backed by its own HTTP/2 transport. Streams
+// are capped at what the server advertises so load is spread across connections
+// instead of the transport silently dialing more.
+func newAPNSClients(tok *token.Token, host string, n int) []*apns2.Client {
+	clients := make([]*apns2.Client, n)
+	for i := range clients {
+		clients[i] = &apns2.Client{
+			Token: tok,
+			Host:  host,
+			HTTPClient: &http.Client{
+				Transport: &http2.Transport{
+					DialTLS:                    apns2.DialTLS,
+					ReadIdleTimeout:            apns2.ReadIdleTimeout,
+					StrictMaxConcurrentStreams: true,
+				},
+				Timeout: apns2.HTTPClientTimeout,
+			},
+		}
+	}
+	return clients
+}
+
+// apnsPriority is the APNs delivery priority for a notification. Low priority ones are
+// sent at the power considerate priority, leaving the device to pick when to deliver.
+func apnsPriority(np domain.NotificationPriority) int {
+	if np == domain.NotificationPriorityLow {
+		return apns2.PriorityLow
+	}
+	return apns2.PriorityHigh
+}
+
+// interruptionLevel is how much a notification may interrupt the user, which decides
+// whether it breaks through Focus modes.
+func interruptionLevel(np domain.NotificationPriority) payload.EInterruptionLevel {
+	if np == domain.NotificationPriorityHigh {
+		return payload.InterruptionLevelTimeSensitive
+	}
+	return payload.InterruptionLevelActive
+}
+
+// pushWithSpan sends a notification inside an "apns:push" span, so traces don't stop
+// right before the step users actually see.
+func pushWithSpan(ctx context.Context, tracer trace.Tracer, client *apns2.Client, notification *apns2.Notification) (*apns2.Response, error) {
+	ctx, span := tracer.Start(ctx, "apns:push")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("apns.device_token", domain.ObfuscateToken(notification.DeviceToken)),
+		attribute.String("apns.topic", notification.Topic),
+		attribute.String("apns.push_type", string(notification.PushType)),
+	)
+
+	res, err := client.PushWithContext(ctx, notification)
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to push notification")
+		span.RecordError(err)
+		return res, err
+	}
+
+	span.SetAttributes(
+		attribute.Int("apns.response.status", res.StatusCode),
+		attribute.String("apns.response.reason", res.Reason),
+	)
+
+	if !res.Sent() {
+		span.SetStatus(codes.Error, "notification not sent")
+	}
+
+	return res, nil
+}
+
+// reasonBadEnvironmentKeyInToken isn't among apns2's reasons yet.
+const reasonBadEnvironmentKeyInToken = "BadEnvironmentKeyInToken"
+
+// wrongEnvironment reports whether APNs refused a token because it belongs to the other
+// environment, as happens when a device moves between TestFlight and the App Store.
+func wrongEnvironment(res *apns2.Response) bool {
+	switch res.Reason {
+	case apns2.ReasonBadDeviceToken, apns2.ReasonDeviceTokenNotForTopic, reasonBadEnvironmentKeyInToken:
+		return true
+	default:
+		return false
+	}
+}
+
+// dualPush sends a notification through primary, the environment the device is
+// believed to be in. If APNs says the token belongs to the other environment, it tries
+// again through fallback, reporting switched when that one took it.
+func dualPush(ctx context.Context, tracer trace.Tracer, primary, fallback *apns2.Client, notification *apns2.Notification) (res *apns2.Response, switched bool, err error) {
+	res, err = pushWithSpan(ctx, tracer, primary, notification)
+	if err != nil || res.Sent() || !wrongEnvironment(res) {
+		return res, false, err
+	}
+
+	fres, err := pushWithSpan(ctx, tracer, fallback, notification)
+	if err != nil || !fres.Sent() {
+		// The first answer says more about why the device can't be reached.
+		return res, false, nil
+	}
+	return fres, true, nil
+}
+
+func apnsConnections(consumers, streamsPerConn int) int {
+	if streamsPerConn <= 0 {
+		streamsPerConn = defaultAPNSStreamsPerConnection
+	}
+
+	conns := (consumers + streamsPerConn - 1) / streamsPerConn
+	if conns < 1 {
+		conns = 1
+	}
+	return conns
+}
+
+func apnsStreamsPerConnection() int {
+	n, err := strconv.Atoi(os.Getenv("APNS_STREAMS_PER_CONNECTION"))
+	if err != nil || n <= 0 {
+		return defaultAPNSStreamsPerConnection
+	}
+	return n
+}
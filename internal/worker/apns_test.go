@@ -0,0 +1,199 @@
+package worker_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/payload"
+	"github.com/sideshow/apns2/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/net/http2"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/worker"
+)
+
+func TestAPNSConnections(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		consumers      int
+		streamsPerConn int
+		want           int
+	}{
+		"no consumers":          {0, 1000, 1},
+		"fewer than a conn":     {64, 1000, 1},
+		"exactly one conn":      {1000, 1000, 1},
+		"spills into second":    {1001, 1000, 2},
+		"small stream limit":    {512, 100, 6},
+		"invalid stream limit":  {2048, 0, 3},
+		"negative stream limit": {10, -1, 1},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, worker.APNSConnections(tc.consumers, tc.streamsPerConn))
+		})
+	}
+}
+
+// newMockAPNSServer returns an HTTP/2 server that advertises the given stream
+// limit and takes latency to answer each push.
+func newMockAPNSServer(tb testing.TB, streams uint32, latency time.Duration) *httptest.Server {
+	tb.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(latency)
+		w.Header().Set("apns-id", "00000000-0000-0000-0000-000000000000")
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.EnableHTTP2 = true
+	require.NoError(tb, http2.ConfigureServer(srv.Config, &http2.Server{MaxConcurrentStreams: streams}))
+	srv.StartTLS()
+	tb.Cleanup(srv.Close)
+
+	return srv
+}
+
+func BenchmarkAPNSClients(b *testing.B) {
+	srv := newMockAPNSServer(b, 8, 2*time.Millisecond)
+
+	cfg := srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	cfg.NextProtos = []string{"h2"}
+
+	dial := apns2.DialTLS
+	apns2.DialTLS = func(network, addr string, _ *tls.Config) (net.Conn, error) {
+		return tls.Dial(network, addr, cfg)
+	}
+	b.Cleanup(func() { apns2.DialTLS = dial })
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(b, err)
+	tok := &token.Token{AuthKey: key, KeyID: "KEYID", TeamID: "TEAMID"}
+
+	for _, conns := range []int{1, 2, 4, 8} {
+		conns := conns
+
+		b.Run(fmt.Sprintf("connections=%d", conns), func(b *testing.B) {
+			clients := worker.NewAPNSClients(tok, srv.URL, conns)
+
+			var tag int64
+			b.SetParallelism(16)
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				client := clients[int(atomic.AddInt64(&tag, 1))%len(clients)]
+				n := &apns2.Notification{DeviceToken: "abc", Topic: "com.christianselig.Apollo", Payload: []byte(`{}`)}
+
+				for pb.Next() {
+					res, err := client.PushWithContext(context.Background(), n)
+					if err != nil || !res.Sent() {
+						b.Errorf("push failed: %v", err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestNotificationPriority(t *testing.T) {
+	t.Parallel()
+
+	tt := map[domain.NotificationPriority]struct {
+		priority int
+		level    payload.EInterruptionLevel
+	}{
+		domain.NotificationPriorityLow:    {apns2.PriorityLow, payload.InterruptionLevelActive},
+		domain.NotificationPriorityNormal: {apns2.PriorityHigh, payload.InterruptionLevelActive},
+		domain.NotificationPriorityHigh:   {apns2.PriorityHigh, payload.InterruptionLevelTimeSensitive},
+	}
+
+	for np, tc := range tt {
+		np, tc := np, tc
+		t.Run(np.String(), func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.priority, worker.APNSPriority(np))
+			assert.Equal(t, tc.level, worker.InterruptionLevel(np))
+		})
+	}
+}
+
+// newEnvironmentServer is an APNs environment that takes the tokens in accepts and
+// turns everything else away with reason.
+func newEnvironmentServer(t *testing.T, reason string, accepts ...string) (*apns2.Client, *int32) {
+	t.Helper()
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		for _, tok := range accepts {
+			if r.URL.Path == "/3/device/"+tok {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprintf(w, `{"reason": %q}`, reason)
+	}))
+	t.Cleanup(srv.Close)
+
+	return &apns2.Client{Host: srv.URL, HTTPClient: srv.Client()}, &calls
+}
+
+func TestDualPush(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		reason     string
+		primary    []string
+		fallback   []string
+		sent       bool
+		switched   bool
+		fallbacks  int32
+		wantReason string
+	}{
+		"sent through primary":       {apns2.ReasonBadDeviceToken, []string{"abc"}, nil, true, false, 0, ""},
+		"bad token falls back":       {apns2.ReasonBadDeviceToken, nil, []string{"abc"}, true, true, 1, ""},
+		"not for topic falls back":   {apns2.ReasonDeviceTokenNotForTopic, nil, []string{"abc"}, true, true, 1, ""},
+		"bad environment falls back": {"BadEnvironmentKeyInToken", nil, []string{"abc"}, true, true, 1, ""},
+		"unregistered":               {apns2.ReasonUnregistered, nil, []string{"abc"}, false, false, 0, apns2.ReasonUnregistered},
+		"unknown in both":            {apns2.ReasonBadDeviceToken, nil, nil, false, false, 1, apns2.ReasonBadDeviceToken},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			primary, _ := newEnvironmentServer(t, tc.reason, tc.primary...)
+			fallback, fallbacks := newEnvironmentServer(t, apns2.ReasonBadDeviceToken, tc.fallback...)
+
+			notification := &apns2.Notification{DeviceToken: "abc", Topic: "com.christianselig.Apollo"}
+			res, switched, err := worker.DualPush(context.Background(), otel.Tracer("test"), primary, fallback, notification)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.sent, res.Sent())
+			assert.Equal(t, tc.switched, switched)
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.fallbacks, atomic.LoadInt32(fallbacks))
+		})
+	}
+}
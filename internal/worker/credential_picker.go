@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+// credentialPickTTL is how long an account's last pick is remembered. Anything idle for
+// longer is as good as never picked.
+const credentialPickTTL = time.Hour
+
+// credentialExpiryMargin is how long an access token has to stay valid for its
+// account to be preferred, so it doesn't expire halfway through a job.
+const credentialExpiryMargin = 5 * time.Minute
+
+// pickCredentialScript hands out the least recently picked account that isn't rate
+// limited. A global counter orders the picks, so ties only happen between accounts that
+// were never picked and go to the first one in the list. KEYS[1] is the counter,
+// followed by each account's rate limit and last pick keys. Returns the 0-based index
+// of the account, or -1 when all of them are rate limited.
+var pickCredentialScript = redis.NewScript(`
+	local best, bestSeen = -1, nil
+	for i = 2, #KEYS, 2 do
+		if redis.call("EXISTS", KEYS[i]) == 0 then
+			local seen = tonumber(redis.call("GET", KEYS[i + 1]) or "0")
+			if bestSeen == nil or seen < bestSeen then
+				best, bestSeen = i, seen
+			end
+		end
+	end
+
+	if best == -1 then
+		return -1
+	end
+
+	local clock = redis.call("INCR", KEYS[1])
+	redis.call("SET", KEYS[best + 1], clock, "EX", ARGV[1])
+	return (best - 2) / 2
+`)
+
+// CredentialPicker spreads Reddit reads fairly across the accounts of everyone watching
+// a subreddit or user, rather than leaning on whichever account a random pick favours.
+type CredentialPicker struct {
+	redis *redis.Client
+}
+
+func NewCredentialPicker(redis *redis.Client) *CredentialPicker {
+	return &CredentialPicker{redis}
+}
+
+// Pick returns the index of the account in accountIDs (Reddit account ids) that went
+// the longest without being picked, skipping rate limited ones. It returns
+// reddit.ErrRateLimited when every account is rate limited.
+func (cp *CredentialPicker) Pick(ctx context.Context, accountIDs []string) (int, error) {
+	keys := make([]string, 0, 2*len(accountIDs)+1)
+	keys = append(keys, "reddit:credentials:clock")
+	for _, id := range accountIDs {
+		keys = append(keys, fmt.Sprintf("reddit:%s:ratelimited", id), fmt.Sprintf("reddit:%s:last-picked", id))
+	}
+
+	i, err := pickCredentialScript.Run(ctx, cp.redis, keys, int(credentialPickTTL.Seconds())).Int()
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		return 0, reddit.ErrRateLimited
+	}
+
+	return i, nil
+}
+
+// pickWatcher picks the watcher whose account to make the next request with, preferring
+// accounts whose access token won't expire mid-job. When none of those are usable it
+// falls back to the other accounts. If Redis can't be asked, it picks at random so the
+// job can still go ahead.
+func (cp *CredentialPicker) pickWatcher(ctx context.Context, watchers []domain.Watcher, now time.Time) (domain.Watcher, error) {
+	fresh := make([]domain.Watcher, 0, len(watchers))
+	for _, watcher := range watchers {
+		if watcher.Account.TokenExpiresAt.After(now.Add(credentialExpiryMargin)) {
+			fresh = append(fresh, watcher)
+		}
+	}
+
+	if len(fresh) > 0 {
+		watcher, err := cp.pickFrom(ctx, fresh)
+		if err != reddit.ErrRateLimited || len(fresh) == len(watchers) {
+			return watcher, err
+		}
+	}
+
+	return cp.pickFrom(ctx, watchers)
+}
+
+func (cp *CredentialPicker) pickFrom(ctx context.Context, watchers []domain.Watcher) (domain.Watcher, error) {
+	ids := make([]string, len(watchers))
+	for i, watcher := range watchers {
+		ids[i] = watcher.Account.AccountID
+	}
+
+	i, err := cp.Pick(ctx, ids)
+	switch err {
+	case nil:
+		return watchers[i], nil
+	case reddit.ErrRateLimited:
+		return domain.Watcher{}, err
+	default:
+		return watchers[rand.Intn(len(watchers))], nil
+	}
+}
@@ -0,0 +1,172 @@
+package worker_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+	"github.com/christianselig/apollo-backend/internal/worker"
+)
+
+func testAccountIDs(t *testing.T, client *redis.Client, n int) []string {
+	t.Helper()
+
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%s-%d", t.Name(), i)
+	}
+
+	t.Cleanup(func() {
+		for _, id := range ids {
+			_ = client.Del(context.Background(),
+				fmt.Sprintf("reddit:%s:last-picked", id),
+				fmt.Sprintf("reddit:%s:ratelimited", id),
+			).Err()
+		}
+	})
+
+	return ids
+}
+
+func TestCredentialPicker_Even(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testhelper.NewTestRedisClient(t)
+	ids := testAccountIDs(t, client, 4)
+
+	cp := worker.NewCredentialPicker(client)
+
+	picks := map[string]int{}
+	for i := 0; i < 100; i++ {
+		idx, err := cp.Pick(ctx, ids)
+		require.NoError(t, err)
+		picks[ids[idx]]++
+	}
+
+	for _, id := range ids {
+		assert.Equal(t, 25, picks[id], id)
+	}
+}
+
+func TestCredentialPicker_SkipsRateLimited(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testhelper.NewTestRedisClient(t)
+	ids := testAccountIDs(t, client, 3)
+
+	require.NoError(t, client.Set(ctx, fmt.Sprintf("reddit:%s:ratelimited", ids[1]), true, 0).Err())
+
+	cp := worker.NewCredentialPicker(client)
+
+	picks := map[string]int{}
+	for i := 0; i < 10; i++ {
+		idx, err := cp.Pick(ctx, ids)
+		require.NoError(t, err)
+		picks[ids[idx]]++
+	}
+
+	assert.Equal(t, map[string]int{ids[0]: 5, ids[2]: 5}, picks)
+
+	for _, id := range ids {
+		require.NoError(t, client.Set(ctx, fmt.Sprintf("reddit:%s:ratelimited", id), true, 0).Err())
+	}
+
+	_, err := cp.Pick(ctx, ids)
+	assert.ErrorIs(t, err, reddit.ErrRateLimited)
+}
+
+func TestCredentialPicker_PickWatcher(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testhelper.NewTestRedisClient(t)
+	ids := testAccountIDs(t, client, 3)
+
+	now := time.Now()
+	watchers := []domain.Watcher{
+		{ID: 1, Account: domain.Account{AccountID: ids[0], TokenExpiresAt: now.Add(-time.Minute)}},
+		{ID: 2, Account: domain.Account{AccountID: ids[1], TokenExpiresAt: now.Add(time.Minute)}},
+		{ID: 3, Account: domain.Account{AccountID: ids[2], TokenExpiresAt: now.Add(time.Hour)}},
+	}
+	cp := worker.NewCredentialPicker(client)
+
+	// Only the account whose token outlives the job gets used.
+	for i := 0; i < 5; i++ {
+		watcher, err := worker.PickWatcher(cp, ctx, watchers, now)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), watcher.ID)
+	}
+
+	// Once it's rate limited, the others take over.
+	require.NoError(t, client.Set(ctx, fmt.Sprintf("reddit:%s:ratelimited", ids[2]), true, 0).Err())
+
+	picked := map[int64]int{}
+	for i := 0; i < 4; i++ {
+		watcher, err := worker.PickWatcher(cp, ctx, watchers, now)
+		require.NoError(t, err)
+		picked[watcher.ID]++
+	}
+	assert.Equal(t, map[int64]int{1: 2, 2: 2}, picked)
+
+	for _, id := range ids {
+		require.NoError(t, client.Set(ctx, fmt.Sprintf("reddit:%s:ratelimited", id), true, 0).Err())
+	}
+	_, err := worker.PickWatcher(cp, ctx, watchers, now)
+	assert.ErrorIs(t, err, reddit.ErrRateLimited)
+}
+
+func TestCredentialPicker_RedisUnavailable(t *testing.T) {
+	t.Parallel()
+
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0", MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+
+	cp := worker.NewCredentialPicker(client)
+	now := time.Now()
+
+	tests := map[string]struct {
+		watchers []domain.Watcher
+		want     []int64
+	}{
+		"prefers fresh tokens": {
+			[]domain.Watcher{
+				{ID: 1, Account: domain.Account{AccountID: "a", TokenExpiresAt: now.Add(-time.Hour)}},
+				{ID: 2, Account: domain.Account{AccountID: "b", TokenExpiresAt: now.Add(time.Hour)}},
+				{ID: 3, Account: domain.Account{AccountID: "c", TokenExpiresAt: now.Add(time.Minute)}},
+			},
+			[]int64{2},
+		},
+		"all expired": {
+			[]domain.Watcher{
+				{ID: 1, Account: domain.Account{AccountID: "a"}},
+				{ID: 2, Account: domain.Account{AccountID: "b", TokenExpiresAt: now.Add(-time.Hour)}},
+			},
+			[]int64{1, 2},
+		},
+	}
+
+	for scenario, tc := range tests {
+		tc := tc
+
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			// Falls back to a random pick instead of holding up the job.
+			for i := 0; i < 10; i++ {
+				watcher, err := worker.PickWatcher(cp, context.Background(), tc.watchers, now)
+				require.NoError(t, err)
+				assert.Contains(t, tc.want, watcher.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// DeadLetterQueue holds payloads that kept failing, for someone to look into.
+	DeadLetterQueue = "dead-letter"
+
+	// maxDeliveryAttempts is how many times a payload gets rejected before it's
+	// moved to the dead-letter queue.
+	maxDeliveryAttempts = 5
+
+	retryCountTTL = 24 * time.Hour
+)
+
+// DeadLetter is what gets published to the dead-letter queue for a failing payload.
+type DeadLetter struct {
+	Queue    string    `json:"queue"`
+	Payload  string    `json:"payload"`
+	Attempts int64     `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+type retryCounter interface {
+	Incr(ctx context.Context, key string) (int64, error)
+	Reset(ctx context.Context, key string) error
+}
+
+type redisRetryCounter struct {
+	client *redis.Client
+}
+
+func (rrc *redisRetryCounter) Incr(ctx context.Context, key string) (int64, error) {
+	n, err := rrc.client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// Forget about payloads that stopped failing for a while.
+	if err := rrc.client.Expire(ctx, key, retryCountTTL).Err(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (rrc *redisRetryCounter) Reset(ctx context.Context, key string) error {
+	return rrc.client.Del(ctx, key).Err()
+}
+
+// retryPolicy rejects failing deliveries so they get retried, until a payload has
+// failed too many times. It's then acked and moved to the dead-letter queue so a
+// payload that can never succeed doesn't keep going around.
+type retryPolicy struct {
+	queue   string
+	conn    rmq.Connection
+	counter retryCounter
+	max     int64
+}
+
+func newRetryPolicy(queue string, conn rmq.Connection, redis *redis.Client) *retryPolicy {
+	return &retryPolicy{
+		queue:   queue,
+		conn:    conn,
+		counter: &redisRetryCounter{redis},
+		max:     maxDeliveryAttempts,
+	}
+}
+
+func (rp *retryPolicy) key(delivery rmq.Delivery) string {
+	return fmt.Sprintf("retries:%s:%s", rp.queue, delivery.Payload())
+}
+
+// ack finishes with a delivery that went through, forgetting about its earlier failures
+// so they don't count against the payload the next time it's enqueued.
+func (rp *retryPolicy) ack(ctx context.Context, delivery rmq.Delivery) error {
+	_ = rp.counter.Reset(ctx, rp.key(delivery))
+	return delivery.Ack()
+}
+
+// reject gives up on a delivery for now, and reports whether it was dead-lettered.
+func (rp *retryPolicy) reject(ctx context.Context, delivery rmq.Delivery) (bool, error) {
+	key := rp.key(delivery)
+
+	attempts, err := rp.counter.Incr(ctx, key)
+	if err != nil || attempts < rp.max {
+		return false, delivery.Reject()
+	}
+
+	dlq, err := rp.conn.OpenQueue(DeadLetterQueue)
+	if err != nil {
+		return false, delivery.Reject()
+	}
+
+	bb, _ := json.Marshal(DeadLetter{
+		Queue:    rp.queue,
+		Payload:  delivery.Payload(),
+		Attempts: attempts,
+		FailedAt: time.Now().UTC(),
+	})
+	if err := dlq.PublishBytes(bb); err != nil {
+		return false, delivery.Reject()
+	}
+
+	_ = rp.counter.Reset(ctx, key)
+	return true, delivery.Ack()
+}
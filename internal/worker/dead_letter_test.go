@@ -0,0 +1,99 @@
+package worker_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/worker"
+)
+
+type memoryRetryCounter map[string]int64
+
+func (m memoryRetryCounter) Incr(_ context.Context, key string) (int64, error) {
+	m[key]++
+	return m[key], nil
+}
+
+func (m memoryRetryCounter) Reset(_ context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func TestRetryPolicyDeadLetters(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := rmq.NewTestConnection()
+	counter := memoryRetryCounter{}
+	reject, _ := worker.NewTestRetryPolicy("users", conn, counter, 3)
+
+	for i := 0; i < 2; i++ {
+		delivery := rmq.NewTestDelivery("not-an-id")
+
+		dead, err := reject(ctx, delivery)
+		require.NoError(t, err)
+		assert.False(t, dead)
+		assert.Equal(t, rmq.Rejected, delivery.State)
+	}
+	assert.Empty(t, conn.GetDeliveries(worker.DeadLetterQueue))
+
+	delivery := rmq.NewTestDelivery("not-an-id")
+	dead, err := reject(ctx, delivery)
+	require.NoError(t, err)
+	assert.True(t, dead)
+	assert.Equal(t, rmq.Acked, delivery.State)
+
+	dls := conn.GetDeliveries(worker.DeadLetterQueue)
+	require.Len(t, dls, 1)
+
+	var dl worker.DeadLetter
+	require.NoError(t, json.Unmarshal([]byte(dls[0]), &dl))
+	assert.Equal(t, "users", dl.Queue)
+	assert.Equal(t, "not-an-id", dl.Payload)
+	assert.Equal(t, int64(3), dl.Attempts)
+
+	// The count starts over, so the payload gets retried again if it's requeued.
+	assert.Empty(t, counter)
+}
+
+func TestRetryPolicyCountsPerPayload(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := rmq.NewTestConnection()
+	reject, _ := worker.NewTestRetryPolicy("users", conn, memoryRetryCounter{}, 2)
+
+	for _, payload := range []string{"a", "b"} {
+		dead, err := reject(ctx, rmq.NewTestDelivery(payload))
+		require.NoError(t, err)
+		assert.False(t, dead)
+	}
+	assert.Empty(t, conn.GetDeliveries(worker.DeadLetterQueue))
+}
+
+func TestRetryPolicyAckForgetsFailures(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := rmq.NewTestConnection()
+	counter := memoryRetryCounter{}
+	reject, ack := worker.NewTestRetryPolicy("notifications", conn, counter, 2)
+
+	// Failing once in a while, with successes in between, never adds up to dead-lettering.
+	for i := 0; i < 3; i++ {
+		dead, err := reject(ctx, rmq.NewTestDelivery("t2_1ia22"))
+		require.NoError(t, err)
+		assert.False(t, dead)
+
+		delivery := rmq.NewTestDelivery("t2_1ia22")
+		require.NoError(t, ack(ctx, delivery))
+		assert.Equal(t, rmq.Acked, delivery.State)
+		assert.Empty(t, counter)
+	}
+	assert.Empty(t, conn.GetDeliveries(worker.DeadLetterQueue))
+}
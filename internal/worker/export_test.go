@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/adjust/rmq/v5"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+var (
+	APNSConnections   = apnsConnections
+	NewAPNSClients    = newAPNSClients
+	APNSPriority      = apnsPriority
+	InterruptionLevel = interruptionLevel
+
+	MatchingComments   = matchingComments
+	FirstUnseenComment = firstUnseenComment
+	StatsChanged       = statsChanged
+	StatsRefreshDue    = statsRefreshDue
+	UnchangedUpdate    = unchangedUpdate
+	KeepComment        = keepComment
+)
+
+var (
+	PayloadFromMessage      = payloadFromMessage
+	PayloadFromPost         = payloadFromPost
+	PayloadFromTrendingPost = payloadFromTrendingPost
+	PayloadFromUserPost     = payloadFromUserPost
+	PayloadFromUserComment  = payloadFromUserComment
+	MergeActivity           = mergeActivity
+	TruncateRunes           = truncateRunes
+)
+
+var (
+	NewJobTracker = newJobTracker
+	StartJob      = (*jobTracker).start
+	DrainJobs     = (*jobTracker).drain
+)
+
+var SentNotificationFromPayload = sentNotificationFromPayload
+
+var (
+	PrefetchForBacklog  = prefetchForBacklog
+	PrefetchNeedsRetune = prefetchNeedsRetune
+	WatcherDedupTTL     = watcherDedupTTL
+)
+
+var (
+	ParseStuckPayload = parseStuckPayload
+	ThingsMatching    = thingsMatching
+	BatchedThings     = batchedThings
+	LastThingExists   = lastThingExists
+	LastGoodThingID   = lastGoodThingID
+)
+
+type RetryCounter = retryCounter
+
+// NewTestRetryPolicy builds a retry policy that counts failures with counter instead
+// of Redis, and returns the functions to reject and ack deliveries with it.
+func NewTestRetryPolicy(queue string, conn rmq.Connection, counter RetryCounter, max int64) (func(context.Context, rmq.Delivery) (bool, error), func(context.Context, rmq.Delivery) error) {
+	rp := &retryPolicy{queue: queue, conn: conn, counter: counter, max: max}
+	return rp.reject, rp.ack
+}
+
+var PickWatcher = (*CredentialPicker).pickWatcher
+
+var (
+	NewLiveActivityPayload    = newLiveActivityPayload
+	LiveActivityPayloadAlert  = (*liveActivityPayload).withAlert
+	EncodeLiveActivityPayload = (*liveActivityPayload).encode
+)
+
+var (
+	UnnotifiedWatchers   = unnotifiedWatchers
+	MarkWatchersNotified = markWatchersNotified
+)
+
+// RedditClient returns the Reddit client a worker makes its requests with.
+func RedditClient(w Worker) *reddit.Client {
+	switch w := w.(type) {
+	case *liveActivitiesWorker:
+		return w.reddit
+	case *notificationsWorker:
+		return w.reddit
+	case *stuckNotificationsWorker:
+		return w.reddit
+	case *subredditsWorker:
+		return w.reddit
+	case *trendingWorker:
+		return w.reddit
+	case *usersWorker:
+		return w.reddit
+	default:
+		return nil
+	}
+}
+
+var DualPush = dualPush
+
+// CorrectEnvironment switches a device to the other APNs environment the way the
+// notifications worker does, saving it through repo.
+func CorrectEnvironment(ctx context.Context, repo domain.DeviceRepository, device *domain.Device) {
+	nc := &notificationsConsumer{notificationsWorker: &notificationsWorker{
+		statsd:     &statsd.NoOpClient{},
+		deviceRepo: repo,
+	}}
+	nc.correctEnvironment(ctx, zap.NewNop(), device)
+}
+
+type SentUpdate = sentUpdate
@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+var ErrNoConsumers = errors.New("queue has no consumers")
+
+// HealthCheck returns an error when a dependency of the worker isn't usable.
+type HealthCheck func(context.Context) error
+
+// NewHealthHandler serves /health/live, which only says the process is up, and
+// /health/ready, which runs every check and reports unavailable if any of them fail.
+func NewHealthHandler(checks map[string]HealthCheck) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/health/live", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, map[string]interface{}{"status": "available"})
+	})
+
+	mux.HandleFunc("/health/ready", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		data := map[string]interface{}{"status": "available"}
+		status := http.StatusOK
+
+		deps := map[string]string{}
+		for name, check := range checks {
+			deps[name] = "available"
+			if err := check(ctx); err != nil {
+				deps[name] = "unavailable"
+				data["status"] = "unavailable"
+				status = http.StatusServiceUnavailable
+			}
+		}
+		data["dependencies"] = deps
+
+		writeHealth(w, status, data)
+	})
+
+	return mux
+}
+
+func writeHealth(w http.ResponseWriter, status int, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func PostgresHealthCheck(pool *pgxpool.Pool) HealthCheck {
+	return func(ctx context.Context) error {
+		_, err := pool.Exec(ctx, "SELECT 1")
+		return err
+	}
+}
+
+func RedisHealthCheck(client *redis.Client) HealthCheck {
+	return func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	}
+}
+
+// QueueHealthCheck fails when the queue can't be reached or nothing is consuming it
+// anymore, which is how a wedged worker shows up.
+func QueueHealthCheck(queue rmq.Connection, name string) HealthCheck {
+	return func(ctx context.Context) error {
+		stats, err := queue.CollectStats([]string{name})
+		if err != nil {
+			return err
+		}
+
+		if stats.QueueStats[name].ConsumerCount() == 0 {
+			return ErrNoConsumers
+		}
+		return nil
+	}
+}
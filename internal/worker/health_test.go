@@ -0,0 +1,80 @@
+package worker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/worker"
+)
+
+func TestHealthHandler(t *testing.T) {
+	t.Parallel()
+
+	healthy := func(context.Context) error { return nil }
+	wedged := func(context.Context) error { return worker.ErrNoConsumers }
+	down := func(context.Context) error { return errors.New("connection refused") }
+
+	tt := map[string]struct {
+		path   string
+		checks map[string]worker.HealthCheck
+		status int
+		want   map[string]interface{}
+	}{
+		"live while wedged": {
+			"/health/live",
+			map[string]worker.HealthCheck{"queue": wedged},
+			http.StatusOK,
+			map[string]interface{}{"status": "available"},
+		},
+		"ready": {
+			"/health/ready",
+			map[string]worker.HealthCheck{"postgres": healthy, "queue": healthy},
+			http.StatusOK,
+			map[string]interface{}{
+				"status":       "available",
+				"dependencies": map[string]interface{}{"postgres": "available", "queue": "available"},
+			},
+		},
+		"not ready without consumers": {
+			"/health/ready",
+			map[string]worker.HealthCheck{"postgres": healthy, "queue": wedged},
+			http.StatusServiceUnavailable,
+			map[string]interface{}{
+				"status":       "unavailable",
+				"dependencies": map[string]interface{}{"postgres": "available", "queue": "unavailable"},
+			},
+		},
+		"not ready with a dependency down": {
+			"/health/ready",
+			map[string]worker.HealthCheck{"redis": down, "queue": healthy},
+			http.StatusServiceUnavailable,
+			map[string]interface{}{
+				"status":       "unavailable",
+				"dependencies": map[string]interface{}{"redis": "unavailable", "queue": "available"},
+			},
+		},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			rr := httptest.NewRecorder()
+			worker.NewHealthHandler(tc.checks).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			assert.Equal(t, tc.status, rr.Code)
+
+			got := map[string]interface{}{}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -3,8 +3,8 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
 	"sort"
 	"time"
 
@@ -13,10 +13,10 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sideshow/apns2"
-	"github.com/sideshow/apns2/token"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
 	"github.com/christianselig/apollo-backend/internal/domain"
 	"github.com/christianselig/apollo-backend/internal/reddit"
 	"github.com/christianselig/apollo-backend/internal/repository"
@@ -37,42 +37,29 @@ type liveActivitiesWorker struct {
 
 	logger *zap.Logger
 	tracer trace.Tracer
-	statsd *statsd.Client
+	statsd statsd.ClientInterface
 	db     *pgxpool.Pool
 	redis  *redis.Client
 	queue  rmq.Connection
 	reddit *reddit.Client
-	apns   *token.Token
+	apns   *apnsClientPool
 
 	consumers int
 
 	liveActivityRepo domain.LiveActivityRepository
-}
 
-func NewLiveActivitiesWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd *statsd.Client, db *pgxpool.Pool, redis *redis.Client, queue rmq.Connection, consumers int) Worker {
-	reddit := reddit.NewClient(
-		os.Getenv("REDDIT_CLIENT_ID"),
-		os.Getenv("REDDIT_CLIENT_SECRET"),
-		tracer,
-		statsd,
-		redis,
-		consumers,
-	)
-
-	var apns *token.Token
-	{
-		authKey, err := token.AuthKeyFromFile(os.Getenv("APPLE_KEY_PATH"))
-		if err != nil {
-			panic(err)
-		}
+	jobs    *jobTracker
+	retries *retryPolicy
+}
 
-		apns = &token.Token{
-			AuthKey: authKey,
-			KeyID:   os.Getenv("APPLE_KEY_ID"),
-			TeamID:  os.Getenv("APPLE_TEAM_ID"),
-		}
+func NewLiveActivitiesWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd statsd.ClientInterface, db *pgxpool.Pool, redis *redis.Client, reddit *reddit.Client, queue rmq.Connection, consumers int) Worker {
+	apns, err := cmdutil.NewAPNSToken()
+	if err != nil {
+		panic(err)
 	}
 
+	conn := repository.WithAcquireTimeout(db, repository.DefaultAcquireTimeout)
+
 	return &liveActivitiesWorker{
 		ctx,
 		logger,
@@ -82,43 +69,32 @@ func NewLiveActivitiesWorker(ctx context.Context, logger *zap.Logger, tracer tra
 		redis,
 		queue,
 		reddit,
-		apns,
+		newAPNSClientPool(apns, consumers),
 		consumers,
 
-		repository.NewPostgresLiveActivity(db),
+		repository.NewPostgresLiveActivity(conn),
+
+		newJobTracker(),
+		newRetryPolicy("live-activities", queue, redis),
 	}
 }
 
 func (law *liveActivitiesWorker) Start() error {
-	queue, err := law.queue.OpenQueue("live-activities")
-	if err != nil {
-		return err
-	}
-
 	law.logger.Info("starting up live activities worker", zap.Int("consumers", law.consumers))
 
-	prefetchLimit := int64(law.consumers * 4)
-
-	if err := queue.StartConsuming(prefetchLimit, pollDuration); err != nil {
-		return err
-	}
-
-	host, _ := os.Hostname()
+	return startConsuming(law, law.logger, law.queue, "live-activities", law.consumers, 4, func(tag int) rmq.Consumer {
+		return NewLiveActivitiesConsumer(law, tag)
+	})
+}
 
-	for i := 0; i < law.consumers; i++ {
-		name := fmt.Sprintf("consumer %s-%d", host, i)
+func (law *liveActivitiesWorker) Stop() {
+	stopped := law.queue.StopAllConsuming()
 
-		consumer := NewLiveActivitiesConsumer(law, i)
-		if _, err := queue.AddConsumer(name, consumer); err != nil {
-			return err
-		}
+	if !law.jobs.drain(shutdownGracePeriod) {
+		law.logger.Warn("shutdown grace period elapsed, cancelling in-flight jobs")
 	}
 
-	return nil
-}
-
-func (law *liveActivitiesWorker) Stop() {
-	<-law.queue.StopAllConsuming() // wait for all Consume() calls to finish
+	<-stopped // wait for all Consume() calls to finish
 }
 
 type liveActivitiesConsumer struct {
@@ -133,13 +109,16 @@ func NewLiveActivitiesConsumer(law *liveActivitiesWorker, tag int) *liveActiviti
 	return &liveActivitiesConsumer{
 		law,
 		tag,
-		apns2.NewTokenClient(law.apns).Production(),
-		apns2.NewTokenClient(law.apns).Development(),
+		law.apns.production(tag),
+		law.apns.development(tag),
 	}
 }
 
 func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
-	ctx, cancel := context.WithCancel(lac)
+	jctx, done := lac.jobs.start()
+	defer done()
+
+	ctx, cancel := context.WithCancel(jctx)
 	defer cancel()
 
 	now := time.Now()
@@ -162,24 +141,35 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		}
 	}()
 
-	lac.logger.Debug("starting job", zap.String("live_activity#apns_token", at))
+	lac.logger.Debug("starting job", zap.String("live_activity#apns_token", domain.ObfuscateToken(at)))
+
+	// Set when the job failed in a way worth trying again.
+	var failed bool
 
 	defer func() {
-		if err := delivery.Ack(); err != nil {
-			lac.logger.Error("failed to acknowledge message", zap.Error(err), zap.String("live_activity#apns_token", at))
+		if failed {
+			if dead, _ := lac.retries.reject(ctx, delivery); dead {
+				lac.logger.Warn("moved payload to dead-letter queue", zap.String("live_activity#apns_token", domain.ObfuscateToken(at)))
+			}
+			return
+		}
+
+		if err := lac.retries.ack(ctx, delivery); err != nil {
+			lac.logger.Error("failed to acknowledge message", zap.Error(err), zap.String("live_activity#apns_token", domain.ObfuscateToken(at)))
 		}
 	}()
 
 	la, err := lac.liveActivityRepo.Get(ctx, at)
 	if err != nil {
-		lac.logger.Error("failed to get live activity", zap.Error(err), zap.String("live_activity#apns_token", at))
+		lac.logger.Error("failed to get live activity", zap.Error(err), zap.String("live_activity#apns_token", domain.ObfuscateToken(at)))
+		failed = !errors.Is(err, domain.ErrNotFound)
 		return
 	}
 
 	rac := lac.reddit.NewAuthenticatedClient(la.RedditAccountID, la.RefreshToken, la.AccessToken)
 	if la.TokenExpiresAt.Before(now.Add(5 * time.Minute)) {
 		lac.logger.Debug("refreshing reddit token",
-			zap.String("live_activity#apns_token", at),
+			zap.String("live_activity#apns_token", domain.ObfuscateToken(at)),
 			zap.String("reddit#id", la.RedditAccountID),
 			zap.String("reddit#access_token", rac.ObfuscatedAccessToken()),
 			zap.String("reddit#refresh_token", rac.ObfuscatedRefreshToken()),
@@ -189,7 +179,7 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		if err != nil {
 			lac.logger.Error("failed to refresh reddit tokens",
 				zap.Error(err),
-				zap.String("live_activity#apns_token", at),
+				zap.String("live_activity#apns_token", domain.ObfuscateToken(at)),
 				zap.String("reddit#id", la.RedditAccountID),
 				zap.String("reddit#access_token", rac.ObfuscatedAccessToken()),
 				zap.String("reddit#refresh_token", rac.ObfuscatedRefreshToken()),
@@ -210,13 +200,26 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		rac = lac.reddit.NewAuthenticatedClient(la.RedditAccountID, tokens.RefreshToken, tokens.AccessToken)
 	}
 
-	lac.logger.Debug("fetching latest comments", zap.String("live_activity#apns_token", at))
+	lac.logger.Debug("fetching latest comments", zap.String("live_activity#apns_token", domain.ObfuscateToken(at)))
+
+	statsKey := fmt.Sprintf("live-activities:%s:stats", at)
+
+	var last sentUpdate
+	if bb, err := lac.redis.Get(ctx, statsKey).Bytes(); err == nil {
+		_ = json.Unmarshal(bb, &last)
+	}
 
 	tr, err := rac.TopLevelComments(ctx, la.Subreddit, la.ThreadID)
+	if errors.Is(err, reddit.ErrThreadNotFound) {
+		lac.logger.Debug("thread is gone, ending live activity", zap.String("live_activity#apns_token", domain.ObfuscateToken(at)))
+		lac.end(ctx, &la, last.DynamicIslandNotification, now)
+		_ = lac.liveActivityRepo.Delete(ctx, at)
+		return
+	}
 	if err != nil {
 		lac.logger.Error("failed to fetch latest comments",
 			zap.Error(err),
-			zap.String("live_activity#apns_token", at),
+			zap.String("live_activity#apns_token", domain.ObfuscateToken(at)),
 			zap.String("reddit#id", la.RedditAccountID),
 			zap.String("reddit#access_token", rac.ObfuscatedAccessToken()),
 			zap.String("reddit#refresh_token", rac.ObfuscatedRefreshToken()),
@@ -227,45 +230,93 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		return
 	}
 
-	if len(tr.Children) == 0 && la.ExpiresAt.After(now) {
-		lac.logger.Debug("no comments found", zap.String("live_activity#apns_token", at))
-		return
-	}
-
-	// Filter out comments in the last minute
 	candidates := make([]*reddit.Thing, 0)
-	cutoffs := []time.Time{
-		now.Add(-domain.LiveActivityCheckInterval),
-		now.Add(-domain.LiveActivityCheckInterval * 2),
-		now.Add(-domain.LiveActivityCheckInterval * 4),
-	}
 
-	for _, cutoff := range cutoffs {
-		for _, t := range tr.Children {
-			if t.CreatedAt.After(cutoff) {
-				candidates = append(candidates, t)
+	// Keyword live activities only surface matching comments, each of them once. The
+	// claim on a comment is given back unless it gets pushed, so a failed push doesn't
+	// lose it for good.
+	var match *reddit.Thing
+	var claimed string
+	sent := false
+	defer func() {
+		if claimed == "" || sent {
+			return
+		}
+		if err := lac.redis.Del(ctx, claimed).Err(); err != nil {
+			lac.logger.Error("failed to release comment", zap.Error(err), zap.String("key", claimed))
+		}
+	}()
+
+	if la.Keyword != "" {
+		match = firstUnseenComment(matchingComments(&la, tr.Children), func(id string) bool {
+			key := fmt.Sprintf("live-activities:%s:comments:%s", at, id)
+			ok, err := lac.redis.SetNX(ctx, key, true, domain.LiveActivityDuration).Result()
+			if err != nil {
+				lac.logger.Error("failed to mark comment as seen", zap.Error(err), zap.String("key", key))
+			}
+			if ok {
+				claimed = key
 			}
+			return ok
+		})
+
+		if match != nil {
+			candidates = append(candidates, match)
 		}
+	} else {
+		// Filter out comments in the last minute
+		cutoffs := []time.Time{
+			now.Add(-domain.LiveActivityCheckInterval),
+			now.Add(-domain.LiveActivityCheckInterval * 2),
+			now.Add(-domain.LiveActivityCheckInterval * 4),
+		}
+
+		for _, cutoff := range cutoffs {
+			for _, t := range tr.Children {
+				if t.CreatedAt.After(cutoff) {
+					candidates = append(candidates, t)
+				}
+			}
 
-		if len(candidates) > 0 {
-			break
+			if len(candidates) > 0 {
+				break
+			}
 		}
 	}
 
+	din := DynamicIslandNotification{
+		PostCommentCount: tr.Post.NumComments,
+		PostScore:        tr.Post.Score,
+	}
+	if len(candidates) == 0 {
+		// Only the post's stats can have moved, keep showing the comment that's up.
+		din = keepComment(din, last.DynamicIslandNotification)
+	}
 	if len(candidates) == 0 && la.ExpiresAt.After(now) {
-		lac.logger.Debug("no new comments found", zap.String("live_activity#apns_token", at))
-		return
+		if !statsRefreshDue(last, din, now) {
+			lac.logger.Debug("no new comments found", zap.String("live_activity#apns_token", domain.ObfuscateToken(at)))
+			return
+		}
+
+		lac.logger.Debug("refreshing post stats", zap.String("live_activity#apns_token", domain.ObfuscateToken(at)))
+	}
+
+	if expiry, ok := la.RenewedExpiry(now); ok && len(candidates) > 0 {
+		if err := lac.liveActivityRepo.Extend(ctx, at, expiry); err != nil {
+			lac.logger.Error("failed to extend live activity", zap.Error(err), zap.String("live_activity#apns_token", domain.ObfuscateToken(at)))
+		} else {
+			lac.logger.Debug("extended live activity",
+				zap.String("live_activity#apns_token", domain.ObfuscateToken(at)),
+				zap.Time("live_activity#expires_at", expiry),
+			)
+			la.ExpiresAt = expiry
+		}
 	}
 
 	sort.Slice(candidates, func(i, j int) bool {
 		return candidates[i].Score > candidates[j].Score
 	})
 
-	din := DynamicIslandNotification{
-		PostCommentCount: tr.Post.NumComments,
-		PostScore:        tr.Post.Score,
-	}
-
 	if len(candidates) > 0 {
 		comment := candidates[0]
 
@@ -276,19 +327,31 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		din.CommentScore = comment.Score
 	}
 
-	ev := "update"
+	ev := liveActivityEventUpdate
 	if la.ExpiresAt.Before(now) {
-		ev = "end"
+		ev = liveActivityEventEnd
 	}
 
-	bb, _ := json.Marshal(map[string]interface{}{
-		"aps": map[string]interface{}{
-			"content-state":  din,
-			"dismissal-date": la.ExpiresAt.Unix(),
-			"event":          ev,
-			"timestamp":      now.Unix(),
-		},
-	})
+	if ev == liveActivityEventUpdate && unchangedUpdate(&la, last.DynamicIslandNotification, din) {
+		lac.logger.Debug("nothing changed since the last update", zap.String("live_activity#apns_token", domain.ObfuscateToken(at)))
+		return
+	}
+
+	payload := newLiveActivityPayload(&la, ev, din, la.ExpiresAt, now)
+	if match != nil {
+		payload.withAlert(fmt.Sprintf("u/%s mentioned “%s”", match.Author, la.Keyword), match.Body)
+	}
+
+	bb, err := payload.encode()
+	if err != nil {
+		_ = lac.statsd.Incr("apns.live_activities.invalid", []string{}, 1)
+		lac.logger.Error("invalid live activity payload",
+			zap.Error(err),
+			zap.String("live_activity#apns_token", domain.ObfuscateToken(at)),
+			zap.String("notification#type", ev),
+		)
+		return
+	}
 
 	notification := &apns2.Notification{
 		DeviceToken: la.APNSToken,
@@ -302,12 +365,12 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		client = lac.dapns
 	}
 
-	res, err := client.PushWithContext(ctx, notification)
+	res, err := pushWithSpan(ctx, lac.tracer, client, notification)
 	if err != nil {
 		_ = lac.statsd.Incr("apns.live_activities.errors", []string{}, 1)
 		lac.logger.Error("failed to send notification",
 			zap.Error(err),
-			zap.String("live_activity#apns_token", at),
+			zap.String("live_activity#apns_token", domain.ObfuscateToken(at)),
 			zap.Bool("live_activity#development", la.Development),
 			zap.String("notification#type", ev),
 		)
@@ -316,7 +379,7 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 	} else if !res.Sent() {
 		_ = lac.statsd.Incr("apns.live_activities.errors", []string{}, 1)
 		lac.logger.Error("notification not sent",
-			zap.String("live_activity#apns_token", at),
+			zap.String("live_activity#apns_token", domain.ObfuscateToken(at)),
 			zap.Bool("live_activity#development", la.Development),
 			zap.String("notification#type", ev),
 			zap.Int("response#status", res.StatusCode),
@@ -325,20 +388,133 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 
 		_ = lac.liveActivityRepo.Delete(ctx, at)
 	} else {
+		sent = true
 		_ = lac.statsd.Incr("apns.notification.sent", []string{}, 1)
 		lac.logger.Debug("sent notification",
-			zap.String("live_activity#apns_token", at),
+			zap.String("live_activity#apns_token", domain.ObfuscateToken(at)),
 			zap.Bool("live_activity#development", la.Development),
 			zap.String("notification#type", ev),
 		)
+
+		stats, _ := json.Marshal(sentUpdate{din, now})
+		if err := lac.redis.Set(ctx, statsKey, stats, domain.LiveActivityDuration).Err(); err != nil {
+			lac.logger.Error("failed to store sent post stats", zap.Error(err), zap.String("key", statsKey))
+		}
+
+		if din.CommentID != "" && din.CommentID != la.LastCommentID {
+			la.LastCommentID = din.CommentID
+			_ = lac.liveActivityRepo.Update(ctx, &la)
+		}
 	}
 
 	if la.ExpiresAt.Before(now) {
-		lac.logger.Debug("live activity expired, deleting", zap.String("live_activity#apns_token", at))
+		lac.logger.Debug("live activity expired, deleting", zap.String("live_activity#apns_token", domain.ObfuscateToken(at)))
 		_ = lac.liveActivityRepo.Delete(ctx, at)
 	}
 
 	lac.logger.Debug("finishing job",
-		zap.String("live_activity#apns_token", at),
+		zap.String("live_activity#apns_token", domain.ObfuscateToken(at)),
 	)
 }
+
+// end pushes an end event for a live activity whose thread is gone, keeping the
+// post stats it was last updated with.
+func (lac *liveActivitiesConsumer) end(ctx context.Context, la *domain.LiveActivity, din DynamicIslandNotification, now time.Time) {
+	bb, err := newLiveActivityPayload(la, liveActivityEventEnd, din, now, now).encode()
+	if err != nil {
+		_ = lac.statsd.Incr("apns.live_activities.invalid", []string{}, 1)
+		lac.logger.Error("invalid live activity payload",
+			zap.Error(err),
+			zap.String("live_activity#apns_token", domain.ObfuscateToken(la.APNSToken)),
+			zap.String("notification#type", liveActivityEventEnd),
+		)
+		return
+	}
+
+	notification := &apns2.Notification{
+		DeviceToken: la.APNSToken,
+		Topic:       "com.christianselig.Apollo.push-type.liveactivity",
+		PushType:    "liveactivity",
+		Payload:     bb,
+	}
+
+	client := lac.papns
+	if la.Development {
+		client = lac.dapns
+	}
+
+	res, err := pushWithSpan(ctx, lac.tracer, client, notification)
+	if err != nil || !res.Sent() {
+		_ = lac.statsd.Incr("apns.live_activities.errors", []string{}, 1)
+		lac.logger.Error("failed to send end notification",
+			zap.Error(err),
+			zap.String("live_activity#apns_token", domain.ObfuscateToken(la.APNSToken)),
+			zap.Bool("live_activity#development", la.Development),
+		)
+		return
+	}
+
+	_ = lac.statsd.Incr("apns.notification.sent", []string{}, 1)
+}
+
+// matchingComments returns the comments matching a live activity's keyword, oldest first.
+func matchingComments(la *domain.LiveActivity, comments []*reddit.Thing) []*reddit.Thing {
+	matches := make([]*reddit.Thing, 0)
+	for _, t := range comments {
+		if la.CommentMatches(t.Body) {
+			matches = append(matches, t)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].CreatedAt.Before(matches[j].CreatedAt)
+	})
+
+	return matches
+}
+
+// sentUpdate is what's remembered of the last update pushed to a live activity.
+type sentUpdate struct {
+	DynamicIslandNotification
+	SentAt time.Time `json:"sentAt"`
+}
+
+// statsRefreshDue reports whether a live activity with no new comment to show should
+// still get an update, because its post stats moved. Quiet threads only get one every
+// LiveActivityRefreshInterval, without holding up the checks for new comments.
+func statsRefreshDue(last sentUpdate, current DynamicIslandNotification, now time.Time) bool {
+	return statsChanged(last.DynamicIslandNotification, current) && now.Sub(last.SentAt) >= domain.LiveActivityRefreshInterval
+}
+
+// statsChanged reports whether a post's comment count or score moved since the
+// last notification sent for a live activity.
+func statsChanged(last, current DynamicIslandNotification) bool {
+	return last.PostCommentCount != current.PostCommentCount || last.PostScore != current.PostScore
+}
+
+// keepComment returns current showing the same comment as last did.
+func keepComment(current, last DynamicIslandNotification) DynamicIslandNotification {
+	current.CommentID = last.CommentID
+	current.CommentAuthor = last.CommentAuthor
+	current.CommentBody = last.CommentBody
+	current.CommentAge = last.CommentAge
+	current.CommentScore = last.CommentScore
+	return current
+}
+
+// unchangedUpdate reports whether an update would show the same comment as the last
+// one pushed for a live activity, with the same post stats.
+func unchangedUpdate(la *domain.LiveActivity, last, current DynamicIslandNotification) bool {
+	return current.CommentID == la.LastCommentID && !statsChanged(last, current)
+}
+
+// firstUnseenComment returns the first comment that claim accepts, so the same
+// comment isn't notified on twice.
+func firstUnseenComment(comments []*reddit.Thing, claim func(id string) bool) *reddit.Thing {
+	for _, t := range comments {
+		if claim(t.ID) {
+			return t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package worker_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fastjson"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+	"github.com/christianselig/apollo-backend/internal/worker"
+)
+
+func NewTestThreadResponse(t *testing.T) *reddit.ThreadResponse {
+	t.Helper()
+
+	bb, err := os.ReadFile("../reddit/testdata/thread.json")
+	require.NoError(t, err)
+
+	val, err := fastjson.ParseBytes(bb)
+	require.NoError(t, err)
+
+	return reddit.NewThreadResponse(val).(*reddit.ThreadResponse)
+}
+
+func TestMatchingComments(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTestThreadResponse(t)
+
+	tt := map[string]struct {
+		keyword string
+		want    []string
+	}{
+		"no keyword":           {"", []string{}},
+		"single match":         {"supply", []string{"issn7pe"}},
+		"oldest matches first": {"pi+price", []string{"issj4i7", "issmjf9", "issn7pe"}},
+		"no matches":           {"nintendo switch", []string{}},
+		"comma separators":     {"master,game gear", []string{"isslv1j"}},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			la := &domain.LiveActivity{Keyword: tc.keyword}
+
+			got := []string{}
+			for _, c := range worker.MatchingComments(la, tr.Children) {
+				got = append(got, c.ID)
+			}
+
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestFirstUnseenComment(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTestThreadResponse(t)
+	la := &domain.LiveActivity{Keyword: "pi"}
+	matches := worker.MatchingComments(la, tr.Children)
+	require.NotEmpty(t, matches)
+
+	seen := map[string]bool{}
+	claim := func(id string) bool {
+		if seen[id] {
+			return false
+		}
+		seen[id] = true
+		return true
+	}
+
+	notified := map[string]bool{}
+	for range matches {
+		c := worker.FirstUnseenComment(matches, claim)
+		require.NotNil(t, c)
+		assert.False(t, notified[c.ID], "comment %s notified twice", c.ID)
+		notified[c.ID] = true
+	}
+
+	assert.Nil(t, worker.FirstUnseenComment(matches, claim))
+}
+
+func TestStatsChanged(t *testing.T) {
+	t.Parallel()
+
+	sent := worker.DynamicIslandNotification{PostCommentCount: 10, PostScore: 100, CommentID: "issn7pe"}
+
+	tt := map[string]struct {
+		last    worker.DynamicIslandNotification
+		current worker.DynamicIslandNotification
+		want    bool
+	}{
+		"unchanged":           {sent, worker.DynamicIslandNotification{PostCommentCount: 10, PostScore: 100}, false},
+		"new comments":        {sent, worker.DynamicIslandNotification{PostCommentCount: 12, PostScore: 100}, true},
+		"score changed":       {sent, worker.DynamicIslandNotification{PostCommentCount: 10, PostScore: 99}, true},
+		"nothing sent before": {worker.DynamicIslandNotification{}, worker.DynamicIslandNotification{PostCommentCount: 10, PostScore: 100}, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, worker.StatsChanged(tc.last, tc.current))
+		})
+	}
+}
+
+func TestUnchangedUpdate(t *testing.T) {
+	t.Parallel()
+
+	la := &domain.LiveActivity{LastCommentID: "issn7pe"}
+	last := worker.DynamicIslandNotification{PostCommentCount: 10, PostScore: 100, CommentID: "issn7pe"}
+
+	tt := map[string]struct {
+		current worker.DynamicIslandNotification
+		want    bool
+	}{
+		"same comment and stats":  {worker.DynamicIslandNotification{PostCommentCount: 10, PostScore: 100, CommentID: "issn7pe"}, true},
+		"same comment, new stats": {worker.DynamicIslandNotification{PostCommentCount: 11, PostScore: 100, CommentID: "issn7pe"}, false},
+		"new comment, same stats": {worker.DynamicIslandNotification{PostCommentCount: 10, PostScore: 100, CommentID: "issj4i7"}, false},
+		"no comment, same stats":  {worker.DynamicIslandNotification{PostCommentCount: 10, PostScore: 100}, false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, worker.UnchangedUpdate(la, last, tc.current))
+		})
+	}
+}
+
+func TestKeepComment(t *testing.T) {
+	t.Parallel()
+
+	la := &domain.LiveActivity{LastCommentID: "issn7pe"}
+	last := worker.DynamicIslandNotification{
+		PostCommentCount: 10,
+		PostScore:        100,
+		CommentID:        "issn7pe",
+		CommentAuthor:    "janedoe",
+		CommentBody:      "Nice post",
+		CommentAge:       1654000000,
+		CommentScore:     3,
+	}
+
+	// A stats-only refresh keeps showing the previous comment...
+	refresh := worker.KeepComment(worker.DynamicIslandNotification{PostCommentCount: 12, PostScore: 140}, last)
+	want := last
+	want.PostCommentCount, want.PostScore = 12, 140
+	assert.Equal(t, want, refresh)
+	assert.False(t, worker.UnchangedUpdate(la, last, refresh))
+
+	// ...and isn't pushed when the stats didn't move either.
+	same := worker.KeepComment(worker.DynamicIslandNotification{PostCommentCount: 10, PostScore: 100}, last)
+	assert.True(t, worker.UnchangedUpdate(la, last, same))
+}
+
+func TestStatsRefreshDue(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	sent := worker.DynamicIslandNotification{PostCommentCount: 10, PostScore: 100, CommentID: "issn7pe"}
+	moved := worker.DynamicIslandNotification{PostCommentCount: 12, PostScore: 140}
+
+	tt := map[string]struct {
+		last    worker.SentUpdate
+		current worker.DynamicIslandNotification
+		want    bool
+	}{
+		"stats moved":              {worker.SentUpdate{sent, now.Add(-domain.LiveActivityRefreshInterval)}, moved, true},
+		"nothing sent before":      {worker.SentUpdate{}, moved, true},
+		"stats moved too recently": {worker.SentUpdate{sent, now.Add(-domain.LiveActivityCheckInterval)}, moved, false},
+		"stats unchanged":          {worker.SentUpdate{sent, now.Add(-time.Hour)}, worker.DynamicIslandNotification{PostCommentCount: 10, PostScore: 100}, false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, worker.StatsRefreshDue(tc.last, tc.current, now))
+		})
+	}
+}
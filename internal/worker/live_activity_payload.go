@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"encoding/json"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+const (
+	liveActivityEventUpdate = "update"
+	liveActivityEventEnd    = "end"
+)
+
+// liveActivityPayload is the push that updates or ends a live activity. The app's
+// widget decodes aps.content-state into its own DynamicIslandNotification, so anything
+// it can't render has to be caught before it goes out.
+type liveActivityPayload struct {
+	APS liveActivityAPS `json:"aps"`
+
+	threadID  string
+	subreddit string
+}
+
+type liveActivityAPS struct {
+	ContentState  DynamicIslandNotification `json:"content-state"`
+	DismissalDate int64                     `json:"dismissal-date"`
+	Event         string                    `json:"event"`
+	Timestamp     int64                     `json:"timestamp"`
+	Alert         *liveActivityAlert        `json:"alert,omitempty"`
+}
+
+type liveActivityAlert struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+func newLiveActivityPayload(la *domain.LiveActivity, event string, din DynamicIslandNotification, dismissal, now time.Time) *liveActivityPayload {
+	return &liveActivityPayload{
+		APS: liveActivityAPS{
+			ContentState:  din,
+			DismissalDate: dismissal.Unix(),
+			Event:         event,
+			Timestamp:     now.Unix(),
+		},
+		threadID:  la.ThreadID,
+		subreddit: la.Subreddit,
+	}
+}
+
+// withAlert also shows the update as a notification.
+func (p *liveActivityPayload) withAlert(title, body string) *liveActivityPayload {
+	p.APS.Alert = &liveActivityAlert{Title: title, Body: body}
+	return p
+}
+
+func (p *liveActivityPayload) Validate() error {
+	if err := validation.ValidateStruct(p,
+		validation.Field(&p.threadID, validation.Required),
+		validation.Field(&p.subreddit, validation.Required),
+	); err != nil {
+		return err
+	}
+
+	aps := &p.APS
+	if err := validation.ValidateStruct(aps,
+		validation.Field(&aps.Event, validation.Required, validation.In(liveActivityEventUpdate, liveActivityEventEnd)),
+		validation.Field(&aps.Timestamp, validation.Required, validation.Min(int64(1))),
+		// An update that's already dismissed would never show.
+		validation.Field(&aps.DismissalDate, validation.Required, validation.Min(int64(1)),
+			validation.When(aps.Event == liveActivityEventUpdate, validation.Min(aps.Timestamp))),
+	); err != nil {
+		return err
+	}
+
+	if aps.Alert != nil {
+		if err := validation.ValidateStruct(aps.Alert,
+			validation.Field(&aps.Alert.Title, validation.Required),
+			validation.Field(&aps.Alert.Body, validation.Required),
+		); err != nil {
+			return err
+		}
+	}
+
+	din := &aps.ContentState
+	return validation.ValidateStruct(din,
+		validation.Field(&din.PostCommentCount, validation.Min(0)),
+		validation.Field(&din.CommentAuthor, validation.When(din.CommentID != "", validation.Required)),
+		validation.Field(&din.CommentAge, validation.When(din.CommentID != "", validation.Required, validation.Min(int64(1)))),
+	)
+}
+
+// encode validates the payload and serializes it for APNs.
+func (p *liveActivityPayload) encode() ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(p)
+}
@@ -0,0 +1,114 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/worker"
+)
+
+func TestLiveActivityPayload(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+	la := &domain.LiveActivity{ThreadID: "t3_abc", Subreddit: "pics"}
+	din := worker.DynamicIslandNotification{
+		PostCommentCount: 12,
+		PostScore:        340,
+		CommentID:        "t1_def",
+		CommentAuthor:    "iamthatis",
+		CommentBody:      "hello",
+		CommentAge:       now.Add(-time.Minute).Unix(),
+		CommentScore:     5,
+	}
+
+	p := worker.NewLiveActivityPayload(la, "update", din, now.Add(time.Hour), now)
+	worker.LiveActivityPayloadAlert(p, "u/iamthatis mentioned “hello”", "hello")
+
+	bb, err := worker.EncodeLiveActivityPayload(p)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"aps": {
+			"content-state": {
+				"postTotalComments": 12,
+				"postScore": 340,
+				"commentId": "t1_def",
+				"commentAuthor": "iamthatis",
+				"commentBody": "hello",
+				"commentAge": 1699999940,
+				"commentScore": 5
+			},
+			"dismissal-date": 1700003600,
+			"event": "update",
+			"timestamp": 1700000000,
+			"alert": {"title": "u/iamthatis mentioned “hello”", "body": "hello"}
+		}
+	}`, string(bb))
+
+	// Without a comment or an alert, only the post stats go out.
+	p = worker.NewLiveActivityPayload(la, "end", worker.DynamicIslandNotification{PostCommentCount: 1, PostScore: 2}, now, now)
+	bb, err = worker.EncodeLiveActivityPayload(p)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"aps": {
+			"content-state": {"postTotalComments": 1, "postScore": 2},
+			"dismissal-date": 1700000000,
+			"event": "end",
+			"timestamp": 1700000000
+		}
+	}`, string(bb))
+}
+
+func TestLiveActivityPayloadValidation(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+	valid := &domain.LiveActivity{ThreadID: "t3_abc", Subreddit: "pics"}
+	comment := worker.DynamicIslandNotification{CommentID: "t1_def", CommentAuthor: "iamthatis", CommentAge: now.Unix()}
+
+	tt := map[string]struct {
+		la        *domain.LiveActivity
+		event     string
+		din       worker.DynamicIslandNotification
+		dismissal time.Time
+		now       time.Time
+		alert     bool
+		err       bool
+	}{
+		"update":                      {valid, "update", comment, now.Add(time.Hour), now, false, false},
+		"ending an expired activity":  {valid, "end", comment, now.Add(-time.Hour), now, false, false},
+		"with an alert":               {valid, "update", comment, now.Add(time.Hour), now, true, false},
+		"missing thread":              {&domain.LiveActivity{Subreddit: "pics"}, "update", comment, now.Add(time.Hour), now, false, true},
+		"missing subreddit":           {&domain.LiveActivity{ThreadID: "t3_abc"}, "update", comment, now.Add(time.Hour), now, false, true},
+		"unknown event":               {valid, "start", comment, now.Add(time.Hour), now, false, true},
+		"no timestamp":                {valid, "end", comment, now, time.Unix(0, 0), false, true},
+		"update already dismissed":    {valid, "update", comment, now.Add(-time.Second), now, false, true},
+		"negative comment count":      {valid, "update", worker.DynamicIslandNotification{PostCommentCount: -1}, now.Add(time.Hour), now, false, true},
+		"comment without an author":   {valid, "update", worker.DynamicIslandNotification{CommentID: "t1_def", CommentAge: now.Unix()}, now.Add(time.Hour), now, false, true},
+		"comment without a timestamp": {valid, "update", worker.DynamicIslandNotification{CommentID: "t1_def", CommentAuthor: "iamthatis"}, now.Add(time.Hour), now, false, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			p := worker.NewLiveActivityPayload(tc.la, tc.event, tc.din, tc.dismissal, tc.now)
+			if tc.alert {
+				worker.LiveActivityPayloadAlert(p, "title", "body")
+			}
+
+			bb, err := worker.EncodeLiveActivityPayload(p)
+			if tc.err {
+				assert.Error(t, err)
+				assert.Nil(t, bb)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
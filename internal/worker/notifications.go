@@ -2,9 +2,10 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
 	"time"
+	"unicode/utf8"
 
 	"github.com/DataDog/datadog-go/statsd"
 	"github.com/adjust/rmq/v5"
@@ -12,12 +13,12 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sideshow/apns2"
 	"github.com/sideshow/apns2/payload"
-	"github.com/sideshow/apns2/token"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
 	"github.com/christianselig/apollo-backend/internal/domain"
 	"github.com/christianselig/apollo-backend/internal/reddit"
 	"github.com/christianselig/apollo-backend/internal/repository"
@@ -26,12 +27,23 @@ import (
 const (
 	rate = 0.1
 
-	postReplyNotificationTitleFormat       = "%s to %s"
-	commentReplyNotificationTitleFormat    = "%s in %s"
-	privateMessageNotificationTitleFormat  = "Message from %s"
-	usernameMentionNotificationTitleFormat = "Mention in \u201c%s\u201d"
+	inboxNotificationPriority = domain.NotificationPriorityHigh
 )
 
+// inboxTitles maps inbox notification categories to the localized string the app builds
+// their title from, and the English title sent alongside it for clients that don't have
+// the string yet.
+var inboxTitles = map[string]struct {
+	locKey string
+	format string
+}{
+	"inbox-comment-reply":               {"INBOX_COMMENT_REPLY_TITLE", "%s in %s"},
+	"inbox-post-reply":                  {"INBOX_POST_REPLY_TITLE", "%s to %s"},
+	"inbox-private-message":             {"INBOX_PRIVATE_MESSAGE_TITLE", "Message from %s"},
+	"inbox-username-mention-context":    {"INBOX_USERNAME_MENTION_TITLE", "Mention in \u201c%s\u201d"},
+	"inbox-username-mention-no-context": {"INBOX_USERNAME_MENTION_TITLE", "Mention in \u201c%s\u201d"},
+}
+
 var notificationTags = []string{"queue:notifications"}
 
 type notificationsWorker struct {
@@ -39,43 +51,31 @@ type notificationsWorker struct {
 
 	logger *zap.Logger
 	tracer trace.Tracer
-	statsd *statsd.Client
+	statsd statsd.ClientInterface
 	db     *pgxpool.Pool
 	redis  *redis.Client
 	queue  rmq.Connection
 	reddit *reddit.Client
-	apns   *token.Token
+	apns   *apnsClientPool
 
 	consumers int
 
-	accountRepo domain.AccountRepository
-	deviceRepo  domain.DeviceRepository
-}
-
-func NewNotificationsWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd *statsd.Client, db *pgxpool.Pool, redis *redis.Client, queue rmq.Connection, consumers int) Worker {
-	reddit := reddit.NewClient(
-		os.Getenv("REDDIT_CLIENT_ID"),
-		os.Getenv("REDDIT_CLIENT_SECRET"),
-		tracer,
-		statsd,
-		redis,
-		consumers,
-	)
+	accountRepo          domain.AccountRepository
+	deviceRepo           domain.DeviceRepository
+	sentNotificationRepo domain.SentNotificationRepository
 
-	var apns *token.Token
-	{
-		authKey, err := token.AuthKeyFromFile(os.Getenv("APPLE_KEY_PATH"))
-		if err != nil {
-			panic(err)
-		}
+	jobs    *jobTracker
+	retries *retryPolicy
+}
 
-		apns = &token.Token{
-			AuthKey: authKey,
-			KeyID:   os.Getenv("APPLE_KEY_ID"),
-			TeamID:  os.Getenv("APPLE_TEAM_ID"),
-		}
+func NewNotificationsWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd statsd.ClientInterface, db *pgxpool.Pool, redis *redis.Client, reddit *reddit.Client, queue rmq.Connection, consumers int) Worker {
+	apns, err := cmdutil.NewAPNSToken()
+	if err != nil {
+		panic(err)
 	}
 
+	conn := repository.WithAcquireTimeout(db, repository.DefaultAcquireTimeout)
+
 	return &notificationsWorker{
 		ctx,
 		logger,
@@ -85,42 +85,34 @@ func NewNotificationsWorker(ctx context.Context, logger *zap.Logger, tracer trac
 		redis,
 		queue,
 		reddit,
-		apns,
+		newAPNSClientPool(apns, consumers),
 		consumers,
 
-		repository.NewPostgresAccount(db),
-		repository.NewPostgresDevice(db),
+		repository.NewPostgresAccount(conn),
+		repository.NewPostgresDevice(conn),
+		repository.NewPostgresSentNotification(conn),
+
+		newJobTracker(),
+		newRetryPolicy("notifications", queue, redis),
 	}
 }
 
 func (nw *notificationsWorker) Start() error {
-	queue, err := nw.queue.OpenQueue("notifications")
-	if err != nil {
-		return err
-	}
-
 	nw.logger.Info("starting up notifications worker", zap.Int("consumers", nw.consumers))
 
-	if err := queue.StartConsuming(int64(nw.consumers*2), pollDuration); err != nil {
-		return err
-	}
-
-	host, _ := os.Hostname()
+	return startConsuming(nw, nw.logger, nw.queue, "notifications", nw.consumers, 2, func(tag int) rmq.Consumer {
+		return NewNotificationsConsumer(nw, tag)
+	})
+}
 
-	for i := 0; i < nw.consumers; i++ {
-		name := fmt.Sprintf("consumer %s-%d", host, i)
+func (nw *notificationsWorker) Stop() {
+	stopped := nw.queue.StopAllConsuming()
 
-		consumer := NewNotificationsConsumer(nw, i)
-		if _, err := queue.AddConsumer(name, consumer); err != nil {
-			return err
-		}
+	if !nw.jobs.drain(shutdownGracePeriod) {
+		nw.logger.Warn("shutdown grace period elapsed, cancelling in-flight jobs")
 	}
 
-	return nil
-}
-
-func (nw *notificationsWorker) Stop() {
-	<-nw.queue.StopAllConsuming() // wait for all Consume() calls to finish
+	<-stopped // wait for all Consume() calls to finish
 }
 
 type notificationsConsumer struct {
@@ -134,13 +126,16 @@ func NewNotificationsConsumer(nw *notificationsWorker, tag int) *notificationsCo
 	return &notificationsConsumer{
 		nw,
 		tag,
-		apns2.NewTokenClient(nw.apns).Production(),
-		apns2.NewTokenClient(nw.apns).Development(),
+		nw.apns.production(tag),
+		nw.apns.development(tag),
 	}
 }
 
 func (nc *notificationsConsumer) Consume(delivery rmq.Delivery) {
-	ctx, cancel := context.WithCancel(nc)
+	jctx, done := nc.jobs.start()
+	defer done()
+
+	ctx, cancel := context.WithCancel(jctx)
 	defer cancel()
 
 	id := delivery.Payload()
@@ -157,11 +152,21 @@ func (nc *notificationsConsumer) Consume(delivery rmq.Delivery) {
 		_ = nc.statsd.Incr("apollo.consumer.executions", notificationTags, 0.1)
 	}()
 
+	// Set when the job failed in a way worth trying again.
+	var failed bool
+
 	defer func(ctx context.Context) {
+		if failed {
+			if dead, _ := nc.retries.reject(ctx, delivery); dead {
+				logger.Warn("moved payload to dead-letter queue")
+			}
+			return
+		}
+
 		_, span := nc.tracer.Start(ctx, "queue:ack")
 		defer span.End()
 
-		if err := delivery.Ack(); err != nil {
+		if err := nc.retries.ack(ctx, delivery); err != nil {
 			span.SetStatus(codes.Error, "failed to acknowledge message")
 			span.RecordError(err)
 			logger.Error("failed to acknowledge message", zap.Error(err))
@@ -189,6 +194,7 @@ func (nc *notificationsConsumer) Consume(delivery rmq.Delivery) {
 	account, err := nc.accountRepo.GetByRedditID(ctx, id)
 	if err != nil {
 		logger.Debug("could not fetch account", zap.Error(err))
+		failed = !errors.Is(err, domain.ErrNotFound)
 		return
 	}
 
@@ -208,14 +214,12 @@ func (nc *notificationsConsumer) Consume(delivery rmq.Delivery) {
 				return
 			}
 
-			if err = nc.deleteAccount(ctx, account); err != nil {
-				logger.Error("failed to remove revoked account", zap.Error(err))
-			}
-
+			nc.handleRevokedAccount(ctx, logger, &account, now)
 			return
 		}
 
 		// Update account
+		account.RevocationConfirmations = 0
 		account.AccessToken = tokens.AccessToken
 		account.RefreshToken = tokens.RefreshToken
 		account.TokenExpiresAt = now.Add(tokens.Expiry)
@@ -242,32 +246,34 @@ func (nc *notificationsConsumer) Consume(delivery rmq.Delivery) {
 		case reddit.ErrTimeout, reddit.ErrRateLimited: // Don't log timeouts or rate limits
 			break
 		case reddit.ErrOauthRevoked:
-			if err = nc.deleteAccount(ctx, account); err != nil {
-				logger.Error("failed to remove revoked account", zap.Error(err))
-			} else {
-				logger.Info("removed revoked account")
-			}
+			nc.handleRevokedAccount(ctx, logger, &account, now)
 		default:
 			logger.Error("failed to fetch message inbox", zap.Error(err))
 		}
 		return
 	}
 
+	account.RevocationConfirmations = 0
+
 	// Figure out where we stand
 	if msgs.Count == 0 {
-		logger.Debug("no new messages, bailing early")
+		account.ScheduleNextNotificationCheck(now, false)
+		_ = nc.accountRepo.Update(ctx, &account)
+
+		logger.Debug("no new messages, bailing early", zap.Time("account#next_check_at", account.NextNotificationCheckAt))
 		return
 	}
 
 	logger.Debug("fetched messages", zap.Int("count", msgs.Count))
 
+	account.ScheduleNextNotificationCheck(now, true)
 	for _, msg := range msgs.Children {
 		if !msg.IsDeleted() {
 			account.LastMessageID = msg.FullName()
-			_ = nc.accountRepo.Update(ctx, &account)
 			break
 		}
 	}
+	_ = nc.accountRepo.Update(ctx, &account)
 
 	// Let's populate this with the latest message so we don't flood users with stuff
 	if account.CheckCount == 0 {
@@ -281,6 +287,7 @@ func (nc *notificationsConsumer) Consume(delivery rmq.Delivery) {
 	devices, err := nc.deviceRepo.GetInboxNotifiableByAccountID(ctx, account.ID)
 	if err != nil {
 		logger.Error("failed to fetch account devices", zap.Error(err))
+		failed = true
 		return
 	}
 
@@ -304,22 +311,27 @@ func (nc *notificationsConsumer) Consume(delivery rmq.Delivery) {
 
 		notification := &apns2.Notification{}
 		notification.Topic = "com.christianselig.Apollo"
+		notification.Priority = apnsPriority(inboxNotificationPriority)
 		notification.Payload = payloadFromMessage(account, msg, msgs.Count)
 
-		client := nc.papns
-		if account.Development {
-			client = nc.dapns
-		}
-
 		for _, device := range devices {
 			notification.DeviceToken = device.APNSToken
 
-			res, err := client.PushWithContext(ctx, notification)
+			client, fallback := nc.papns, nc.dapns
+			if device.Sandbox {
+				client, fallback = nc.dapns, nc.papns
+			}
+
+			res, switched, err := dualPush(ctx, nc.tracer, client, fallback, notification)
+			if switched {
+				nc.correctEnvironment(ctx, logger, &device)
+			}
+
 			if err != nil {
 				_ = nc.statsd.Incr("apns.notification.errors", []string{}, 1)
 				logger.Error("failed to send notification",
 					zap.Error(err),
-					zap.String("device#token", device.APNSToken),
+					zap.String("device#token", device.ObfuscatedToken()),
 				)
 
 				// Delete device as notifications might have been disabled here
@@ -327,7 +339,7 @@ func (nc *notificationsConsumer) Consume(delivery rmq.Delivery) {
 			} else if !res.Sent() {
 				_ = nc.statsd.Incr("apns.notification.errors", []string{}, 1)
 				logger.Error("notification not sent",
-					zap.String("device#token", device.APNSToken),
+					zap.String("device#token", device.ObfuscatedToken()),
 					zap.Int("response#status", res.StatusCode),
 					zap.String("response#reason", res.Reason),
 				)
@@ -336,7 +348,8 @@ func (nc *notificationsConsumer) Consume(delivery rmq.Delivery) {
 				_ = nc.deviceRepo.Delete(ctx, device.APNSToken)
 			} else {
 				_ = nc.statsd.Incr("apns.notification.sent", []string{}, 1)
-				logger.Info("sent notification", zap.String("device#token", device.APNSToken))
+				recordSentNotification(ctx, nc.sentNotificationRepo, device.ID, notification.Payload)
+				logger.Info("sent notification", zap.String("device#token", device.ObfuscatedToken()))
 			}
 		}
 	}
@@ -349,6 +362,39 @@ func (nc *notificationsConsumer) Consume(delivery rmq.Delivery) {
 	logger.Debug("finishing job")
 }
 
+// correctEnvironment moves a device over to the other APNs environment, after its token
+// turned out to belong there.
+func (nc *notificationsConsumer) correctEnvironment(ctx context.Context, logger *zap.Logger, device *domain.Device) {
+	_ = nc.statsd.Incr("apns.notification.environment_switched", []string{}, 1)
+	logger.Info("device was in the other apns environment, switching it",
+		zap.String("device#token", device.ObfuscatedToken()),
+		zap.Bool("device#sandbox", !device.Sandbox),
+	)
+
+	if err := nc.deviceRepo.SetSandbox(ctx, device, !device.Sandbox); err != nil {
+		logger.Error("failed to update device environment", zap.Error(err))
+	}
+}
+
+// handleRevokedAccount deletes an account once reddit has reported its tokens as revoked
+// enough checks in a row, and backs off checking it until then.
+func (nc *notificationsConsumer) handleRevokedAccount(ctx context.Context, logger *zap.Logger, account *domain.Account, now time.Time) {
+	if !account.ConfirmRevocation(now) {
+		logger.Info("account looks revoked, waiting for confirmation",
+			zap.Int64("account#revocation_confirmations", account.RevocationConfirmations),
+			zap.Time("account#next_check_at", account.NextNotificationCheckAt),
+		)
+		_ = nc.accountRepo.Update(ctx, account)
+		return
+	}
+
+	if err := nc.deleteAccount(ctx, *account); err != nil {
+		logger.Error("failed to remove revoked account", zap.Error(err))
+	} else {
+		logger.Info("removed revoked account")
+	}
+}
+
 func (nc *notificationsConsumer) deleteAccount(ctx context.Context, account domain.Account) error {
 	// Disassociate account from devices
 	devs, err := nc.deviceRepo.GetByAccountID(ctx, account.ID)
@@ -357,27 +403,33 @@ func (nc *notificationsConsumer) deleteAccount(ctx context.Context, account doma
 	}
 
 	for _, dev := range devs {
-		if err := nc.accountRepo.Disassociate(nc, &account, &dev); err != nil {
+		if err := nc.accountRepo.Disassociate(ctx, &account, &dev); err != nil {
 			return err
 		}
 	}
 
-	return nc.accountRepo.Delete(nc, account.ID)
+	return nc.accountRepo.Delete(ctx, account.ID)
 }
 
-func payloadFromMessage(acct domain.Account, msg *reddit.Thing, badgeCount int) *payload.Payload {
-	postBody := msg.Body
-	if len(postBody) > 2000 {
-		postBody = msg.Body[:2000]
+// truncateRunes caps s at max characters, ending it with an ellipsis when it had to
+// be cut. It counts runes rather than bytes so multibyte characters stay whole.
+func truncateRunes(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
 	}
 
+	runes := []rune(s)
+	return string(runes[:max]) + "…"
+}
+
+func payloadFromMessage(acct domain.Account, msg *reddit.Thing, badgeCount int) *payload.Payload {
+	postBody := truncateRunes(reddit.PlainText(msg.Body), 2000)
+
 	postTitle := msg.LinkTitle
 	if postTitle == "" {
 		postTitle = msg.Subject
 	}
-	if len(postTitle) > 75 {
-		postTitle = fmt.Sprintf("%s…", postTitle[0:75])
-	}
+	postTitle = truncateRunes(reddit.PlainText(postTitle), 75)
 
 	payload := payload.
 		NewPayload().
@@ -390,15 +442,17 @@ func payloadFromMessage(acct domain.Account, msg *reddit.Thing, badgeCount int)
 		Custom("parent_id", msg.ParentID).
 		Custom("post_title", msg.LinkTitle).
 		Custom("subreddit", msg.Subreddit).
+		InterruptionLevel(interruptionLevel(inboxNotificationPriority)).
 		MutableContent().
 		Sound("traloop.wav")
 
+	var category string
+	var titleArgs []string
+
 	switch {
 	case (msg.Kind == "t1" && msg.Type == "username_mention"):
-		title := fmt.Sprintf(usernameMentionNotificationTitleFormat, postTitle)
 		postID := reddit.PostIDFromContext(msg.Context)
 		payload = payload.
-			AlertTitle(title).
 			Custom("comment_id", msg.ID).
 			Custom("post_id", postID).
 			Custom("subreddit", msg.Subreddit).
@@ -406,18 +460,18 @@ func payloadFromMessage(acct domain.Account, msg *reddit.Thing, badgeCount int)
 
 		pType, _ := reddit.SplitID(msg.ParentID)
 		if pType == "t1" {
-			payload = payload.Category("inbox-username-mention-context")
+			category = "inbox-username-mention-context"
 		} else {
-			payload = payload.Category("inbox-username-mention-no-context")
+			category = "inbox-username-mention-no-context"
 		}
+		titleArgs = []string{postTitle}
 
 		payload = payload.Custom("subject", "comment").ThreadID("comment")
 	case (msg.Kind == "t1" && msg.Type == "post_reply"):
-		title := fmt.Sprintf(postReplyNotificationTitleFormat, msg.Author, postTitle)
 		postID := reddit.PostIDFromContext(msg.Context)
+		category = "inbox-post-reply"
+		titleArgs = []string{msg.Author, postTitle}
 		payload = payload.
-			AlertTitle(title).
-			Category("inbox-post-reply").
 			Custom("comment_id", msg.ID).
 			Custom("post_id", postID).
 			Custom("subject", "comment").
@@ -425,11 +479,10 @@ func payloadFromMessage(acct domain.Account, msg *reddit.Thing, badgeCount int)
 			Custom("type", "post").
 			ThreadID("comment")
 	case (msg.Kind == "t1" && msg.Type == "comment_reply"):
-		title := fmt.Sprintf(commentReplyNotificationTitleFormat, msg.Author, postTitle)
 		postID := reddit.PostIDFromContext(msg.Context)
+		category = "inbox-comment-reply"
+		titleArgs = []string{msg.Author, postTitle}
 		payload = payload.
-			AlertTitle(title).
-			Category("inbox-comment-reply").
 			Custom("comment_id", msg.ID).
 			Custom("post_id", postID).
 			Custom("subject", "comment").
@@ -437,14 +490,27 @@ func payloadFromMessage(acct domain.Account, msg *reddit.Thing, badgeCount int)
 			Custom("type", "comment").
 			ThreadID("comment")
 	case (msg.Kind == "t4"):
-		title := fmt.Sprintf(privateMessageNotificationTitleFormat, msg.Author)
+		category = "inbox-private-message"
+		titleArgs = []string{msg.Author}
 		payload = payload.
-			AlertTitle(title).
 			AlertSubtitle(postTitle).
-			Category("inbox-private-message").
 			Custom("comment_id", msg.ID).
 			Custom("type", "private-message")
 	}
 
+	if category != "" {
+		args := make([]interface{}, len(titleArgs))
+		for i, arg := range titleArgs {
+			args[i] = arg
+		}
+
+		title := inboxTitles[category]
+		payload = payload.
+			Category(category).
+			AlertTitle(fmt.Sprintf(title.format, args...)).
+			AlertTitleLocKey(title.locKey).
+			AlertTitleLocArgs(titleArgs)
+	}
+
 	return payload
 }
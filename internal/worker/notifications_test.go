@@ -0,0 +1,57 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/worker"
+)
+
+type stubDeviceRepository struct {
+	domain.DeviceRepository
+
+	set []bool
+	err error
+}
+
+func (r *stubDeviceRepository) SetSandbox(ctx context.Context, dev *domain.Device, sandbox bool) error {
+	r.set = append(r.set, sandbox)
+	if r.err != nil {
+		return r.err
+	}
+	dev.Sandbox = sandbox
+	return nil
+}
+
+func TestCorrectEnvironment(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		sandbox bool
+		err     error
+		want    bool
+	}{
+		"production to sandbox": {false, nil, true},
+		"sandbox to production": {true, nil, false},
+		"update fails":          {false, errors.New("boom"), false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			repo := &stubDeviceRepository{err: tc.err}
+			dev := &domain.Device{ID: 1, APNSToken: "abc", Sandbox: tc.sandbox}
+
+			worker.CorrectEnvironment(context.Background(), repo, dev)
+
+			assert.Equal(t, tc.want, dev.Sandbox)
+			assert.Equal(t, []bool{!tc.sandbox}, repo.set)
+		})
+	}
+}
@@ -0,0 +1,392 @@
+package worker_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sideshow/apns2/payload"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+	"github.com/christianselig/apollo-backend/internal/worker"
+)
+
+// payloadFields flattens the keys the iOS client relies on for deep-linking: the
+// custom keys at the top level, plus the category, thread ID and interruption level
+// from aps.
+func payloadFields(t *testing.T, p *payload.Payload) map[string]interface{} {
+	t.Helper()
+
+	bb, err := json.Marshal(p)
+	require.NoError(t, err)
+
+	fields := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(bb, &fields))
+
+	aps, ok := fields["aps"].(map[string]interface{})
+	require.True(t, ok)
+	delete(fields, "aps")
+
+	fields["category"] = aps["category"]
+	fields["thread_id"] = aps["thread-id"]
+	fields["interruption_level"] = aps["interruption-level"]
+
+	return fields
+}
+
+func TestPayloadFromMessage(t *testing.T) {
+	t.Parallel()
+
+	acct := domain.Account{AccountID: "1ia22"}
+
+	tt := map[string]struct {
+		msg  *reddit.Thing
+		want map[string]interface{}
+	}{
+		"username mention": {
+			&reddit.Thing{Kind: "t1", Type: "username_mention", ID: "i6xobpa", ParentID: "t3_u02338", Subreddit: "calicosummer", Context: "/r/calicosummer/comments/u02338/testimg/i6xobpa/?context=3"},
+			map[string]interface{}{"type": "username", "category": "inbox-username-mention-no-context", "interruption_level": "time-sensitive", "thread_id": "comment", "comment_id": "i6xobpa", "post_id": "u02338", "subreddit": "calicosummer", "account_id": "1ia22"},
+		},
+		"username mention with context": {
+			&reddit.Thing{Kind: "t1", Type: "username_mention", ID: "i6xobpa", ParentID: "t1_i6xo000", Subreddit: "calicosummer", Context: "/r/calicosummer/comments/u02338/testimg/i6xobpa/?context=3"},
+			map[string]interface{}{"type": "username", "category": "inbox-username-mention-context", "thread_id": "comment", "comment_id": "i6xobpa", "post_id": "u02338", "subreddit": "calicosummer"},
+		},
+		"post reply": {
+			&reddit.Thing{Kind: "t1", Type: "post_reply", ID: "hyg01ip", ParentID: "t3_t0qn4z", Subreddit: "OculusQuest2", Context: "/r/OculusQuest2/comments/t0qn4z/quest_2_use_during_chemo/hyg01ip/?context=3"},
+			map[string]interface{}{"type": "post", "category": "inbox-post-reply", "interruption_level": "time-sensitive", "thread_id": "comment", "comment_id": "hyg01ip", "post_id": "t0qn4z", "subreddit": "OculusQuest2", "parent_id": "t3_t0qn4z"},
+		},
+		"comment reply": {
+			&reddit.Thing{Kind: "t1", Type: "comment_reply", ID: "hwp66zg", ParentID: "t1_hwonb97", Subreddit: "ottawa", Context: "/r/ottawa/comments/sqqk29/protests/hwp66zg/?context=3"},
+			map[string]interface{}{"type": "comment", "category": "inbox-comment-reply", "interruption_level": "time-sensitive", "thread_id": "comment", "comment_id": "hwp66zg", "post_id": "sqqk29", "subreddit": "ottawa", "parent_id": "t1_hwonb97"},
+		},
+		"private message": {
+			&reddit.Thing{Kind: "t4", ID: "1d2oouy", Author: "welcomebot", Subject: "hello"},
+			map[string]interface{}{"type": "private-message", "category": "inbox-private-message", "interruption_level": "time-sensitive", "comment_id": "1d2oouy", "author": "welcomebot", "account_id": "1ia22"},
+		},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			fields := payloadFields(t, worker.PayloadFromMessage(acct, tc.msg, 1))
+			for key, want := range tc.want {
+				assert.Equal(t, want, fields[key], key)
+			}
+		})
+	}
+}
+
+func TestPayloadFromMessageLocalizesTitle(t *testing.T) {
+	t.Parallel()
+
+	acct := domain.Account{AccountID: "1ia22"}
+
+	tt := map[string]struct {
+		msg   *reddit.Thing
+		key   string
+		args  []string
+		title string
+	}{
+		"username mention": {
+			&reddit.Thing{Kind: "t1", Type: "username_mention", Author: "iamthatis", ParentID: "t3_u02338", LinkTitle: "testimg"},
+			"INBOX_USERNAME_MENTION_TITLE",
+			[]string{"testimg"},
+			"Mention in \u201ctestimg\u201d",
+		},
+		"username mention with context": {
+			&reddit.Thing{Kind: "t1", Type: "username_mention", Author: "iamthatis", ParentID: "t1_i6xo000", LinkTitle: "testimg"},
+			"INBOX_USERNAME_MENTION_TITLE",
+			[]string{"testimg"},
+			"Mention in \u201ctestimg\u201d",
+		},
+		"post reply": {
+			&reddit.Thing{Kind: "t1", Type: "post_reply", Author: "Ryfter", LinkTitle: "Quest 2 use during chemo"},
+			"INBOX_POST_REPLY_TITLE",
+			[]string{"Ryfter", "Quest 2 use during chemo"},
+			"Ryfter to Quest 2 use during chemo",
+		},
+		"comment reply": {
+			&reddit.Thing{Kind: "t1", Type: "comment_reply", Author: "Equinox_Shift", LinkTitle: "Protests"},
+			"INBOX_COMMENT_REPLY_TITLE",
+			[]string{"Equinox_Shift", "Protests"},
+			"Equinox_Shift in Protests",
+		},
+		"private message": {
+			&reddit.Thing{Kind: "t4", Author: "welcomebot", Subject: "hello"},
+			"INBOX_PRIVATE_MESSAGE_TITLE",
+			[]string{"welcomebot"},
+			"Message from welcomebot",
+		},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			bb, err := json.Marshal(worker.PayloadFromMessage(acct, tc.msg, 1))
+			require.NoError(t, err)
+
+			var content struct {
+				APS struct {
+					Alert struct {
+						Title        string   `json:"title"`
+						TitleLocKey  string   `json:"title-loc-key"`
+						TitleLocArgs []string `json:"title-loc-args"`
+					} `json:"alert"`
+				} `json:"aps"`
+			}
+			require.NoError(t, json.Unmarshal(bb, &content))
+
+			// Older clients don't know the keys, so they still get a finished title.
+			assert.Equal(t, tc.title, content.APS.Alert.Title)
+			assert.Equal(t, tc.key, content.APS.Alert.TitleLocKey)
+			assert.Equal(t, tc.args, content.APS.Alert.TitleLocArgs)
+		})
+	}
+}
+
+func TestSentNotificationFromInboxPayload(t *testing.T) {
+	t.Parallel()
+
+	msg := &reddit.Thing{Kind: "t1", Type: "comment_reply", Author: "Equinox_Shift", LinkTitle: "Protests"}
+	sn := worker.SentNotificationFromPayload(worker.PayloadFromMessage(domain.Account{}, msg, 1))
+
+	assert.Equal(t, "inbox-comment-reply", sn.Type)
+	assert.Equal(t, "Equinox_Shift in Protests", sn.Title)
+}
+
+func TestPayloadFromPosts(t *testing.T) {
+	t.Parallel()
+
+	post := &reddit.Thing{
+		Kind:      "t3",
+		ID:        "ufzaml",
+		Title:     "A Goliath Stick Insect",
+		Author:    "befarked247",
+		Subreddit: "pics",
+		Thumbnail: "https://a.thumbs.redditmedia.com/thumb.jpg",
+		CreatedAt: time.Unix(1651409659, 0),
+	}
+
+	tt := map[string]struct {
+		payload *payload.Payload
+		want    map[string]interface{}
+	}{
+		"subreddit watcher": {
+			worker.PayloadFromPost(post, domain.DefaultNotificationSound),
+			map[string]interface{}{"category": "subreddit-watcher", "interruption_level": "active", "thread_id": "subreddit-watcher", "post_id": "ufzaml", "subreddit": "pics", "author": "befarked247", "thumbnail": post.Thumbnail},
+		},
+		"trending post": {
+			worker.PayloadFromTrendingPost(post, domain.DefaultNotificationSound),
+			map[string]interface{}{"category": "trending-post", "interruption_level": "active", "thread_id": "trending-post", "post_id": "ufzaml", "subreddit": "pics", "author": "befarked247", "thumbnail": post.Thumbnail},
+		},
+		"user watcher": {
+			worker.PayloadFromUserPost(post, domain.DefaultNotificationSound),
+			map[string]interface{}{"category": "user-watch", "interruption_level": "active", "post_id": "ufzaml", "subreddit": "pics", "author": "befarked247"},
+		},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			fields := payloadFields(t, tc.payload)
+			for key, want := range tc.want {
+				assert.Equal(t, want, fields[key], key)
+			}
+			assert.Contains(t, fields, "post_title")
+			assert.Contains(t, fields, "post_age")
+		})
+	}
+}
+
+func TestPayloadFromPostsSound(t *testing.T) {
+	t.Parallel()
+
+	post := &reddit.Thing{Kind: "t3", ID: "ufzaml", Title: "[GPU] RTX 4090", Author: "deals", Subreddit: "buildapcsales"}
+	comment := &reddit.Thing{Kind: "t1", ID: "iszq2a1", Body: "Still in stock", LinkID: "t3_ufzaml", Subreddit: "buildapcsales"}
+	watcher := domain.Watcher{Sound: "default"}
+
+	tt := map[string]*payload.Payload{
+		"subreddit watcher": worker.PayloadFromPost(post, watcher.NotificationSound()),
+		"trending post":     worker.PayloadFromTrendingPost(post, watcher.NotificationSound()),
+		"user post":         worker.PayloadFromUserPost(post, watcher.NotificationSound()),
+		"user comment":      worker.PayloadFromUserComment(comment, watcher.NotificationSound()),
+	}
+
+	for scenario, p := range tt {
+		p := p
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			bb, err := json.Marshal(p)
+			require.NoError(t, err)
+			assert.Contains(t, string(bb), `"sound":"default"`)
+		})
+	}
+}
+
+func TestPayloadFromPostSkipsNSFWThumbnails(t *testing.T) {
+	t.Parallel()
+
+	post := &reddit.Thing{ID: "ufzaml", Thumbnail: "https://a.thumbs.redditmedia.com/thumb.jpg", Over18: true}
+
+	assert.NotContains(t, payloadFields(t, worker.PayloadFromPost(post, domain.DefaultNotificationSound)), "thumbnail")
+	assert.NotContains(t, payloadFields(t, worker.PayloadFromTrendingPost(post, domain.DefaultNotificationSound)), "thumbnail")
+}
+
+func TestTruncateRunes(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		in   string
+		max  int
+		want string
+	}{
+		"short":                 {"hello", 10, "hello"},
+		"exactly max":           {"hello", 5, "hello"},
+		"ascii":                 {"hello world", 5, "hello…"},
+		"accents at boundary":   {"crème brûlée", 4, "crèm…"},
+		"emoji at boundary":     {"ab🎉🎉", 3, "ab🎉…"},
+		"multibyte fits":        {"🎉🎉🎉", 3, "🎉🎉🎉"},
+		"cjk":                   {"日本語のテキスト", 3, "日本語…"},
+		"empty":                 {"", 3, ""},
+		"more bytes than runes": {"ééé", 4, "ééé"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			got := worker.TruncateRunes(tc.in, tc.max)
+			assert.Equal(t, tc.want, got)
+			assert.True(t, utf8.ValidString(got))
+		})
+	}
+}
+
+func TestPayloadFromMessageTruncatesOnRunes(t *testing.T) {
+	t.Parallel()
+
+	msg := &reddit.Thing{
+		Kind:      "t1",
+		Type:      "comment_reply",
+		Author:    "Equinox_Shift",
+		Body:      strings.Repeat("a", 1999) + "🎉🎉",
+		LinkTitle: strings.Repeat("é", 80),
+	}
+
+	bb, err := json.Marshal(worker.PayloadFromMessage(domain.Account{}, msg, 1))
+	require.NoError(t, err)
+
+	var content struct {
+		APS struct {
+			Alert struct {
+				Body         string   `json:"body"`
+				TitleLocArgs []string `json:"title-loc-args"`
+			} `json:"alert"`
+		} `json:"aps"`
+	}
+	require.NoError(t, json.Unmarshal(bb, &content))
+
+	assert.Equal(t, strings.Repeat("a", 1999)+"🎉…", content.APS.Alert.Body)
+	require.Len(t, content.APS.Alert.TitleLocArgs, 2)
+	assert.Equal(t, strings.Repeat("é", 75)+"…", content.APS.Alert.TitleLocArgs[1])
+}
+
+func TestPayloadsUsePlainText(t *testing.T) {
+	t.Parallel()
+
+	msg := &reddit.Thing{Kind: "t4", Author: "welcomebot", Subject: "Q&amp;A", Body: "**Welcome!** Read the [rules](https://reddit.com/r/pics/wiki) &amp; say hi"}
+	post := &reddit.Thing{ID: "ufzaml", Title: "Before &amp; after"}
+
+	tt := map[string]struct {
+		payload *payload.Payload
+		want    string
+	}{
+		"message":       {worker.PayloadFromMessage(domain.Account{}, msg, 1), "Welcome! Read the rules & say hi"},
+		"trending post": {worker.PayloadFromTrendingPost(post, domain.DefaultNotificationSound), "Before & after"},
+		"user post":     {worker.PayloadFromUserPost(post, domain.DefaultNotificationSound), "Before & after"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			bb, err := json.Marshal(tc.payload)
+			require.NoError(t, err)
+
+			var content struct {
+				APS struct {
+					Alert struct {
+						Body string `json:"body"`
+					} `json:"alert"`
+				} `json:"aps"`
+			}
+			require.NoError(t, json.Unmarshal(bb, &content))
+			assert.Equal(t, tc.want, content.APS.Alert.Body)
+		})
+	}
+}
+
+func TestPayloadFromUserComment(t *testing.T) {
+	t.Parallel()
+
+	comment := &reddit.Thing{
+		Kind:      "t1",
+		ID:        "iszq2a1",
+		Author:    "changelog",
+		Body:      "Thanks, that &amp; the widgets are great.",
+		LinkID:    "t3_y4l1cs",
+		LinkTitle: "Apollo 1.15 is out",
+		Subreddit: "apolloapp",
+		CreatedAt: time.Unix(1665849600, 0),
+	}
+
+	p := worker.PayloadFromUserComment(comment, domain.DefaultNotificationSound)
+
+	fields := payloadFields(t, p)
+	want := map[string]interface{}{"category": "user-watch", "type": "comment", "comment_id": "iszq2a1", "post_id": "y4l1cs", "post_title": "Apollo 1.15 is out", "subreddit": "apolloapp", "author": "changelog"}
+	for key, want := range want {
+		assert.Equal(t, want, fields[key], key)
+	}
+
+	bb, err := json.Marshal(p)
+	require.NoError(t, err)
+	assert.Contains(t, string(bb), `"body":"Thanks, that \u0026 the widgets are great."`)
+}
+
+func TestMergeActivity(t *testing.T) {
+	t.Parallel()
+
+	at := func(minutes int) time.Time { return time.Unix(1665849600, 0).Add(time.Duration(minutes) * time.Minute) }
+
+	posts := []*reddit.Thing{
+		{Kind: "t3", ID: "a", CreatedAt: at(30)},
+		{Kind: "t3", ID: "b", CreatedAt: at(10)},
+	}
+	comments := []*reddit.Thing{
+		{Kind: "t1", ID: "c", CreatedAt: at(40)},
+		{Kind: "t1", ID: "a", CreatedAt: at(20)},
+		{Kind: "t1", ID: "c", CreatedAt: at(40)},
+	}
+
+	var got []string
+	for _, thing := range worker.MergeActivity(posts, comments) {
+		got = append(got, thing.FullName())
+	}
+
+	assert.Equal(t, []string{"t1_c", "t3_a", "t1_a", "t3_b"}, got)
+	assert.Empty(t, worker.MergeActivity(nil, nil))
+}
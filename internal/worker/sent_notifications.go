@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+// sentNotificationTimeout bounds how long a push waits on its record being written.
+const sentNotificationTimeout = 500 * time.Millisecond
+
+// recordSentNotification keeps a summary of a delivered notification around for support
+// purposes. It's best effort, and gives up quickly rather than hold up the next push.
+func recordSentNotification(ctx context.Context, repo domain.SentNotificationRepository, deviceID int64, p interface{}) {
+	sn := sentNotificationFromPayload(p)
+	sn.DeviceID = deviceID
+	sn.CreatedAt = time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, sentNotificationTimeout)
+	defer cancel()
+
+	_ = repo.Create(ctx, &sn)
+}
+
+func sentNotificationFromPayload(p interface{}) domain.SentNotification {
+	var content struct {
+		APS struct {
+			Alert struct {
+				Title string `json:"title"`
+			} `json:"alert"`
+			Category string `json:"category"`
+		} `json:"aps"`
+	}
+
+	if bb, err := json.Marshal(p); err == nil {
+		_ = json.Unmarshal(bb, &content)
+	}
+
+	return domain.SentNotification{
+		Type:  content.APS.Category,
+		Title: content.APS.Alert.Title,
+	}
+}
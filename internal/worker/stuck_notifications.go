@@ -2,9 +2,8 @@ package worker
 
 import (
 	"context"
-	"fmt"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
@@ -24,7 +23,7 @@ type stuckNotificationsWorker struct {
 
 	logger *zap.Logger
 	tracer trace.Tracer
-	statsd *statsd.Client
+	statsd statsd.ClientInterface
 	db     *pgxpool.Pool
 	redis  *redis.Client
 	queue  rmq.Connection
@@ -33,17 +32,13 @@ type stuckNotificationsWorker struct {
 	consumers int
 
 	accountRepo domain.AccountRepository
+
+	jobs    *jobTracker
+	retries *retryPolicy
 }
 
-func NewStuckNotificationsWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd *statsd.Client, db *pgxpool.Pool, redis *redis.Client, queue rmq.Connection, consumers int) Worker {
-	reddit := reddit.NewClient(
-		os.Getenv("REDDIT_CLIENT_ID"),
-		os.Getenv("REDDIT_CLIENT_SECRET"),
-		tracer,
-		statsd,
-		redis,
-		consumers,
-	)
+func NewStuckNotificationsWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd statsd.ClientInterface, db *pgxpool.Pool, redis *redis.Client, reddit *reddit.Client, queue rmq.Connection, consumers int) Worker {
+	conn := repository.WithAcquireTimeout(db, repository.DefaultAcquireTimeout)
 
 	return &stuckNotificationsWorker{
 		ctx,
@@ -56,40 +51,29 @@ func NewStuckNotificationsWorker(ctx context.Context, logger *zap.Logger, tracer
 		reddit,
 		consumers,
 
-		repository.NewPostgresAccount(db),
+		repository.NewPostgresAccount(conn),
+
+		newJobTracker(),
+		newRetryPolicy("stuck-notifications", queue, redis),
 	}
 }
 
 func (snw *stuckNotificationsWorker) Start() error {
-	queue, err := snw.queue.OpenQueue("stuck-notifications")
-	if err != nil {
-		return err
-	}
-
 	snw.logger.Info("starting up stuck notifications worker", zap.Int("consumers", snw.consumers))
 
-	prefetchLimit := int64(snw.consumers * 2)
-
-	if err := queue.StartConsuming(prefetchLimit, pollDuration); err != nil {
-		return err
-	}
-
-	host, _ := os.Hostname()
+	return startConsuming(snw, snw.logger, snw.queue, "stuck-notifications", snw.consumers, 2, func(tag int) rmq.Consumer {
+		return NewStuckNotificationsConsumer(snw, tag)
+	})
+}
 
-	for i := 0; i < snw.consumers; i++ {
-		name := fmt.Sprintf("consumer %s-%d", host, i)
+func (snw *stuckNotificationsWorker) Stop() {
+	stopped := snw.queue.StopAllConsuming()
 
-		consumer := NewStuckNotificationsConsumer(snw, i)
-		if _, err := queue.AddConsumer(name, consumer); err != nil {
-			return err
-		}
+	if !snw.jobs.drain(shutdownGracePeriod) {
+		snw.logger.Warn("shutdown grace period elapsed, cancelling in-flight jobs")
 	}
 
-	return nil
-}
-
-func (snw *stuckNotificationsWorker) Stop() {
-	<-snw.queue.StopAllConsuming() // wait for all Consume() calls to finish
+	<-stopped // wait for all Consume() calls to finish
 }
 
 type stuckNotificationsConsumer struct {
@@ -105,7 +89,10 @@ func NewStuckNotificationsConsumer(snw *stuckNotificationsWorker, tag int) *stuc
 }
 
 func (snc *stuckNotificationsConsumer) Consume(delivery rmq.Delivery) {
-	ctx, cancel := context.WithCancel(snc)
+	jctx, done := snc.jobs.start()
+	defer done()
+
+	ctx, cancel := context.WithCancel(jctx)
 	defer cancel()
 
 	now := time.Now()
@@ -114,32 +101,89 @@ func (snc *stuckNotificationsConsumer) Consume(delivery rmq.Delivery) {
 		_ = snc.statsd.Histogram("apollo.consumer.runtime", float64(elapsed), []string{"queue:stuck-notifications"}, 0.1)
 	}()
 
-	id, err := strconv.ParseInt(delivery.Payload(), 10, 64)
+	ids, err := parseStuckPayload(delivery.Payload())
 	if err != nil {
 		snc.logger.Error("failed to parse account id from payload", zap.Error(err), zap.String("payload", delivery.Payload()))
 
-		_ = delivery.Reject()
+		if dead, _ := snc.retries.reject(ctx, delivery); dead {
+			snc.logger.Warn("moved payload to dead-letter queue", zap.String("payload", delivery.Payload()))
+		}
 		return
 	}
 
-	snc.logger.Debug("starting job", zap.Int64("account#id", id))
+	snc.logger.Debug("starting job", zap.Int64s("account#ids", ids))
 
-	defer func() { _ = delivery.Ack() }()
+	defer func() { _ = snc.retries.ack(ctx, delivery) }()
 
-	account, err := snc.accountRepo.GetByID(ctx, id)
-	if err != nil {
-		snc.logger.Error("failed to fetch account from database", zap.Error(err), zap.Int64("account#id", id))
-		return
+	var inboxed, others []domain.Account
+	for _, id := range ids {
+		account, err := snc.accountRepo.GetByID(ctx, id)
+		if err != nil {
+			snc.logger.Error("failed to fetch account from database", zap.Error(err), zap.Int64("account#id", id))
+			continue
+		}
+
+		if account.LastMessageID == "" {
+			snc.logger.Debug("account has no messages, bailing early",
+				zap.Int64("account#id", id),
+				zap.String("account#username", account.NormalizedUsername()),
+			)
+			continue
+		}
+
+		if account.LastMessageID[:2] == "t4" {
+			inboxed = append(inboxed, account)
+		} else {
+			others = append(others, account)
+		}
 	}
 
-	if account.LastMessageID == "" {
-		snc.logger.Debug("account has no messages, bailing early",
-			zap.Int64("account#id", id),
-			zap.String("account#username", account.NormalizedUsername()),
+	// Messages can only be seen through each account's own inbox, anything else can be
+	// looked up for the whole batch at once.
+	for i := range inboxed {
+		snc.checkAccount(ctx, &inboxed[i], nil)
+	}
+
+	batch := snc.lookupLastThings(ctx, others)
+	for i := range others {
+		snc.checkAccount(ctx, &others[i], batchedThings(batch, others[i].LastMessageID))
+	}
+}
+
+// lookupLastThings fetches the last thing of every account in one go, using the first
+// account's tokens. It returns nil when there's nothing worth batching or the lookup
+// failed, leaving each account to look its own last thing up. Since the first account
+// may not be able to see everyone's things, only what it got back can be relied on, see
+// batchedThings.
+func (snc *stuckNotificationsConsumer) lookupLastThings(ctx context.Context, accounts []domain.Account) *reddit.ListingResponse {
+	if len(accounts) < 2 {
+		return nil
+	}
+
+	fullnames := make([]string, len(accounts))
+	for i, account := range accounts {
+		fullnames[i] = account.LastMessageID
+	}
+
+	rac := snc.reddit.NewAuthenticatedClient(accounts[0].AccountID, accounts[0].RefreshToken, accounts[0].AccessToken)
+	things, err := rac.AboutInfoMany(ctx, fullnames)
+	if err != nil {
+		snc.logger.Warn("failed to fetch last things in batch, checking accounts one by one",
+			zap.Error(err),
+			zap.Int("count", len(accounts)),
 		)
-		return
+		return nil
 	}
 
+	_ = snc.statsd.Incr("apollo.stuck_notifications.batched", nil, float64(len(accounts)))
+	return things
+}
+
+// checkAccount resets account's last message ID if the thing it points at got deleted.
+// things is what looking that thing up returned, if it was already fetched as part of a
+// batch.
+func (snc *stuckNotificationsConsumer) checkAccount(ctx context.Context, account *domain.Account, things *reddit.ListingResponse) {
+	id := account.ID
 	rac := snc.reddit.NewAuthenticatedClient(account.AccountID, account.RefreshToken, account.AccessToken)
 
 	snc.logger.Debug("fetching last thing",
@@ -149,7 +193,7 @@ func (snc *stuckNotificationsConsumer) Consume(delivery rmq.Delivery) {
 
 	kind := account.LastMessageID[:2]
 
-	var things *reddit.ListingResponse
+	var err error
 	if kind == "t4" {
 		snc.logger.Debug("checking last thing via inbox",
 			zap.Int64("account#id", id),
@@ -167,7 +211,7 @@ func (snc *stuckNotificationsConsumer) Consume(delivery rmq.Delivery) {
 			}
 			return
 		}
-	} else {
+	} else if things == nil {
 		things, err = rac.AboutInfo(ctx, account.LastMessageID)
 		if err != nil {
 			snc.logger.Error("failed to fetch last thing",
@@ -179,46 +223,22 @@ func (snc *stuckNotificationsConsumer) Consume(delivery rmq.Delivery) {
 		}
 	}
 
-	if things.Count > 0 {
-		for _, thing := range things.Children {
-			if thing.FullName() != account.LastMessageID {
-				continue
-			}
-
-			if thing.IsDeleted() {
-				break
-			}
-
-			if kind == "t4" {
-				return
-			}
-
-			sthings, err := rac.MessageInbox(ctx)
-			if err != nil {
-				snc.logger.Error("failed to check inbox",
-					zap.Error(err),
-					zap.Int64("account#id", id),
-					zap.String("account#username", account.NormalizedUsername()),
-				)
-				return
-			}
-
-			found := false
-			for _, sthing := range sthings.Children {
-				if sthing.FullName() == account.LastMessageID {
-					found = true
-				}
-			}
+	if lastThingExists(account.LastMessageID, things) {
+		if kind == "t4" {
+			return
+		}
 
-			if !found {
-				snc.logger.Debug("thing exists, but not on inbox, marking as deleted",
-					zap.Int64("account#id", id),
-					zap.String("account#username", account.NormalizedUsername()),
-					zap.String("thing#id", account.LastMessageID),
-				)
-				break
-			}
+		sthings, err := rac.MessageInbox(ctx)
+		if err != nil {
+			snc.logger.Error("failed to check inbox",
+				zap.Error(err),
+				zap.Int64("account#id", id),
+				zap.String("account#username", account.NormalizedUsername()),
+			)
+			return
+		}
 
+		if thingsMatching(sthings, account.LastMessageID).Count > 0 {
 			snc.logger.Debug("thing exists, bailing early",
 				zap.Int64("account#id", id),
 				zap.String("account#username", account.NormalizedUsername()),
@@ -226,6 +246,12 @@ func (snc *stuckNotificationsConsumer) Consume(delivery rmq.Delivery) {
 			)
 			return
 		}
+
+		snc.logger.Debug("thing exists, but not on inbox, marking as deleted",
+			zap.Int64("account#id", id),
+			zap.String("account#username", account.NormalizedUsername()),
+			zap.String("thing#id", account.LastMessageID),
+		)
 	}
 
 	snc.logger.Info("thing got deleted, resetting",
@@ -251,25 +277,11 @@ func (snc *stuckNotificationsConsumer) Consume(delivery rmq.Delivery) {
 		}
 	}
 
-	account.LastMessageID = ""
-
 	snc.logger.Debug("calculating last good thing",
 		zap.Int64("account#id", id),
 		zap.String("account#username", account.NormalizedUsername()),
 	)
-	for _, thing := range things.Children {
-		if thing.IsDeleted() {
-			snc.logger.Debug("thing got deleted, checking next",
-				zap.Int64("account#id", id),
-				zap.String("account#username", account.NormalizedUsername()),
-				zap.String("thing#id", thing.FullName()),
-			)
-			continue
-		}
-
-		account.LastMessageID = thing.FullName()
-		break
-	}
+	account.LastMessageID = lastGoodThingID(things)
 
 	snc.logger.Debug("updating last good thing",
 		zap.Int64("account#id", id),
@@ -277,7 +289,7 @@ func (snc *stuckNotificationsConsumer) Consume(delivery rmq.Delivery) {
 		zap.String("thing#id", account.LastMessageID),
 	)
 
-	if err := snc.accountRepo.Update(ctx, &account); err != nil {
+	if err := snc.accountRepo.Update(ctx, account); err != nil {
 		snc.logger.Error("failed to update account's last message id",
 			zap.Error(err),
 			zap.Int64("account#id", id),
@@ -285,3 +297,67 @@ func (snc *stuckNotificationsConsumer) Consume(delivery rmq.Delivery) {
 		)
 	}
 }
+
+// parseStuckPayload reads the comma separated account IDs of a stuck notifications job.
+func parseStuckPayload(payload string) ([]int64, error) {
+	parts := strings.Split(payload, ",")
+
+	ids := make([]int64, len(parts))
+	for i, part := range parts {
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// thingsMatching narrows things down to the ones called fullname.
+func thingsMatching(things *reddit.ListingResponse, fullname string) *reddit.ListingResponse {
+	ret := &reddit.ListingResponse{}
+	for _, thing := range things.Children {
+		if thing.FullName() == fullname {
+			ret.Children = append(ret.Children, thing)
+		}
+	}
+	ret.Count = len(ret.Children)
+	return ret
+}
+
+// batchedThings picks fullname out of a batched lookup. Something missing from the batch
+// may just be out of sight of the account that ran it, so it returns nil then, leaving
+// the account to look the thing up with its own tokens.
+func batchedThings(batch *reddit.ListingResponse, fullname string) *reddit.ListingResponse {
+	if batch == nil {
+		return nil
+	}
+
+	things := thingsMatching(batch, fullname)
+	if things.Count == 0 {
+		return nil
+	}
+	return things
+}
+
+// lastThingExists reports whether fullname is among things and hasn't been deleted.
+func lastThingExists(fullname string, things *reddit.ListingResponse) bool {
+	for _, thing := range things.Children {
+		if thing.FullName() != fullname {
+			continue
+		}
+		return !thing.IsDeleted()
+	}
+	return false
+}
+
+// lastGoodThingID returns the newest thing that hasn't been deleted, if there is one.
+func lastGoodThingID(things *reddit.ListingResponse) string {
+	for _, thing := range things.Children {
+		if thing.IsDeleted() {
+			continue
+		}
+		return thing.FullName()
+	}
+	return ""
+}
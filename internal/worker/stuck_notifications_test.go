@@ -0,0 +1,129 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/adjust/rmq/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/reddit"
+	"github.com/christianselig/apollo-backend/internal/worker"
+)
+
+func TestParseStuckPayload(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		payload string
+		want    []int64
+		err     bool
+	}{
+		"single account": {"42", []int64{42}, false},
+		"batch":          {"1,2,3", []int64{1, 2, 3}, false},
+		"garbage":        {"1,x", nil, true},
+		"empty":          {"", nil, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			ids, err := worker.ParseStuckPayload(tc.payload)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, ids)
+		})
+	}
+}
+
+func TestStuckNotificationsBatchedDecisions(t *testing.T) {
+	t.Parallel()
+
+	alive := &reddit.Thing{Kind: "t1", ID: "alive", Author: "hugocat"}
+	deleted := &reddit.Thing{Kind: "t1", ID: "deleted", Author: "[deleted]"}
+	other := &reddit.Thing{Kind: "t1", ID: "other", Author: "hugocat"}
+
+	// What looking each thing up on its own would have returned.
+	single := map[string]*reddit.ListingResponse{
+		"t1_alive":   {Count: 1, Children: []*reddit.Thing{alive}},
+		"t1_deleted": {Count: 1, Children: []*reddit.Thing{deleted}},
+		"t1_other":   {Count: 1, Children: []*reddit.Thing{other}},
+		"t1_missing": {Count: 0},
+	}
+
+	batch := &reddit.ListingResponse{Count: 3, Children: []*reddit.Thing{other, deleted, alive}}
+
+	for fullname, things := range single {
+		fullname, things := fullname, things
+		t.Run(fullname, func(t *testing.T) {
+			t.Parallel()
+
+			want := worker.LastThingExists(fullname, things)
+			got := worker.LastThingExists(fullname, worker.ThingsMatching(batch, fullname))
+			assert.Equal(t, want, got)
+		})
+	}
+
+	assert.True(t, worker.LastThingExists("t1_alive", batch))
+	assert.False(t, worker.LastThingExists("t1_deleted", batch))
+	assert.False(t, worker.LastThingExists("t1_missing", batch))
+}
+
+func TestBatchedThings(t *testing.T) {
+	t.Parallel()
+
+	alive := &reddit.Thing{Kind: "t1", ID: "alive", Author: "hugocat"}
+	batch := &reddit.ListingResponse{Count: 1, Children: []*reddit.Thing{alive}}
+
+	things := worker.BatchedThings(batch, "t1_alive")
+	if assert.NotNil(t, things) {
+		assert.Equal(t, []*reddit.Thing{alive}, things.Children)
+	}
+
+	// Left out of the batch means the account has to look for itself, not that it's gone.
+	assert.Nil(t, worker.BatchedThings(batch, "t1_private"))
+	assert.Nil(t, worker.BatchedThings(nil, "t1_alive"))
+}
+
+func TestLastGoodThingID(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		things []*reddit.Thing
+		want   string
+	}{
+		"newest":       {[]*reddit.Thing{{Kind: "t4", ID: "a"}, {Kind: "t4", ID: "b"}}, "t4_a"},
+		"skip deleted": {[]*reddit.Thing{{Kind: "t4", ID: "a", Author: "[deleted]"}, {Kind: "t4", ID: "b"}}, "t4_b"},
+		"all deleted":  {[]*reddit.Thing{{Kind: "t4", ID: "a", Author: "[deleted]"}}, ""},
+		"empty":        {nil, ""},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			lr := &reddit.ListingResponse{Count: len(tc.things), Children: tc.things}
+			assert.Equal(t, tc.want, worker.LastGoodThingID(lr))
+		})
+	}
+}
+
+func TestNewStuckNotificationsWorker_InjectedRedditClient(t *testing.T) {
+	t.Parallel()
+
+	rc := reddit.NewClient("<ID>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, nil, 1)
+	w := worker.NewStuckNotificationsWorker(context.Background(), zap.NewNop(), otel.Tracer("test"), &statsd.NoOpClient{}, nil, nil, rc, rmq.NewTestConnection(), 1)
+
+	assert.Same(t, rc, worker.RedditClient(w))
+}
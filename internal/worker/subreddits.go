@@ -3,10 +3,7 @@ package worker
 import (
 	"context"
 	"fmt"
-	"math/rand"
-	"os"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
@@ -15,10 +12,10 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sideshow/apns2"
 	"github.com/sideshow/apns2/payload"
-	"github.com/sideshow/apns2/token"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
 	"github.com/christianselig/apollo-backend/internal/domain"
 	"github.com/christianselig/apollo-backend/internal/reddit"
 	"github.com/christianselig/apollo-backend/internal/repository"
@@ -29,50 +26,51 @@ type subredditsWorker struct {
 
 	logger *zap.Logger
 	tracer trace.Tracer
-	statsd *statsd.Client
+	statsd statsd.ClientInterface
 	db     *pgxpool.Pool
 	redis  *redis.Client
 	queue  rmq.Connection
 	reddit *reddit.Client
-	apns   *token.Token
+	apns   *apnsClientPool
 
 	consumers int
 
-	accountRepo   domain.AccountRepository
-	deviceRepo    domain.DeviceRepository
-	subredditRepo domain.SubredditRepository
-	watcherRepo   domain.WatcherRepository
+	accountRepo          domain.AccountRepository
+	deviceRepo           domain.DeviceRepository
+	subredditRepo        domain.SubredditRepository
+	watcherRepo          domain.WatcherRepository
+	sentNotificationRepo domain.SentNotificationRepository
+
+	jobs        *jobTracker
+	retries     *retryPolicy
+	credentials *CredentialPicker
+
+	dedupTTL time.Duration
+}
+
+// The subreddit and trending workers share hot listings through the cache, so they have to
+// request them the same way.
+var hotListingOptions = []reddit.RequestOption{
+	reddit.WithQuery("limit", "100"),
+	reddit.WithQuery("show", "all"),
+	reddit.WithQuery("always_show_media", "1"),
 }
 
 const (
 	subredditNotificationTitleFormat = "📣 \u201c%s\u201d Watcher"
 	subredditNotificationBodyFormat  = "r/%s: \u201c%s\u201d"
-)
 
-func NewSubredditsWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd *statsd.Client, db *pgxpool.Pool, redis *redis.Client, queue rmq.Connection, consumers int) Worker {
-	reddit := reddit.NewClient(
-		os.Getenv("REDDIT_CLIENT_ID"),
-		os.Getenv("REDDIT_CLIENT_SECRET"),
-		tracer,
-		statsd,
-		redis,
-		consumers,
-	)
-
-	var apns *token.Token
-	{
-		authKey, err := token.AuthKeyFromFile(os.Getenv("APPLE_KEY_PATH"))
-		if err != nil {
-			panic(err)
-		}
+	subredditNotificationPriority = domain.NotificationPriorityLow
+)
 
-		apns = &token.Token{
-			AuthKey: authKey,
-			KeyID:   os.Getenv("APPLE_KEY_ID"),
-			TeamID:  os.Getenv("APPLE_TEAM_ID"),
-		}
+func NewSubredditsWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd statsd.ClientInterface, db *pgxpool.Pool, redis *redis.Client, reddit *reddit.Client, queue rmq.Connection, consumers int) Worker {
+	apns, err := cmdutil.NewAPNSToken()
+	if err != nil {
+		panic(err)
 	}
 
+	conn := repository.WithAcquireTimeout(db, repository.DefaultAcquireTimeout)
+
 	return &subredditsWorker{
 		ctx,
 		logger,
@@ -82,46 +80,39 @@ func NewSubredditsWorker(ctx context.Context, logger *zap.Logger, tracer trace.T
 		redis,
 		queue,
 		reddit,
-		apns,
+		newAPNSClientPool(apns, consumers),
 		consumers,
 
-		repository.NewPostgresAccount(db),
-		repository.NewPostgresDevice(db),
-		repository.NewPostgresSubreddit(db),
-		repository.NewPostgresWatcher(db),
+		repository.NewPostgresAccount(conn),
+		repository.NewPostgresDevice(conn),
+		repository.NewPostgresSubreddit(conn),
+		repository.NewPostgresWatcher(conn),
+		repository.NewPostgresSentNotification(conn),
+
+		newJobTracker(),
+		newRetryPolicy("subreddits", queue, redis),
+		NewCredentialPicker(redis),
+
+		watcherDedupTTL(domain.SubredditWatcher),
 	}
 }
 
 func (sw *subredditsWorker) Start() error {
-	queue, err := sw.queue.OpenQueue("subreddits")
-	if err != nil {
-		return err
-	}
-
 	sw.logger.Info("starting up subreddits worker", zap.Int("consumers", sw.consumers))
 
-	prefetchLimit := int64(sw.consumers * 2)
-
-	if err := queue.StartConsuming(prefetchLimit, pollDuration); err != nil {
-		return err
-	}
-
-	host, _ := os.Hostname()
+	return startConsuming(sw, sw.logger, sw.queue, "subreddits", sw.consumers, 2, func(tag int) rmq.Consumer {
+		return NewSubredditsConsumer(sw, tag)
+	})
+}
 
-	for i := 0; i < sw.consumers; i++ {
-		name := fmt.Sprintf("consumer %s-%d", host, i)
+func (sw *subredditsWorker) Stop() {
+	stopped := sw.queue.StopAllConsuming()
 
-		consumer := NewSubredditsConsumer(sw, i)
-		if _, err := queue.AddConsumer(name, consumer); err != nil {
-			return err
-		}
+	if !sw.jobs.drain(shutdownGracePeriod) {
+		sw.logger.Warn("shutdown grace period elapsed, cancelling in-flight jobs")
 	}
 
-	return nil
-}
-
-func (sw *subredditsWorker) Stop() {
-	<-sw.queue.StopAllConsuming() // wait for all Consume() calls to finish
+	<-stopped // wait for all Consume() calls to finish
 }
 
 type subredditsConsumer struct {
@@ -136,25 +127,30 @@ func NewSubredditsConsumer(sw *subredditsWorker, tag int) *subredditsConsumer {
 	return &subredditsConsumer{
 		sw,
 		tag,
-		apns2.NewTokenClient(sw.apns),
-		apns2.NewTokenClient(sw.apns).Production(),
+		sw.apns.development(tag),
+		sw.apns.production(tag),
 	}
 }
 
 func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
-	ctx, cancel := context.WithCancel(sc)
+	jctx, done := sc.jobs.start()
+	defer done()
+
+	ctx, cancel := context.WithCancel(jctx)
 	defer cancel()
 
 	id, err := strconv.ParseInt(delivery.Payload(), 10, 64)
 	if err != nil {
 		sc.logger.Error("failed to parse subreddit id from payload", zap.Error(err), zap.String("payload", delivery.Payload()))
-		_ = delivery.Reject()
+		if dead, _ := sc.retries.reject(ctx, delivery); dead {
+			sc.logger.Warn("moved payload to dead-letter queue", zap.String("payload", delivery.Payload()))
+		}
 		return
 	}
 
 	sc.logger.Debug("starting job", zap.Int64("subreddit#id", id))
 
-	defer func() { _ = delivery.Ack() }()
+	defer func() { _ = sc.retries.ack(ctx, delivery) }()
 
 	subreddit, err := sc.subredditRepo.GetByID(ctx, id)
 	if err != nil {
@@ -173,6 +169,7 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 	}
 
 	if len(watchers) == 0 {
+		_ = sc.statsd.Incr("apollo.watcher.jobs.empty", subredditWatcherTags, 0.1)
 		sc.logger.Debug("no watchers for subreddit, bailing early",
 			zap.Int64("subreddit#id", id),
 			zap.String("subreddit#name", subreddit.NormalizedName()),
@@ -199,8 +196,15 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 			zap.Int("page", page),
 		)
 
-		i := rand.Intn(len(watchers))
-		watcher := watchers[i]
+		watcher, err := sc.credentials.pickWatcher(ctx, watchers, time.Now())
+		if err != nil {
+			sc.logger.Info("no watcher credentials available, bailing early",
+				zap.Error(err),
+				zap.Int64("subreddit#id", id),
+				zap.String("subreddit#name", subreddit.NormalizedName()),
+			)
+			return
+		}
 
 		rac := sc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
 		sps, err := rac.SubredditNew(ctx,
@@ -249,6 +253,7 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 
 		// If it's empty, we're done
 		if sps.Count == 0 {
+			finished = true
 			break
 		}
 
@@ -279,22 +284,41 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 		}
 	}
 
+	// Measure how busy the subreddit is from its new posts. If we stopped at the page limit
+	// rather than the date threshold, they only go back as far as the oldest one.
+	span := 24 * time.Hour
+	if !finished && len(posts) > 0 {
+		span = time.Since(posts[len(posts)-1].CreatedAt)
+	}
+
+	subreddit.PostsPerHour = float64(len(posts)) / span.Hours()
+	subreddit.NextCheckAt = time.Now().Add(subreddit.CheckInterval())
+	subreddit.LastCheckedAt = time.Now()
+	if len(posts) > 0 {
+		subreddit.LastPostID = posts[0].ID
+	}
+	if err := sc.subredditRepo.Update(ctx, &subreddit); err != nil {
+		sc.logger.Error("failed to update subreddit check interval",
+			zap.Error(err),
+			zap.Int64("subreddit#id", id),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+		)
+	}
+
 	// Load hot posts
 	sc.logger.Debug("loading hot posts",
 		zap.Int64("subreddit#id", id),
 		zap.String("subreddit#name", subreddit.NormalizedName()),
 	)
-	{
-		i := rand.Intn(len(watchers))
-		watcher := watchers[i]
-
-		rac := sc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
-		sps, err := rac.SubredditHot(ctx,
-			subreddit.Name,
-			reddit.WithQuery("limit", "100"),
-			reddit.WithQuery("show", "all"),
-			reddit.WithQuery("always_show_media", "1"),
+	if watcher, err := sc.credentials.pickWatcher(ctx, watchers, time.Now()); err != nil {
+		sc.logger.Info("no watcher credentials available, skipping hot posts",
+			zap.Error(err),
+			zap.Int64("subreddit#id", id),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
 		)
+	} else {
+		rac := sc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
+		sps, err := rac.CachedSubredditPosts(ctx, subreddit.Name, "hot", hotListingOptions...)
 
 		if err != nil {
 			sc.logger.Error("failed to fetch hot posts",
@@ -335,13 +359,11 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 		zap.String("subreddit#name", subreddit.NormalizedName()),
 		zap.Int("count", len(posts)),
 	)
+	_ = sc.statsd.Histogram("apollo.watcher.posts_scanned", float64(len(posts)), subredditWatcherTags, 0.1)
 	for _, post := range posts {
-		lowcaseAuthor := strings.ToLower(post.Author)
-		lowcaseTitle := strings.ToLower(post.Title)
-		lowcaseFlair := strings.ToLower(post.Flair)
-		lowcaseDomain := strings.ToLower(post.URL)
+		watched := watchedPost(post)
 
-		notifs := []domain.Watcher{}
+		candidates := []domain.Watcher{}
 
 		for _, watcher := range watchers {
 			// Make sure we only alert on posts created after the search
@@ -349,25 +371,12 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 				continue
 			}
 
-			matched := watcher.KeywordMatches(lowcaseTitle)
-
-			if watcher.Author != "" && lowcaseAuthor != watcher.Author {
-				matched = false
-			}
-
-			if watcher.Upvotes > 0 && post.Score < watcher.Upvotes {
-				matched = false
-			}
-
-			if watcher.Flair != "" && !strings.Contains(lowcaseFlair, watcher.Flair) {
-				matched = false
-			}
-
-			if watcher.Domain != "" && !strings.Contains(lowcaseDomain, watcher.Domain) {
-				matched = false
+			if !watcher.MatchesPost(watched) {
+				continue
 			}
 
-			if !matched {
+			if watcher.Device.InQuietHours(time.Now()) {
+				_ = sc.statsd.Incr("apns.notification.quiet_hours", subredditWatcherTags, 1)
 				continue
 			}
 
@@ -382,19 +391,41 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 				zap.Int64("post#score", post.Score),
 			)
 
-			lockKey := fmt.Sprintf("watcher:%d:%s", watcher.DeviceID, post.ID)
-			notified, _ := sc.redis.Get(ctx, lockKey).Bool()
+			candidates = append(candidates, watcher)
+		}
 
-			if notified {
-				sc.logger.Debug("already notified, skipping",
-					zap.Int64("subreddit#id", id),
-					zap.String("subreddit#name", subreddit.NormalizedName()),
-					zap.Int64("watcher#id", watcher.ID),
-					zap.String("post#id", post.ID),
-				)
-				continue
-			}
+		lockKey := func(watcher domain.Watcher) string {
+			return fmt.Sprintf("watcher:%d:%s", watcher.DeviceID, post.ID)
+		}
+
+		notifs, err := unnotifiedWatchers(ctx, sc.redis, candidates, lockKey)
+		if err != nil {
+			sc.logger.Error("failed to check watcher locks",
+				zap.Error(err),
+				zap.Int64("subreddit#id", id),
+				zap.String("subreddit#name", subreddit.NormalizedName()),
+				zap.String("post#id", post.ID),
+			)
+		}
+		if skipped := len(candidates) - len(notifs); skipped > 0 {
+			sc.logger.Debug("already notified, skipping",
+				zap.Int64("subreddit#id", id),
+				zap.String("subreddit#name", subreddit.NormalizedName()),
+				zap.String("post#id", post.ID),
+				zap.Int("count", skipped),
+			)
+		}
+
+		if err := markWatchersNotified(ctx, sc.redis, notifs, lockKey, sc.dedupTTL); err != nil {
+			sc.logger.Error("failed to lock watchers",
+				zap.Error(err),
+				zap.Int64("subreddit#id", id),
+				zap.String("subreddit#name", subreddit.NormalizedName()),
+				zap.String("post#id", post.ID),
+			)
+		}
 
+		for _, watcher := range notifs {
 			if err := sc.watcherRepo.IncrementHits(ctx, watcher.ID); err != nil {
 				sc.logger.Error("could not increment hits",
 					zap.Error(err),
@@ -410,14 +441,12 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 				zap.Int64("watcher#id", watcher.ID),
 				zap.String("post#id", post.ID),
 			)
-
-			sc.redis.SetEX(ctx, lockKey, true, 24*time.Hour)
-			notifs = append(notifs, watcher)
 		}
 
 		if len(notifs) == 0 {
 			continue
 		}
+		_ = sc.statsd.Count("apollo.watcher.hits", int64(len(notifs)), subredditWatcherTags, 1)
 		sc.logger.Debug("got hits for post",
 			zap.Int64("subreddit#id", id),
 			zap.String("subreddit#name", subreddit.NormalizedName()),
@@ -425,17 +454,18 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 			zap.Int("count", len(notifs)),
 		)
 
-		payload := payloadFromPost(post)
-
 		for _, watcher := range notifs {
+			payload := payloadFromPost(post, watcher.NotificationSound())
+
 			title := fmt.Sprintf(subredditNotificationTitleFormat, watcher.Label)
 			payload.AlertTitle(title)
 
-			body := fmt.Sprintf(subredditNotificationBodyFormat, subreddit.Name, post.Title)
+			body := fmt.Sprintf(subredditNotificationBodyFormat, subreddit.Name, reddit.PlainText(post.Title))
 			payload.AlertBody(body)
 
 			notification := &apns2.Notification{}
 			notification.Topic = "com.christianselig.Apollo"
+			notification.Priority = apnsPriority(subredditNotificationPriority)
 			notification.DeviceToken = watcher.Device.APNSToken
 			notification.Payload = payload
 
@@ -444,7 +474,7 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 				client = sc.apnsSandbox
 			}
 
-			res, err := client.Push(notification)
+			res, err := pushWithSpan(ctx, sc.tracer, client, notification)
 			if err != nil {
 				_ = sc.statsd.Incr("apns.notification.errors", []string{}, 1)
 				sc.logger.Error("failed to send notification",
@@ -452,7 +482,7 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 					zap.Int64("subreddit#id", id),
 					zap.String("subreddit#name", subreddit.NormalizedName()),
 					zap.String("post#id", post.ID),
-					zap.String("apns", watcher.Device.APNSToken),
+					zap.String("apns", watcher.Device.ObfuscatedToken()),
 				)
 			} else if !res.Sent() {
 				_ = sc.statsd.Incr("apns.notification.errors", []string{}, 1)
@@ -460,17 +490,18 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 					zap.Int64("subreddit#id", id),
 					zap.String("subreddit#name", subreddit.NormalizedName()),
 					zap.String("post#id", post.ID),
-					zap.String("apns", watcher.Device.APNSToken),
+					zap.String("apns", watcher.Device.ObfuscatedToken()),
 					zap.Int("response#status", res.StatusCode),
 					zap.String("response#reason", res.Reason),
 				)
 			} else {
 				_ = sc.statsd.Incr("apns.notification.sent", []string{}, 1)
+				recordSentNotification(ctx, sc.sentNotificationRepo, watcher.Device.ID, notification.Payload)
 				sc.logger.Info("sent notification",
 					zap.Int64("subreddit#id", id),
 					zap.String("subreddit#name", subreddit.NormalizedName()),
 					zap.String("post#id", post.ID),
-					zap.String("device#token", watcher.Device.APNSToken),
+					zap.String("device#token", watcher.Device.ObfuscatedToken()),
 				)
 			}
 		}
@@ -482,7 +513,20 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 	)
 }
 
-func payloadFromPost(post *reddit.Thing) *payload.Payload {
+// watchedPost returns what a watcher's criteria get matched against for post.
+func watchedPost(post *reddit.Thing) domain.WatchedPost {
+	return domain.WatchedPost{
+		Author:    post.Author,
+		Title:     post.Title,
+		Flair:     post.Flair,
+		URL:       post.URL,
+		Score:     post.Score,
+		NSFW:      post.Over18,
+		Crosspost: post.IsCrosspost,
+	}
+}
+
+func payloadFromPost(post *reddit.Thing, sound string) *payload.Payload {
 	payload := payload.
 		NewPayload().
 		AlertSummaryArg(post.Subreddit).
@@ -493,8 +537,9 @@ func payloadFromPost(post *reddit.Thing) *payload.Payload {
 		Custom("author", post.Author).
 		Custom("post_age", post.CreatedAt).
 		ThreadID("subreddit-watcher").
+		InterruptionLevel(interruptionLevel(subredditNotificationPriority)).
 		MutableContent().
-		Sound("traloop.wav")
+		Sound(sound)
 
 	if post.Thumbnail != "" && !post.Over18 {
 		payload.Custom("thumbnail", post.Thumbnail)
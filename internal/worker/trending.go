@@ -3,8 +3,6 @@ package worker
 import (
 	"context"
 	"fmt"
-	"math/rand"
-	"os"
 	"sort"
 	"strconv"
 	"time"
@@ -15,10 +13,10 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sideshow/apns2"
 	"github.com/sideshow/apns2/payload"
-	"github.com/sideshow/apns2/token"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
 	"github.com/christianselig/apollo-backend/internal/domain"
 	"github.com/christianselig/apollo-backend/internal/reddit"
 	"github.com/christianselig/apollo-backend/internal/repository"
@@ -29,46 +27,43 @@ type trendingWorker struct {
 
 	logger *zap.Logger
 	tracer trace.Tracer
-	statsd *statsd.Client
+	statsd statsd.ClientInterface
 	redis  *redis.Client
 	queue  rmq.Connection
 	reddit *reddit.Client
-	apns   *token.Token
+	apns   *apnsClientPool
 
 	consumers int
 
-	accountRepo   domain.AccountRepository
-	deviceRepo    domain.DeviceRepository
-	subredditRepo domain.SubredditRepository
-	watcherRepo   domain.WatcherRepository
-}
+	accountRepo          domain.AccountRepository
+	deviceRepo           domain.DeviceRepository
+	subredditRepo        domain.SubredditRepository
+	watcherRepo          domain.WatcherRepository
+	sentNotificationRepo domain.SentNotificationRepository
 
-const trendingNotificationTitleFormat = "🔥 r/%s Trending"
+	jobs        *jobTracker
+	retries     *retryPolicy
+	credentials *CredentialPicker
 
-func NewTrendingWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd *statsd.Client, db *pgxpool.Pool, redis *redis.Client, queue rmq.Connection, consumers int) Worker {
-	reddit := reddit.NewClient(
-		os.Getenv("REDDIT_CLIENT_ID"),
-		os.Getenv("REDDIT_CLIENT_SECRET"),
-		tracer,
-		statsd,
-		redis,
-		consumers,
-	)
+	dedupTTL time.Duration
+}
 
-	var apns *token.Token
-	{
-		authKey, err := token.AuthKeyFromFile(os.Getenv("APPLE_KEY_PATH"))
-		if err != nil {
-			panic(err)
-		}
+const (
+	trendingNotificationTitleFormat = "🔥 r/%s Trending"
+	trendingNotificationPriority    = domain.NotificationPriorityLow
 
-		apns = &token.Token{
-			AuthKey: authKey,
-			KeyID:   os.Getenv("APPLE_KEY_ID"),
-			TeamID:  os.Getenv("APPLE_TEAM_ID"),
-		}
+	// Reddit's default page size, which is all trending used to fetch.
+	trendingHotPostsLimit = 25
+)
+
+func NewTrendingWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd statsd.ClientInterface, db *pgxpool.Pool, redis *redis.Client, reddit *reddit.Client, queue rmq.Connection, consumers int) Worker {
+	apns, err := cmdutil.NewAPNSToken()
+	if err != nil {
+		panic(err)
 	}
 
+	conn := repository.WithAcquireTimeout(db, repository.DefaultAcquireTimeout)
+
 	return &trendingWorker{
 		ctx,
 		logger,
@@ -77,46 +72,39 @@ func NewTrendingWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tra
 		redis,
 		queue,
 		reddit,
-		apns,
+		newAPNSClientPool(apns, consumers),
 		consumers,
 
-		repository.NewPostgresAccount(db),
-		repository.NewPostgresDevice(db),
-		repository.NewPostgresSubreddit(db),
-		repository.NewPostgresWatcher(db),
+		repository.NewPostgresAccount(conn),
+		repository.NewPostgresDevice(conn),
+		repository.NewPostgresSubreddit(conn),
+		repository.NewPostgresWatcher(conn),
+		repository.NewPostgresSentNotification(conn),
+
+		newJobTracker(),
+		newRetryPolicy("trending", queue, redis),
+		NewCredentialPicker(redis),
+
+		watcherDedupTTL(domain.TrendingWatcher),
 	}
 }
 
 func (tw *trendingWorker) Start() error {
-	queue, err := tw.queue.OpenQueue("trending")
-	if err != nil {
-		return err
-	}
-
 	tw.logger.Info("starting up trending subreddits worker", zap.Int("consumers", tw.consumers))
 
-	prefetchLimit := int64(tw.consumers * 2)
-
-	if err := queue.StartConsuming(prefetchLimit, pollDuration); err != nil {
-		return err
-	}
-
-	host, _ := os.Hostname()
+	return startConsuming(tw, tw.logger, tw.queue, "trending", tw.consumers, 2, func(tag int) rmq.Consumer {
+		return NewTrendingConsumer(tw, tag)
+	})
+}
 
-	for i := 0; i < tw.consumers; i++ {
-		name := fmt.Sprintf("consumer %s-%d", host, i)
+func (tw *trendingWorker) Stop() {
+	stopped := tw.queue.StopAllConsuming()
 
-		consumer := NewTrendingConsumer(tw, i)
-		if _, err := queue.AddConsumer(name, consumer); err != nil {
-			return err
-		}
+	if !tw.jobs.drain(shutdownGracePeriod) {
+		tw.logger.Warn("shutdown grace period elapsed, cancelling in-flight jobs")
 	}
 
-	return nil
-}
-
-func (tw *trendingWorker) Stop() {
-	<-tw.queue.StopAllConsuming() // wait for all Consume() calls to finish
+	<-stopped // wait for all Consume() calls to finish
 }
 
 type trendingConsumer struct {
@@ -131,25 +119,30 @@ func NewTrendingConsumer(tw *trendingWorker, tag int) *trendingConsumer {
 	return &trendingConsumer{
 		tw,
 		tag,
-		apns2.NewTokenClient(tw.apns),
-		apns2.NewTokenClient(tw.apns).Production(),
+		tw.apns.development(tag),
+		tw.apns.production(tag),
 	}
 }
 
 func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
-	ctx, cancel := context.WithCancel(tc)
+	jctx, done := tc.jobs.start()
+	defer done()
+
+	ctx, cancel := context.WithCancel(jctx)
 	defer cancel()
 
 	id, err := strconv.ParseInt(delivery.Payload(), 10, 64)
 	if err != nil {
 		tc.logger.Error("failed to parse subreddit id from payload", zap.Error(err), zap.String("payload", delivery.Payload()))
-		_ = delivery.Reject()
+		if dead, _ := tc.retries.reject(ctx, delivery); dead {
+			tc.logger.Warn("moved payload to dead-letter queue", zap.String("payload", delivery.Payload()))
+		}
 		return
 	}
 
 	tc.logger.Debug("starting job", zap.Int64("subreddit#id", id))
 
-	defer func() { _ = delivery.Ack() }()
+	defer func() { _ = tc.retries.ack(ctx, delivery) }()
 
 	subreddit, err := tc.subredditRepo.GetByID(ctx, id)
 	if err != nil {
@@ -168,6 +161,7 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 	}
 
 	if len(watchers) == 0 {
+		_ = tc.statsd.Incr("apollo.watcher.jobs.empty", trendingWatcherTags, 0.1)
 		tc.logger.Debug("no watchers for subreddit, bailing early",
 			zap.Int64("subreddit#id", id),
 			zap.String("subreddit#name", subreddit.NormalizedName()),
@@ -176,8 +170,15 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 	}
 
 	// Grab last month's top posts so we calculate a trending average
-	i := rand.Intn(len(watchers))
-	watcher := watchers[i]
+	watcher, err := tc.credentials.pickWatcher(ctx, watchers, time.Now())
+	if err != nil {
+		tc.logger.Info("no watcher credentials available, bailing early",
+			zap.Error(err),
+			zap.Int64("subreddit#id", id),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+		)
+		return
+	}
 	rac := tc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
 
 	tps, err := rac.SubredditTop(ctx, subreddit.Name, reddit.WithQuery("t", "week"), reddit.WithQuery("show", "all"), reddit.WithQuery("limit", "25"))
@@ -218,11 +219,18 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 	)
 
 	// Grab hot posts and filter out anything that's > 2 days old
-	i = rand.Intn(len(watchers))
-	watcher = watchers[i]
+	watcher, err = tc.credentials.pickWatcher(ctx, watchers, time.Now())
+	if err != nil {
+		tc.logger.Info("no watcher credentials available, bailing early",
+			zap.Error(err),
+			zap.Int64("subreddit#id", id),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+		)
+		return
+	}
 	rac = tc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
 
-	hps, err := rac.SubredditHot(ctx, subreddit.Name, reddit.WithQuery("show", "all"), reddit.WithQuery("always_show_media", "1"))
+	hps, err := rac.CachedSubredditPosts(ctx, subreddit.Name, "hot", hotListingOptions...)
 	if err != nil {
 		tc.logger.Error("failed to fetch hot posts",
 			zap.Error(err),
@@ -240,7 +248,14 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 	// Trending only counts for posts less than 2 days old
 	threshold := time.Now().Add(-24 * time.Hour * 2)
 
-	for _, post := range hps.Children {
+	// Only consider what would have been the first page of hot posts
+	hot := hps.Children
+	if len(hot) > trendingHotPostsLimit {
+		hot = hot[:trendingHotPostsLimit]
+	}
+	_ = tc.statsd.Histogram("apollo.watcher.posts_scanned", float64(len(hot)), trendingWatcherTags, 0.1)
+
+	for _, post := range hot {
 		if post.Score < medianScore {
 			continue
 		}
@@ -251,27 +266,55 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 
 		notification := &apns2.Notification{}
 		notification.Topic = "com.christianselig.Apollo"
-		notification.Payload = payloadFromTrendingPost(post)
+		notification.Priority = apnsPriority(trendingNotificationPriority)
 
+		candidates := []domain.Watcher{}
 		for _, watcher := range watchers {
 			if watcher.CreatedAt.After(post.CreatedAt) {
 				continue
 			}
 
-			lockKey := fmt.Sprintf("watcher:trending:%d:%s", watcher.DeviceID, post.ID)
-			notified, _ := tc.redis.Get(ctx, lockKey).Bool()
-
-			if notified {
-				tc.logger.Debug("already notified, skipping",
-					zap.Int64("subreddit#id", id),
-					zap.String("subreddit#name", subreddit.NormalizedName()),
-					zap.Int64("watcher#id", watcher.ID),
-					zap.String("post#id", post.ID),
-				)
+			if watcher.Device.InQuietHours(time.Now()) {
+				_ = tc.statsd.Incr("apns.notification.quiet_hours", trendingWatcherTags, 1)
 				continue
 			}
 
-			tc.redis.SetEX(ctx, lockKey, true, 48*time.Hour)
+			candidates = append(candidates, watcher)
+		}
+
+		lockKey := func(watcher domain.Watcher) string {
+			return fmt.Sprintf("watcher:trending:%d:%s", watcher.DeviceID, post.ID)
+		}
+
+		notifs, err := unnotifiedWatchers(ctx, tc.redis, candidates, lockKey)
+		if err != nil {
+			tc.logger.Error("failed to check watcher locks",
+				zap.Error(err),
+				zap.Int64("subreddit#id", id),
+				zap.String("subreddit#name", subreddit.NormalizedName()),
+				zap.String("post#id", post.ID),
+			)
+		}
+		if skipped := len(candidates) - len(notifs); skipped > 0 {
+			tc.logger.Debug("already notified, skipping",
+				zap.Int64("subreddit#id", id),
+				zap.String("subreddit#name", subreddit.NormalizedName()),
+				zap.String("post#id", post.ID),
+				zap.Int("count", skipped),
+			)
+		}
+
+		if err := markWatchersNotified(ctx, tc.redis, notifs, lockKey, tc.dedupTTL); err != nil {
+			tc.logger.Error("failed to lock watchers",
+				zap.Error(err),
+				zap.Int64("subreddit#id", id),
+				zap.String("subreddit#name", subreddit.NormalizedName()),
+				zap.String("post#id", post.ID),
+			)
+		}
+
+		for _, watcher := range notifs {
+			_ = tc.statsd.Incr("apollo.watcher.hits", trendingWatcherTags, 1)
 
 			if err := tc.watcherRepo.IncrementHits(ctx, watcher.ID); err != nil {
 				tc.logger.Error("could not increment hits",
@@ -283,6 +326,7 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 				return
 			}
 
+			notification.Payload = payloadFromTrendingPost(post, watcher.NotificationSound())
 			notification.DeviceToken = watcher.Device.APNSToken
 
 			client := tc.apnsProduction
@@ -290,7 +334,7 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 				client = tc.apnsSandbox
 			}
 
-			res, err := client.Push(notification)
+			res, err := pushWithSpan(ctx, tc.tracer, client, notification)
 			if err != nil {
 				_ = tc.statsd.Incr("apns.notification.errors", []string{}, 1)
 				tc.logger.Error("failed to send notification",
@@ -298,7 +342,7 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 					zap.Int64("subreddit#id", id),
 					zap.String("subreddit#name", subreddit.NormalizedName()),
 					zap.String("post#id", post.ID),
-					zap.String("apns", watcher.Device.APNSToken),
+					zap.String("apns", watcher.Device.ObfuscatedToken()),
 					zap.Int64("median_score", medianScore),
 				)
 			} else if !res.Sent() {
@@ -307,19 +351,20 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 					zap.Int64("subreddit#id", id),
 					zap.String("subreddit#name", subreddit.NormalizedName()),
 					zap.String("post#id", post.ID),
-					zap.String("apns", watcher.Device.APNSToken),
+					zap.String("apns", watcher.Device.ObfuscatedToken()),
 					zap.Int64("median_score", medianScore),
 					zap.Int("response#status", res.StatusCode),
 					zap.String("response#reason", res.Reason),
 				)
 			} else {
 				_ = tc.statsd.Incr("apns.notification.sent", []string{}, 1)
+				recordSentNotification(ctx, tc.sentNotificationRepo, watcher.Device.ID, notification.Payload)
 				tc.logger.Info("sent notification",
 					zap.Int64("subreddit#id", id),
 					zap.String("subreddit#name", subreddit.NormalizedName()),
 					zap.String("post#id", post.ID),
 					zap.Int64("post#score", post.Score),
-					zap.String("device#token", watcher.Device.APNSToken),
+					zap.String("device#token", watcher.Device.ObfuscatedToken()),
 					zap.Int64("median_score", medianScore),
 				)
 			}
@@ -332,13 +377,13 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 	)
 }
 
-func payloadFromTrendingPost(post *reddit.Thing) *payload.Payload {
+func payloadFromTrendingPost(post *reddit.Thing, sound string) *payload.Payload {
 	title := fmt.Sprintf(trendingNotificationTitleFormat, post.Subreddit)
 
 	payload := payload.
 		NewPayload().
 		AlertTitle(title).
-		AlertBody(post.Title).
+		AlertBody(reddit.PlainText(post.Title)).
 		AlertSummaryArg(post.Subreddit).
 		Category("trending-post").
 		Custom("post_title", post.Title).
@@ -347,8 +392,9 @@ func payloadFromTrendingPost(post *reddit.Thing) *payload.Payload {
 		Custom("author", post.Author).
 		Custom("post_age", post.CreatedAt).
 		ThreadID("trending-post").
+		InterruptionLevel(interruptionLevel(trendingNotificationPriority)).
 		MutableContent().
-		Sound("traloop.wav")
+		Sound(sound)
 
 	if post.Thumbnail != "" && !post.Over18 {
 		payload.Custom("thumbnail", post.Thumbnail)
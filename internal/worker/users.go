@@ -3,10 +3,10 @@ package worker
 import (
 	"context"
 	"fmt"
-	"math/rand"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
 	"github.com/adjust/rmq/v5"
@@ -14,10 +14,10 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/sideshow/apns2"
 	"github.com/sideshow/apns2/payload"
-	"github.com/sideshow/apns2/token"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
 	"github.com/christianselig/apollo-backend/internal/domain"
 	"github.com/christianselig/apollo-backend/internal/reddit"
 	"github.com/christianselig/apollo-backend/internal/repository"
@@ -28,47 +28,39 @@ type usersWorker struct {
 
 	logger *zap.Logger
 	tracer trace.Tracer
-	statsd *statsd.Client
+	statsd statsd.ClientInterface
 	db     *pgxpool.Pool
 	redis  *redis.Client
 	queue  rmq.Connection
 	reddit *reddit.Client
-	apns   *token.Token
+	apns   *apnsClientPool
 
 	consumers int
 
-	accountRepo domain.AccountRepository
-	deviceRepo  domain.DeviceRepository
-	userRepo    domain.UserRepository
-	watcherRepo domain.WatcherRepository
-}
-
-const userNotificationTitleFormat = "👨\u200d🚀 %s"
+	accountRepo          domain.AccountRepository
+	deviceRepo           domain.DeviceRepository
+	userRepo             domain.UserRepository
+	watcherRepo          domain.WatcherRepository
+	sentNotificationRepo domain.SentNotificationRepository
 
-func NewUsersWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd *statsd.Client, db *pgxpool.Pool, redis *redis.Client, queue rmq.Connection, consumers int) Worker {
-	reddit := reddit.NewClient(
-		os.Getenv("REDDIT_CLIENT_ID"),
-		os.Getenv("REDDIT_CLIENT_SECRET"),
-		tracer,
-		statsd,
-		redis,
-		consumers,
-	)
+	jobs        *jobTracker
+	retries     *retryPolicy
+	credentials *CredentialPicker
+}
 
-	var apns *token.Token
-	{
-		authKey, err := token.AuthKeyFromFile(os.Getenv("APPLE_KEY_PATH"))
-		if err != nil {
-			panic(err)
-		}
+const (
+	userNotificationTitleFormat = "👨\u200d🚀 %s"
+	userNotificationPriority    = domain.NotificationPriorityNormal
+)
 
-		apns = &token.Token{
-			AuthKey: authKey,
-			KeyID:   os.Getenv("APPLE_KEY_ID"),
-			TeamID:  os.Getenv("APPLE_TEAM_ID"),
-		}
+func NewUsersWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd statsd.ClientInterface, db *pgxpool.Pool, redis *redis.Client, reddit *reddit.Client, queue rmq.Connection, consumers int) Worker {
+	apns, err := cmdutil.NewAPNSToken()
+	if err != nil {
+		panic(err)
 	}
 
+	conn := repository.WithAcquireTimeout(db, repository.DefaultAcquireTimeout)
+
 	return &usersWorker{
 		ctx,
 		logger,
@@ -78,46 +70,37 @@ func NewUsersWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer
 		redis,
 		queue,
 		reddit,
-		apns,
+		newAPNSClientPool(apns, consumers),
 		consumers,
 
-		repository.NewPostgresAccount(db),
-		repository.NewPostgresDevice(db),
-		repository.NewPostgresUser(db),
-		repository.NewPostgresWatcher(db),
+		repository.NewPostgresAccount(conn),
+		repository.NewPostgresDevice(conn),
+		repository.NewPostgresUser(conn),
+		repository.NewPostgresWatcher(conn),
+		repository.NewPostgresSentNotification(conn),
+
+		newJobTracker(),
+		newRetryPolicy("users", queue, redis),
+		NewCredentialPicker(redis),
 	}
 }
 
 func (uw *usersWorker) Start() error {
-	queue, err := uw.queue.OpenQueue("users")
-	if err != nil {
-		return err
-	}
-
 	uw.logger.Info("starting up subreddits worker", zap.Int("consumers", uw.consumers))
 
-	prefetchLimit := int64(uw.consumers * 2)
-
-	if err := queue.StartConsuming(prefetchLimit, pollDuration); err != nil {
-		return err
-	}
-
-	host, _ := os.Hostname()
+	return startConsuming(uw, uw.logger, uw.queue, "users", uw.consumers, 2, func(tag int) rmq.Consumer {
+		return NewUsersConsumer(uw, tag)
+	})
+}
 
-	for i := 0; i < uw.consumers; i++ {
-		name := fmt.Sprintf("consumer %s-%d", host, i)
+func (uw *usersWorker) Stop() {
+	stopped := uw.queue.StopAllConsuming()
 
-		consumer := NewUsersConsumer(uw, i)
-		if _, err := queue.AddConsumer(name, consumer); err != nil {
-			return err
-		}
+	if !uw.jobs.drain(shutdownGracePeriod) {
+		uw.logger.Warn("shutdown grace period elapsed, cancelling in-flight jobs")
 	}
 
-	return nil
-}
-
-func (uw *usersWorker) Stop() {
-	<-uw.queue.StopAllConsuming() // wait for all Consume() calls to finish
+	<-stopped // wait for all Consume() calls to finish
 }
 
 type usersConsumer struct {
@@ -132,25 +115,30 @@ func NewUsersConsumer(uw *usersWorker, tag int) *usersConsumer {
 	return &usersConsumer{
 		uw,
 		tag,
-		apns2.NewTokenClient(uw.apns),
-		apns2.NewTokenClient(uw.apns).Production(),
+		uw.apns.development(tag),
+		uw.apns.production(tag),
 	}
 }
 
 func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
-	ctx, cancel := context.WithCancel(uc)
+	jctx, done := uc.jobs.start()
+	defer done()
+
+	ctx, cancel := context.WithCancel(jctx)
 	defer cancel()
 
 	id, err := strconv.ParseInt(delivery.Payload(), 10, 64)
 	if err != nil {
 		uc.logger.Error("failed to parse subreddit id from payload", zap.Error(err), zap.String("payload", delivery.Payload()))
-		_ = delivery.Reject()
+		if dead, _ := uc.retries.reject(ctx, delivery); dead {
+			uc.logger.Warn("moved payload to dead-letter queue", zap.String("payload", delivery.Payload()))
+		}
 		return
 	}
 
 	uc.logger.Debug("starting job", zap.Int64("subreddit#id", id))
 
-	defer func() { _ = delivery.Ack() }()
+	defer func() { _ = uc.retries.ack(ctx, delivery) }()
 
 	user, err := uc.userRepo.GetByID(ctx, id)
 	if err != nil {
@@ -169,6 +157,7 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 	}
 
 	if len(watchers) == 0 {
+		_ = uc.statsd.Incr("apollo.watcher.jobs.empty", userWatcherTags, 0.1)
 		uc.logger.Debug("no watchers for user, bailing early",
 			zap.Int64("user#id", id),
 			zap.String("user#name", user.NormalizedName()),
@@ -177,8 +166,15 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 	}
 
 	// Load 25 newest posts
-	i := rand.Intn(len(watchers))
-	watcher := watchers[i]
+	watcher, err := uc.credentials.pickWatcher(ctx, watchers, time.Now())
+	if err != nil {
+		uc.logger.Info("no watcher credentials available, bailing early",
+			zap.Error(err),
+			zap.Int64("user#id", id),
+			zap.String("user#name", user.NormalizedName()),
+		)
+		return
+	}
 
 	acc, _ := uc.accountRepo.GetByID(ctx, watcher.AccountID)
 	rac := uc.reddit.NewAuthenticatedClient(acc.AccountID, acc.RefreshToken, acc.AccessToken)
@@ -228,10 +224,34 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 		return
 	}
 
-	for _, post := range posts.Children {
-		lowcaseSubreddit := strings.ToLower(post.Subreddit)
+	// Comments are a nice to have, so carry on with just the posts if they can't be had.
+	comments, err := rac.UserComments(ctx, user.Name)
+	if err != nil {
+		uc.logger.Warn("failed to fetch user comments",
+			zap.Error(err),
+			zap.Int64("user#id", id),
+			zap.String("user#name", user.NormalizedName()),
+		)
+		comments = &reddit.ListingResponse{}
+	}
+
+	devices, err := uc.watcherDevices(ctx, watchers)
+	if err != nil {
+		uc.logger.Error("failed to fetch watcher devices",
+			zap.Error(err),
+			zap.Int64("user#id", id),
+			zap.String("user#name", user.NormalizedName()),
+		)
+		return
+	}
+
+	activities := mergeActivity(posts.Children, comments.Children)
+	_ = uc.statsd.Histogram("apollo.watcher.posts_scanned", float64(len(activities)), userWatcherTags, 0.1)
 
-		if post.SubredditType == "private" {
+	for _, activity := range activities {
+		lowcaseSubreddit := strings.ToLower(activity.Subreddit)
+
+		if activity.SubredditType == "private" {
 			continue
 		}
 
@@ -239,11 +259,11 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 
 		for _, watcher := range watchers {
 			// Make sure we only alert on activities created after the search
-			if watcher.CreatedAt.After(post.CreatedAt) {
+			if watcher.CreatedAt.After(activity.CreatedAt) {
 				continue
 			}
 
-			if watcher.LastNotifiedAt.After(post.CreatedAt) {
+			if watcher.LastNotifiedAt.After(activity.CreatedAt) {
 				continue
 			}
 
@@ -251,17 +271,22 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 				continue
 			}
 
+			if watcher.Device.InQuietHours(time.Now()) {
+				_ = uc.statsd.Incr("apns.notification.quiet_hours", userWatcherTags, 1)
+				continue
+			}
+
 			notifs = append(notifs, watcher)
 		}
 
 		if len(notifs) == 0 {
 			continue
 		}
-
-		payload := payloadFromUserPost(post)
+		_ = uc.statsd.Count("apollo.watcher.hits", int64(len(notifs)), userWatcherTags, 1)
 
 		notification := &apns2.Notification{}
 		notification.Topic = "com.christianselig.Apollo"
+		notification.Priority = apnsPriority(userNotificationPriority)
 
 		for _, watcher := range notifs {
 			if err := uc.watcherRepo.IncrementHits(ctx, watcher.ID); err != nil {
@@ -274,7 +299,12 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 				return
 			}
 
-			device, _ := uc.deviceRepo.GetByID(ctx, watcher.DeviceID)
+			device := devices[watcher.DeviceID]
+
+			payload := payloadFromUserPost(activity, watcher.NotificationSound())
+			if activity.Kind == "t1" {
+				payload = payloadFromUserComment(activity, watcher.NotificationSound())
+			}
 
 			title := fmt.Sprintf(userNotificationTitleFormat, watcher.Label)
 			payload.AlertTitle(title)
@@ -287,25 +317,26 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 				client = uc.apnsSandbox
 			}
 
-			res, err := client.Push(notification)
+			res, err := pushWithSpan(ctx, uc.tracer, client, notification)
 			if err != nil || !res.Sent() {
 				_ = uc.statsd.Incr("apns.notification.errors", []string{}, 1)
 				uc.logger.Error("failed to send notification",
 					zap.Error(err),
 					zap.Int64("user#id", id),
 					zap.String("user#name", user.NormalizedName()),
-					zap.String("post#id", post.ID),
-					zap.String("apns", watcher.Device.APNSToken),
+					zap.String("activity#id", activity.FullName()),
+					zap.String("apns", watcher.Device.ObfuscatedToken()),
 					zap.Int("response#status", res.StatusCode),
 					zap.String("response#reason", res.Reason),
 				)
 			} else {
 				_ = uc.statsd.Incr("apns.notification.sent", []string{}, 1)
+				recordSentNotification(ctx, uc.sentNotificationRepo, device.ID, notification.Payload)
 				uc.logger.Info("sent notification",
 					zap.Int64("user#id", id),
 					zap.String("user#name", user.NormalizedName()),
-					zap.String("post#id", post.ID),
-					zap.String("device#token", watcher.Device.APNSToken),
+					zap.String("activity#id", activity.FullName()),
+					zap.String("device#token", watcher.Device.ObfuscatedToken()),
 				)
 			}
 		}
@@ -317,10 +348,51 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 	)
 }
 
-func payloadFromUserPost(post *reddit.Thing) *payload.Payload {
+// watcherDevices loads the devices behind a set of watchers in one query, keyed by ID.
+func (uc *usersConsumer) watcherDevices(ctx context.Context, watchers []domain.Watcher) (map[int64]domain.Device, error) {
+	ids := make([]int64, 0, len(watchers))
+	for _, watcher := range watchers {
+		ids = append(ids, watcher.DeviceID)
+	}
+
+	devs, err := uc.deviceRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make(map[int64]domain.Device, len(devs))
+	for _, dev := range devs {
+		devices[dev.ID] = dev
+	}
+	return devices, nil
+}
+
+// mergeActivity combines listings of a user's posts and comments into one, newest
+// first, dropping anything that shows up more than once.
+func mergeActivity(listings ...[]*reddit.Thing) []*reddit.Thing {
+	seen := map[string]bool{}
+	var activity []*reddit.Thing
+
+	for _, things := range listings {
+		for _, thing := range things {
+			if seen[thing.FullName()] {
+				continue
+			}
+			seen[thing.FullName()] = true
+			activity = append(activity, thing)
+		}
+	}
+
+	sort.SliceStable(activity, func(i, j int) bool {
+		return activity[i].CreatedAt.After(activity[j].CreatedAt)
+	})
+	return activity
+}
+
+func payloadFromUserPost(post *reddit.Thing, sound string) *payload.Payload {
 	payload := payload.
 		NewPayload().
-		AlertBody(post.Title).
+		AlertBody(reddit.PlainText(post.Title)).
 		AlertSubtitle(post.Author).
 		AlertSummaryArg(post.Author).
 		Category("user-watch").
@@ -329,8 +401,32 @@ func payloadFromUserPost(post *reddit.Thing) *payload.Payload {
 		Custom("subreddit", post.Subreddit).
 		Custom("author", post.Author).
 		Custom("post_age", post.CreatedAt).
+		InterruptionLevel(interruptionLevel(userNotificationPriority)).
+		MutableContent().
+		Sound(sound)
+
+	return payload
+}
+
+func payloadFromUserComment(comment *reddit.Thing, sound string) *payload.Payload {
+	_, postID := reddit.SplitID(comment.LinkID)
+
+	payload := payload.
+		NewPayload().
+		AlertBody(truncateRunes(reddit.PlainText(comment.Body), 2000)).
+		AlertSubtitle(comment.Author).
+		AlertSummaryArg(comment.Author).
+		Category("user-watch").
+		Custom("comment_id", comment.ID).
+		Custom("post_title", comment.LinkTitle).
+		Custom("post_id", postID).
+		Custom("subreddit", comment.Subreddit).
+		Custom("author", comment.Author).
+		Custom("post_age", comment.CreatedAt).
+		Custom("type", "comment").
+		InterruptionLevel(interruptionLevel(userNotificationPriority)).
 		MutableContent().
-		Sound("traloop.wav")
+		Sound(sound)
 
 	return payload
 }
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+// unnotifiedWatchers returns the watchers whose lock key isn't set yet, checking all of
+// them with a single MGET. Only the first watcher for each key is kept, so a device
+// with several watchers matching the same post only hears about it once. If Redis
+// can't be reached, every watcher counts as not notified yet and the error is returned
+// alongside them.
+func unnotifiedWatchers(ctx context.Context, rc *redis.Client, watchers []domain.Watcher, lockKey func(domain.Watcher) string) ([]domain.Watcher, error) {
+	keys := make([]string, 0, len(watchers))
+	candidates := make([]domain.Watcher, 0, len(watchers))
+	seen := make(map[string]bool, len(watchers))
+
+	for _, watcher := range watchers {
+		key := lockKey(watcher)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		keys = append(keys, key)
+		candidates = append(candidates, watcher)
+	}
+
+	if len(keys) == 0 {
+		return candidates, nil
+	}
+
+	vals, err := rc.MGet(ctx, keys...).Result()
+	if err != nil {
+		return candidates, err
+	}
+
+	unnotified := candidates[:0]
+	for i, val := range vals {
+		s, _ := val.(string)
+		if notified, _ := strconv.ParseBool(s); notified {
+			continue
+		}
+		unnotified = append(unnotified, candidates[i])
+	}
+
+	return unnotified, nil
+}
+
+// markWatchersNotified sets the lock key of every watcher in one pipeline, so they
+// aren't notified about the same thing again until ttl runs out.
+func markWatchersNotified(ctx context.Context, rc *redis.Client, watchers []domain.Watcher, lockKey func(domain.Watcher) string, ttl time.Duration) error {
+	if len(watchers) == 0 {
+		return nil
+	}
+
+	_, err := rc.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, watcher := range watchers {
+			pipe.SetEX(ctx, lockKey(watcher), true, ttl)
+		}
+		return nil
+	})
+	return err
+}
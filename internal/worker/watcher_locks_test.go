@@ -0,0 +1,86 @@
+package worker_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+	"github.com/christianselig/apollo-backend/internal/worker"
+)
+
+func watcherIDs(watchers []domain.Watcher) []int64 {
+	ids := make([]int64, len(watchers))
+	for i, watcher := range watchers {
+		ids[i] = watcher.ID
+	}
+	return ids
+}
+
+func TestWatcherLocks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testhelper.NewTestRedisClient(t)
+
+	post := fmt.Sprintf("t3_%d", time.Now().UnixNano())
+	lockKey := func(watcher domain.Watcher) string {
+		return fmt.Sprintf("watcher:test:%d:%s", watcher.DeviceID, post)
+	}
+
+	watchers := []domain.Watcher{
+		{ID: 1, DeviceID: 10},
+		{ID: 2, DeviceID: 20},
+		{ID: 3, DeviceID: 10}, // same device as the first one
+		{ID: 4, DeviceID: 30},
+		{ID: 5, DeviceID: 40},
+	}
+	t.Cleanup(func() {
+		for _, watcher := range watchers {
+			_ = client.Del(ctx, lockKey(watcher)).Err()
+		}
+	})
+
+	// Notified one way or another before this run.
+	require.NoError(t, client.SetEX(ctx, lockKey(watchers[1]), true, time.Minute).Err())
+	require.NoError(t, client.SetEX(ctx, lockKey(watchers[4]), "garbage", time.Minute).Err())
+
+	unnotified, err := worker.UnnotifiedWatchers(ctx, client, watchers, lockKey)
+	require.NoError(t, err)
+	// Same as checking one watcher at a time and locking as we go: the second watcher
+	// was already notified and the third shares a device with the first.
+	assert.Equal(t, []int64{1, 4, 5}, watcherIDs(unnotified))
+
+	require.NoError(t, worker.MarkWatchersNotified(ctx, client, unnotified, lockKey, 90*time.Minute))
+
+	unnotified, err = worker.UnnotifiedWatchers(ctx, client, watchers, lockKey)
+	require.NoError(t, err)
+	assert.Empty(t, unnotified)
+
+	// The locks last as long as configured.
+	ttl, err := client.TTL(ctx, lockKey(watchers[3])).Result()
+	require.NoError(t, err)
+	assert.InDelta(t, 90*time.Minute, ttl, float64(time.Minute))
+}
+
+func TestWatcherLocks_RedisUnavailable(t *testing.T) {
+	t.Parallel()
+
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0", MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+
+	lockKey := func(watcher domain.Watcher) string {
+		return fmt.Sprintf("watcher:%d:t3_abc", watcher.DeviceID)
+	}
+	watchers := []domain.Watcher{{ID: 1, DeviceID: 10}, {ID: 2, DeviceID: 10}, {ID: 3, DeviceID: 20}}
+
+	unnotified, err := worker.UnnotifiedWatchers(context.Background(), client, watchers, lockKey)
+	assert.Error(t, err)
+	assert.Equal(t, []int64{1, 3}, watcherIDs(unnotified))
+}
@@ -2,6 +2,12 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
@@ -10,12 +16,217 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/cmdutil"
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+const (
+	pollDuration = 100 * time.Millisecond
+
+	// shutdownGracePeriod is how long a stopping worker lets in-flight jobs finish
+	// before cancelling them.
+	shutdownGracePeriod = 20 * time.Second
+
+	// prefetchRetuneInterval is how often a queue's prefetch limit is checked against
+	// its backlog when WORKER_AUTO_PREFETCH=true.
+	prefetchRetuneInterval = time.Minute
+)
+
+// Tags for the watcher workers' apollo.watcher.* stats, so hit rates can be compared
+// across them.
+var (
+	subredditWatcherTags = []string{"type:subreddit"}
+	trendingWatcherTags  = []string{"type:trending"}
+	userWatcherTags      = []string{"type:user"}
 )
 
-const pollDuration = 100 * time.Millisecond
+// watcherDedupTTL returns how long a device isn't notified about the same post again by
+// watchers of the given type, configurable through WATCHER_DEDUP_TTL_<TYPE>
+// (e.g. WATCHER_DEDUP_TTL_TRENDING=72h).
+func watcherDedupTTL(typ domain.WatcherType) time.Duration {
+	def := domain.SubredditWatcherDedupTTL
+	if typ == domain.TrendingWatcher {
+		def = domain.TrendingWatcherDedupTTL
+	}
+
+	key := fmt.Sprintf("WATCHER_DEDUP_TTL_%s", strings.ToUpper(typ.String()))
+	if ttl := cmdutil.DurationFromEnv(key, def); ttl > 0 {
+		return ttl
+	}
+	return def
+}
+
+// prefetchForBacklog sizes how many jobs get fetched ahead of the consumers to cover the
+// queue's backlog, staying within min and max.
+func prefetchForBacklog(ready, min, max int64) int64 {
+	if ready < min {
+		return min
+	}
+	if ready > max {
+		return max
+	}
+	return ready
+}
+
+// prefetchLimit returns the prefetch limit to consume a queue with, capped at perConsumer
+// jobs per consumer. With WORKER_AUTO_PREFETCH=true it's sized to the queue's current
+// backlog instead of always using the cap.
+func prefetchLimit(conn rmq.Connection, name string, consumers, perConsumer int) int64 {
+	max := int64(consumers * perConsumer)
+	if !autoPrefetch() {
+		return max
+	}
+
+	stats, err := conn.CollectStats([]string{name})
+	if err != nil {
+		return max
+	}
+	return prefetchForBacklog(stats.QueueStats[name].ReadyCount, int64(consumers), max)
+}
+
+func autoPrefetch() bool {
+	return os.Getenv("WORKER_AUTO_PREFETCH") == "true"
+}
+
+// prefetchNeedsRetune reports whether the prefetch limit moved far enough from the one
+// a queue is consuming with to be worth restarting consumption for.
+func prefetchNeedsRetune(current, next int64) bool {
+	return next >= current*2 || next*2 <= current
+}
+
+// startConsuming opens the named queue and starts consuming it with consumers built by
+// newConsumer. With WORKER_AUTO_PREFETCH=true the prefetch limit follows the backlog: it
+// is re-checked every prefetchRetuneInterval until ctx is done, and consumption restarts
+// with the new limit when it has doubled or halved.
+func startConsuming(ctx context.Context, logger *zap.Logger, conn rmq.Connection, name string, consumers, perConsumer int, newConsumer func(tag int) rmq.Consumer) error {
+	limit := prefetchLimit(conn, name, consumers, perConsumer)
+
+	queue, err := openConsumingQueue(conn, name, limit, consumers, newConsumer)
+	if err != nil {
+		return err
+	}
+
+	if autoPrefetch() {
+		go retunePrefetch(ctx, logger, conn, queue, name, limit, consumers, perConsumer, newConsumer)
+	}
+
+	return nil
+}
+
+func openConsumingQueue(conn rmq.Connection, name string, limit int64, consumers int, newConsumer func(tag int) rmq.Consumer) (rmq.Queue, error) {
+	queue, err := conn.OpenQueue(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := queue.StartConsuming(limit, pollDuration); err != nil {
+		return nil, err
+	}
 
-type NewWorkerFn func(context.Context, *zap.Logger, trace.Tracer, *statsd.Client, *pgxpool.Pool, *redis.Client, rmq.Connection, int) Worker
+	host, _ := os.Hostname()
+
+	for i := 0; i < consumers; i++ {
+		tag := fmt.Sprintf("consumer %s-%d", host, i)
+
+		if _, err := queue.AddConsumer(tag, newConsumer(i)); err != nil {
+			return nil, err
+		}
+	}
+
+	return queue, nil
+}
+
+// retunePrefetch restarts consumption of the queue whenever its prefetch limit needs
+// retuning. rmq fixes the limit once a queue starts consuming, so the old queue is
+// stopped, the jobs it had fetched but not handed out are returned to the ready list,
+// and the queue is reopened with the new limit.
+func retunePrefetch(ctx context.Context, logger *zap.Logger, conn rmq.Connection, queue rmq.Queue, name string, limit int64, consumers, perConsumer int, newConsumer func(tag int) rmq.Consumer) {
+	ticker := time.NewTicker(prefetchRetuneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		next := prefetchLimit(conn, name, consumers, perConsumer)
+		if !prefetchNeedsRetune(limit, next) {
+			continue
+		}
+
+		<-queue.StopConsuming()
+
+		if _, err := queue.ReturnUnacked(math.MaxInt64); err != nil {
+			logger.Error("failed to return prefetched jobs", zap.Error(err), zap.String("queue", name))
+		}
+
+		reopened, err := openConsumingQueue(conn, name, next, consumers, newConsumer)
+		if errors.Is(err, rmq.ErrorConsumingStopped) {
+			return // the worker is shutting down
+		}
+		if err != nil {
+			logger.Error("failed to restart consuming", zap.Error(err), zap.String("queue", name))
+			return
+		}
+
+		logger.Info("retuned prefetch limit",
+			zap.String("queue", name),
+			zap.Int64("from", limit),
+			zap.Int64("to", next),
+		)
+
+		queue, limit = reopened, next
+	}
+}
+
+type NewWorkerFn func(context.Context, *zap.Logger, trace.Tracer, statsd.ClientInterface, *pgxpool.Pool, *redis.Client, *reddit.Client, rmq.Connection, int) Worker
 type Worker interface {
 	Start() error
 	Stop()
 }
+
+// jobTracker keeps track of in-flight jobs so a worker can let them finish when it
+// shuts down. Jobs run under the tracker's context rather than the worker's, so
+// they don't get cancelled the moment the worker is told to stop.
+type jobTracker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newJobTracker() *jobTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobTracker{ctx: ctx, cancel: cancel}
+}
+
+// start marks a job as in flight, returning the context it should run under and a
+// function to call once it's done.
+func (jt *jobTracker) start() (context.Context, func()) {
+	jt.wg.Add(1)
+	return jt.ctx, jt.wg.Done
+}
+
+// drain waits up to timeout for in-flight jobs to finish, then cancels whatever is
+// left. It reports whether every job finished in time.
+func (jt *jobTracker) drain(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		jt.wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		jt.cancel()
+		return false
+	}
+}
@@ -0,0 +1,122 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/worker"
+)
+
+func TestJobTrackerDrain(t *testing.T) {
+	t.Parallel()
+
+	jt := worker.NewJobTracker()
+	ctx, done := worker.StartJob(jt)
+
+	finished := make(chan error, 1)
+	go func() {
+		defer done()
+
+		// Pretend to be mid-request to Reddit when the shutdown starts.
+		time.Sleep(50 * time.Millisecond)
+		finished <- ctx.Err()
+	}()
+
+	assert.True(t, worker.DrainJobs(jt, time.Second))
+	assert.NoError(t, <-finished, "job was cancelled within the grace period")
+}
+
+func TestJobTrackerDrainTimeout(t *testing.T) {
+	t.Parallel()
+
+	jt := worker.NewJobTracker()
+	ctx, done := worker.StartJob(jt)
+
+	go func() {
+		defer done()
+		<-ctx.Done()
+	}()
+
+	assert.False(t, worker.DrainJobs(jt, 20*time.Millisecond))
+	assert.Error(t, ctx.Err())
+}
+
+func TestPrefetchForBacklog(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		ready int64
+		want  int64
+	}{
+		"empty queue":   {0, 64},
+		"small backlog": {10, 64},
+		"within bounds": {100, 100},
+		"at the cap":    {128, 128},
+		"large backlog": {10000, 128},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, worker.PrefetchForBacklog(tc.ready, 64, 128))
+		})
+	}
+}
+
+func TestPrefetchNeedsRetune(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		next int64
+		want bool
+	}{
+		"unchanged":    {64, false},
+		"grown a bit":  {100, false},
+		"doubled":      {128, true},
+		"shrunk a bit": {40, false},
+		"halved":       {32, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, worker.PrefetchNeedsRetune(64, tc.next))
+		})
+	}
+}
+
+func TestWatcherDedupTTL(t *testing.T) { //nolint:paralleltest
+	tt := map[string]struct {
+		typ  domain.WatcherType
+		env  string
+		want time.Duration
+	}{
+		"subreddit default":  {domain.SubredditWatcher, "", 24 * time.Hour},
+		"trending default":   {domain.TrendingWatcher, "", 48 * time.Hour},
+		"subreddit override": {domain.SubredditWatcher, "6h", 6 * time.Hour},
+		"trending override":  {domain.TrendingWatcher, "72h", 72 * time.Hour},
+		"invalid":            {domain.TrendingWatcher, "soon", 48 * time.Hour},
+		"zero":               {domain.SubredditWatcher, "0s", 24 * time.Hour},
+	}
+
+	for scenario, tc := range tt { //nolint:paralleltest
+		t.Run(scenario, func(t *testing.T) {
+			t.Setenv("WATCHER_DEDUP_TTL_SUBREDDIT", "")
+			t.Setenv("WATCHER_DEDUP_TTL_TRENDING", "")
+			if tc.typ == domain.TrendingWatcher {
+				t.Setenv("WATCHER_DEDUP_TTL_TRENDING", tc.env)
+			} else {
+				t.Setenv("WATCHER_DEDUP_TTL_SUBREDDIT", tc.env)
+			}
+
+			assert.Equal(t, tc.want, worker.WatcherDedupTTL(tc.typ))
+		})
+	}
+}
@@ -0,0 +1,2 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS is_deleted boolean DEFAULT false;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS development boolean DEFAULT false;
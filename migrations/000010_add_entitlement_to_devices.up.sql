@@ -0,0 +1,2 @@
+ALTER TABLE devices ADD COLUMN entitlement_active boolean DEFAULT false;
+ALTER TABLE devices ADD COLUMN receipt_checked_at timestamp without time zone DEFAULT '1970-01-01 00:00:00';
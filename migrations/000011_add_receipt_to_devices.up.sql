@@ -0,0 +1 @@
+ALTER TABLE devices ADD COLUMN receipt text DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE accounts ADD COLUMN idle_check_count integer DEFAULT 0;
@@ -0,0 +1,14 @@
+-- Table Definition ----------------------------------------------
+
+CREATE TABLE sent_notifications (
+    id SERIAL PRIMARY KEY,
+    device_id integer REFERENCES devices(id) ON DELETE CASCADE,
+    type character varying(64) DEFAULT ''::character varying,
+    title text DEFAULT ''::text,
+    created_at timestamp without time zone
+);
+
+-- Indices -------------------------------------------------------
+
+CREATE INDEX sent_notifications_device_id_created_at_idx ON sent_notifications(device_id int4_ops,created_at timestamp_ops);
+CREATE INDEX sent_notifications_created_at_idx ON sent_notifications(created_at timestamp_ops);
@@ -0,0 +1 @@
+ALTER TABLE live_activities ADD COLUMN IF NOT EXISTS keyword character varying(32) DEFAULT ''::character varying;
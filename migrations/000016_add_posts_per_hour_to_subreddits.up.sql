@@ -0,0 +1 @@
+ALTER TABLE subreddits ADD COLUMN IF NOT EXISTS posts_per_hour real DEFAULT 0;
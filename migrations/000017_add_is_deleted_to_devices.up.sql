@@ -0,0 +1 @@
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS is_deleted boolean DEFAULT false;
@@ -0,0 +1,4 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS created_at timestamp without time zone DEFAULT NOW();
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS updated_at timestamp without time zone DEFAULT NOW();
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS created_at timestamp without time zone DEFAULT NOW();
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS updated_at timestamp without time zone DEFAULT NOW();
@@ -0,0 +1,2 @@
+ALTER TABLE live_activities ADD COLUMN IF NOT EXISTS renew_on_activity boolean DEFAULT false;
+ALTER TABLE live_activities ADD COLUMN IF NOT EXISTS created_at timestamp without time zone DEFAULT NOW();
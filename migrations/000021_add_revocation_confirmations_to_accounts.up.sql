@@ -0,0 +1 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS revocation_confirmations integer DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS quiet_hours_start integer DEFAULT 0;
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS quiet_hours_end integer DEFAULT 0;
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS quiet_hours_timezone character varying(64) DEFAULT ''::character varying;
@@ -0,0 +1,2 @@
+ALTER TABLE watchers ADD COLUMN IF NOT EXISTS exclude_nsfw boolean DEFAULT false;
+ALTER TABLE watchers ADD COLUMN IF NOT EXISTS exclude_crossposts boolean DEFAULT false;
@@ -0,0 +1,9 @@
+ALTER TABLE subreddits ADD COLUMN IF NOT EXISTS watcher_count integer DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS watcher_count integer DEFAULT 0;
+
+UPDATE subreddits SET watcher_count = (
+    SELECT COUNT(*) FROM watchers WHERE watchers.watchee_id = subreddits.id AND watchers.type IN (0, 2)
+);
+UPDATE users SET watcher_count = (
+    SELECT COUNT(*) FROM watchers WHERE watchers.watchee_id = users.id AND watchers.type = 1
+);
@@ -0,0 +1,2 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS watchers_search_idx ON watchers USING GIN (label gin_trgm_ops, keyword gin_trgm_ops, domain gin_trgm_ops);
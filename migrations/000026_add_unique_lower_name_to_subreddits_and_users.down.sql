@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_lower_name_idx;
+DROP INDEX IF EXISTS subreddits_lower_name_idx;
@@ -0,0 +1,30 @@
+-- Fold rows whose names only differ in case into the oldest one before enforcing uniqueness.
+WITH dupes AS (
+    SELECT id, MIN(id) OVER (PARTITION BY LOWER(name)) AS keep FROM subreddits
+)
+UPDATE watchers SET watchee_id = dupes.keep
+FROM dupes
+WHERE watchers.type IN (0, 2) AND watchers.watchee_id = dupes.id AND dupes.id <> dupes.keep;
+
+DELETE FROM subreddits WHERE id NOT IN (SELECT MIN(id) FROM subreddits GROUP BY LOWER(name));
+
+UPDATE subreddits SET name = LOWER(name), watcher_count = (
+    SELECT COUNT(*) FROM watchers WHERE watchers.watchee_id = subreddits.id AND watchers.type IN (0, 2)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS subreddits_lower_name_idx ON subreddits (LOWER(name));
+
+WITH dupes AS (
+    SELECT id, MIN(id) OVER (PARTITION BY LOWER(name)) AS keep FROM users
+)
+UPDATE watchers SET watchee_id = dupes.keep
+FROM dupes
+WHERE watchers.type = 1 AND watchers.watchee_id = dupes.id AND dupes.id <> dupes.keep;
+
+DELETE FROM users WHERE id NOT IN (SELECT MIN(id) FROM users GROUP BY LOWER(name));
+
+UPDATE users SET name = LOWER(name), watcher_count = (
+    SELECT COUNT(*) FROM watchers WHERE watchers.watchee_id = users.id AND watchers.type = 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_lower_name_idx ON users (LOWER(name));
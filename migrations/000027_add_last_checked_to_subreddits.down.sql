@@ -0,0 +1,2 @@
+ALTER TABLE subreddits DROP COLUMN IF EXISTS last_post_id;
+ALTER TABLE subreddits DROP COLUMN IF EXISTS last_checked_at;
@@ -0,0 +1,2 @@
+ALTER TABLE subreddits ADD COLUMN IF NOT EXISTS last_checked_at timestamp without time zone DEFAULT NOW();
+ALTER TABLE subreddits ADD COLUMN IF NOT EXISTS last_post_id character varying(32) DEFAULT ''::character varying;
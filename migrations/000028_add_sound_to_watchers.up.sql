@@ -0,0 +1 @@
+ALTER TABLE watchers ADD COLUMN IF NOT EXISTS sound character varying(32) DEFAULT ''::character varying;
@@ -0,0 +1 @@
+ALTER TABLE live_activities DROP COLUMN IF EXISTS reply_token_hash;
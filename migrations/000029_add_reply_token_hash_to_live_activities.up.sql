@@ -0,0 +1 @@
+ALTER TABLE live_activities ADD COLUMN IF NOT EXISTS reply_token_hash character varying(64) DEFAULT ''::character varying;